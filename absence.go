@@ -0,0 +1,245 @@
+package iterator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cerc-io/eth-iterator-utils/nibbles"
+)
+
+// ErrNoBounds is returned by ResumeAbsence when an iterator does not report its bounds, as
+// PrefixBoundIterator and tracker.Iterator do. It is an ErrUnsupportedIterator.
+var ErrNoBounds = fmt.Errorf("%w: iterator has no bounds", ErrUnsupportedIterator)
+
+// AbsenceProof proves that a key is absent from a trie, by the nodes on its path from the root
+// down to where the path leaves the trie. If the key turns out to be present, Exists is set and
+// the proof is of its inclusion instead. Either can be checked with trie.VerifyProof.
+type AbsenceProof struct {
+	Key    []byte   // the key as stored in the trie, e.g. the hash of an address
+	Proof  [][]byte // encoded nodes, from the root
+	Exists bool
+}
+
+// AbsenceVisitor consumes the proofs generated by ProveAbsence. It is called concurrently from the
+// traversal's workers.
+type AbsenceVisitor = func(*AbsenceProof) error
+
+// ProveAbsence generates proofs of absence for keys expected to be missing from a trie. The keys
+// are divided among `nbins` subtries, which are walked on a pool of `workers` goroutines as by
+// Traverse, descending only towards the keys of each bin, so nodes shared by their proofs are read
+// once. Each proof is passed to visit as soon as the walk has passed its key.
+//
+// With WithTracker, the walks are tracked, so that an interrupted run can be continued with
+// ResumeAbsence.
+func ProveAbsence(
+	ctx context.Context, makeIterator IteratorConstructor, keys [][]byte, nbins, workers uint,
+	visit AbsenceVisitor, opts ...TraverseOption,
+) error {
+	conf := newTraverseConfig(opts)
+	group, ctx := errgroup.WithContext(ctx)
+	makeIterator = conf.withResolver(makeIterator)
+	iters, err := SubtrieIterators(withContext(ctx, makeIterator), nbins)
+	if err != nil {
+		return err
+	}
+	targets := sortedTargets(keys)
+	walks := make([]*absenceWalk, len(iters))
+	var lower []byte
+	for i, it := range iters {
+		_, upper, err := Bounds(it)
+		if err != nil {
+			return err
+		}
+		walks[i] = newAbsenceWalk(makeIterator, targets, lower, upper)
+		lower = upper
+		if conf.tracker != nil {
+			iters[i] = conf.tracker.Tracked(it)
+		}
+	}
+	return traverse(group, iters, workers, func(i int, it trie.NodeIterator) error {
+		return walks[i].run(it, visit)
+	}, &conf)
+}
+
+// ResumeAbsence continues ProveAbsence from iterators restored by a tracker, for the same keys.
+// Some proofs may be generated again, including any which were generated but not yet consumed when
+// the state was saved. Of the options, only WithMonitor, WithResolver, WithRoot and WithBudget
+// apply.
+func ResumeAbsence(
+	ctx context.Context, makeIterator IteratorConstructor, iters []trie.NodeIterator, keys [][]byte,
+	workers uint, visit AbsenceVisitor, opts ...TraverseOption,
+) error {
+	conf := newTraverseConfig(opts)
+	group, ctx := errgroup.WithContext(ctx)
+	targets := sortedTargets(keys)
+	makeIterator = conf.withResolver(makeIterator)
+	walks := make([]*absenceWalk, len(iters))
+	wrapped, err := conf.wrap(ctx, iters)
+	if err != nil {
+		return err
+	}
+	for i, it := range iters {
+		// the iterator resumes after its start path, which precedes any proof that was in
+		// progress: see absenceWalk.run
+		start, end, err := Bounds(it)
+		if err != nil {
+			return ErrNoBounds
+		}
+		walks[i] = newAbsenceWalk(makeIterator, targets, start, end)
+	}
+	return traverse(group, wrapped, workers, func(i int, it trie.NodeIterator) error {
+		return walks[i].run(it, visit)
+	}, &conf)
+}
+
+type absenceTarget struct {
+	key, path []byte
+}
+
+func sortedTargets(keys [][]byte) []absenceTarget {
+	targets := make([]absenceTarget, len(keys))
+	for i, key := range keys {
+		targets[i] = absenceTarget{key, KeyBytesToHex(key)}
+	}
+	sort.Slice(targets, func(i, j int) bool { return bytes.Compare(targets[i].path, targets[j].path) < 0 })
+	return targets
+}
+
+// absenceWalk proves the keys within the bounds of one bin.
+type absenceWalk struct {
+	makeIterator IteratorConstructor
+	targets      []absenceTarget
+}
+
+// newAbsenceWalk selects the targets with paths in [lower, upper); nil bounds are open.
+func newAbsenceWalk(makeIterator IteratorConstructor, sorted []absenceTarget, lower, upper []byte) *absenceWalk {
+	from := sort.Search(len(sorted), func(i int) bool { return bytes.Compare(sorted[i].path, lower) >= 0 })
+	to := len(sorted)
+	if upper != nil {
+		to = sort.Search(len(sorted), func(i int) bool { return bytes.Compare(sorted[i].path, upper) >= 0 })
+	}
+	return &absenceWalk{makeIterator: makeIterator, targets: sorted[from:to]}
+}
+
+type proofNode struct {
+	path, blob []byte
+}
+
+// run walks the bin's iterator towards its targets. The nodes above where the iterator starts are
+// not yielded by it, so they are read first, from the root; so are the whole proofs of any targets
+// before the first node it yields.
+//
+// The saved path of a tracked walk is that of the last node reached, and any proofs it completed
+// may not yet have been visited. These can only be of targets under the node's parent, which the
+// restored iterator's start path is, so ResumeAbsence selects targets from the start path.
+func (w *absenceWalk) run(it trie.NodeIterator, visit AbsenceVisitor) error {
+	if len(w.targets) == 0 {
+		// the bin must still be exhausted, so a tracker releases it
+		for it.Next(false) {
+		}
+		return nil
+	}
+	stack, err := w.ancestors(it.Path())
+	if err != nil {
+		return err
+	}
+	ok := it.Next(true)
+	if err := it.Error(); err != nil {
+		return err
+	}
+	split := len(w.targets)
+	if ok {
+		first := it.Path()
+		split = sort.Search(len(w.targets), func(i int) bool { return bytes.Compare(w.targets[i].path, first) >= 0 })
+	}
+	if split > 0 {
+		root, err := w.makeIterator(nil)
+		if err != nil {
+			return err
+		}
+		if err := proveTargets(root, root.Next(true), w.targets[:split], nil, visit); err != nil {
+			return err
+		}
+	}
+	return proveTargets(it, ok, w.targets[split:], stack, visit)
+}
+
+// proveTargets walks an iterator, which has been advanced to its first node (if ok), towards the
+// sorted targets. A target is passed to visit once the walk reaches a node past it; until then,
+// the nodes on the current path are kept on a stack, which starts with any nodes above the first.
+func proveTargets(it trie.NodeIterator, ok bool, targets []absenceTarget, stack []proofNode, visit AbsenceVisitor) error {
+	paths := make([][]byte, len(targets))
+	for i, target := range targets {
+		paths[i] = target.path
+	}
+	var next int
+	var found []byte // path of the last target leaf reached
+	emit := func() error {
+		target := targets[next]
+		res := &AbsenceProof{Key: target.key, Proof: [][]byte{}, Exists: bytes.Equal(target.path, found)}
+		for _, node := range stack {
+			if bytes.HasPrefix(target.path, node.path) {
+				res.Proof = append(res.Proof, node.blob)
+			}
+		}
+		next++
+		return visit(res)
+	}
+	for descend := true; ok; ok = it.Next(descend) {
+		path := it.Path()
+		for next < len(targets) && bytes.Compare(targets[next].path, path) < 0 {
+			if err := emit(); err != nil {
+				return err
+			}
+		}
+		if descend = hasPrefixed(paths[next:], path); !descend {
+			continue
+		}
+		for len(stack) > 0 && !nibbles.IsProperPrefix(stack[len(stack)-1].path, path) {
+			stack = stack[:len(stack)-1]
+		}
+		if it.Hash() != (common.Hash{}) {
+			stack = append(stack, proofNode{append([]byte(nil), path...), it.NodeBlob()})
+		}
+		if it.Leaf() {
+			found = append(found[:0], path...)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for next < len(targets) {
+		if err := emit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ancestors reads the nodes from the root down to the given path, inclusive. An iterator at the
+// root has not necessarily yielded it, so the root is always read.
+func (w *absenceWalk) ancestors(path []byte) ([]proofNode, error) {
+	it, err := w.makeIterator(nil)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []proofNode
+	for descend := true; it.Next(descend); {
+		if descend = bytes.HasPrefix(path, it.Path()); !descend {
+			continue
+		}
+		if it.Hash() != (common.Hash{}) {
+			nodes = append(nodes, proofNode{append([]byte(nil), it.Path()...), it.NodeBlob()})
+		}
+		if len(it.Path()) == len(path) {
+			break
+		}
+	}
+	return nodes, it.Error()
+}
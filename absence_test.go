@@ -0,0 +1,165 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestProveAbsence(t *testing.T) {
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := func(i int) common.Address { return common.BytesToAddress([]byte{byte(i >> 8), byte(i)}) }
+	for i := 0; i < 500; i++ {
+		statedb.SetBalance(addr(i), uint256.NewInt(uint64(i+1)))
+	}
+	root, err := statedb.Commit(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeIterator := iter.NewTrieDBConstructor(sdb.TrieDB(), trie.StateTrieID(root))
+
+	// mostly absent keys, with some present ones
+	var keys [][]byte
+	exists := map[string]bool{}
+	for i := 490; i < 800; i++ {
+		key := crypto.Keccak256(addr(i).Bytes())
+		keys = append(keys, key)
+		exists[string(key)] = i < 500
+	}
+
+	// verify checks each proof, and counts the times each key is proven
+	type result struct {
+		proofs map[string]int
+		mu     sync.Mutex
+	}
+	verify := func(t *testing.T, res *result) iter.AbsenceVisitor {
+		return func(proof *iter.AbsenceProof) error {
+			db := rawdb.NewMemoryDatabase()
+			for _, node := range proof.Proof {
+				db.Put(crypto.Keccak256(node), node)
+			}
+			value, err := trie.VerifyProof(root, proof.Key, db)
+			if err != nil {
+				t.Errorf("invalid proof for %x: %v", proof.Key, err)
+			}
+			if (value != nil) != proof.Exists || proof.Exists != exists[string(proof.Key)] {
+				t.Errorf("wrong existence for %x: proven %v, with value %x", proof.Key, proof.Exists, value)
+			}
+			res.mu.Lock()
+			res.proofs[string(proof.Key)]++
+			res.mu.Unlock()
+			return nil
+		}
+	}
+
+	t.Run("all keys", func(t *testing.T) {
+		res := &result{proofs: map[string]int{}}
+		if err := iter.ProveAbsence(context.Background(), makeIterator, keys, 16, 4, verify(t, res)); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if res.proofs[string(key)] != 1 {
+				t.Errorf("key %x proven %d times", key, res.proofs[string(key)])
+			}
+		}
+	})
+
+	t.Run("with budget", func(t *testing.T) {
+		res := &result{proofs: map[string]int{}}
+		err := iter.ProveAbsence(context.Background(), makeIterator, keys, 4, 2, verify(t, res),
+			iter.WithBudget(iter.NewBudget(0, 1e6)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.proofs) != len(keys) {
+			t.Fatalf("expected %d keys to be proven, got %d", len(keys), len(res.proofs))
+		}
+		err = iter.ProveAbsence(context.Background(), makeIterator, keys, 4, 2, verify(t, res),
+			iter.WithBudget(iter.NewBudget(0, 10)))
+		if !errors.Is(err, iter.ErrBudgetExceeded) {
+			t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+		}
+	})
+
+	// interrupt the traversal after some of the keys are proven
+	for _, cancelAt := range []int{1, len(keys) / 3, len(keys) * 2 / 3} {
+		cancelAt := cancelAt
+		t.Run(fmt.Sprintf("resumed after %d", cancelAt), func(t *testing.T) {
+			recoveryFile := filepath.Join(t.TempDir(), "recovery.csv")
+			ctx, cancel := context.WithCancel(context.Background())
+			res := &result{proofs: map[string]int{}}
+			visit := verify(t, res)
+			tr := tracker.New(recoveryFile, 16)
+			err := iter.ProveAbsence(ctx, makeIterator, keys, 16, 2, func(proof *iter.AbsenceProof) error {
+				if err := visit(proof); err != nil {
+					return err
+				}
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				if len(res.proofs) == cancelAt {
+					cancel()
+				}
+				return nil
+			}, iter.WithTracker(tr))
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+			if err := tr.CloseAndSave(); err != nil {
+				t.Fatal(err)
+			}
+			if len(res.proofs) >= len(keys) {
+				t.Fatal("traversal was not interrupted")
+			}
+
+			tr = tracker.New(recoveryFile, 16)
+			iters, _, err := tr.Restore(makeIterator)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = iter.ResumeAbsence(context.Background(), makeIterator, iters, keys, 2, visit,
+				iter.WithBudget(iter.NewBudget(0, 1e6)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.CloseAndSave(); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range keys {
+				if res.proofs[string(key)] == 0 {
+					t.Errorf("key %x not proven", key)
+				}
+			}
+		})
+	}
+
+	t.Run("unsorted duplicates", func(t *testing.T) {
+		dups := [][]byte{keys[5], keys[0], keys[5], bytes.Repeat([]byte{0xff}, 32)}
+		exists[string(dups[3])] = false
+		res := &result{proofs: map[string]int{}}
+		if err := iter.ProveAbsence(context.Background(), makeIterator, dups, 4, 1, verify(t, res)); err != nil {
+			t.Fatal(err)
+		}
+		if res.proofs[string(keys[5])] != 2 || len(res.proofs) != 3 {
+			t.Fatalf("wrong proof counts: %v", res.proofs)
+		}
+	})
+}
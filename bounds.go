@@ -0,0 +1,26 @@
+package iterator
+
+import "github.com/cerc-io/eth-iterator-utils/nibbles"
+
+// MaxPathLength is the length in nibbles of a leaf path in a trie with 32-byte keys, excluding the
+// terminator.
+const MaxPathLength = nibbles.MaxLength
+
+// MinPathUnder returns the smallest path under prefix which can be used as an iterator's start key,
+// i.e. the prefix padded with a 0 nibble to an even length. An iterator started there covers the
+// whole subtrie at prefix except, for an odd-length prefix, the node at prefix itself. The prefix
+// is not modified.
+//
+// E.g. MinPathUnder([8]) = [8 0], MinPathUnder([8 1]) = [8 1].
+func MinPathUnder(prefix []byte) []byte {
+	return nibbles.PadMin(prefix)
+}
+
+// MaxPathUnder returns the largest leaf path under prefix, i.e. the prefix padded with 0xf nibbles
+// to MaxPathLength. Every node path under the prefix, ignoring the terminator, is at most this
+// path. The prefix is not modified.
+//
+// E.g. MaxPathUnder([8]) = [8 f f ... f], 64 nibbles long.
+func MaxPathUnder(prefix []byte) []byte {
+	return nibbles.PadMax(prefix)
+}
@@ -0,0 +1,52 @@
+package iterator_test
+
+import (
+	"bytes"
+	"testing"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+func TestPathsUnder(t *testing.T) {
+	max := func(prefix ...byte) []byte {
+		path := append([]byte(nil), prefix...)
+		for len(path) < iter.MaxPathLength {
+			path = append(path, 0xf)
+		}
+		return path
+	}
+	cases := []struct {
+		prefix   []byte
+		min, max []byte
+	}{
+		{nil, []byte{}, max()},
+		{[]byte{8}, []byte{8, 0}, max(8)},
+		{[]byte{8, 1}, []byte{8, 1}, max(8, 1)},
+		{[]byte{0, 0, 0}, []byte{0, 0, 0, 0}, max(0, 0, 0)},
+		{max(1), max(1), max(1)},
+	}
+	for _, tc := range cases {
+		prefix := append([]byte(nil), tc.prefix...)
+		if min := iter.MinPathUnder(prefix); !bytes.Equal(min, tc.min) {
+			t.Errorf("MinPathUnder(%x): expected %x, got %x", tc.prefix, tc.min, min)
+		}
+		if max := iter.MaxPathUnder(prefix); !bytes.Equal(max, tc.max) {
+			t.Errorf("MaxPathUnder(%x): expected %x, got %x", tc.prefix, tc.max, max)
+		}
+		if !bytes.Equal(prefix, tc.prefix) {
+			t.Errorf("prefix %x was modified: %x", tc.prefix, prefix)
+		}
+	}
+
+	// the paths of each bin lie between the bounds of its prefix, and before the next bin
+	paths := iter.MakePaths(nil, 32)
+	for i := 0; i+1 < len(paths); i++ {
+		min, max := iter.MinPathUnder(paths[i]), iter.MaxPathUnder(paths[i])
+		if bytes.Compare(paths[i], min) > 0 || bytes.Compare(min, max) > 0 {
+			t.Fatalf("bad bounds for %x: %x, %x", paths[i], min, max)
+		}
+		if bytes.Compare(max, paths[i+1]) >= 0 {
+			t.Fatalf("upper bound %x of %x is not before next bin %x", max, paths[i], paths[i+1])
+		}
+	}
+}
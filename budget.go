@@ -0,0 +1,95 @@
+package iterator
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ErrBudgetExceeded is returned by iterators which were stopped because their Budget ran out.
+var ErrBudgetExceeded = errors.New("traversal budget exceeded")
+
+// Budget limits the wall-clock time and number of nodes of a traversal, which may be shared by
+// many iterators. Once it is exhausted, every iterator bound to it stops with ErrBudgetExceeded, so
+// a tracked traversal can be saved and resumed later.
+type Budget struct {
+	deadline time.Time
+	maxNodes uint64
+	nodes    atomic.Uint64
+}
+
+// NewBudget returns a budget which runs out after maxDuration, or once maxNodes nodes have been
+// visited. A zero value disables the respective limit.
+func NewBudget(maxDuration time.Duration, maxNodes uint64) *Budget {
+	b := &Budget{maxNodes: maxNodes}
+	if maxDuration > 0 {
+		b.deadline = time.Now().Add(maxDuration)
+	}
+	return b
+}
+
+// Nodes returns the number of nodes visited so far under the budget.
+func (b *Budget) Nodes() uint64 {
+	return b.nodes.Load()
+}
+
+// spend charges a visited node to the budget, returning false if the budget is exhausted.
+func (b *Budget) spend() bool {
+	if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+		return false
+	}
+	if b.maxNodes == 0 {
+		b.nodes.Add(1)
+		return true
+	}
+	for {
+		n := b.nodes.Load()
+		if n >= b.maxNodes {
+			return false
+		}
+		if b.nodes.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// BudgetIterator is a NodeIterator which stops when its Budget is exhausted. Traverse binds the
+// iterator of every bin to a budget with WithBudget.
+type BudgetIterator struct {
+	trie.NodeIterator
+	budget *Budget
+	err    error
+}
+
+// NewBudgetIterator binds an iterator to a budget.
+func NewBudgetIterator(it trie.NodeIterator, budget *Budget) *BudgetIterator {
+	return &BudgetIterator{NodeIterator: it, budget: budget}
+}
+
+// Next moves to the next node, and charges it to the budget. Only nodes which are yielded are
+// charged, so an iterator which is exhausted within the budget does not fail. The node at which the
+// budget runs out is not yielded, so a tracked iterator resumes from it.
+func (it *BudgetIterator) Next(descend bool) bool {
+	if it.err != nil || !it.NodeIterator.Next(descend) {
+		return false
+	}
+	if !it.budget.spend() {
+		it.err = ErrBudgetExceeded
+		return false
+	}
+	return true
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *BudgetIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+func (it *BudgetIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
@@ -0,0 +1,116 @@
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestBudgetIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	t.Run("max nodes", func(t *testing.T) {
+		const maxNodes = 10
+		budget := iter.NewBudget(0, maxNodes)
+		iters, err := iter.SubtrieIterators(tree.NodeIterator, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var its []*iter.BudgetIterator
+		for _, it := range iters {
+			its = append(its, iter.NewBudgetIterator(it, budget))
+		}
+		// advance the iterators in turn until the shared budget stops them
+		count := 0
+		for running := true; running; {
+			running = false
+			for _, it := range its {
+				if it.Next(true) {
+					running = true
+					count++
+				}
+			}
+		}
+		if count != maxNodes {
+			t.Fatalf("expected %d nodes to be visited, got %d", maxNodes, count)
+		}
+		for _, it := range its {
+			if !errors.Is(it.Error(), iter.ErrBudgetExceeded) {
+				t.Fatalf("expected ErrBudgetExceeded, got %v", it.Error())
+			}
+		}
+	})
+
+	t.Run("exact budget", func(t *testing.T) {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		budget := iter.NewBudget(0, uint64(len(internal.FixtureNodePaths)))
+		it := iter.NewBudgetIterator(nodeit, budget)
+		count := 0
+		for it.Next(true) {
+			count++
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("expected a traversal within the budget to succeed, got %v", err)
+		}
+		if count != len(internal.FixtureNodePaths) || budget.Nodes() != uint64(count) {
+			t.Fatalf("expected %d nodes to be visited, got %d (charged %d)",
+				len(internal.FixtureNodePaths), count, budget.Nodes())
+		}
+	})
+
+	t.Run("max duration", func(t *testing.T) {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := iter.NewBudgetIterator(nodeit, iter.NewBudget(time.Nanosecond, 0))
+		time.Sleep(time.Millisecond)
+		if it.Next(true) {
+			t.Fatal("iterator advanced past deadline")
+		}
+		if !errors.Is(it.Error(), iter.ErrBudgetExceeded) {
+			t.Fatalf("expected ErrBudgetExceeded, got %v", it.Error())
+		}
+	})
+}
+
+func TestTraverseWithBudget(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	traverse := func(budget *iter.Budget) (int64, error) {
+		var visited atomic.Int64
+		err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, func(it trie.NodeIterator) error {
+			for it.Next(true) {
+				visited.Add(1)
+			}
+			return nil
+		}, iter.WithBudget(budget))
+		return visited.Load(), err
+	}
+	total, err := traverse(iter.NewBudget(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited, err := traverse(iter.NewBudget(0, uint64(total))); err != nil || visited != total {
+		t.Fatalf("expected %d nodes within the budget, got %d (%v)", total, visited, err)
+	}
+	visited, err := traverse(iter.NewBudget(0, 100))
+	if !errors.Is(err, iter.ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+	if visited != 100 {
+		t.Fatalf("expected 100 nodes to be visited, got %d", visited)
+	}
+}
@@ -0,0 +1,100 @@
+package iterator
+
+import (
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// BufferPolicy is the ownership of the buffers returned by the iterators passed to the visitor of
+// a traversal, by Path, LeafKey, LeafBlob and NodeBlob.
+type BufferPolicy uint8
+
+const (
+	// CopyBuffers returns a fresh copy from every call, which the visitor owns, and may retain or
+	// pass to other goroutines. This is the default.
+	CopyBuffers BufferPolicy = iota
+	// BorrowBuffers returns the iterator's own buffers, which geth reuses: they are only valid
+	// until the next call to Next, and must not be modified. This avoids a copy per call, for
+	// visitors which consume each node before moving on, or copy what they keep.
+	BorrowBuffers
+	// PoisonBuffers returns copies which are overwritten when the iterator moves on, to find
+	// visitors which are not safe with BorrowBuffers. See PoisonIterator.
+	PoisonBuffers
+)
+
+func (p BufferPolicy) String() string {
+	switch p {
+	case CopyBuffers:
+		return "copy"
+	case BorrowBuffers:
+		return "borrow"
+	case PoisonBuffers:
+		return "poison"
+	}
+	return "unknown"
+}
+
+// WithBufferPolicy sets the ownership of the buffers returned by the iterator of every bin.
+func WithBufferPolicy(policy BufferPolicy) TraverseOption {
+	return func(conf *traverseConfig) {
+		conf.buffers = policy
+	}
+}
+
+// lend wraps the iterators passed to the visitor according to the buffer policy.
+func (conf *traverseConfig) lend(iters []trie.NodeIterator) []trie.NodeIterator {
+	if conf.buffers == BorrowBuffers {
+		return iters
+	}
+	wrapped := make([]trie.NodeIterator, len(iters))
+	for i, it := range iters {
+		if conf.buffers == PoisonBuffers {
+			wrapped[i] = NewPoisonIterator(it)
+		} else {
+			wrapped[i] = NewCopyingIterator(it)
+		}
+	}
+	return wrapped
+}
+
+// CopyingIterator is a NodeIterator which returns a copy of the buffers of the wrapped iterator
+// from every call to Path, LeafKey, LeafBlob and NodeBlob, so that they stay valid after it moves.
+type CopyingIterator struct {
+	trie.NodeIterator
+}
+
+// NewCopyingIterator wraps an iterator to copy the buffers it returns.
+func NewCopyingIterator(it trie.NodeIterator) *CopyingIterator {
+	return &CopyingIterator{it}
+}
+
+// Path returns a copy of the current path.
+func (it *CopyingIterator) Path() []byte {
+	return copyBuffer(it.NodeIterator.Path())
+}
+
+// LeafKey returns a copy of the current leaf key.
+func (it *CopyingIterator) LeafKey() []byte {
+	return copyBuffer(it.NodeIterator.LeafKey())
+}
+
+// LeafBlob returns a copy of the current leaf value.
+func (it *CopyingIterator) LeafBlob() []byte {
+	return copyBuffer(it.NodeIterator.LeafBlob())
+}
+
+// NodeBlob returns a copy of the current node blob.
+func (it *CopyingIterator) NodeBlob() []byte {
+	return copyBuffer(it.NodeIterator.NodeBlob())
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *CopyingIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+func copyBuffer(buf []byte) []byte {
+	if buf == nil {
+		return nil
+	}
+	return append([]byte{}, buf...)
+}
@@ -0,0 +1,119 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestCopyingIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	base, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := iter.NewCopyingIterator(base)
+	var retained, copied [][]byte
+	for it.Next(true) {
+		path := it.Path()
+		retained = append(retained, path)
+		copied = append(copied, common.CopyBytes(path))
+		if it.Leaf() {
+			key, blob := it.LeafKey(), it.LeafBlob()
+			key[0] ^= 0xff
+			if bytes.Equal(key, it.LeafKey()) || !bytes.Equal(blob, it.LeafBlob()) {
+				t.Fatal("leaf buffers not copied")
+			}
+		}
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range retained {
+		if !bytes.Equal(path, copied[i]) {
+			t.Fatalf("retained path %x changed to %x", copied[i], path)
+		}
+	}
+}
+
+func TestTraverseBufferPolicy(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var expected [][]byte
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+		expected = append(expected, common.CopyBytes(it.Path()))
+	}
+
+	collect := func(opts ...iter.TraverseOption) ([][]byte, []trie.NodeIterator) {
+		var (
+			paths [][]byte
+			bins  []trie.NodeIterator
+			mu    sync.Mutex
+		)
+		visit := func(it trie.NodeIterator) error {
+			mu.Lock()
+			bins = append(bins, it)
+			mu.Unlock()
+			for it.Next(true) {
+				mu.Lock()
+				paths = append(paths, it.Path()) // retained without copying
+				mu.Unlock()
+			}
+			return it.Error()
+		}
+		err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, visit, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paths, bins
+	}
+
+	// by default, retained paths stay valid
+	paths, bins := collect()
+	if _, ok := bins[0].(*iter.CopyingIterator); !ok {
+		t.Fatalf("expected copying iterator, got %T", bins[0])
+	}
+	sort.Slice(paths, func(i, j int) bool { return bytes.Compare(paths[i], paths[j]) < 0 })
+	// the bound of each bin is yielded by both of its neighbours
+	var unique [][]byte
+	for _, path := range paths {
+		if len(unique) == 0 || !bytes.Equal(unique[len(unique)-1], path) {
+			unique = append(unique, path)
+		}
+	}
+	if len(unique) != len(expected) {
+		t.Fatalf("expected %d paths, got %d", len(expected), len(unique))
+	}
+	for i := range expected {
+		if !bytes.Equal(unique[i], expected[i]) {
+			t.Fatalf("expected path %x, got %x", expected[i], unique[i])
+		}
+	}
+
+	// borrowed buffers are the bin iterators' own
+	_, bins = collect(iter.WithBufferPolicy(iter.BorrowBuffers))
+	for _, it := range bins {
+		if _, ok := it.(*iter.PrefixBoundIterator); !ok {
+			t.Fatalf("expected unwrapped bin iterator, got %T", it)
+		}
+	}
+
+	if iter.CopyBuffers.String() != "copy" || iter.PoisonBuffers.String() != "poison" {
+		t.Fatal("unexpected policy names")
+	}
+}
@@ -0,0 +1,116 @@
+package iterator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ErrUnsupportedIterator is returned when an iterator does not support an operation which a
+// feature needs, e.g. it reports no bounds, or cannot produce leaf proofs once wrapped.
+var ErrUnsupportedIterator = errors.New("unsupported iterator")
+
+// Wrapper is implemented by iterators which wrap another NodeIterator, so that the capabilities of
+// the wrapped iterator can still be found. All the wrappers in this module implement it.
+type Wrapper interface {
+	Unwrap() trie.NodeIterator
+}
+
+type boundedIterator interface {
+	Bounds() ([]byte, []byte)
+}
+
+type sequencedIterator interface {
+	Sequence() (bin []byte, index uint64, ok bool)
+}
+
+type closingIterator interface {
+	Close()
+}
+
+func unsupported(it trie.NodeIterator, op string) error {
+	return fmt.Errorf("%w: %T does not support %s", ErrUnsupportedIterator, it, op)
+}
+
+// Bounds returns the start and end paths of a bounded iterator, such as PrefixBoundIterator,
+// looking through any wrappers around it. Returns ErrUnsupportedIterator if no iterator in the
+// chain has bounds.
+func Bounds(it trie.NodeIterator) (start, end []byte, err error) {
+	for inner := it; inner != nil; {
+		if bounded, ok := inner.(boundedIterator); ok {
+			start, end = bounded.Bounds()
+			return start, end, nil
+		}
+		wrapper, ok := inner.(Wrapper)
+		if !ok {
+			break
+		}
+		inner = wrapper.Unwrap()
+	}
+	return nil, nil, unsupported(it, "Bounds")
+}
+
+// Sequence returns the bin of the iterator's current node and the node's index in the bin, as
+// numbered by an iterator of a tracker made with tracker.WithSequence, looking through any wrappers
+// around it. Returns ErrUnsupportedIterator if no iterator in the chain numbers its nodes.
+func Sequence(it trie.NodeIterator) (bin []byte, index uint64, err error) {
+	for inner := it; inner != nil; {
+		if sequenced, ok := inner.(sequencedIterator); ok {
+			if bin, index, ok = sequenced.Sequence(); ok {
+				return bin, index, nil
+			}
+			break
+		}
+		wrapper, ok := inner.(Wrapper)
+		if !ok {
+			break
+		}
+		inner = wrapper.Unwrap()
+	}
+	return nil, 0, unsupported(it, "Sequence")
+}
+
+// LeafProof returns the proof of the leaf at the iterator's position, as NodeIterator.LeafProof
+// does, but returns an error rather than panicking if the iterator is not at a leaf. Returns
+// ErrUnsupportedIterator if the iterator produces no proof, as PrefetchIterator does.
+func LeafProof(it trie.NodeIterator) ([][]byte, error) {
+	if !it.Leaf() {
+		return nil, fmt.Errorf("iterator not at leaf: %x", it.Path())
+	}
+	proof := it.LeafProof()
+	if proof == nil {
+		return nil, unsupported(it, "LeafProof")
+	}
+	return proof, nil
+}
+
+// AddResolver adds a node resolver to an iterator, as NodeIterator.AddResolver does, but returns
+// ErrUnsupportedIterator rather than panicking if the iterator cannot use one, as geth's own
+// difference and union iterators cannot.
+func AddResolver(it trie.NodeIterator, resolver trie.NodeResolver) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w (%v)", unsupported(it, "AddResolver"), r)
+		}
+	}()
+	it.AddResolver(resolver)
+	return nil
+}
+
+// Close releases the resources of every iterator in the chain of wrappers which holds any, such as
+// the read-ahead goroutine of a PrefetchIterator, outermost first. A closed iterator is exhausted,
+// but still reports its position. Traverse closes each bin once it is visited, so a visitor which
+// stops early need not; an iterator used on its own must be closed by its owner unless exhausted.
+func Close(it trie.NodeIterator) {
+	for inner := it; inner != nil; {
+		if closing, ok := inner.(closingIterator); ok {
+			closing.Close()
+		}
+		wrapper, ok := inner.(Wrapper)
+		if !ok {
+			break
+		}
+		inner = wrapper.Unwrap()
+	}
+}
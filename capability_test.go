@@ -0,0 +1,57 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestCapabilities(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	open := func() trie.NodeIterator {
+		it, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+
+	t.Run("bounds", func(t *testing.T) {
+		bounded := iter.NewPrefixBoundIterator(open(), []byte{8})
+		wrapped := iter.NewContextIterator(context.Background(), iter.NewFilterIterator(bounded, iter.IsLeaf))
+		if _, end, err := iter.Bounds(wrapped); err != nil || !bytes.Equal(end, []byte{8}) {
+			t.Fatalf("expected bounds of wrapped iterator, got %x (err: %v)", end, err)
+		}
+		if _, _, err := iter.Bounds(iter.NewContextIterator(context.Background(), open())); !errors.Is(err, iter.ErrUnsupportedIterator) {
+			t.Fatalf("expected ErrUnsupportedIterator, got %v", err)
+		}
+	})
+
+	t.Run("leaf proof", func(t *testing.T) {
+		it := open()
+		it.Next(true)
+		if _, err := iter.LeafProof(it); err == nil || errors.Is(err, iter.ErrUnsupportedIterator) {
+			t.Fatalf("expected error at non-leaf, got %v", err)
+		}
+		for it.Next(true) && !it.Leaf() {
+		}
+		if proof, err := iter.LeafProof(it); err != nil || len(proof) == 0 {
+			t.Fatalf("expected leaf proof, got %d nodes (err: %v)", len(proof), err)
+		}
+
+		prefetched := iter.NewPrefetchIterator(open(), 8)
+		for prefetched.Next(true) && !prefetched.Leaf() {
+		}
+		if _, err := iter.LeafProof(prefetched); !errors.Is(err, iter.ErrUnsupportedIterator) {
+			t.Fatalf("expected ErrUnsupportedIterator, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+// Package classify tags the accounts of a state trie by their code, as externally owned accounts,
+// contracts, ERC-20-like tokens or EIP-1167 minimal proxies, so that the consumers of a traversal's
+// records don't each implement the same heuristics. A Classifier is safe for concurrent use, and is
+// shared by the bins of a traversal so that accounts are classified in parallel:
+//
+//	c := classify.New(db)
+//	visit := func(it trie.NodeIterator) error {
+//		for it.Next(true) {
+//			if !it.Leaf() {
+//				continue
+//			}
+//			account, err := c.AccountRecord(it)
+//			if err != nil {
+//				return err
+//			}
+//			...
+//		}
+//		return it.Error()
+//	}
+//
+// A contract is ERC-20-like if its code pushes the selector of each function of the standard's
+// interface, as the dispatcher of a compiled contract does. This is a heuristic: a contract may
+// have the selectors without implementing the standard, e.g. a router, and one which dispatches
+// calls in another way is missed.
+package classify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+
+	"github.com/cerc-io/eth-iterator-utils/record"
+)
+
+// DefaultCacheSize is the number of code hashes whose class is cached by default.
+const DefaultCacheSize = 4096
+
+// ErrMissingCode is returned for a contract whose code is not in the database.
+var ErrMissingCode = errors.New("contract code not found")
+
+// ERC20Selectors are the selectors of totalSupply(), balanceOf(address), transfer(address,uint256),
+// transferFrom(address,address,uint256), approve(address,uint256) and allowance(address,address).
+var ERC20Selectors = []uint32{0x18160ddd, 0x70a08231, 0xa9059cbb, 0x23b872dd, 0x095ea7b3, 0xdd62ed3e}
+
+// The code of an EIP-1167 minimal proxy is its implementation's address between these.
+var (
+	proxyPrefix = common.FromHex("0x363d3d373d3d3d363d73")
+	proxySuffix = common.FromHex("0x5af43d82803e903d91602b57fd5bf3")
+)
+
+// Option configures a Classifier.
+type Option func(*Classifier)
+
+// WithCacheSize sets the number of code hashes whose class is cached. Many accounts share code,
+// e.g. the clones of one proxy, so each class is worked out once.
+func WithCacheSize(n int) Option {
+	return func(c *Classifier) {
+		c.cacheSize = n
+	}
+}
+
+// Classifier tags accounts by their code, read from a database. It is safe for concurrent use.
+type Classifier struct {
+	db        ethdb.KeyValueReader
+	cacheSize int
+	cache     *lru.Cache[common.Hash, classified]
+}
+
+type classified struct {
+	class          record.AccountClass
+	implementation common.Address
+}
+
+// New returns a classifier of accounts whose code is read from db.
+func New(db ethdb.KeyValueReader, opts ...Option) *Classifier {
+	c := &Classifier{db: db, cacheSize: DefaultCacheSize}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.cacheSize < 1 {
+		c.cacheSize = 1
+	}
+	c.cache = lru.NewCache[common.Hash, classified](c.cacheSize)
+	return c
+}
+
+// AccountRecord returns the record of the account at the iterator's current leaf, classified.
+func (c *Classifier) AccountRecord(it trie.NodeIterator) (*record.AccountRecord, error) {
+	account, err := record.NewAccountRecord(it)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Classify(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Classify sets the class of an account record and, for a proxy, its implementation's address.
+func (c *Classifier) Classify(account *record.AccountRecord) error {
+	codeHash := common.BytesToHash(account.CodeHash)
+	if len(account.CodeHash) == 0 || codeHash == types.EmptyCodeHash {
+		account.Class, account.Implementation = record.ClassEOA, nil
+		return nil
+	}
+	result, ok := c.cache.Get(codeHash)
+	if !ok {
+		code := rawdb.ReadCode(c.db, codeHash)
+		if len(code) == 0 {
+			return fmt.Errorf("%w: account %x, code hash %x", ErrMissingCode, account.LeafKey, codeHash)
+		}
+		result.class, result.implementation = ClassifyCode(code)
+		c.cache.Add(codeHash, result)
+	}
+	account.Class, account.Implementation = result.class, nil
+	if result.class == record.ClassProxy {
+		account.Implementation = result.implementation.Bytes()
+	}
+	return nil
+}
+
+// ClassifyCode returns the class of a contract's code, and the implementation's address if it is
+// an EIP-1167 minimal proxy.
+func ClassifyCode(code []byte) (record.AccountClass, common.Address) {
+	if len(code) == len(proxyPrefix)+common.AddressLength+len(proxySuffix) &&
+		bytes.HasPrefix(code, proxyPrefix) && bytes.HasSuffix(code, proxySuffix) {
+		return record.ClassProxy, common.BytesToAddress(code[len(proxyPrefix) : len(proxyPrefix)+common.AddressLength])
+	}
+	pushed := pushedSelectors(code)
+	for _, selector := range ERC20Selectors {
+		if _, ok := pushed[selector]; !ok {
+			return record.ClassContract, common.Address{}
+		}
+	}
+	return record.ClassERC20, common.Address{}
+}
+
+// pushedSelectors returns the values of up to four bytes pushed by the code, skipping over the
+// data of each push so that it isn't read as instructions. A compiler pushes a selector with a
+// leading zero byte in fewer bytes.
+func pushedSelectors(code []byte) map[uint32]struct{} {
+	pushed := map[uint32]struct{}{}
+	for pc := 0; pc < len(code); pc++ {
+		op := vm.OpCode(code[pc])
+		if op < vm.PUSH1 || op > vm.PUSH32 {
+			continue
+		}
+		n := int(op-vm.PUSH1) + 1
+		if n <= 4 && pc+n < len(code) {
+			var value uint32
+			for _, b := range code[pc+1 : pc+1+n] {
+				value = value<<8 | uint32(b)
+			}
+			pushed[value] = struct{}{}
+		}
+		pc += n
+	}
+	return pushed
+}
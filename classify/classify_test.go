@@ -0,0 +1,150 @@
+package classify_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+
+	"github.com/cerc-io/eth-iterator-utils/classify"
+	"github.com/cerc-io/eth-iterator-utils/record"
+)
+
+// dispatcher returns code comparing the call's selector with each of the given ones, as a
+// compiled contract does.
+func dispatcher(selectors ...uint32) []byte {
+	code := []byte{byte(vm.PUSH1), 0xe0, byte(vm.CALLDATALOAD), byte(vm.SHR)}
+	for _, selector := range selectors {
+		code = append(code, byte(vm.DUP1))
+		if selector>>24 == 0 {
+			code = append(code, byte(vm.PUSH3), byte(selector>>16), byte(selector>>8), byte(selector))
+		} else {
+			code = append(code, byte(vm.PUSH4))
+			code = binary.BigEndian.AppendUint32(code, selector)
+		}
+		code = append(code, byte(vm.EQ), byte(vm.PUSH1), 0x00, byte(vm.JUMPI))
+	}
+	return append(code, byte(vm.STOP))
+}
+
+func TestClassifyCode(t *testing.T) {
+	impl := common.HexToAddress("0xbebebebebebebebebebebebebebebebebebebebe")
+	proxy := append(append(common.FromHex("0x363d3d373d3d3d363d73"), impl.Bytes()...),
+		common.FromHex("0x5af43d82803e903d91602b57fd5bf3")...)
+	// the selectors are only data of a PUSH32, not pushed themselves
+	hidden := []byte{byte(vm.PUSH32)}
+	for _, selector := range classify.ERC20Selectors {
+		hidden = binary.BigEndian.AppendUint32(hidden, selector)
+	}
+	hidden = append(append(hidden, make([]byte, 33-len(hidden))...), byte(vm.POP))
+
+	for _, test := range []struct {
+		name  string
+		code  []byte
+		class record.AccountClass
+	}{
+		{"token", dispatcher(append([]uint32{0x06fdde03}, classify.ERC20Selectors...)...), record.ClassERC20},
+		{"token with short selector", dispatcher(append([]uint32{0x00fdde03}, classify.ERC20Selectors...)...), record.ClassERC20},
+		{"partial interface", dispatcher(classify.ERC20Selectors[1:]...), record.ClassContract},
+		{"selectors in push data", hidden, record.ClassContract},
+		{"truncated push", append(dispatcher(classify.ERC20Selectors[1:]...), byte(vm.PUSH4), 0x18, 0x16), record.ClassContract},
+		{"proxy", proxy, record.ClassProxy},
+		{"proxy with extra code", append(proxy, byte(vm.STOP)), record.ClassContract},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			class, addr := classify.ClassifyCode(test.code)
+			if class != test.class {
+				t.Fatalf("expected %v, got %v", test.class, class)
+			}
+			if class == record.ClassProxy && addr != impl {
+				t.Fatalf("expected implementation %x, got %x", impl, addr)
+			}
+		})
+	}
+}
+
+// countingReader counts the reads of a database.
+type countingReader struct {
+	ethdb.KeyValueReader
+	reads int
+}
+
+func (r *countingReader) Get(key []byte) ([]byte, error) {
+	r.reads++
+	return r.KeyValueReader.Get(key)
+}
+
+func TestClassifier(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	token := dispatcher(classify.ERC20Selectors...)
+	contract := dispatcher(classify.ERC20Selectors[:2]...)
+	for _, code := range [][]byte{token, contract} {
+		rawdb.WriteCode(db, crypto.Keccak256Hash(code), code)
+	}
+	accounts := map[common.Hash]*types.StateAccount{
+		common.HexToHash("0x01"): {Balance: uint256.NewInt(1), Root: types.EmptyRootHash, CodeHash: types.EmptyCodeHash.Bytes()},
+		common.HexToHash("0x02"): {Root: types.EmptyRootHash, CodeHash: crypto.Keccak256(token)},
+		common.HexToHash("0x03"): {Root: types.EmptyRootHash, CodeHash: crypto.Keccak256(token)},
+		common.HexToHash("0x04"): {Root: types.EmptyRootHash, CodeHash: crypto.Keccak256(contract)},
+	}
+	expected := map[common.Hash]record.AccountClass{
+		common.HexToHash("0x01"): record.ClassEOA,
+		common.HexToHash("0x02"): record.ClassERC20,
+		common.HexToHash("0x03"): record.ClassERC20,
+		common.HexToHash("0x04"): record.ClassContract,
+	}
+	tree := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for key, account := range accounts {
+		blob, err := rlp.EncodeToBytes(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.MustUpdate(key.Bytes(), blob)
+	}
+
+	reader := &countingReader{KeyValueReader: db}
+	c := classify.New(reader)
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		account, err := c.AccountRecord(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if class := expected[common.BytesToHash(it.LeafKey())]; account.Class != class {
+			t.Fatalf("account %x: expected %v, got %v", it.LeafKey(), class, account.Class)
+		}
+		n++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(accounts) {
+		t.Fatalf("expected %d accounts, got %d", len(accounts), n)
+	}
+	// the code shared by two accounts is read once
+	if reader.reads != 2 {
+		t.Fatalf("expected 2 code reads, got %d", reader.reads)
+	}
+
+	missing := &record.AccountRecord{CodeHash: crypto.Keccak256([]byte{byte(vm.STOP)})}
+	if err := c.Classify(missing); !errors.Is(err, classify.ErrMissingCode) {
+		t.Fatalf("expected ErrMissingCode, got %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// benchMethods are the ways of reading the accounts of a state which bench compares. Each one
+// decodes every account, and none reads storage or code.
+var benchMethods = []string{"dump", "snapshot", "parallel"}
+
+type benchConfig struct {
+	datadir, ancient string
+	block            int64
+	bins, workers    uint
+	runs             uint
+	methods          string
+	output           string
+}
+
+func (conf *benchConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("trie-iterate bench", flag.ContinueOnError)
+	fs.StringVar(&conf.datadir, "datadir", "", "chaindata directory (required)")
+	fs.StringVar(&conf.ancient, "ancient", "", "ancient store directory (default <datadir>/ancient)")
+	fs.Int64Var(&conf.block, "block", -1, "block number of the state to read (default head)")
+	fs.UintVar(&conf.bins, "bins", 16, "number of subtries to divide the trie into, for the parallel traversal")
+	fs.UintVar(&conf.workers, "workers", uint(runtime.NumCPU()), "number of subtries to traverse concurrently")
+	fs.UintVar(&conf.runs, "runs", 3, "number of times to run each method; the median is reported")
+	fs.StringVar(&conf.methods, "methods", strings.Join(benchMethods, ","), "comma-separated methods to run: dump, snapshot and parallel")
+	fs.StringVar(&conf.output, "output", "table", "output format: table (aligned columns) or json (a single object)")
+	return fs
+}
+
+func parseBenchFlags(args []string) (*benchConfig, []string, error) {
+	var conf benchConfig
+	if err := conf.flagSet().Parse(args); err != nil {
+		return nil, nil, &usageError{err}
+	}
+	flags := commonFlags{datadir: conf.datadir, ancient: conf.ancient, output: conf.output}
+	if err := flags.checkOutput(); err != nil {
+		return nil, nil, &usageError{err}
+	}
+	if err := flags.check(); err != nil {
+		return nil, nil, &usageError{err}
+	}
+	conf.ancient = flags.ancient
+	if conf.runs == 0 || conf.bins == 0 || conf.workers == 0 {
+		return nil, nil, &usageError{errors.New("-runs, -bins and -workers must be positive")}
+	}
+	var methods []string
+	for _, method := range strings.Split(conf.methods, ",") {
+		known := false
+		for _, m := range benchMethods {
+			known = known || m == method
+		}
+		if !known {
+			return nil, nil, &usageError{fmt.Errorf("unknown method %q: expected dump, snapshot or parallel", method)}
+		}
+		methods = append(methods, method)
+	}
+	return &conf, methods, nil
+}
+
+// benchResult is the median run of a method.
+type benchResult struct {
+	Method   string        `json:"method"`
+	Accounts uint64        `json:"accounts"`
+	Wall     time.Duration `json:"wallNanos"`
+	// CPU is the user and system time of the process during the run.
+	CPU time.Duration `json:"cpuNanos"`
+	// Alloc is the number of bytes allocated on the heap during the run.
+	Alloc uint64 `json:"allocBytes"`
+	// Speedup is the wall time of dump divided by that of the method, if dump was run.
+	Speedup float64 `json:"speedup,omitempty"`
+	// Error is why the method could not be run, e.g. the datadir has no snapshot of the state.
+	Error string `json:"error,omitempty"`
+}
+
+type benchReport struct {
+	Root       common.Hash   `json:"root"`
+	Bins       uint          `json:"bins"`
+	Workers    uint          `json:"workers"`
+	Runs       uint          `json:"runs"`
+	GOMAXPROCS int           `json:"gomaxprocs"`
+	MaxRSS     uint64        `json:"maxRSSBytes,omitempty"`
+	Results    []benchResult `json:"results"`
+}
+
+// runBench reads the accounts of a state with each method in turn, reporting the median wall
+// time and resources of each, and the speedup against state.Dump. Runs of the methods are
+// interleaved, so that each sees a similarly warm cache.
+func runBench(ctx context.Context, args []string, stdout io.Writer) error {
+	conf, methods, err := parseBenchFlags(args)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(&commonFlags{datadir: conf.datadir, ancient: conf.ancient})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	root, err := stateRoot(db, conf.block)
+	if err != nil {
+		return err
+	}
+	tdb := iter.OpenTrieDB(db)
+	defer tdb.Close()
+
+	runs := make(map[string][]benchResult)
+	for r := uint(0); r < conf.runs; r++ {
+		for _, method := range methods {
+			res, err := benchRun(ctx, conf, method, db, tdb, root)
+			if err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				res = benchResult{Method: method, Error: err.Error()}
+			}
+			runs[method] = append(runs[method], res)
+		}
+	}
+
+	report := benchReport{
+		Root: root, Bins: conf.bins, Workers: conf.workers, Runs: conf.runs,
+		GOMAXPROCS: runtime.GOMAXPROCS(0), MaxRSS: maxRSS(),
+	}
+	for _, method := range methods {
+		report.Results = append(report.Results, median(runs[method]))
+	}
+	for _, base := range report.Results {
+		if base.Method != "dump" || base.Error != "" {
+			continue
+		}
+		for i, res := range report.Results {
+			if res.Error == "" && res.Wall > 0 {
+				report.Results[i].Speedup = float64(base.Wall) / float64(res.Wall)
+			}
+		}
+	}
+	if err := checkAccounts(report.Results); err != nil {
+		return err
+	}
+	if conf.output == "json" {
+		return json.NewEncoder(stdout).Encode(report)
+	}
+	return report.writeTable(stdout)
+}
+
+// median returns the run with the median wall time, or the first failed run.
+func median(runs []benchResult) benchResult {
+	for _, res := range runs {
+		if res.Error != "" {
+			return res
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Wall < runs[j].Wall })
+	return runs[len(runs)/2]
+}
+
+// checkAccounts verifies that the methods which ran read the same number of accounts, as a
+// benchmark of methods reading different states is meaningless.
+func checkAccounts(results []benchResult) error {
+	var first *benchResult
+	for i := range results {
+		res := &results[i]
+		if res.Error != "" {
+			continue
+		}
+		if first == nil {
+			first = res
+		} else if res.Accounts != first.Accounts {
+			return fmt.Errorf("%s read %d accounts, but %s read %d", res.Method, res.Accounts, first.Method, first.Accounts)
+		}
+	}
+	return nil
+}
+
+func (report *benchReport) writeTable(out io.Writer) error {
+	fmt.Fprintf(out, "root %x, %d bins, %d workers, GOMAXPROCS %d, median of %d runs\n",
+		report.Root, report.Bins, report.Workers, report.GOMAXPROCS, report.Runs)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "method\taccounts\twall\tcpu\tcpu/wall\talloc MiB\taccounts/s\tspeedup\t")
+	var failed []benchResult
+	for _, res := range report.Results {
+		if res.Error != "" {
+			failed = append(failed, res)
+			continue
+		}
+		speedup := "-"
+		if res.Speedup != 0 {
+			speedup = fmt.Sprintf("%.2fx", res.Speedup)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%v\t%v\t%.2f\t%.1f\t%.0f\t%s\t\n", res.Method, res.Accounts,
+			res.Wall.Round(time.Millisecond), res.CPU.Round(time.Millisecond),
+			res.CPU.Seconds()/res.Wall.Seconds(), float64(res.Alloc)/(1<<20),
+			float64(res.Accounts)/res.Wall.Seconds(), speedup)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, res := range failed {
+		fmt.Fprintf(out, "%s not run: %s\n", res.Method, res.Error)
+	}
+	if report.MaxRSS != 0 {
+		fmt.Fprintf(out, "peak RSS of all runs: %.1f MiB\n", float64(report.MaxRSS)/(1<<20))
+	}
+	return nil
+}
+
+// benchRun reads the accounts with a method, measuring its wall and CPU time and allocations.
+func benchRun(
+	ctx context.Context, conf *benchConfig, method string, db ethdb.Database, tdb *triedb.Database,
+	root common.Hash,
+) (benchResult, error) {
+	var read func() (uint64, error)
+	switch method {
+	case "dump":
+		read = func() (uint64, error) { return dumpAccounts(db, tdb, root) }
+	case "snapshot":
+		read = func() (uint64, error) { return snapshotAccounts(ctx, db, tdb, root) }
+	case "parallel":
+		read = func() (uint64, error) { return traverseAccounts(ctx, conf, db, root) }
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cpu := cpuTime()
+	start := time.Now()
+	accounts, err := read()
+	wall := time.Since(start)
+	cpu = cpuTime() - cpu
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return benchResult{}, err
+	}
+	return benchResult{
+		Method: method, Accounts: accounts, Wall: wall, CPU: cpu, Alloc: after.TotalAlloc - before.TotalAlloc,
+	}, nil
+}
+
+// dumpCounter is a state.DumpCollector which counts the accounts.
+type dumpCounter struct {
+	accounts uint64
+}
+
+func (c *dumpCounter) OnRoot(common.Hash)                           {}
+func (c *dumpCounter) OnAccount(*common.Address, state.DumpAccount) { c.accounts++ }
+
+// dumpAccounts reads the accounts with geth's single-threaded state.Dump, which also looks up the
+// preimage of each account's key.
+func dumpAccounts(db ethdb.Database, tdb *triedb.Database, root common.Hash) (uint64, error) {
+	statedb, err := state.New(root, state.NewDatabaseWithNodeDB(db, tdb), nil)
+	if err != nil {
+		return 0, err
+	}
+	var c dumpCounter
+	statedb.DumpToCollector(&c, &state.DumpConfig{SkipCode: true, SkipStorage: true})
+	return c.accounts, nil
+}
+
+// snapshotAccounts reads the accounts from the datadir's state snapshot, which must be of the
+// root, as it is not generated.
+func snapshotAccounts(ctx context.Context, db ethdb.Database, tdb *triedb.Database, root common.Hash) (uint64, error) {
+	if rawdb.ReadSnapshotRoot(db) != root {
+		return 0, errors.New("no snapshot of the state")
+	}
+	snaps, err := snapshot.New(snapshot.Config{CacheSize: 256, NoBuild: true}, db, tdb, root)
+	if err != nil {
+		return 0, err
+	}
+	defer snaps.Release()
+	it, err := snaps.AccountIterator(root, common.Hash{})
+	if err != nil {
+		return 0, err
+	}
+	defer it.Release()
+	var accounts uint64
+	for it.Next() {
+		if _, err := types.FullAccount(it.Account()); err != nil {
+			return 0, err
+		}
+		if accounts++; accounts%10000 == 0 && ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+	return accounts, it.Error()
+}
+
+// traverseAccounts reads the accounts with a parallel traversal of the state trie.
+func traverseAccounts(ctx context.Context, conf *benchConfig, db ethdb.Database, root common.Hash) (uint64, error) {
+	makeIterator, tdb, err := iter.OpenTrieConstructor(db, trie.StateTrieID(root))
+	if err != nil {
+		return 0, err
+	}
+	defer tdb.Close()
+	// each node is yielded once, even where bins meet
+	iters, err := iter.SubtrieIteratorsDedup(makeIterator, conf.bins)
+	if err != nil {
+		return 0, err
+	}
+	var accounts atomic.Uint64
+	err = iter.TraverseIterators(ctx, iters, conf.workers, func(it trie.NodeIterator) error {
+		var account types.StateAccount
+		for it.Next(true) {
+			if !it.Leaf() {
+				continue
+			}
+			if err := rlp.DecodeBytes(it.LeafBlob(), &account); err != nil {
+				return err
+			}
+			accounts.Add(1)
+		}
+		return it.Error()
+	}, iter.WithBufferPolicy(iter.BorrowBuffers))
+	return accounts.Load(), err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	var out bytes.Buffer
+	args := append([]string{"bench"}, fixtureArgs("-runs", "1", "-workers", "4", "-output", "json")...)
+	if err := run(context.Background(), args, &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	var report benchReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != len(benchMethods) {
+		t.Fatalf("expected results of %d methods, got %v", len(benchMethods), report.Results)
+	}
+	for _, res := range report.Results {
+		if res.Method == "snapshot" {
+			continue // the fixture has no snapshot of the block
+		}
+		if res.Error != "" || res.Accounts == 0 || res.Speedup == 0 {
+			t.Errorf("%s: expected accounts and speedup, got %+v", res.Method, res)
+		}
+	}
+
+	out.Reset()
+	args = append([]string{"bench"}, fixtureArgs("-runs", "1", "-methods", "dump,parallel")...)
+	if err := run(context.Background(), args, &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "speedup") || !strings.Contains(out.String(), "parallel") {
+		t.Fatalf("expected a table of results, got\n%s", out.String())
+	}
+
+	var usage *usageError
+	args = append([]string{"bench"}, fixtureArgs("-methods", "dump,trie")...)
+	if err := run(context.Background(), args, &out, nil); !errors.As(err, &usage) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
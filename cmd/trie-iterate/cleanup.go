@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+type cleanupConfig struct {
+	dir       string
+	olderThan time.Duration
+	dryRun    bool
+	output    string
+}
+
+func (conf *cleanupConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("trie-iterate cleanup", flag.ContinueOnError)
+	fs.StringVar(&conf.dir, "dir", "", "directory of the recovery files of runs (required)")
+	fs.DurationVar(&conf.olderThan, "older-than", 7*24*time.Hour, "remove the artifacts of runs not saved for this long")
+	fs.BoolVar(&conf.dryRun, "dry-run", false, "only list the stale artifacts")
+	fs.StringVar(&conf.output, "output", "table", "output format: table (a path per line) or json (an object per line)")
+	return fs
+}
+
+func parseCleanupFlags(args []string) (*cleanupConfig, error) {
+	var conf cleanupConfig
+	if err := conf.flagSet().Parse(args); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.dir == "" {
+		return nil, &usageError{errors.New("-dir is required")}
+	}
+	if conf.olderThan <= 0 {
+		return nil, &usageError{errors.New("-older-than must be positive")}
+	}
+	flags := commonFlags{output: conf.output}
+	if err := flags.checkOutput(); err != nil {
+		return nil, &usageError{err}
+	}
+	return &conf, nil
+}
+
+// runCleanup removes the stale recovery artifacts of the runs in a directory, as named by
+// tracker.NewForRun, and writes their paths. Runs held by a live tracker are kept.
+func runCleanup(args []string, stdout io.Writer) error {
+	conf, err := parseCleanupFlags(args)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	if conf.dryRun {
+		paths, err = tracker.FindStale(conf.dir, conf.olderThan)
+	} else {
+		paths, err = tracker.Cleanup(conf.dir, conf.olderThan)
+	}
+	// list what was removed before a failure
+	enc := json.NewEncoder(stdout)
+	for _, path := range paths {
+		var werr error
+		if conf.output == "json" {
+			werr = enc.Encode(struct {
+				Path    string `json:"path"`
+				Removed bool   `json:"removed"`
+			}{path, !conf.dryRun})
+		} else {
+			_, werr = fmt.Fprintln(stdout, path)
+		}
+		if werr != nil {
+			return werr
+		}
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestCleanup(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	stale := filepath.Join(dir, tracker.RecoveryFileName(common.HexToHash("0x01"), "1"))
+	live := filepath.Join(dir, tracker.RecoveryFileName(common.HexToHash("0x01"), "2"))
+	// the lock and seek index of the stale run are removed with it
+	staleFiles := []string{stale, stale + ".lock", tracker.SeekIndexFile(stale)}
+	for _, path := range append([]string{live, tracker.SeekIndexFile(live)}, staleFiles...) {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range staleFiles {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed := strings.Join(staleFiles, "\n") + "\n"
+
+	var out bytes.Buffer
+	args := []string{"cleanup", "-dir", dir, "-older-than", "24h"}
+	if err := run(context.Background(), append(args, "-dry-run"), &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != listed {
+		t.Fatalf("expected dry run to list %q, got %q", listed, out.String())
+	}
+	for _, path := range staleFiles {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("dry run removed stale file: %v", err)
+		}
+	}
+
+	out.Reset()
+	if err := run(context.Background(), append(args, "-output", "json"), &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&out)
+	for _, path := range staleFiles {
+		var removed struct {
+			Path    string `json:"path"`
+			Removed bool   `json:"removed"`
+		}
+		if err := dec.Decode(&removed); err != nil {
+			t.Fatal(err)
+		}
+		if removed.Path != path || !removed.Removed {
+			t.Fatalf("unexpected output %+v, expected %s to be removed", removed, path)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected stale file to be removed, got %v", err)
+		}
+	}
+	for _, path := range []string{live, tracker.SeekIndexFile(live)} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected recent file to be kept: %v", err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"cleanup"},
+		{"cleanup", "-dir", dir, "-older-than", "0"},
+		{"cleanup", "-dir", dir, "-output", "csv"},
+	} {
+		var usage *usageError
+		if err := run(context.Background(), args, nil, nil); !errors.As(err, &usage) {
+			t.Errorf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+}
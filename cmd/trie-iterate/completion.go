@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var (
+	shells = []string{"bash", "zsh", "fish"}
+
+	// flags completed with directory and file names, rather than from a list of values
+	dirFlags  = map[string]bool{"datadir": true, "ancient": true, "dir": true}
+	fileFlags = map[string]bool{"out": true, "recovery": true, "manifest": true, "keys": true}
+)
+
+// runCompletion writes the completion script for a shell.
+func runCompletion(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return &usageError{fmt.Errorf("usage: trie-iterate completion %s", strings.Join(shells, "|"))}
+	}
+	iterateFlags, resumeFlags := (&config{}).flagSet(), (&resumeConfig{}).flagSet()
+	benchFlags, cleanupFlags := (&benchConfig{}).flagSet(), (&cleanupConfig{}).flagSet()
+	patchFlags, verifyFlags := (&patchConfig{}).flagSet(), (&verifyConfig{}).flagSet()
+	var script string
+	switch args[0] {
+	case "bash":
+		script = bashCompletion(iterateFlags, resumeFlags, benchFlags, patchFlags, cleanupFlags, verifyFlags)
+	case "zsh":
+		script = "autoload -U +X bashcompinit && bashcompinit\n" +
+			bashCompletion(iterateFlags, resumeFlags, benchFlags, patchFlags, cleanupFlags, verifyFlags)
+	case "fish":
+		script = fishCompletion(iterateFlags, resumeFlags, benchFlags, patchFlags, cleanupFlags, verifyFlags)
+	default:
+		return &usageError{fmt.Errorf("unknown shell %q: expected one of %s", args[0], strings.Join(shells, ", "))}
+	}
+	_, err := io.WriteString(stdout, script)
+	return err
+}
+
+func flagNames(fs *flag.FlagSet) string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+	})
+	return strings.Join(names, " ")
+}
+
+// flagPatterns returns a bash case pattern matching the named flags, with one or two dashes.
+func flagPatterns(names map[string]bool) string {
+	var patterns []string
+	for name := range names {
+		patterns = append(patterns, "-"+name, "--"+name)
+	}
+	sort.Strings(patterns) // for a stable script
+	return strings.Join(patterns, "|")
+}
+
+func bashCompletion(iterateFlags, resumeFlags, benchFlags, patchFlags, cleanupFlags, verifyFlags *flag.FlagSet) string {
+	return fmt.Sprintf(`_trie_iterate() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} words
+	case $prev in
+	-output|--output) COMPREPLY=($(compgen -W "%s" -- "$cur")); return ;;
+	%s) COMPREPLY=($(compgen -d -- "$cur")); return ;;
+	%s) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+	esac
+	case ${COMP_WORDS[1]} in
+	resume) words="%s" ;;
+	bench) words="%s" ;;
+	patch) words="%s" ;;
+	cleanup) words="%s" ;;
+	verify-manifest) words="%s" ;;
+	completion) words="%s" ;;
+	*) words="%s"; [[ $COMP_CWORD == 1 ]] && words="resume bench patch cleanup verify-manifest completion $words" ;;
+	esac
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -F _trie_iterate trie-iterate
+`, strings.Join(outputFormats, " "), flagPatterns(dirFlags), flagPatterns(fileFlags),
+		flagNames(resumeFlags), flagNames(benchFlags), flagNames(patchFlags), flagNames(cleanupFlags),
+		flagNames(verifyFlags), strings.Join(shells, " "), flagNames(iterateFlags))
+}
+
+func fishCompletion(iterateFlags, resumeFlags, benchFlags, patchFlags, cleanupFlags, verifyFlags *flag.FlagSet) string {
+	var b strings.Builder
+	b.WriteString("complete -c trie-iterate -f\n")
+	b.WriteString("complete -c trie-iterate -n __fish_use_subcommand -a 'resume bench patch cleanup verify-manifest completion'\n")
+	fmt.Fprintf(&b, "complete -c trie-iterate -n '__fish_seen_subcommand_from completion' -a '%s'\n",
+		strings.Join(shells, " "))
+	for _, set := range []struct {
+		condition string
+		flags     *flag.FlagSet
+	}{
+		{"not __fish_seen_subcommand_from resume bench patch cleanup verify-manifest completion", iterateFlags},
+		{"__fish_seen_subcommand_from resume", resumeFlags},
+		{"__fish_seen_subcommand_from bench", benchFlags},
+		{"__fish_seen_subcommand_from patch", patchFlags},
+		{"__fish_seen_subcommand_from cleanup", cleanupFlags},
+		{"__fish_seen_subcommand_from verify-manifest", verifyFlags},
+	} {
+		set.flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(&b, "complete -c trie-iterate -n '%s' -o %s -d '%s'",
+				set.condition, f.Name, strings.ReplaceAll(f.Usage, "'", `\'`))
+			if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !boolFlag.IsBoolFlag() {
+				switch {
+				case f.Name == "output":
+					fmt.Fprintf(&b, " -r -a '%s'", strings.Join(outputFormats, " "))
+				case dirFlags[f.Name]:
+					b.WriteString(" -r -a '(__fish_complete_directories)'")
+				case fileFlags[f.Name]:
+					b.WriteString(" -r -F")
+				default:
+					b.WriteString(" -r")
+				}
+			}
+			b.WriteString("\n")
+		})
+	}
+	return b.String()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range shells {
+		var out bytes.Buffer
+		if err := run(context.Background(), []string{"completion", shell}, &out, nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, word := range []string{
+			"trie-iterate", "resume", "bench", "patch", "cleanup", "verify-manifest", "older-than", "methods", "datadir", "inspect", "json",
+		} {
+			if !strings.Contains(out.String(), word) {
+				t.Errorf("%s completion does not mention %s", shell, word)
+			}
+		}
+	}
+	var usage *usageError
+	if err := run(context.Background(), []string{"completion", "tcsh"}, nil, nil); !errors.As(err, &usage) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
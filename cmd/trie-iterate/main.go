@@ -0,0 +1,428 @@
+// Command trie-iterate traverses the state trie of a geth chaindata directory in parallel, writing
+// the nodes it visits as tab-separated lines:
+//
+//	node	<path>	<hash>
+//	leaf	<path>	<leaf key>
+//
+// Paths are in hex, one byte per nibble, as returned by NodeIterator.Path. Bins run concurrently,
+// so nodes from different bins are interleaved. E.g.
+//
+//	trie-iterate -datadir ~/.ethereum/geth/chaindata -block 1000000 -bins 64 -workers 8 \
+//		-leaves -recovery iterate.csv > leaves.tsv
+//
+// With -recovery, the state of an interrupted traversal (e.g. by SIGINT) is saved to the file. The
+// resume subcommand reports the saved state, and continues the traversal from it:
+//
+//	trie-iterate resume -datadir ~/.ethereum/geth/chaindata -recovery iterate.csv >> leaves.tsv
+//
+// A recovery file written by a version of the tracker in an incompatible format is refused, unless
+// resumed with -force-migrate.
+//
+// With -output json, nodes are written as one JSON object per line instead, with the fields kind,
+// path and hash or key, and the resume report as a single object.
+//
+// With -summary, the outcome of the traversal and the resources it used are written to stderr once
+// it stops: its wall and CPU time, the process's peak RSS, GC cycles, the reads from the database's
+// key-value store and the most goroutines running, as a single object with -output json.
+//
+// With -manifest, a manifest of the run is written to the file when the traversal stops, as a JSON
+// record.RunManifest listing the -out file with its size and SHA-256 digest. A resumed run updates
+// the manifest. The verify-manifest subcommand checks the files listed by a manifest, e.g. copies
+// of them on another host, which may be fetched over http(s):
+//
+//	trie-iterate verify-manifest -manifest https://exports.example.org/run1/manifest.json
+//
+// The exit status tells schedulers the outcome without parsing the logs:
+//
+//	0	the traversal completed
+//	1	fatal error
+//	2	invalid arguments
+//	3	the traversal was interrupted, or stopped by -max-nodes or -max-duration, and its state
+//		saved for resume
+//
+// The bench subcommand compares the parallel traversal with geth's single-threaded state.Dump
+// and, if the datadir has a snapshot of the state, snapshot iteration, reporting the median wall
+// and CPU time, allocations and speedup of each over a number of runs, e.g.
+//
+//	trie-iterate bench -datadir ~/.ethereum/geth/chaindata -bins 256 -workers 16 -runs 5
+//
+// The patch subcommand re-exports the leaves with the keys listed in a file, e.g. the rows of an
+// export reported bad by downstream validation, traversing only the paths to them rather than the
+// bins holding them. The leaves are written as by -leaves, and the keys which are not in the trie
+// are reported to stderr:
+//
+//	trie-iterate patch -datadir ~/.ethereum/geth/chaindata -block 1000000 -keys bad-keys.txt > patch.tsv
+//
+// The cleanup subcommand removes the recovery files of runs in a directory, as named by
+// tracker.NewForRun, which were not saved for a week or -older-than, with their lock files and seek
+// indexes, listing the removed files.
+// Runs whose recovery file is locked by a live tracker are kept, and -dry-run only lists them:
+//
+//	trie-iterate cleanup -dir /var/lib/jobs/recovery -older-than 72h
+//
+// The completion subcommand writes a completion script for bash, zsh or fish, e.g.
+//
+//	source <(trie-iterate completion bash)
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "trie-iterate:", err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes of the command.
+const (
+	exitCompleted   = 0
+	exitFatal       = 1
+	exitUsage       = 2
+	exitInterrupted = 3
+)
+
+// exitCode returns the exit code for the error returned by run.
+func exitCode(err error) int {
+	var usage *usageError
+	var saved *savedError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitCompleted
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &saved) && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, iter.ErrBudgetExceeded)):
+		return exitInterrupted
+	}
+	return exitFatal
+}
+
+// usageError is an error in the arguments of the command.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// savedError is an error of a traversal whose state was saved to the recovery file.
+type savedError struct {
+	err      error
+	recovery string
+}
+
+func (e *savedError) Error() string { return fmt.Sprintf("%v (state saved to %s)", e.err, e.recovery) }
+func (e *savedError) Unwrap() error { return e.err }
+
+// run runs the command, or a subcommand named by the first argument.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "resume":
+			return runResume(ctx, args[1:], stdout, stderr)
+		case "bench":
+			return runBench(ctx, args[1:], stdout)
+		case "patch":
+			return runPatch(ctx, args[1:], stdout, stderr)
+		case "cleanup":
+			return runCleanup(args[1:], stdout)
+		case "verify-manifest":
+			return runVerify(ctx, args[1:], stdout)
+		case "completion":
+			return runCompletion(args[1:], stdout)
+		}
+	}
+	return runIterate(ctx, args, stdout, stderr)
+}
+
+// commonFlags are the flags of both the command and the resume subcommand.
+type commonFlags struct {
+	datadir, ancient string
+	workers          uint
+	leaves           bool
+	out, recovery    string
+	manifest         string
+	output           string
+	maxNodes         uint64
+	maxDuration      time.Duration
+	summary          bool
+}
+
+func (conf *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&conf.datadir, "datadir", "", "chaindata directory (required)")
+	fs.StringVar(&conf.ancient, "ancient", "", "ancient store directory (default <datadir>/ancient)")
+	fs.UintVar(&conf.workers, "workers", 4, "number of subtries to traverse concurrently")
+	fs.BoolVar(&conf.leaves, "leaves", false, "only write leaves")
+	fs.StringVar(&conf.out, "out", "", "output file (default stdout)")
+	fs.StringVar(&conf.recovery, "recovery", "", "file to save the state of an interrupted traversal to")
+	fs.StringVar(&conf.manifest, "manifest", "", "file to write a manifest of the run to, with the digest of -out")
+	fs.StringVar(&conf.output, "output", "table", "output format: table (tab-separated) or json (an object per line)")
+	fs.Uint64Var(&conf.maxNodes, "max-nodes", 0, "stop after visiting this many nodes, saving the state to resume (0 for no limit)")
+	fs.DurationVar(&conf.maxDuration, "max-duration", 0, "stop after running this long, saving the state to resume (0 for no limit)")
+	fs.BoolVar(&conf.summary, "summary", false, "write the outcome and resource usage of the traversal to stderr")
+}
+
+// outputFormats are the values of -output.
+var outputFormats = []string{"table", "json"}
+
+func (conf *commonFlags) checkOutput() error {
+	for _, format := range outputFormats {
+		if conf.output == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid -output %q: expected table or json", conf.output)
+}
+
+func (conf *commonFlags) check() error {
+	if conf.datadir == "" {
+		return errors.New("-datadir is required")
+	}
+	if conf.manifest != "" && conf.out == "" {
+		return errors.New("-manifest requires -out")
+	}
+	if conf.ancient == "" {
+		conf.ancient = filepath.Join(conf.datadir, "ancient")
+	}
+	return nil
+}
+
+type config struct {
+	commonFlags
+	block int64
+	bins  uint
+}
+
+func (conf *config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("trie-iterate", flag.ContinueOnError)
+	conf.register(fs)
+	fs.Int64Var(&conf.block, "block", -1, "block number of the state to traverse (default head)")
+	fs.UintVar(&conf.bins, "bins", 16, "number of subtries to divide the trie into")
+	return fs
+}
+
+func parseFlags(args []string) (*config, error) {
+	var conf config
+	if err := conf.flagSet().Parse(args); err != nil {
+		return nil, &usageError{err}
+	}
+	if err := conf.checkOutput(); err != nil {
+		return nil, &usageError{err}
+	}
+	if err := conf.check(); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.bins == 0 || conf.workers == 0 {
+		return nil, &usageError{errors.New("-bins and -workers must be positive")}
+	}
+	if conf.recovery != "" {
+		if _, err := os.Stat(conf.recovery); err == nil {
+			return nil, fmt.Errorf("recovery file %s already exists; resume it, or remove it to start over", conf.recovery)
+		}
+	}
+	return &conf, nil
+}
+
+func runIterate(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	conf, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(&conf.commonFlags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	root, err := stateRoot(db, conf.block)
+	if err != nil {
+		return err
+	}
+	makeIterator, tdb, err := iter.OpenTrieConstructor(db, trie.StateTrieID(root))
+	if err != nil {
+		return err
+	}
+	defer tdb.Close()
+
+	// each node is written once, even where bins meet
+	iters, err := iter.SubtrieIteratorsDedup(makeIterator, conf.bins)
+	if err != nil {
+		return err
+	}
+	var tr *tracker.Tracker
+	if conf.recovery != "" {
+		tr = tracker.New(conf.recovery, conf.bins, tracker.WithRoot(root))
+		for i, it := range iters {
+			iters[i] = tr.Tracked(it)
+		}
+	}
+	return traverse(ctx, &conf.commonFlags, root, iters, tr, db, stdout, stderr, false)
+}
+
+// openDB opens the chaindata read-only, counting the reads for the run summary.
+func openDB(conf *commonFlags) (*readCountingDB, error) {
+	db, err := rawdb.Open(rawdb.OpenOptions{
+		Directory:         conf.datadir,
+		AncientsDirectory: conf.ancient,
+		Namespace:         "trie-iterate",
+		ReadOnly:          true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &readCountingDB{Database: db}, nil
+}
+
+// traverse writes the nodes of the iterators to the output, appending to an output file if
+// resuming, and the run summary to stderr and the manifest if configured. The tracker, if any, is closed afterwards,
+// saving the state of an interrupted traversal.
+func traverse(
+	ctx context.Context, conf *commonFlags, root common.Hash, iters []trie.NodeIterator,
+	tr *tracker.Tracker, db *readCountingDB, stdout, stderr io.Writer, resuming bool,
+) (err error) {
+	if tr != nil {
+		defer func() {
+			if serr := tr.CloseAndSave(); serr != nil {
+				err = fmt.Errorf("failed to save recovery state: %w (traversal error: %v)", serr, err)
+			} else if err != nil {
+				err = &savedError{err: err, recovery: conf.recovery}
+			}
+		}()
+	}
+	out := stdout
+	if conf.out != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if resuming {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(conf.out, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	w := newNodeWriter(out, conf.leaves, conf.output == "json")
+	opts := []iter.TraverseOption{iter.WithRoot(root)}
+	if conf.maxNodes != 0 || conf.maxDuration != 0 {
+		opts = append(opts, iter.WithBudget(iter.NewBudget(conf.maxDuration, conf.maxNodes)))
+	}
+	usage, started := startUsage(db), time.Now()
+	err = iter.TraverseIterators(ctx, iters, conf.workers, w.visit, opts...)
+	if ferr := w.flush(); err == nil {
+		err = ferr
+	}
+	if conf.manifest != "" {
+		merr := writeManifest(conf, root, uint(len(iters)), started, w.nodes.Load(), w.leafCount.Load(), err == nil, resuming)
+		if err == nil {
+			err = merr
+		}
+	}
+	if conf.summary {
+		summary := usage.stop()
+		summary.Root, summary.Nodes, summary.Leaves = root, w.nodes.Load(), w.leafCount.Load()
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		if serr := summary.write(stderr, conf.output == "json"); err == nil {
+			err = serr
+		}
+	} else {
+		usage.stop()
+	}
+	return err
+}
+
+// stateRoot returns the state root of the given block, or of the head block if it is negative.
+func stateRoot(db ethdb.Database, number int64) (common.Hash, error) {
+	if number < 0 {
+		head := rawdb.ReadHeadBlock(db)
+		if head == nil {
+			return common.Hash{}, errors.New("no head block")
+		}
+		return head.Root(), nil
+	}
+	hash := rawdb.ReadCanonicalHash(db, uint64(number))
+	header := rawdb.ReadHeader(db, hash, uint64(number))
+	if header == nil {
+		return common.Hash{}, fmt.Errorf("no canonical header at block %d", number)
+	}
+	return header.Root, nil
+}
+
+// nodeWriter writes the nodes visited by all bins to one output, as tab-separated or JSON lines.
+type nodeWriter struct {
+	out    *bufio.Writer
+	leaves bool
+	json   bool
+	mu     sync.Mutex // guards out
+
+	nodes, leafCount atomic.Uint64 // nodes and leaves visited
+}
+
+func newNodeWriter(out io.Writer, leaves, json bool) *nodeWriter {
+	return &nodeWriter{out: bufio.NewWriter(out), leaves: leaves, json: json}
+}
+
+func (w *nodeWriter) visit(it trie.NodeIterator) error {
+	for it.Next(true) {
+		w.nodes.Add(1)
+		if it.Leaf() {
+			w.leafCount.Add(1)
+		}
+		if err := w.write(it); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+func (w *nodeWriter) write(it trie.NodeIterator) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	switch {
+	case it.Leaf() && w.json:
+		_, err = fmt.Fprintf(w.out, "{\"kind\":\"leaf\",\"path\":\"%x\",\"key\":\"%x\"}\n", it.Path(), it.LeafKey())
+	case it.Leaf():
+		_, err = fmt.Fprintf(w.out, "leaf\t%x\t%x\n", it.Path(), it.LeafKey())
+	case w.leaves:
+	case w.json:
+		_, err = fmt.Fprintf(w.out, "{\"kind\":\"node\",\"path\":\"%x\",\"hash\":\"%x\"}\n", it.Path(), it.Hash())
+	default:
+		_, err = fmt.Fprintf(w.out, "node\t%x\t%x\n", it.Path(), it.Hash())
+	}
+	return err
+}
+
+func (w *nodeWriter) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Flush()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cerc-io/eth-testing/chaindata/small2"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func fixtureArgs(args ...string) []string {
+	return append([]string{"-datadir", small2.ChainData.ChainData, "-ancient", small2.ChainData.Ancient,
+		"-block", "1"}, args...)
+}
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(context.Background(), fixtureArgs("-bins", "16", "-workers", "4"), &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		paths = append(paths, strings.Split(line, "\t")[1])
+	}
+	var expected []string
+	for _, path := range internal.FixtureNodePaths {
+		expected = append(expected, fmt.Sprintf("%x", path))
+	}
+	// each node is written once
+	sort.Strings(paths)
+	sort.Strings(expected)
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Fatalf("wrong paths: got %d, expected %d", len(paths), len(expected))
+	}
+
+	out.Reset()
+	if err := run(context.Background(), fixtureArgs("-leaves"), &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "leaf\t"); n != len(internal.FixtureLeafKeys) ||
+		strings.Count(out.String(), "\n") != n {
+		t.Fatalf("expected %d leaves, got %d", len(internal.FixtureLeafKeys), n)
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	recovery := filepath.Join(t.TempDir(), "recovery.csv")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	err := run(ctx, fixtureArgs("-recovery", recovery), &out, io.Discard)
+	if err == nil {
+		t.Fatal("expected interrupted traversal to fail")
+	}
+	if code := exitCode(err); code != exitInterrupted {
+		t.Fatalf("expected exit code %d, got %d (%v)", exitInterrupted, code, err)
+	}
+	if _, err := os.Stat(recovery); err != nil {
+		t.Fatalf("recovery state not saved: %v", err)
+	}
+	// an existing traversal isn't overwritten
+	if err := run(context.Background(), fixtureArgs("-recovery", recovery), &out, io.Discard); err == nil {
+		t.Fatal("expected existing recovery file to be refused")
+	}
+}
+
+func TestRunMaxNodes(t *testing.T) {
+	recovery := filepath.Join(t.TempDir(), "recovery.csv")
+	var out bytes.Buffer
+	err := run(context.Background(), fixtureArgs("-recovery", recovery, "-max-nodes", "100"), &out, io.Discard)
+	if code := exitCode(err); code != exitInterrupted {
+		t.Fatalf("expected exit code %d, got %d (%v)", exitInterrupted, code, err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 100 {
+		t.Fatalf("expected 100 nodes to be written, got %d", lines)
+	}
+	args := append([]string{"resume"}, fixtureArgs("-recovery", recovery)...)
+	if err := run(context.Background(), args, &out, io.Discard); err != nil {
+		t.Fatalf("expected resume to complete, got %v", err)
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(context.Background(), fixtureArgs("-output", "json"), &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	var nodes, leaves int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var node struct{ Kind, Path, Hash, Key string }
+		if err := json.Unmarshal([]byte(line), &node); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		switch {
+		case node.Kind == "leaf" && node.Key != "":
+			leaves++
+		case node.Kind == "node" && node.Hash != "":
+			nodes++
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+	if nodes+leaves != len(internal.FixtureNodePaths) || leaves != len(internal.FixtureLeafKeys) {
+		t.Fatalf("expected %d nodes and %d leaves, got %d and %d",
+			len(internal.FixtureNodePaths), len(internal.FixtureLeafKeys), nodes+leaves, leaves)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	var summary bytes.Buffer
+	if err := run(context.Background(), fixtureArgs("-summary", "-output", "json"), io.Discard, &summary); err != nil {
+		t.Fatal(err)
+	}
+	var report runSummary
+	if err := json.Unmarshal(summary.Bytes(), &report); err != nil {
+		t.Fatalf("summary %q: %v", summary.String(), err)
+	}
+	if report.Nodes != uint64(len(internal.FixtureNodePaths)) || report.Leaves != uint64(len(internal.FixtureLeafKeys)) {
+		t.Fatalf("expected %d nodes and %d leaves, got %+v", len(internal.FixtureNodePaths), len(internal.FixtureLeafKeys), report)
+	}
+	if report.Error != "" || report.Wall <= 0 || report.DBReads == 0 || report.DBReadBytes == 0 || report.MaxGoroutines == 0 {
+		t.Fatalf("unexpected summary %+v", report)
+	}
+
+	// an interrupted run is summarized with its error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary.Reset()
+	if err := run(ctx, fixtureArgs("-summary"), io.Discard, &summary); err == nil {
+		t.Fatal("expected the traversal to be interrupted")
+	}
+	if !strings.Contains(summary.String(), "stopped: ") || !strings.Contains(summary.String(), "DB reads") {
+		t.Fatalf("unexpected summary %q", summary.String())
+	}
+
+	// nothing is written without -summary
+	summary.Reset()
+	if err := run(context.Background(), fixtureArgs("-leaves"), io.Discard, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Len() != 0 {
+		t.Fatalf("expected no summary, got %q", summary.String())
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{fixtureArgs("-leaves"), exitCompleted},
+		{[]string{"-h"}, exitCompleted},
+		{[]string{"-bins"}, exitUsage},
+		{fixtureArgs("-output", "xml"), exitUsage},
+		{[]string{"-workers", "2"}, exitUsage},
+		{fixtureArgs("-bins", "0"), exitUsage},
+		{fixtureArgs("-workers", "0"), exitUsage},
+		{[]string{"resume", "-datadir", t.TempDir(), "-recovery", "x.csv", "-workers", "0"}, exitUsage},
+		{[]string{"resume", "-datadir", t.TempDir()}, exitUsage},
+		{[]string{"-datadir", t.TempDir()}, exitFatal},
+	} {
+		err := run(context.Background(), test.args, io.Discard, io.Discard)
+		if code := exitCode(err); code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d (%v)", test.args, test.code, code, err)
+		}
+	}
+}
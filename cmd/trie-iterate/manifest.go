@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cerc-io/eth-iterator-utils/record"
+)
+
+// writeManifest writes the manifest of a run to conf.manifest, with the digest of its output file.
+// A resumed run adds its counts to those of the manifest written by the run before it, so the
+// counts include the nodes written twice where the runs meet.
+func writeManifest(
+	conf *commonFlags, root common.Hash, bins uint, started time.Time, nodes, leaves uint64, completed, resuming bool,
+) error {
+	manifest := record.NewRunManifest(root, 0, bins)
+	manifest.StartedAt = started.Unix()
+	if resuming {
+		prev, err := readManifest(context.Background(), conf.manifest)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return err
+		default:
+			manifest.StartedAt = prev.StartedAt
+			nodes, leaves = nodes+prev.Nodes, leaves+prev.Leaves
+		}
+	}
+	manifest.Nodes, manifest.Leaves = nodes, leaves
+	if completed {
+		manifest.FinishedAt = time.Now().Unix()
+	}
+	chunk, err := digestFile(conf.out, filepath.Dir(conf.manifest))
+	if err != nil {
+		return err
+	}
+	manifest.Chunks = []*record.ChunkDigest{chunk}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := conf.manifest + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, conf.manifest)
+}
+
+// digestFile returns the digest of a file, recorded under its path relative to dir if it has one.
+func digestFile(file, dir string) (*record.ChunkDigest, error) {
+	name, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	if absDir, err := filepath.Abs(dir); err == nil {
+		if rel, err := filepath.Rel(absDir, name); err == nil {
+			name = rel
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return record.NewChunkDigest(filepath.ToSlash(name), f)
+}
+
+// readManifest reads a manifest from a file or an http(s) URL, refusing one of a newer schema.
+func readManifest(ctx context.Context, loc string) (*record.RunManifest, error) {
+	r, err := openLocation(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var manifest record.RunManifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %w", loc, err)
+	}
+	if err := manifest.CheckVersion(); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func isURL(loc string) bool {
+	return strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://")
+}
+
+// openLocation opens a file, or fetches an http(s) URL.
+func openLocation(ctx context.Context, loc string) (io.ReadCloser, error) {
+	if !isURL(loc) {
+		return os.Open(loc)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s: %s", loc, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parentLocation returns the directory of a file, or of the path of a URL.
+func parentLocation(loc string) string {
+	if !isURL(loc) {
+		return filepath.Dir(loc)
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return loc
+	}
+	u.Path, u.RawQuery = path.Dir(u.Path), ""
+	return u.String()
+}
+
+// chunkLocation returns the location of a chunk in the directory or under the URL base.
+func chunkLocation(base, name string) (string, error) {
+	if path.IsAbs(name) {
+		return filepath.FromSlash(name), nil
+	}
+	if isURL(base) {
+		return url.JoinPath(base, name)
+	}
+	return filepath.Join(base, filepath.FromSlash(name)), nil
+}
+
+type verifyConfig struct {
+	manifest string
+	base     string
+	output   string
+}
+
+func (conf *verifyConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("trie-iterate verify-manifest", flag.ContinueOnError)
+	fs.StringVar(&conf.manifest, "manifest", "", "manifest file or http(s) URL to verify (required)")
+	fs.StringVar(&conf.base, "base", "", "directory or http(s) URL of the chunk files (default the manifest's)")
+	fs.StringVar(&conf.output, "output", "table", "output format: table (a chunk per line) or json (an object per line)")
+	return fs
+}
+
+func parseVerifyFlags(args []string) (*verifyConfig, error) {
+	var conf verifyConfig
+	if err := conf.flagSet().Parse(args); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.manifest == "" {
+		return nil, &usageError{errors.New("-manifest is required")}
+	}
+	flags := commonFlags{output: conf.output}
+	if err := flags.checkOutput(); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.base == "" {
+		conf.base = parentLocation(conf.manifest)
+	}
+	return &conf, nil
+}
+
+// runVerify checks the chunks listed by a manifest against their digests, writing the outcome for
+// each, and fails if any is missing or does not match.
+func runVerify(ctx context.Context, args []string, stdout io.Writer) error {
+	conf, err := parseVerifyFlags(args)
+	if err != nil {
+		return err
+	}
+	manifest, err := readManifest(ctx, conf.manifest)
+	if err != nil {
+		return err
+	}
+	if len(manifest.Chunks) == 0 {
+		return fmt.Errorf("no chunks in manifest %s", conf.manifest)
+	}
+	enc := json.NewEncoder(stdout)
+	var failed int
+	for _, chunk := range manifest.Chunks {
+		verr := verifyChunk(ctx, conf.base, chunk)
+		if verr != nil {
+			failed++
+		}
+		var werr error
+		switch {
+		case conf.output == "json":
+			result := struct {
+				Path  string `json:"path"`
+				OK    bool   `json:"ok"`
+				Error string `json:"error,omitempty"`
+			}{Path: chunk.Path, OK: verr == nil}
+			if verr != nil {
+				result.Error = verr.Error()
+			}
+			werr = enc.Encode(result)
+		case verr != nil:
+			_, werr = fmt.Fprintf(stdout, "FAILED\t%s\t%v\n", chunk.Path, verr)
+		default:
+			_, werr = fmt.Fprintf(stdout, "ok\t%s\n", chunk.Path)
+		}
+		if werr != nil {
+			return werr
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d chunks failed verification", failed, len(manifest.Chunks))
+	}
+	return nil
+}
+
+func verifyChunk(ctx context.Context, base string, chunk *record.ChunkDigest) error {
+	loc, err := chunkLocation(base, chunk.Path)
+	if err != nil {
+		return err
+	}
+	r, err := openLocation(ctx, loc)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return chunk.Verify(r)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/record"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	out, manifestFile := filepath.Join(dir, "leaves.tsv"), filepath.Join(dir, "manifest.json")
+	args := fixtureArgs("-leaves", "-out", out, "-manifest", manifestFile)
+	if err := run(context.Background(), args, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest(context.Background(), manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Leaves != uint64(len(internal.FixtureLeafKeys)) || manifest.FinishedAt == 0 ||
+		len(manifest.Chunks) != 1 || manifest.Chunks[0].Path != "leaves.tsv" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	var stdout bytes.Buffer
+	if err := run(context.Background(), []string{"verify-manifest", "-manifest", manifestFile}, &stdout, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "ok\tleaves.tsv\n" {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+
+	// a copy served over http is verified against the same manifest
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+	stdout.Reset()
+	args = []string{"verify-manifest", "-manifest", srv.URL + "/manifest.json", "-output", "json"}
+	if err := run(context.Background(), args, &stdout, nil); err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Path string
+		OK   bool
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil || !result.OK {
+		t.Fatalf("unexpected output %q (%v)", stdout.String(), err)
+	}
+
+	// a partial copy is detected
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(out, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	err = run(context.Background(), []string{"verify-manifest", "-manifest", manifestFile}, &stdout, nil)
+	if err == nil || exitCode(err) != exitFatal {
+		t.Fatalf("expected verification to fail, got %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "FAILED\tleaves.tsv\t") {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+	// as is a missing one
+	if err := os.Remove(out); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), []string{"verify-manifest", "-manifest", manifestFile}, io.Discard, nil); err == nil {
+		t.Fatal("expected verification to fail")
+	}
+}
+
+func TestManifestResumed(t *testing.T) {
+	dir := t.TempDir()
+	out, manifestFile := filepath.Join(dir, "nodes.tsv"), filepath.Join(dir, "manifest.json")
+	recovery := filepath.Join(dir, "recovery.csv")
+	args := fixtureArgs("-out", out, "-manifest", manifestFile, "-recovery", recovery)
+	err := run(context.Background(), append(args, "-max-nodes", "100"), io.Discard, io.Discard)
+	if exitCode(err) != exitInterrupted {
+		t.Fatalf("expected traversal to be stopped, got %v", err)
+	}
+	first, err := readManifest(context.Background(), manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Nodes != 100 || first.FinishedAt != 0 {
+		t.Fatalf("unexpected manifest of stopped run %+v", first)
+	}
+	if err := run(context.Background(), append([]string{"resume"}, args...), io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest(context.Background(), manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// resumed nodes are counted twice, as they are written
+	if manifest.Nodes < uint64(len(internal.FixtureNodePaths)) || manifest.FinishedAt == 0 ||
+		manifest.StartedAt != first.StartedAt {
+		t.Fatalf("unexpected manifest of resumed run %+v", manifest)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := manifest.Chunks[0].Verify(f); err != nil {
+		t.Fatal(err)
+	}
+
+	var usage *usageError
+	err = run(context.Background(), fixtureArgs("-manifest", manifestFile), io.Discard, io.Discard)
+	if !errors.As(err, &usage) {
+		t.Fatalf("expected -manifest without -out to be refused, got %v", err)
+	}
+	if _, err := readManifest(context.Background(), filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatal("expected missing manifest to fail")
+	}
+	newer := filepath.Join(dir, "newer.json")
+	data := fmt.Sprintf(`{"schemaVersion":%d}`, record.SchemaVersion+1)
+	if err := os.WriteFile(newer, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readManifest(context.Background(), newer); !errors.Is(err, record.ErrSchemaVersion) {
+		t.Fatalf("expected ErrSchemaVersion, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+type patchConfig struct {
+	datadir, ancient string
+	block            int64
+	keys             string
+	bins, workers    uint
+	out, output      string
+}
+
+func (conf *patchConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("trie-iterate patch", flag.ContinueOnError)
+	fs.StringVar(&conf.datadir, "datadir", "", "chaindata directory (required)")
+	fs.StringVar(&conf.ancient, "ancient", "", "ancient store directory (default <datadir>/ancient)")
+	fs.Int64Var(&conf.block, "block", -1, "block number of the state to read (default head)")
+	fs.StringVar(&conf.keys, "keys", "", "file of the leaf keys to re-export, one in hex per line (required)")
+	fs.UintVar(&conf.bins, "bins", 16, "number of batches to divide the keys into")
+	fs.UintVar(&conf.workers, "workers", 4, "number of batches to traverse concurrently")
+	fs.StringVar(&conf.out, "out", "", "output file (default stdout)")
+	fs.StringVar(&conf.output, "output", "table", "output format: table (tab-separated) or json (an object per line)")
+	return fs
+}
+
+func parsePatchFlags(args []string) (*patchConfig, error) {
+	var conf patchConfig
+	if err := conf.flagSet().Parse(args); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.keys == "" {
+		return nil, &usageError{errors.New("-keys is required")}
+	}
+	flags := commonFlags{datadir: conf.datadir, ancient: conf.ancient, output: conf.output}
+	if err := flags.checkOutput(); err != nil {
+		return nil, &usageError{err}
+	}
+	if err := flags.check(); err != nil {
+		return nil, &usageError{err}
+	}
+	conf.ancient = flags.ancient
+	if conf.bins == 0 || conf.workers == 0 {
+		return nil, &usageError{errors.New("-bins and -workers must be positive")}
+	}
+	return &conf, nil
+}
+
+// runPatch re-exports the leaves with the keys listed in a file, e.g. the rows of an export which
+// failed validation, traversing only the paths to them. The leaves are written as by -leaves, and
+// the keys which are not in the trie are reported to stderr.
+func runPatch(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	conf, err := parsePatchFlags(args)
+	if err != nil {
+		return err
+	}
+	keys, err := readKeys(conf.keys)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(&commonFlags{datadir: conf.datadir, ancient: conf.ancient})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	root, err := stateRoot(db, conf.block)
+	if err != nil {
+		return err
+	}
+	makeIterator, tdb, err := iter.OpenTrieConstructor(db, trie.StateTrieID(root))
+	if err != nil {
+		return err
+	}
+	defer tdb.Close()
+
+	out := stdout
+	if conf.out != "" {
+		file, err := os.Create(conf.out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	w := newNodeWriter(out, true, conf.output == "json")
+	missing, err := iter.TraverseKeys(ctx, makeIterator, keys, conf.bins, conf.workers, w.visit, iter.WithRoot(root))
+	if ferr := w.flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range missing {
+		if conf.output == "json" {
+			_, err = fmt.Fprintf(stderr, "{\"kind\":\"missing\",\"key\":\"%x\"}\n", key)
+		} else {
+			_, err = fmt.Fprintf(stderr, "missing\t%x\n", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readKeys reads a file of hex keys, one per line, with or without a 0x prefix. Blank lines and
+// lines starting with # are skipped.
+func readKeys(file string) ([][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys [][]byte
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(text, "0x"))
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("%s:%d: invalid key %q", file, line, text)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", file)
+	}
+	return keys, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestPatch(t *testing.T) {
+	leafKeys := internal.FixtureLeafKeys
+	absent := "0x" + strings.Repeat("00", 32)
+	keys := filepath.Join(t.TempDir(), "keys.txt")
+	list := fmt.Sprintf("# rows which failed validation\n0x%x\n\n%x\n%s\n", leafKeys[3], leafKeys[1], absent)
+	if err := os.WriteFile(keys, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	args := []string{"patch", "-datadir", fixtureArgs()[1], "-ancient", fixtureArgs()[3], "-block", "1", "-keys", keys}
+	if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	// only the listed leaves are written
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	sort.Strings(lines)
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], fmt.Sprintf("\t%x", leafKeys[1])) ||
+		!strings.HasSuffix(lines[1], fmt.Sprintf("\t%x", leafKeys[3])) {
+		t.Fatalf("unexpected patch %q", stdout.String())
+	}
+	if stderr.String() != "missing\t"+absent[2:]+"\n" {
+		t.Fatalf("unexpected missing keys %q", stderr.String())
+	}
+
+	if err := os.WriteFile(keys, []byte("0xzz\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), args, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "keys.txt:1") {
+		t.Fatalf("expected invalid key to be refused, got %v", err)
+	}
+	var usage *usageError
+	if err := run(context.Background(), args[:len(args)-2], &stdout, &stderr); !errors.As(err, &usage) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
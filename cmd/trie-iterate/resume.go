@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+type resumeConfig struct {
+	commonFlags
+	block        int64
+	inspect      bool
+	forceMigrate bool
+}
+
+func (conf *resumeConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("trie-iterate resume", flag.ContinueOnError)
+	conf.register(fs)
+	fs.Int64Var(&conf.block, "block", -1, "block number of the state, if the recovery file has no root (default head)")
+	fs.BoolVar(&conf.inspect, "inspect", false, "only report the saved positions, e.g. of a live run")
+	fs.BoolVar(&conf.forceMigrate, "force-migrate", false, "load a recovery file written in an incompatible format")
+	return fs
+}
+
+func parseResumeFlags(args []string) (*resumeConfig, error) {
+	var conf resumeConfig
+	if err := conf.flagSet().Parse(args); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.recovery == "" {
+		return nil, &usageError{errors.New("-recovery is required")}
+	}
+	if err := conf.checkOutput(); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.inspect {
+		return &conf, nil
+	}
+	if err := conf.check(); err != nil {
+		return nil, &usageError{err}
+	}
+	if conf.workers == 0 {
+		return nil, &usageError{errors.New("-workers must be positive")}
+	}
+	return &conf, nil
+}
+
+// runResume reports the positions saved to a recovery file to stderr, then continues the traversal
+// from them, appending to the output. Positions are restored inclusively, so the node at each one
+// may be written twice.
+func runResume(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	conf, err := parseResumeFlags(args)
+	if err != nil {
+		return err
+	}
+	var storeOpts []tracker.FileStoreOption
+	if conf.forceMigrate {
+		storeOpts = append(storeOpts, tracker.WithForceMigrate())
+	}
+	loadOpts := storeOpts
+	if conf.inspect {
+		// a run can be inspected while its tracker holds the lock
+		loadOpts = append(loadOpts, tracker.WithReadOnly())
+	}
+	store := tracker.NewFileStore(conf.recovery, loadOpts...)
+	positions, err := store.Load()
+	// release the lock for the tracker below
+	if cerr := store.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, tracker.ErrIncompatibleFormat) {
+		return fmt.Errorf("%w; resume with -force-migrate to load it anyway", err)
+	}
+	if err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return fmt.Errorf("no saved positions in %s", conf.recovery)
+	}
+	root := positions[0].Root
+	if err := report(stderr, conf.recovery, positions, conf.output == "json"); err != nil {
+		return err
+	}
+	if conf.inspect {
+		return nil
+	}
+
+	db, err := openDB(&conf.commonFlags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var opts []tracker.Option
+	if root == (common.Hash{}) {
+		if root, err = stateRoot(db, conf.block); err != nil {
+			return err
+		}
+	} else {
+		// the tracker refuses positions saved for another root
+		opts = append(opts, tracker.WithRoot(root))
+	}
+	makeIterator, tdb, err := iter.OpenTrieConstructor(db, trie.StateTrieID(root))
+	if err != nil {
+		return err
+	}
+	defer tdb.Close()
+
+	tr := tracker.NewWithStore(tracker.NewFileStore(conf.recovery, storeOpts...), uint(len(positions)), opts...)
+	iters, _, err := tr.Restore(makeIterator)
+	if err != nil {
+		if cerr := tr.CloseAndSave(); cerr != nil {
+			return fmt.Errorf("%w (failed to close tracker: %v)", err, cerr)
+		}
+		return err
+	}
+	return traverse(ctx, &conf.commonFlags, root, iters, tr, db, stdout, stderr, true)
+}
+
+// report writes a summary of the saved positions, and how far each has progressed through the
+// keyspace, as text or a JSON object.
+func report(w io.Writer, file string, positions []tracker.Position, asJSON bool) error {
+	root := "unknown"
+	if positions[0].Root != (common.Hash{}) {
+		root = positions[0].Root.Hex()
+	}
+	if asJSON {
+		return reportJSON(w, file, root, positions)
+	}
+	if _, err := fmt.Fprintf(w, "%s: %d positions, root %s\n", file, len(positions), root); err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		if _, err := fmt.Fprintf(w, "  %x\t-> %x\t(%.2f%% of keyspace remaining)\n",
+			pos.Path, pos.EndPath, 100*remaining(pos)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type positionReport struct {
+	Path      string  `json:"path"`
+	EndPath   string  `json:"endPath"`
+	Remaining float64 `json:"remaining"` // fraction of the keyspace
+}
+
+func reportJSON(w io.Writer, file, root string, positions []tracker.Position) error {
+	out := struct {
+		File      string           `json:"file"`
+		Root      string           `json:"root"`
+		Positions []positionReport `json:"positions"`
+	}{File: file, Root: root}
+	for _, pos := range positions {
+		out.Positions = append(out.Positions, positionReport{
+			Path:      fmt.Sprintf("%x", pos.Path),
+			EndPath:   fmt.Sprintf("%x", pos.EndPath),
+			Remaining: remaining(pos),
+		})
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+// remaining returns the fraction of the keyspace between a position and its end.
+func remaining(pos tracker.Position) float64 {
+	done, end := iter.Progress(pos.Path), 1.0
+	if pos.EndPath != nil {
+		end = iter.Progress(pos.EndPath)
+	}
+	if done > end {
+		return 0
+	}
+	return end - done
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cerc-io/eth-testing/chaindata/small2"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	recovery, out := filepath.Join(dir, "recovery.csv"), filepath.Join(dir, "out.tsv")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := run(ctx, fixtureArgs("-recovery", recovery, "-out", out), nil, nil); err == nil {
+		t.Fatal("expected interrupted traversal to fail")
+	}
+
+	resumeArgs := []string{"resume", "-datadir", small2.ChainData.ChainData, "-ancient", small2.ChainData.Ancient,
+		"-recovery", recovery, "-out", out}
+	var report bytes.Buffer
+	if err := run(context.Background(), append(resumeArgs, "-inspect"), nil, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "16 positions") {
+		t.Fatalf("unexpected report: %s", report.String())
+	}
+	if _, err := os.Stat(recovery); err != nil {
+		t.Fatalf("inspecting removed recovery state: %v", err)
+	}
+
+	report.Reset()
+	if err := run(context.Background(), append(resumeArgs, "-inspect", "-output", "json"), nil, &report); err != nil {
+		t.Fatal(err)
+	}
+	var summary struct {
+		File      string
+		Positions []struct{ Remaining float64 }
+	}
+	if err := json.Unmarshal(report.Bytes(), &summary); err != nil {
+		t.Fatalf("report %q: %v", report.String(), err)
+	}
+	if summary.File != recovery || len(summary.Positions) != 16 || summary.Positions[0].Remaining <= 0 {
+		t.Fatalf("unexpected report: %s", report.String())
+	}
+
+	// a live run, whose tracker holds the recovery file, can be inspected
+	live := tracker.NewFileStore(recovery)
+	if _, err := live.Load(); err != nil {
+		t.Fatal(err)
+	}
+	report.Reset()
+	err := run(context.Background(), append(resumeArgs, "-inspect"), nil, &report)
+	if cerr := live.Close(); cerr != nil {
+		t.Fatal(cerr)
+	}
+	if err != nil {
+		t.Fatalf("inspecting live run: %v", err)
+	}
+
+	if err := run(context.Background(), resumeArgs, nil, &report); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(recovery); !os.IsNotExist(err) {
+		t.Fatalf("expected recovery state to be cleared, got %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		written[strings.Split(line, "\t")[1]] = true
+	}
+	for _, path := range internal.FixtureNodePaths {
+		if !written[fmt.Sprintf("%x", path)] {
+			t.Fatalf("node not written: %x", path)
+		}
+	}
+
+	// nothing is left to resume
+	if err := run(context.Background(), resumeArgs, nil, &report); err == nil {
+		t.Fatal("expected missing recovery state to fail")
+	}
+}
+
+func TestResumeIncompatible(t *testing.T) {
+	recovery := filepath.Join(t.TempDir(), "recovery.csv")
+	if err := os.WriteFile(recovery, []byte("#format=99,library=v99.0.0\n,08\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"resume", "-recovery", recovery, "-inspect"}
+	var report bytes.Buffer
+	err := run(context.Background(), args, nil, &report)
+	if !errors.Is(err, tracker.ErrIncompatibleFormat) || !strings.Contains(err.Error(), "-force-migrate") {
+		t.Fatalf("expected incompatible format, got %v", err)
+	}
+	if err := run(context.Background(), append(args, "-force-migrate"), nil, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "1 positions") {
+		t.Fatalf("unexpected report: %s", report.String())
+	}
+}
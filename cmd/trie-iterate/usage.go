@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// usageSampleInterval is the interval between samples of the number of goroutines of a run.
+const usageSampleInterval = 100 * time.Millisecond
+
+// readCountingDB is a database which counts the values read from its key-value store, as trie
+// nodes are. Reads from the ancient store are not counted.
+type readCountingDB struct {
+	ethdb.Database
+	reads, bytes atomic.Uint64
+}
+
+func (db *readCountingDB) Get(key []byte) ([]byte, error) {
+	value, err := db.Database.Get(key)
+	if err == nil {
+		db.reads.Add(1)
+		db.bytes.Add(uint64(len(value)))
+	}
+	return value, err
+}
+
+// runSummary is the outcome and resource usage of a traversal, as written by -summary.
+type runSummary struct {
+	Root   common.Hash `json:"root"`
+	Nodes  uint64      `json:"nodes"`
+	Leaves uint64      `json:"leaves"`
+	// Error is why the traversal stopped, if it did not complete.
+	Error string        `json:"error,omitempty"`
+	Wall  time.Duration `json:"wallNanos"`
+	// CPU is the user and system time of the process during the traversal.
+	CPU time.Duration `json:"cpuNanos"`
+	// MaxRSS is the peak resident set size of the process, if known.
+	MaxRSS   uint64 `json:"maxRSSBytes,omitempty"`
+	GCCycles uint32 `json:"gcCycles"`
+	// DBReads and DBReadBytes count the values read from the key-value store.
+	DBReads     uint64 `json:"dbReads"`
+	DBReadBytes uint64 `json:"dbReadBytes"`
+	// MaxGoroutines is the largest number of goroutines sampled during the traversal.
+	MaxGoroutines int `json:"maxGoroutines"`
+}
+
+// usageMonitor measures the resources used by a traversal, sampling the number of goroutines in
+// the background until it is stopped.
+type usageMonitor struct {
+	db            *readCountingDB
+	start         time.Time
+	cpu           time.Duration
+	gc            uint32
+	reads, bytes  uint64
+	maxGoroutines atomic.Int64
+	quit, done    chan struct{}
+}
+
+func startUsage(db *readCountingDB) *usageMonitor {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	m := &usageMonitor{
+		db:    db,
+		start: time.Now(),
+		cpu:   cpuTime(),
+		gc:    mem.NumGC,
+		reads: db.reads.Load(),
+		bytes: db.bytes.Load(),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	m.sample()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(usageSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+			case <-m.quit:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *usageMonitor) sample() {
+	n := int64(runtime.NumGoroutine())
+	for prev := m.maxGoroutines.Load(); n > prev && !m.maxGoroutines.CompareAndSwap(prev, n); {
+		prev = m.maxGoroutines.Load()
+	}
+}
+
+// stop ends the sampling, and returns the resources used since the monitor was started.
+func (m *usageMonitor) stop() runSummary {
+	m.sample()
+	close(m.quit)
+	<-m.done
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return runSummary{
+		Wall:          time.Since(m.start),
+		CPU:           cpuTime() - m.cpu,
+		MaxRSS:        maxRSS(),
+		GCCycles:      mem.NumGC - m.gc,
+		DBReads:       m.db.reads.Load() - m.reads,
+		DBReadBytes:   m.db.bytes.Load() - m.bytes,
+		MaxGoroutines: int(m.maxGoroutines.Load()),
+	}
+}
+
+func (s *runSummary) write(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(s)
+	}
+	status := "completed"
+	if s.Error != "" {
+		status = "stopped: " + s.Error
+	}
+	_, err := fmt.Fprintf(w, "traversal of %x %s\n"+
+		"%d nodes, %d leaves in %v, cpu %v, %d GC cycles, %d DB reads (%.1f MiB), up to %d goroutines\n",
+		s.Root, status, s.Nodes, s.Leaves, s.Wall.Round(time.Millisecond), s.CPU.Round(time.Millisecond),
+		s.GCCycles, s.DBReads, float64(s.DBReadBytes)/(1<<20), s.MaxGoroutines)
+	if err == nil && s.MaxRSS != 0 {
+		_, err = fmt.Fprintf(w, "peak RSS %.1f MiB\n", float64(s.MaxRSS)/(1<<20))
+	}
+	return err
+}
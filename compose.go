@@ -0,0 +1,119 @@
+package iterator
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// NewBoundedDifferenceIterator returns an iterator over the nodes of `b` which are not in `a`, up
+// to the path `endPath`, and a pointer to the number of nodes scanned in either trie.
+//
+// The bound is applied to the difference, rather than to each input: a bounded `a` which stopped
+// at its bound would otherwise make the difference yield every following node of `b`. Both inputs
+// should be created at the same start key, e.g. the lower bound of a bin.
+func NewBoundedDifferenceIterator(a, b trie.NodeIterator, endPath []byte) (*PrefixBoundIterator, *int) {
+	diff, count := trie.NewDifferenceIterator(a, b)
+	return NewPrefixBoundIterator(&composedIterator{diff, []trie.NodeIterator{a, b}}, endPath), count
+}
+
+// NewBoundedUnionIterator returns an iterator over the nodes present in any of `iters`, up to the
+// path `endPath`, and a pointer to the number of nodes scanned across all tries. Nodes with the
+// same path and hash in several tries are yielded once. All inputs should be created at the same
+// start key.
+func NewBoundedUnionIterator(iters []trie.NodeIterator, endPath []byte) (*PrefixBoundIterator, *int) {
+	union, count := trie.NewUnionIterator(iters)
+	return NewPrefixBoundIterator(&composedIterator{union, iters}, endPath), count
+}
+
+// NewUnionConstructor returns an IteratorConstructor over the union of the tries returned by each
+// of `makeIterators`, e.g. the state tries of several recent blocks. It can be passed to
+// SubtrieIterators or Traverse to traverse the union in parallel.
+func NewUnionConstructor(makeIterators ...IteratorConstructor) IteratorConstructor {
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		iters := make([]trie.NodeIterator, len(makeIterators))
+		for i, makeIterator := range makeIterators {
+			it, err := makeIterator(startKey)
+			if err != nil {
+				return nil, err
+			}
+			iters[i] = it
+		}
+		union, _ := trie.NewUnionIterator(iters)
+		return &composedIterator{union, iters}, nil
+	}
+}
+
+// NewDeletionConstructor returns an IteratorConstructor over the leaves of the trie opened by
+// makeA whose keys are not in the trie opened by makeB, e.g. the accounts or slots deleted between
+// two states, which a difference iterator from a to b does not yield. It can be passed to
+// SubtrieIterators or Traverse to find the deletions in parallel, each bin yielding the leaves of
+// `a` in its range, from which tombstone records are made.
+//
+// The deleted leaves are those of the difference from b to a, i.e. the leaves of `a` which differ
+// from `b`, whose keys are not among the leaves of the difference from a to b: a key in both
+// tries is in `b`'s side of the difference if its leaf moved or changed, and in neither if not.
+// Only the parts of the tries which differ are read, twice.
+func NewDeletionConstructor(makeA, makeB IteratorConstructor) IteratorConstructor {
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		var iters []trie.NodeIterator
+		for _, makeIterator := range []IteratorConstructor{makeA, makeB, makeA, makeB} {
+			it, err := makeIterator(startKey)
+			if err != nil {
+				return nil, err
+			}
+			iters = append(iters, it)
+		}
+		removed, _ := trie.NewDifferenceIterator(iters[1], iters[0])
+		added, _ := trie.NewDifferenceIterator(iters[2], iters[3])
+		return &deletionIterator{
+			composedIterator: composedIterator{removed, iters},
+			added:            added,
+		}, nil
+	}
+}
+
+// deletionIterator yields the leaves of a difference from b to a whose keys are not yielded by the
+// difference from a to b. Both differences yield leaves in key order.
+type deletionIterator struct {
+	composedIterator
+	added     trie.NodeIterator
+	addedDone bool
+}
+
+// Next moves to the next deleted leaf. Only leaves are yielded, so descend is ignored.
+func (it *deletionIterator) Next(bool) bool {
+	for it.NodeIterator.Next(true) {
+		if !it.NodeIterator.Leaf() {
+			continue
+		}
+		key := it.NodeIterator.LeafKey()
+		for !it.addedDone && (!it.added.Leaf() || bytes.Compare(it.added.LeafKey(), key) < 0) {
+			it.addedDone = !it.added.Next(true)
+		}
+		if it.addedDone || !bytes.Equal(it.added.LeafKey(), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *deletionIterator) Error() error {
+	if err := it.NodeIterator.Error(); err != nil {
+		return err
+	}
+	return it.added.Error()
+}
+
+// composedIterator is a difference or union iterator from geth, which adds resolvers to each of
+// its inputs rather than panicking.
+type composedIterator struct {
+	trie.NodeIterator
+	inputs []trie.NodeIterator
+}
+
+func (it *composedIterator) AddResolver(resolver trie.NodeResolver) {
+	for _, input := range it.inputs {
+		input.AddResolver(resolver)
+	}
+}
@@ -0,0 +1,265 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// buildStateRoots commits a sequence of states, each updating some of the accounts of the last,
+// and returns their roots.
+func buildStateRoots(t *testing.T, count int) (state.Database, []common.Hash) {
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots []common.Hash
+	for n := 0; n < count; n++ {
+		for i := n * 50; i < 200+n*50; i += n + 1 {
+			statedb.SetBalance(common.BytesToAddress([]byte{byte(i), byte(i >> 8)}), uint256.NewInt(uint64(n+1)))
+		}
+		root, err := statedb.Commit(uint64(n+1), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statedb, err = state.New(root, sdb, nil); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+	return sdb, roots
+}
+
+func collectPaths(t *testing.T, it trie.NodeIterator) map[string]struct{} {
+	paths := map[string]struct{}{}
+	for it.Next(true) {
+		paths[string(it.Path())] = struct{}{}
+	}
+	if it.Error() != nil {
+		t.Fatal(it.Error())
+	}
+	return paths
+}
+
+func TestBoundedDifferenceIterator(t *testing.T) {
+	sdb, roots := buildStateRoots(t, 2)
+	makeA := iter.NewTrieDBConstructor(sdb.TrieDB(), trie.StateTrieID(roots[0]))
+	makeB := iter.NewTrieDBConstructor(sdb.TrieDB(), trie.StateTrieID(roots[1]))
+	open := func(makeIterator iter.IteratorConstructor, startKey []byte) trie.NodeIterator {
+		it, err := makeIterator(startKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+
+	diff, _ := trie.NewDifferenceIterator(open(makeA, nil), open(makeB, nil))
+	expected := collectPaths(t, diff)
+	if len(expected) == 0 {
+		t.Fatal("expected tries to differ")
+	}
+
+	// the bounded differences over a set of bins cover exactly the full difference
+	starts := iter.MakePaths(nil, 16)
+	actual := map[string]struct{}{}
+	for i, start := range starts {
+		var end []byte
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		var startKey []byte
+		if i != 0 {
+			if len(start)%2 != 0 {
+				start = append(start, 0)
+			}
+			startKey = iter.HexToKeyBytes(start)
+		}
+		it, count := iter.NewBoundedDifferenceIterator(open(makeA, startKey), open(makeB, startKey), end)
+		for path := range collectPaths(t, it) {
+			if _, ok := expected[path]; !ok {
+				t.Fatalf("bin %d yielded node %x, which is not in the difference", i, path)
+			}
+			actual[path] = struct{}{}
+		}
+		if *count == 0 {
+			t.Fatalf("bin %d scanned no nodes", i)
+		}
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d nodes in bounded differences, got %d", len(expected), len(actual))
+	}
+}
+
+func TestUnionIterators(t *testing.T) {
+	sdb, roots := buildStateRoots(t, 3)
+	var makers []iter.IteratorConstructor
+	expected := map[string]struct{}{}
+	for _, root := range roots {
+		makeIterator := iter.NewTrieDBConstructor(sdb.TrieDB(), trie.StateTrieID(root))
+		makers = append(makers, makeIterator)
+		it, err := makeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.Next(true) {
+			expected[string(it.Path())+it.Hash().Hex()] = struct{}{}
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+	}
+
+	collect := func(it trie.NodeIterator, nodes map[string]struct{}) {
+		for it.Next(true) {
+			key := string(it.Path()) + it.Hash().Hex()
+			if _, ok := expected[key]; !ok {
+				t.Fatalf("union yielded node %x not in any trie", it.Path())
+			}
+			nodes[key] = struct{}{}
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+	}
+
+	t.Run("bounded", func(t *testing.T) {
+		var iters []trie.NodeIterator
+		for _, makeIterator := range makers {
+			it, err := makeIterator(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			iters = append(iters, it)
+		}
+		// bound the union to the first half of the keyspace
+		it, count := iter.NewBoundedUnionIterator(iters, []byte{8})
+		nodes := 0
+		for it.Next(true) {
+			if bytes.Compare(it.Path(), []byte{8}) > 0 {
+				t.Fatalf("bounded union yielded node %x beyond bound", it.Path())
+			}
+			nodes++
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+		if *count == 0 || nodes == 0 {
+			t.Fatal("expected nodes in bounded union")
+		}
+	})
+
+	t.Run("subtries", func(t *testing.T) {
+		iters, err := iter.SubtrieIterators(iter.NewUnionConstructor(makers...), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := map[string]struct{}{}
+		for _, it := range iters {
+			collect(it, actual)
+		}
+		if len(actual) != len(expected) {
+			t.Fatalf("expected %d nodes in union, got %d", len(expected), len(actual))
+		}
+	})
+}
+
+func TestDeletionConstructor(t *testing.T) {
+	db := triedb.NewDatabase(rawdb.NewMemoryDatabase(), triedb.HashDefaults)
+	key := func(i int) []byte { return crypto.Keccak256([]byte{byte(i >> 8), byte(i)}) }
+	commit := func(tree *trie.Trie, parent common.Hash, block uint64) common.Hash {
+		root, nodes, err := tree.Commit(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Update(root, parent, block, trienode.NewWithNodeSet(nodes), nil); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	const leaves = 300
+	tree := trie.NewEmpty(db)
+	for i := 0; i < leaves; i++ {
+		tree.MustUpdate(key(i), []byte{1, byte(i)})
+	}
+	rootA := commit(tree, types.EmptyRootHash, 1)
+
+	// delete some leaves of a, change others, and add new ones, moving leaves whose siblings go
+	tree, err := trie.New(trie.StateTrieID(rootA), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted [][]byte
+	for i := 0; i < leaves; i++ {
+		switch {
+		case i%7 == 0:
+			tree.MustDelete(key(i))
+			deleted = append(deleted, key(i))
+		case i%5 == 0:
+			tree.MustUpdate(key(i), []byte{2, byte(i)})
+		}
+	}
+	for i := leaves; i < leaves+50; i++ {
+		tree.MustUpdate(key(i), []byte{1, byte(i)})
+	}
+	rootB := commit(tree, rootA, 2)
+	sort.Slice(deleted, func(i, j int) bool { return bytes.Compare(deleted[i], deleted[j]) < 0 })
+
+	makeA := iter.NewTrieDBConstructor(db, trie.StateTrieID(rootA))
+	makeB := iter.NewTrieDBConstructor(db, trie.StateTrieID(rootB))
+	var (
+		found [][]byte
+		mu    sync.Mutex
+	)
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			if !it.Leaf() {
+				t.Errorf("yielded non-leaf at %x", it.Path())
+			}
+			mu.Lock()
+			found = append(found, it.LeafKey())
+			mu.Unlock()
+		}
+		return it.Error()
+	}
+	if err := iter.Traverse(context.Background(), iter.NewDeletionConstructor(makeA, makeB), 8, 4, visit); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(found, func(i, j int) bool { return bytes.Compare(found[i], found[j]) < 0 })
+	checkKeys(t, "deleted", found, deleted)
+
+	// nothing is deleted from a trie by itself
+	it, err := iter.NewDeletionConstructor(makeA, makeA)(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Next(true) {
+		t.Fatalf("unexpected deletion at %x", it.Path())
+	}
+	// and the leaves deleted going back from b are those added to it
+	it, err = iter.NewDeletionConstructor(makeB, makeA)(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added int
+	for it.Next(true) {
+		added++
+	}
+	if it.Error() != nil || added != 50 {
+		t.Fatalf("expected the 50 added leaves of b, got %d (%v)", added, it.Error())
+	}
+}
@@ -0,0 +1,43 @@
+package iterator
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ContextIterator is a NodeIterator which stops when its context is cancelled.
+type ContextIterator struct {
+	trie.NodeIterator
+	ctx context.Context
+	err error
+}
+
+// NewContextIterator returns an iterator which stops advancing once ctx is done. The context's
+// error is then reported by Error.
+func NewContextIterator(ctx context.Context, it trie.NodeIterator) *ContextIterator {
+	return &ContextIterator{NodeIterator: it, ctx: ctx}
+}
+
+func (it *ContextIterator) Next(descend bool) bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+	return it.NodeIterator.Next(descend)
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *ContextIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+func (it *ContextIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
@@ -0,0 +1,40 @@
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestContextIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const cancelAt = 10
+	it := iter.NewContextIterator(ctx, nodeit)
+	count := 0
+	for ; it.Next(true); count++ {
+		if count == cancelAt {
+			cancel()
+		}
+	}
+	if count != cancelAt+1 {
+		t.Fatalf("expected iterator to stop after %d nodes, got %d", cancelAt+1, count)
+	}
+	if !errors.Is(it.Error(), context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", it.Error())
+	}
+	if it.Next(true) {
+		t.Fatal("iterator advanced after cancellation")
+	}
+}
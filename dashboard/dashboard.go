@@ -0,0 +1,328 @@
+// Package dashboard serves a live view of a running traversal over HTTP, like geth's debug pages,
+// for operators of long exports who would otherwise tail logs. The page shows the progress of each
+// bin, a graph of throughput, the checkpoints saved and the errors of failed bins, and refreshes
+// itself; the same data is served as JSON by "?format=json".
+//
+// A Dashboard is a metrics.Collector, so it counts the nodes of the bins visited through its Visitor
+// and the checkpoints of a tracker configured WithCollector. It also reads the
+// positions and checkpoint statistics of the tracker it is given by Track, and the bin counts of a
+// TraverseMonitor. The bins finished through its Visitor are recorded by the dashboard, as a
+// tracker does not keep their positions by default:
+//
+//	monitor := new(iter.TraverseMonitor)
+//	dash := dashboard.New(dashboard.WithMonitor(monitor))
+//	tr := tracker.New(recoveryFile, 256, tracker.WithCollector(dash), tracker.WithAutoCheckpoint(time.Minute))
+//	dash.Track(tr)
+//	go http.ListenAndServe("localhost:8080", dash)
+//
+//	err := iter.Traverse(ctx, makeIterator, 256, 16, dash.Visitor(visit),
+//		iter.WithTracker(tr), iter.WithMonitor(monitor))
+//
+// Nothing is served unless the caller starts a server, so the package adds no cost otherwise.
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/metrics"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+const (
+	// maxSamples, maxCheckpoints and maxErrors bound the history kept for the page; the oldest
+	// entries are dropped first.
+	maxSamples     = 300
+	maxCheckpoints = 100
+	maxErrors      = 100
+	// sampleNodes is the number of nodes between checks of the sample interval.
+	sampleNodes = 1024
+)
+
+// Tracker is the part of a tracker.Tracker which the dashboard reads.
+type Tracker interface {
+	Positions() []tracker.LivePosition
+	CheckpointStats() tracker.CheckpointStats
+}
+
+var _ metrics.Collector = &Dashboard{}
+
+// Dashboard collects the progress of a traversal and serves it as a web page. It is safe for
+// concurrent use.
+type Dashboard struct {
+	monitor  *iter.TraverseMonitor
+	interval time.Duration
+	started  time.Time
+
+	nodes, leaves, bins atomic.Uint64
+
+	tracker     Tracker
+	samples     []Sample
+	checkpoints []time.Time
+	errors      []BinError
+	finished    []tracker.LivePosition // positions of the bins finished through Visitor
+	mu          sync.Mutex             // guards tracker, samples, checkpoints, errors and finished
+}
+
+// Option configures a Dashboard.
+type Option func(*Dashboard)
+
+// WithMonitor shows the bin counts of a traversal.
+func WithMonitor(m *iter.TraverseMonitor) Option {
+	return func(d *Dashboard) {
+		d.monitor = m
+	}
+}
+
+// WithSampleInterval sets the interval between the throughput samples of the graph, one second
+// by default.
+func WithSampleInterval(interval time.Duration) Option {
+	return func(d *Dashboard) {
+		d.interval = interval
+	}
+}
+
+// New returns a dashboard for a traversal starting now.
+func New(opts ...Option) *Dashboard {
+	d := &Dashboard{interval: time.Second, started: time.Now()}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.samples = []Sample{{Time: d.started}}
+	return d
+}
+
+// Sample is the number of nodes visited by a point in time.
+type Sample struct {
+	Time   time.Time `json:"time"`
+	Nodes  uint64    `json:"nodes"`
+	Leaves uint64    `json:"leaves"`
+}
+
+// BinError is the error of a failed bin.
+type BinError struct {
+	Time time.Time `json:"time"`
+	// Path is the path of the bin's iterator when it failed.
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// NodeVisited counts a node, and records a throughput sample once the sample interval has passed.
+func (d *Dashboard) NodeVisited(_ int, _ []byte, leaf bool) {
+	if leaf {
+		d.leaves.Add(1)
+	}
+	if d.nodes.Add(1)%sampleNodes == 0 {
+		d.sample(time.Now())
+	}
+}
+
+// BinCompleted counts a completed bin.
+func (d *Dashboard) BinCompleted(int) {
+	d.bins.Add(1)
+}
+
+// Track shows the positions of a tracker's iterators as the progress of each bin, and its
+// checkpoint statistics. The tracker is usually made after the dashboard, to report its
+// checkpoints to it.
+func (d *Dashboard) Track(tr Tracker) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tracker = tr
+}
+
+// CheckpointSaved records the time of a checkpoint.
+func (d *Dashboard) CheckpointSaved() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.checkpoints) == maxCheckpoints {
+		d.checkpoints = d.checkpoints[1:]
+	}
+	d.checkpoints = append(d.checkpoints, time.Now())
+}
+
+// Visitor wraps a visitor to count the nodes of each bin with metrics.NewIterator, reporting to the
+// dashboard, and to record the bins it finishes and the errors of those it fails, as Traverse only
+// returns the first. Bins are numbered in the order they are visited.
+func (d *Dashboard) Visitor(visit iter.Visitor) iter.Visitor {
+	var bin atomic.Int64
+	return func(it trie.NodeIterator) error {
+		err := visit(metrics.NewIterator(it, d, int(bin.Add(1)-1)))
+		if err == nil {
+			err = it.Error()
+		}
+		if err != nil {
+			d.ReportError(it.Path(), err)
+		} else {
+			d.binFinished(it)
+		}
+		return err
+	}
+}
+
+// binFinished records the position of a finished bin, if it is bounded.
+func (d *Dashboard) binFinished(it trie.NodeIterator) {
+	_, end, err := iter.Bounds(it)
+	if err != nil {
+		return
+	}
+	pos := tracker.LivePosition{
+		Position: tracker.Position{Path: common.CopyBytes(it.Path()), EndPath: end, Kind: tracker.TrieIterator},
+		State:    tracker.Finished,
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.finished = append(d.finished, pos)
+}
+
+// ReportError records the error of a bin whose iterator was at path.
+func (d *Dashboard) ReportError(path []byte, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.errors) == maxErrors {
+		d.errors = d.errors[1:]
+	}
+	d.errors = append(d.errors, BinError{Time: time.Now(), Path: common.Bytes2Hex(path), Error: err.Error()})
+}
+
+func (d *Dashboard) sample(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.samples[len(d.samples)-1].Time) < d.interval {
+		return
+	}
+	if len(d.samples) == maxSamples {
+		d.samples = d.samples[1:]
+	}
+	d.samples = append(d.samples, Sample{Time: now, Nodes: d.nodes.Load(), Leaves: d.leaves.Load()})
+}
+
+// Status is the state of a traversal as shown by the dashboard.
+type Status struct {
+	Started time.Time     `json:"started"`
+	Elapsed time.Duration `json:"elapsedNanos"`
+	// Nodes and Leaves count the nodes visited, and CompletedBins the bins exhausted.
+	Nodes         uint64 `json:"nodes"`
+	Leaves        uint64 `json:"leaves"`
+	CompletedBins uint64 `json:"completedBins"`
+	// Rate is the number of nodes visited per second over the last sample interval.
+	Rate    float64   `json:"rate"`
+	Samples []Sample  `json:"samples"`
+	Bins    []BinInfo `json:"bins,omitempty"`
+	// Monitor holds the bin counts of the traversal, if the dashboard has a monitor.
+	Monitor *iter.TraverseStats `json:"monitor,omitempty"`
+	// Checkpoints holds the statistics of the tracker's checkpoints, if the dashboard has a
+	// tracker, and CheckpointTimes the times of the latest ones.
+	Checkpoints     *tracker.CheckpointStats `json:"checkpoints,omitempty"`
+	CheckpointTimes []time.Time              `json:"checkpointTimes,omitempty"`
+	Errors          []BinError               `json:"errors,omitempty"`
+}
+
+// BinInfo is the progress of a bin of the state trie, as tracked.
+type BinInfo struct {
+	State   string `json:"state"`
+	Path    string `json:"path"`
+	EndPath string `json:"endPath"`
+	// Progress is the fraction of the bin's keyspace before its path.
+	Progress float64 `json:"progress"`
+}
+
+// Status returns the current state of the traversal.
+func (d *Dashboard) Status() Status {
+	now := time.Now()
+	status := Status{
+		Started:       d.started,
+		Elapsed:       now.Sub(d.started),
+		Nodes:         d.nodes.Load(),
+		Leaves:        d.leaves.Load(),
+		CompletedBins: d.bins.Load(),
+	}
+	d.mu.Lock()
+	status.Samples = append(append([]Sample(nil), d.samples...), Sample{Time: now, Nodes: status.Nodes, Leaves: status.Leaves})
+	status.CheckpointTimes = append([]time.Time(nil), d.checkpoints...)
+	status.Errors = append([]BinError(nil), d.errors...)
+	tr := d.tracker
+	finished := append([]tracker.LivePosition(nil), d.finished...)
+	d.mu.Unlock()
+	if n := len(status.Samples); n > 1 {
+		last, prev := status.Samples[n-1], status.Samples[n-2]
+		if elapsed := last.Time.Sub(prev.Time).Seconds(); elapsed > 0 {
+			status.Rate = float64(last.Nodes-prev.Nodes) / elapsed
+		}
+	}
+	if d.monitor != nil {
+		stats := d.monitor.Stats()
+		status.Monitor = &stats
+	}
+	if tr != nil {
+		stats := tr.CheckpointStats()
+		status.Checkpoints = &stats
+		status.Bins = bins(tr.Positions(), finished)
+	}
+	return status
+}
+
+// bins returns the progress of the tracked and finished bins of the state trie. Finished positions
+// kept by the tracker are ignored in favour of those recorded by Visitor. Each bin is taken to
+// start at the end of the one before it, as they do when a trie is divided into subtries.
+func bins(positions, finished []tracker.LivePosition) []BinInfo {
+	state := finished
+	for _, pos := range positions {
+		if pos.Kind == tracker.TrieIterator && pos.Owner == (common.Hash{}) && pos.State != tracker.Finished {
+			state = append(state, pos)
+		}
+	}
+	progress := func(path []byte) float64 {
+		if path == nil {
+			return 1
+		}
+		return iter.Progress(path)
+	}
+	sort.Slice(state, func(i, j int) bool { return progress(state[i].EndPath) < progress(state[j].EndPath) })
+
+	infos := make([]BinInfo, len(state))
+	start := 0.0
+	for i, pos := range state {
+		end := progress(pos.EndPath)
+		info := BinInfo{
+			State:   pos.State.String(),
+			Path:    common.Bytes2Hex(pos.Path),
+			EndPath: common.Bytes2Hex(pos.EndPath),
+		}
+		switch {
+		case pos.State == tracker.Finished:
+			info.Progress = 1
+		case end > start:
+			info.Progress = (iter.Progress(pos.Path) - start) / (end - start)
+			if info.Progress < 0 {
+				info.Progress = 0
+			} else if info.Progress > 1 {
+				info.Progress = 1
+			}
+		}
+		infos[i], start = info, end
+	}
+	return infos
+}
+
+// ServeHTTP serves the dashboard page, or its status as JSON with "?format=json".
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := d.Status()
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, newPageData(status)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,134 @@
+package dashboard_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/dashboard"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestDashboard(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	monitor := new(iter.TraverseMonitor)
+	dash := dashboard.New(dashboard.WithMonitor(monitor))
+	tr := tracker.New(t.TempDir()+"/recovery.csv", 4, tracker.WithCollector(dash))
+	dash.Track(tr)
+
+	var nodes, leaves uint64
+	failure := errors.New("bin failed")
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			nodes++
+			if it.Leaf() {
+				leaves++
+			}
+			// fail the last bin after its first leaf
+			if it.Leaf() && len(it.Path()) > 0 && it.Path()[0] == 0xf {
+				return failure
+			}
+		}
+		return nil
+	}
+	err := iter.Traverse(context.Background(), tree.NodeIterator, 4, 1, dash.Visitor(visit),
+		iter.WithTracker(tr), iter.WithMonitor(monitor))
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the bin's failure, got %v", err)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := dash.Status()
+	if status.Nodes != nodes || status.Leaves != leaves {
+		t.Fatalf("expected %d nodes and %d leaves, got %d and %d", nodes, leaves, status.Nodes, status.Leaves)
+	}
+	if status.CompletedBins != 3 {
+		t.Fatalf("expected 3 completed bins, got %d", status.CompletedBins)
+	}
+	if status.Monitor == nil || status.Monitor.Completed != 3 || status.Monitor.Failed != 1 {
+		t.Fatalf("expected the monitor's bin counts, got %+v", status.Monitor)
+	}
+	// the final save is reported to the collector, though not counted as a checkpoint
+	if status.Checkpoints == nil || len(status.CheckpointTimes) != 1 {
+		t.Fatalf("expected the tracker's save, got %+v and %v", status.Checkpoints, status.CheckpointTimes)
+	}
+	if len(status.Errors) != 1 || status.Errors[0].Error != failure.Error() {
+		t.Fatalf("expected the bin's error, got %+v", status.Errors)
+	}
+
+	if len(status.Bins) != 4 {
+		t.Fatalf("expected 4 bins, got %+v", status.Bins)
+	}
+	for i, bin := range status.Bins[:3] {
+		if bin.State != tracker.Finished.String() || bin.Progress != 1 {
+			t.Fatalf("expected bin %d to be finished, got %+v", i, bin)
+		}
+	}
+	if last := status.Bins[3]; last.State == tracker.Finished.String() || last.Progress <= 0 || last.Progress >= 1 {
+		t.Fatalf("expected the last bin to be partly done, got %+v", last)
+	}
+}
+
+func TestDashboardServe(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	dash := dashboard.New()
+	tr := tracker.New(t.TempDir()+"/recovery.csv", 4, tracker.WithCollector(dash))
+	dash.Track(tr)
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+		}
+		return nil
+	}
+	err := iter.Traverse(context.Background(), tree.NodeIterator, 4, 2, dash.Visitor(visit), iter.WithTracker(tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(dash)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status dashboard.Status
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Nodes == 0 || status.CompletedBins != 4 || len(status.Bins) != 4 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	resp, err = server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("expected an HTML page, got %q", ct)
+	}
+	for _, want := range []string{"<polyline", `<div style="width: 100.0%">`, "http-equiv=\"refresh\""} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("expected the page to contain %q:\n%s", want, body)
+		}
+	}
+}
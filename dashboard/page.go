@@ -0,0 +1,166 @@
+package dashboard
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+const (
+	// graphWidth and graphHeight are the size of the throughput graph in pixels.
+	graphWidth  = 600
+	graphHeight = 120
+	// refreshSeconds is the interval at which the page reloads itself.
+	refreshSeconds = 2
+)
+
+// pageData is a Status formatted for the page.
+type pageData struct {
+	Status
+	Refresh  int
+	Width    int
+	Height   int
+	Elapsed  string
+	Rate     string
+	MaxRate  string
+	Points   string
+	Bins     []pageBin
+	Times    []string
+	Interval string
+	Latency  string
+}
+
+type pageBin struct {
+	BinInfo
+	Percent string
+}
+
+func newPageData(status Status) pageData {
+	data := pageData{
+		Status:  status,
+		Refresh: refreshSeconds,
+		Width:   graphWidth,
+		Height:  graphHeight,
+		Elapsed: status.Elapsed.Round(time.Second).String(),
+		Rate:    fmt.Sprintf("%.0f", status.Rate),
+	}
+	for _, bin := range status.Bins {
+		data.Bins = append(data.Bins, pageBin{BinInfo: bin, Percent: fmt.Sprintf("%.1f", 100*bin.Progress)})
+	}
+	// newest first
+	for i := len(status.CheckpointTimes) - 1; i >= 0; i-- {
+		data.Times = append(data.Times, status.CheckpointTimes[i].Format(time.RFC3339))
+	}
+	if stats := status.Checkpoints; stats != nil {
+		data.Interval = stats.LastInterval.Round(time.Millisecond).String()
+		data.Latency = fmt.Sprintf("%s (max %s)",
+			stats.LastLatency.Round(time.Millisecond), stats.MaxLatency.Round(time.Millisecond))
+	}
+	data.Points, data.MaxRate = graph(status.Samples)
+	return data
+}
+
+// graph returns the points of a polyline of the node rate between samples, scaled to the graph,
+// and the rate at its top.
+func graph(samples []Sample) (string, string) {
+	if len(samples) < 2 {
+		return "", "0"
+	}
+	rates := make([]float64, len(samples)-1)
+	peak := 0.0
+	for i := range rates {
+		prev, next := samples[i], samples[i+1]
+		if elapsed := next.Time.Sub(prev.Time).Seconds(); elapsed > 0 {
+			rates[i] = float64(next.Nodes-prev.Nodes) / elapsed
+		}
+		if rates[i] > peak {
+			peak = rates[i]
+		}
+	}
+	span := samples[len(samples)-1].Time.Sub(samples[0].Time).Seconds()
+	var points strings.Builder
+	for i, rate := range rates {
+		x := 0.0
+		if span > 0 {
+			x = samples[i+1].Time.Sub(samples[0].Time).Seconds() / span * graphWidth
+		}
+		y := float64(graphHeight)
+		if peak > 0 {
+			y -= rate / peak * graphHeight
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f ", x, y)
+	}
+	return strings.TrimSpace(points.String()), fmt.Sprintf("%.0f", peak)
+}
+
+var page = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Traversal</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; }
+.bar { width: 300px; height: 12px; background: #eee; }
+.bar div { height: 100%; background: #4a8; }
+.error { color: #b22; }
+code { font-size: 90%; }
+</style>
+</head>
+<body>
+<h1>Traversal</h1>
+<p>
+Running for {{.Elapsed}}: {{.Nodes}} nodes, {{.Leaves}} leaves, {{.CompletedBins}} bins completed,
+{{.Rate}} nodes/s.
+{{with .Monitor}}Bins queued {{.Queued}}, active {{.Active}}, completed {{.Completed}}, failed {{.Failed}}.{{end}}
+</p>
+
+<h2>Throughput</h2>
+<svg width="{{.Width}}" height="{{.Height}}" style="border: 1px solid #ccc">
+<polyline fill="none" stroke="#4a8" stroke-width="2" points="{{.Points}}"/>
+</svg>
+<p>Peak {{.MaxRate}} nodes/s.</p>
+
+{{if .Bins}}
+<h2>Bins</h2>
+<table>
+<tr><th>State</th><th>Progress</th><th></th><th>Path</th><th>End</th></tr>
+{{range .Bins}}<tr>
+<td>{{.State}}</td>
+<td><div class="bar"><div style="width: {{.Percent}}%"></div></div></td>
+<td>{{.Percent}}%</td>
+<td><code>{{.Path}}</code></td>
+<td><code>{{.EndPath}}</code></td>
+</tr>
+{{end}}</table>
+{{end}}
+
+{{with .Checkpoints}}
+<h2>Checkpoints</h2>
+<table>
+<tr><td>Saved</td><td>{{.Saved}}</td></tr>
+<tr><td>Coalesced</td><td>{{.Coalesced}}</td></tr>
+<tr><td>Failed</td><td>{{.Failed}}</td></tr>
+<tr><td>Latency</td><td>{{$.Latency}}</td></tr>
+<tr><td>Interval</td><td>{{$.Interval}}</td></tr>
+</table>
+{{end}}
+{{if .Times}}
+<p>Latest:</p>
+<ul>
+{{range .Times}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+
+{{if .Errors}}
+<h2>Errors</h2>
+<ul>
+{{range .Errors}}<li class="error">{{.Time.Format "15:04:05"}} at <code>{{.Path}}</code>: {{.Error}}</li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
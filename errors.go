@@ -0,0 +1,75 @@
+package iterator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// IteratorError is an error returned by a traversal, annotated with where it occurred, so that the
+// failing region of a trie can be found. It wraps the error of the bin's visitor or iterator, so
+// errors.Is and errors.As see through it.
+type IteratorError struct {
+	Err error
+	// Path is the path at which the bin failed: the path of the node its iterator failed to
+	// resolve, or else the iterator's position when the visitor failed.
+	Path []byte
+	// Bin is the index of the failing bin in the traversal.
+	Bin int
+	// Root is the root of the traversed trie, if it was given with WithRoot, or else zero.
+	Root common.Hash
+}
+
+func (e *IteratorError) Error() string {
+	if e.Root != (common.Hash{}) {
+		return fmt.Sprintf("bin %d at path %x (root %s): %v", e.Bin, e.Path, e.Root, e.Err)
+	}
+	return fmt.Sprintf("bin %d at path %x: %v", e.Bin, e.Path, e.Err)
+}
+
+func (e *IteratorError) Unwrap() error {
+	return e.Err
+}
+
+// newIteratorError annotates the error of a bin with the path at failure. Only the bin's own
+// iterator is checked for a missing node, as the visitor may fail resolving another trie's.
+func newIteratorError(err error, bin int, it trie.NodeIterator, root common.Hash) *IteratorError {
+	var path []byte
+	var missing *trie.MissingNodeError
+	if errors.As(it.Error(), &missing) {
+		path = common.CopyBytes(missing.Path)
+	} else {
+		path = common.CopyBytes(it.Path())
+	}
+	return &IteratorError{Err: err, Path: path, Bin: bin, Root: root}
+}
+
+// ErrorPath returns the path at which a traversal failed, if err is or wraps an IteratorError.
+func ErrorPath(err error) ([]byte, bool) {
+	var ierr *IteratorError
+	if !errors.As(err, &ierr) {
+		return nil, false
+	}
+	return ierr.Path, true
+}
+
+// ErrorBin returns the index of the bin which failed, if err is or wraps an IteratorError.
+func ErrorBin(err error) (int, bool) {
+	var ierr *IteratorError
+	if !errors.As(err, &ierr) {
+		return 0, false
+	}
+	return ierr.Bin, true
+}
+
+// ErrorRoot returns the root of the trie whose traversal failed, if err is or wraps an
+// IteratorError and the root is known.
+func ErrorRoot(err error) (common.Hash, bool) {
+	var ierr *IteratorError
+	if !errors.As(err, &ierr) || ierr.Root == (common.Hash{}) {
+		return common.Hash{}, false
+	}
+	return ierr.Root, true
+}
@@ -0,0 +1,96 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+func TestIteratorError(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	tdb := triedb.NewDatabase(db, nil)
+	tree := trie.NewEmpty(tdb)
+	for i := 0; i < 500; i++ {
+		tree.MustUpdate(crypto.Keccak256([]byte{byte(i >> 8), byte(i)}), []byte{1, byte(i)})
+	}
+	root, nodes, err := tree.Commit(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tdb.Update(root, types.EmptyRootHash, 0, trienode.NewWithNodeSet(nodes), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tdb.Commit(root, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// remove a node from the last bin, past the node at its start which the previous bin also reaches
+	tdb = triedb.NewDatabase(db, nil)
+	makeIterator := iter.NewTrieDBConstructor(tdb, trie.StateTrieID(root))
+	it, err := makeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var missing []byte
+	for it.Next(true) {
+		if path := it.Path(); len(path) == 2 && path[0] == 0xf && path[1] > 0 && it.Hash() != (common.Hash{}) {
+			missing = append([]byte(nil), path...)
+			db.Delete(it.Hash().Bytes())
+			break
+		}
+	}
+
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+		}
+		return nil
+	}
+	err = iter.Traverse(context.Background(), makeIterator, 16, 4, visit, iter.WithRoot(root))
+	var merr *trie.MissingNodeError
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected MissingNodeError, got %v", err)
+	}
+	if path, ok := iter.ErrorPath(err); !ok || !bytes.Equal(path, missing) {
+		t.Fatalf("expected failure at %x, got %x", missing, path)
+	}
+	if bin, ok := iter.ErrorBin(err); !ok || bin != 15 {
+		t.Fatalf("expected failure in bin 15, got %d", bin)
+	}
+	if r, ok := iter.ErrorRoot(err); !ok || r != root {
+		t.Fatalf("expected root %s, got %s", root, r)
+	}
+
+	// a visitor's error is located at the iterator's position
+	errStop := errors.New("stop")
+	err = iter.Traverse(context.Background(), makeIterator, 1, 1, func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			if len(it.Path()) == 3 {
+				return errStop
+			}
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected visitor error, got %v", err)
+	}
+	if path, _ := iter.ErrorPath(err); len(path) != 3 {
+		t.Fatalf("expected failure at a path of length 3, got %x", path)
+	}
+	if _, ok := iter.ErrorRoot(err); ok {
+		t.Fatal("expected no root")
+	}
+	if _, ok := iter.ErrorBin(errStop); ok {
+		t.Fatal("expected no bin for a bare error")
+	}
+}
@@ -0,0 +1,175 @@
+package iterator_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// exampleTrie returns an in-memory trie holding n leaves with hashed keys.
+func exampleTrie(n int) *trie.Trie {
+	tree := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for i := 0; i < n; i++ {
+		key := crypto.Keccak256([]byte{byte(i >> 8), byte(i)})
+		tree.MustUpdate(key, []byte{1, byte(i)})
+	}
+	return tree
+}
+
+func ExampleMakePaths() {
+	fmt.Println(iter.MakePaths(nil, 4))
+	fmt.Println(iter.MakePaths([]byte{4}, 2))
+	// Output:
+	// [[0] [4] [8] [12]]
+	// [[4 0] [4 8]]
+}
+
+func ExampleMakePathsAtDepth() {
+	fmt.Println(iter.MakePaths(nil, 3))
+	fmt.Println(iter.MakePathsAtDepth(nil, 3, 2))
+	// Output:
+	// [[0] [5] [10]]
+	// [[0 0] [5 5] [10 10]]
+}
+
+// Traverse a trie in parallel by dividing it into subtries, one per goroutine.
+func ExampleSubtrieIterators() {
+	tree := exampleTrie(1000)
+
+	iters, err := iter.SubtrieIterators(tree.NodeIterator, 8)
+	if err != nil {
+		panic(err)
+	}
+
+	var wg sync.WaitGroup
+	counts := make([]int, len(iters))
+	for i, it := range iters {
+		wg.Add(1)
+		go func(i int, it trie.NodeIterator) {
+			defer wg.Done()
+			for it.Next(true) {
+				if it.Leaf() {
+					counts[i]++
+				}
+			}
+		}(i, it)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	fmt.Println("leaves:", total)
+	// Output:
+	// leaves: 1000
+}
+
+// Find the leaves changed between two roots, diffing each subtrie on its own goroutine.
+func ExampleNewBoundedDifferenceIterator() {
+	a, b := exampleTrie(1000), exampleTrie(1000)
+	for i := 0; i < 10; i++ {
+		b.MustUpdate(crypto.Keccak256([]byte{0, byte(i)}), []byte{2, byte(i)})
+	}
+
+	starts, ends := iter.SubtrieBounds(4)
+	var wg sync.WaitGroup
+	changed := make([]int, len(starts))
+	for i := range starts {
+		// both iterators start at the first key of the bin, padding its path to whole bytes
+		var startKey []byte
+		if start := starts[i]; len(start) > 0 {
+			if len(start)%2 != 0 {
+				start = append(append([]byte(nil), start...), 0)
+			}
+			startKey = iter.HexToKeyBytes(start)
+		}
+		itA, err := a.NodeIterator(startKey)
+		if err != nil {
+			panic(err)
+		}
+		itB, err := b.NodeIterator(startKey)
+		if err != nil {
+			panic(err)
+		}
+		diff, _ := iter.NewBoundedDifferenceIterator(itA, itB, ends[i])
+
+		wg.Add(1)
+		go func(i int, it trie.NodeIterator) {
+			defer wg.Done()
+			for it.Next(true) {
+				if it.Leaf() {
+					changed[i]++
+				}
+			}
+		}(i, diff)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, count := range changed {
+		total += count
+	}
+	fmt.Println("changed leaves:", total)
+	// Output:
+	// changed leaves: 10
+}
+
+// Visit the storage trie of every account of a state, on a pool of workers.
+func ExampleTraverseStorage() {
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		panic(err)
+	}
+	// account i holds i storage slots
+	for i := 1; i <= 8; i++ {
+		addr := common.BytesToAddress([]byte{byte(i)})
+		statedb.SetNonce(addr, 1)
+		for j := 1; j <= i; j++ {
+			statedb.SetState(addr, common.BytesToHash([]byte{byte(j)}), common.BytesToHash([]byte{1}))
+		}
+	}
+	root, err := statedb.Commit(1, false)
+	if err != nil {
+		panic(err)
+	}
+	accounts, err := iter.NewTrieDBConstructor(sdb.TrieDB(), trie.StateTrieID(root))(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	var mu sync.Mutex
+	slots, tries := 0, 0
+	err = iter.TraverseStorage(context.Background(), sdb.TrieDB(), root, accounts,
+		func(account common.Hash, it trie.NodeIterator) error {
+			n := 0
+			for it.Next(true) {
+				if it.Leaf() {
+					n++
+				}
+			}
+			mu.Lock()
+			slots, tries = slots+n, tries+1
+			mu.Unlock()
+			return it.Error()
+		}, iter.WithStorageWorkers(4))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("storage tries:", tries)
+	fmt.Println("slots:", slots)
+	// Output:
+	// storage tries: 8
+	// slots: 36
+}
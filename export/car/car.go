@@ -0,0 +1,195 @@
+// Package car exports the nodes of a trie as IPLD blocks in a CAR (content addressable archive,
+// version 1) file, each keyed by the CID of its keccak-256 hash. Embedded nodes are part of their
+// parent's block, so only hashed nodes are written.
+//
+// A Writer is an export.Sink for a traversal, and can be shared by all its bins:
+//
+//	w, err := car.NewWriter(file, car.EthStateTrie, []common.Hash{root})
+//	if err != nil { ... }
+//	err = iter.Traverse(ctx, makeIterator, 16, 8, w.Visit, iter.WithTracker(tr))
+//	if ferr := w.Flush(); err == nil {
+//		err = ferr
+//	}
+//
+// Blocks are appended as they are visited, so an interrupted export is resumed into a new file,
+// from the iterators restored by the tracker, and the files are read together.
+package car
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	"github.com/cerc-io/eth-iterator-utils/export"
+	"github.com/cerc-io/eth-iterator-utils/hashset"
+)
+
+// Multicodecs of Ethereum trie nodes.
+const (
+	EthStateTrie   uint64 = 0x96
+	EthStorageTrie uint64 = 0x98
+)
+
+const (
+	cidVersion = 1
+	keccak256  = 0x1b // multihash code
+)
+
+// CID returns the binary CIDv1 of the trie node with the given hash and codec.
+func CID(codec uint64, hash common.Hash) []byte {
+	cid := make([]byte, 0, 8+common.HashLength)
+	cid = binary.AppendUvarint(cid, cidVersion)
+	cid = binary.AppendUvarint(cid, codec)
+	cid = binary.AppendUvarint(cid, keccak256)
+	cid = binary.AppendUvarint(cid, common.HashLength)
+	return append(cid, hash[:]...)
+}
+
+// Option configures a Writer.
+type Option func(*Writer)
+
+// WithDedup skips nodes whose hash is in the set, and adds the hashes of written nodes to it, so
+// that nodes visited twice, e.g. at the shared bound of adjacent bins or in tries sharing
+// subtries, are written once. A set kept from an interrupted export also skips the nodes already
+// written to its file.
+func WithDedup(seen hashset.HashMembership) Option {
+	return func(w *Writer) {
+		w.seen = seen
+	}
+}
+
+// WithoutHeader omits the header, to append blocks to a CAR file which already has one, e.g. one
+// rolled back to a checkpoint by a wal.Log.
+func WithoutHeader() Option {
+	return func(w *Writer) {
+		w.noHeader = true
+	}
+}
+
+// Writer writes trie nodes to a CAR file. It is safe for concurrent use.
+type Writer struct {
+	out   *bufio.Writer
+	codec uint64
+	seen  hashset.HashMembership
+
+	noHeader bool
+
+	blocks uint64
+	buf    []byte
+	mu     sync.Mutex // guards out, seen, blocks and buf
+}
+
+var _ export.Sink = &Writer{}
+
+// NewWriter writes the header of a CAR file with the given roots to out, unless WithoutHeader is
+// passed, and returns a writer of nodes of the given codec to it.
+func NewWriter(out io.Writer, codec uint64, roots []common.Hash, opts ...Option) (*Writer, error) {
+	w := &Writer{out: bufio.NewWriter(out), codec: codec}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.noHeader {
+		return w, nil
+	}
+	header := encodeHeader(codec, roots)
+	w.buf = binary.AppendUvarint(w.buf[:0], uint64(len(header)))
+	if _, err := w.out.Write(append(w.buf, header...)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// encodeHeader encodes the CAR header {roots: [CID...], version: 1} as DAG-CBOR, in which map
+// keys are sorted by length, and CIDs are tag 42 byte strings with a zero prefix.
+func encodeHeader(codec uint64, roots []common.Hash) []byte {
+	header := []byte{0xa2} // map of 2 entries
+	header = appendCBORText(header, "roots")
+	header = appendCBORHead(header, 4, uint64(len(roots))) // array
+	for _, root := range roots {
+		cid := CID(codec, root)
+		header = append(header, 0xd8, 42) // tag 42
+		header = appendCBORHead(header, 2, uint64(len(cid)+1))
+		header = append(append(header, 0), cid...)
+	}
+	header = appendCBORText(header, "version")
+	return appendCBORHead(header, 0, 1)
+}
+
+func appendCBORHead(buf []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= 0xff:
+		return append(buf, major|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+}
+
+func appendCBORText(buf []byte, s string) []byte {
+	return append(appendCBORHead(buf, 3, uint64(len(s))), s...)
+}
+
+// Put writes a node blob as a block, unless it has been written already.
+func (w *Writer) Put(hash common.Hash, blob []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// the set is checked under the lock, so that bins meeting at a node don't both write it
+	if w.seen != nil {
+		if seen, err := w.seen.Contains(hash); err != nil || seen {
+			return err
+		}
+	}
+	cid := CID(w.codec, hash)
+	w.buf = binary.AppendUvarint(w.buf[:0], uint64(len(cid)+len(blob)))
+	w.buf = append(append(w.buf, cid...), blob...)
+	if _, err := w.out.Write(w.buf); err != nil {
+		return err
+	}
+	w.blocks++
+	if w.seen != nil {
+		return w.seen.Add(hash)
+	}
+	return nil
+}
+
+// Visit writes every hashed node yielded by the iterator, e.g. a bin of a traversal.
+func (w *Writer) Visit(it trie.NodeIterator) error {
+	for it.Next(true) {
+		hash := it.Hash()
+		if hash == (common.Hash{}) {
+			continue
+		}
+		blob := it.NodeBlob()
+		if len(blob) == 0 {
+			return fmt.Errorf("no blob for node %x at path %x", hash, it.Path())
+		}
+		if err := w.Put(hash, blob); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// Blocks returns the number of blocks written.
+func (w *Writer) Blocks() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.blocks
+}
+
+// Flush writes any buffered blocks to the underlying writer.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Flush()
+}
@@ -0,0 +1,103 @@
+package car_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/export/car"
+	"github.com/cerc-io/eth-iterator-utils/hashset"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestWriter(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var file bytes.Buffer
+	root := tree.Hash()
+	w, err := car.NewWriter(&file, car.EthStateTrie, []common.Hash{root}, car.WithDedup(hashset.NewMemory()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, w.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hashed nodes of the trie, each written once
+	expected := map[common.Hash]bool{}
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+		if it.Hash() != (common.Hash{}) {
+			expected[it.Hash()] = true
+		}
+	}
+
+	in := bufio.NewReader(&file)
+	header := readSection(t, in)
+	rootCID := car.CID(car.EthStateTrie, root)
+	var want []byte
+	want = append(want, 0xa2, 0x65)
+	want = append(want, "roots"...)
+	want = append(want, 0x81, 0xd8, 0x2a, 0x58, byte(len(rootCID)+1), 0x00)
+	want = append(want, rootCID...)
+	want = append(want, 0x67)
+	want = append(want, "version"...)
+	want = append(want, 0x01)
+	if !bytes.Equal(header, want) {
+		t.Fatalf("wrong header:\n%x\nexpected\n%x", header, want)
+	}
+	if !bytes.Equal(rootCID[:5], []byte{0x01, 0x96, 0x01, 0x1b, 0x20}) {
+		t.Fatalf("wrong CID prefix: %x", rootCID[:5])
+	}
+
+	blocks := 0
+	for {
+		block := readSection(t, in)
+		if block == nil {
+			break
+		}
+		cid, blob := block[:len(rootCID)], block[len(rootCID):]
+		hash := crypto.Keccak256Hash(blob)
+		if !bytes.Equal(cid, car.CID(car.EthStateTrie, hash)) {
+			t.Fatalf("block CID %x does not match its data", cid)
+		}
+		if !expected[hash] {
+			t.Fatalf("unexpected or duplicate block %x", hash)
+		}
+		delete(expected, hash)
+		blocks++
+	}
+	if len(expected) != 0 || uint64(blocks) != w.Blocks() {
+		t.Fatalf("%d nodes not written, %d blocks of %d counted", len(expected), blocks, w.Blocks())
+	}
+}
+
+// readSection reads a varint length-prefixed section, or returns nil at the end of the file.
+func readSection(t *testing.T, in *bufio.Reader) []byte {
+	n, err := binary.ReadUvarint(in)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(in, data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,17 @@
+// Package export defines the interface shared by the exporters in its subpackages, which write
+// the nodes or leaves of a trie to files as they are visited by a traversal.
+package export
+
+import (
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Sink is an exporter which is driven by the bins of a traversal, e.g. with a tracker so that an
+// interrupted export can be resumed into a new file. Visit is called concurrently by the bins,
+// and Flush once they finish.
+type Sink interface {
+	// Visit writes the nodes yielded by an iterator, and is an iter.Visitor.
+	Visit(trie.NodeIterator) error
+	// Flush writes any buffered output to the underlying writer.
+	Flush() error
+}
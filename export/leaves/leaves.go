@@ -0,0 +1,240 @@
+// Package leaves exports the leaves of a trie as rows of their key and value, i.e. the RLP of an
+// account or storage slot, as CSV or newline-delimited JSON. Keys and values are 0x-prefixed hex.
+//
+// A Writer is an export.Sink, and can be shared by all the bins of a traversal:
+//
+//	w, err := leaves.NewWriter(file, leaves.NDJSON, leaves.WithGzip())
+//	if err != nil { ... }
+//	err = iter.Traverse(ctx, makeIterator, 16, 8, w.Visit, iter.WithTracker(tr))
+//	if cerr := w.Close(); err == nil {
+//		err = cerr
+//	}
+//
+// Rows are written in batches, and every row reached by an iterator is written before Visit
+// returns, so an interrupted export is resumed into a new file from the iterators restored by the
+// tracker. Rows of a bin are in key order, but batches of concurrent bins are interleaved.
+//
+// WithSequence adds the bin of each row and its index in the bin, as numbered by a tracker made
+// with tracker.WithSequence. A resumed bin writes its rows again from its saved position with the
+// same indexes, so a consumer ingesting several files of a run can drop the rows it already has.
+package leaves
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/export"
+)
+
+// Format is the encoding of the rows.
+type Format int
+
+const (
+	// CSV rows have the columns key and value, and bin and seq WithSequence, and follow a header.
+	CSV Format = iota
+	// NDJSON rows are objects with the fields key and value, and bin and seq WithSequence, one per
+	// line.
+	NDJSON
+)
+
+// DefaultBatchSize is the number of rows a bin buffers before writing them.
+const DefaultBatchSize = 1000
+
+// Option configures a Writer.
+type Option func(*Writer)
+
+// WithBatchSize sets the number of rows each bin buffers before writing them to the output.
+func WithBatchSize(rows int) Option {
+	return func(w *Writer) {
+		w.batchSize = rows
+	}
+}
+
+// WithGzip compresses the output with gzip.
+func WithGzip() Option {
+	return func(w *Writer) {
+		w.gzip = true
+	}
+}
+
+// WithoutHeader omits the CSV header, to append rows to a file which already has one, e.g. one
+// rolled back to a checkpoint by a wal.Log.
+func WithoutHeader() Option {
+	return func(w *Writer) {
+		w.noHeader = true
+	}
+}
+
+// WithSequence adds the bin and index of each row, as numbered by a tracker made with
+// tracker.WithSequence: bin is the end path of the row's bin in hex, one byte per nibble, and seq
+// the decimal index of its leaf in the bin. Visit fails with iter.ErrUnsupportedIterator for a bin
+// whose nodes are not numbered.
+func WithSequence() Option {
+	return func(w *Writer) {
+		w.sequence = true
+	}
+}
+
+// Writer writes the leaves of a trie to a file. It is safe for concurrent use.
+type Writer struct {
+	format    Format
+	batchSize int
+	gzip      bool
+	noHeader  bool
+	sequence  bool
+
+	out  *bufio.Writer
+	zw   *gzip.Writer
+	rows uint64
+	mu   sync.Mutex // guards out, zw and rows
+}
+
+var _ export.Sink = &Writer{}
+
+// NewWriter returns a writer of rows in the given format to out, and writes the CSV header unless WithoutHeader is passed.
+func NewWriter(out io.Writer, format Format, opts ...Option) (*Writer, error) {
+	w := &Writer{format: format, batchSize: DefaultBatchSize}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.batchSize < 1 {
+		w.batchSize = 1
+	}
+	if w.gzip {
+		w.zw = gzip.NewWriter(out)
+		out = w.zw
+	}
+	w.out = bufio.NewWriter(out)
+	if format == CSV && !w.noHeader {
+		header := "key,value\n"
+		if w.sequence {
+			header = "key,value,bin,seq\n"
+		}
+		if _, err := w.out.WriteString(header); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Visit writes every leaf yielded by the iterator, e.g. a bin of a traversal.
+func (w *Writer) Visit(it trie.NodeIterator) error {
+	var (
+		batch []byte
+		rows  int
+	)
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		var (
+			bin   []byte
+			index uint64
+		)
+		if w.sequence {
+			var err error
+			if bin, index, err = iter.Sequence(it); err != nil {
+				return err
+			}
+		}
+		batch = w.appendRow(batch, it.LeafKey(), it.LeafBlob(), bin, index)
+		if rows++; rows == w.batchSize {
+			if err := w.write(batch, rows); err != nil {
+				return err
+			}
+			batch, rows = batch[:0], 0
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return w.write(batch, rows)
+}
+
+func (w *Writer) appendRow(buf, key, value, bin []byte, index uint64) []byte {
+	switch w.format {
+	case NDJSON:
+		buf = appendHex(append(buf, `{"key":"`...), key)
+		buf = appendHex(append(buf, `","value":"`...), value)
+		if w.sequence {
+			buf = appendHex(append(buf, `","bin":"`...), bin)
+			buf = strconv.AppendUint(append(buf, `","seq":"`...), index, 10)
+		}
+		return append(buf, "\"}\n"...)
+	default:
+		buf = appendHex(buf, key)
+		buf = appendHex(append(buf, ','), value)
+		if w.sequence {
+			buf = appendHex(append(buf, ','), bin)
+			buf = strconv.AppendUint(append(buf, ','), index, 10)
+		}
+		return append(buf, '\n')
+	}
+}
+
+func appendHex(buf, data []byte) []byte {
+	buf = append(buf, "0x"...)
+	n := len(buf)
+	buf = append(buf, make([]byte, hex.EncodedLen(len(data)))...)
+	hex.Encode(buf[n:], data)
+	return buf
+}
+
+func (w *Writer) write(batch []byte, rows int) error {
+	if rows == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(batch); err != nil {
+		return err
+	}
+	w.rows += uint64(rows)
+	return nil
+}
+
+// Rows returns the number of rows written.
+func (w *Writer) Rows() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rows
+}
+
+// Flush writes any buffered rows to the underlying writer. Compressed output remains a valid
+// gzip stream only once the writer is closed.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
+	if w.zw != nil {
+		return w.zw.Flush()
+	}
+	return nil
+}
+
+// Close flushes the writer and ends the gzip stream, if compressed. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if w.zw != nil {
+		return w.zw.Close()
+	}
+	return nil
+}
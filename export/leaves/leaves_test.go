@@ -0,0 +1,177 @@
+package leaves_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/export/leaves"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// fixtureLeaves returns the values of the leaves of the trie by key.
+func fixtureLeaves(t *testing.T, makeIterator iter.IteratorConstructor) map[string]string {
+	it, err := makeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{}
+	for it.Next(true) {
+		if it.Leaf() {
+			expected[hexutil.Encode(it.LeafKey())] = hexutil.Encode(it.LeafBlob())
+		}
+	}
+	if len(expected) == 0 {
+		t.Fatal("no leaves in fixture")
+	}
+	return expected
+}
+
+func checkRows(t *testing.T, expected map[string]string, rows [][2]string) {
+	got := map[string]string{}
+	for _, row := range rows {
+		got[row[0]] = row[1]
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d leaves, got %d", len(expected), len(got))
+	}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Fatalf("leaf %s: expected %s, got %s", key, value, got[key])
+		}
+	}
+}
+
+func TestWriterCSV(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var file bytes.Buffer
+	w, err := leaves.NewWriter(&file, leaves.CSV, leaves.WithBatchSize(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, w.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || records[0][0] != "key" || records[0][1] != "value" {
+		t.Fatalf("expected header, got %v", records)
+	}
+	if uint64(len(records)-1) != w.Rows() {
+		t.Fatalf("expected %d rows, got %d", w.Rows(), len(records)-1)
+	}
+	var rows [][2]string
+	for _, record := range records[1:] {
+		rows = append(rows, [2]string{record[0], record[1]})
+	}
+	checkRows(t, fixtureLeaves(t, tree.NodeIterator), rows)
+}
+
+func TestWriterNDJSONGzip(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var file bytes.Buffer
+	w, err := leaves.NewWriter(&file, leaves.NDJSON, leaves.WithGzip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, w.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][2]string
+	lines := bufio.NewScanner(zr)
+	for lines.Scan() {
+		var row struct{ Key, Value string }
+		if err := json.Unmarshal(lines.Bytes(), &row); err != nil {
+			t.Fatalf("row %q: %v", lines.Text(), err)
+		}
+		rows = append(rows, [2]string{row.Key, row.Value})
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(rows)) != w.Rows() {
+		t.Fatalf("expected %d rows, got %d", w.Rows(), len(rows))
+	}
+	checkRows(t, fixtureLeaves(t, tree.NodeIterator), rows)
+}
+
+func TestWriterSequence(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var file bytes.Buffer
+	w, err := leaves.NewWriter(&file, leaves.CSV, leaves.WithSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tracker.New(filepath.Join(t.TempDir(), "recovery.csv"), 16, tracker.WithSequence())
+	defer tr.CloseAndSave()
+	if err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, w.Visit, iter.WithTracker(tr)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || strings.Join(records[0], ",") != "key,value,bin,seq" {
+		t.Fatalf("expected header, got %v", records)
+	}
+	// the indexes of each bin's rows increase with their keys
+	last := map[string]uint64{}
+	var rows [][2]string
+	for _, record := range records[1:] {
+		seq, err := strconv.ParseUint(record[3], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq <= last[record[2]] {
+			t.Fatalf("row %v: expected index after %d", record, last[record[2]])
+		}
+		last[record[2]] = seq
+		rows = append(rows, [2]string{record[0], record[1]})
+	}
+	if len(last) != 16 {
+		t.Fatalf("expected rows of 16 bins, got %d", len(last))
+	}
+	checkRows(t, fixtureLeaves(t, tree.NodeIterator), rows)
+
+	// bins not numbered by a tracker can't be written
+	err = iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, w.Visit)
+	if !errors.Is(err, iter.ErrUnsupportedIterator) {
+		t.Fatalf("expected ErrUnsupportedIterator, got %v", err)
+	}
+}
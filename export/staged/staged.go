@@ -0,0 +1,386 @@
+// Package staged exports the bins of a traversal to staging files of their own, which are merged
+// into one file in path order once every bin is written. Bins are written concurrently, and the
+// output is sorted as if they had been visited one after another, however long each takes: unlike
+// the rows of a shared sink, those of a slow bin are never interleaved with the rest.
+//
+// Each bin is written by a sink of its own, e.g. a leaves.Writer without its header, and the
+// header of the merged file is passed to WithHeader:
+//
+//	_, ends := iter.SubtrieBounds(16)
+//	newSink := func(out io.Writer) (export.Sink, error) {
+//		return leaves.NewWriter(out, leaves.CSV, leaves.WithoutHeader())
+//	}
+//	w, err := staged.NewWriter("leaves.csv", ends, newSink, staged.WithHeader([]byte("key,value\n")))
+//	if err != nil { ... }
+//	if err := iter.Traverse(ctx, makeIterator, 16, 8, w.Visit); err != nil { ... }
+//	err = w.Merge()
+//
+// Both steps can be resumed by running them again. A bin's staging file is written atomically once
+// the bin is finished, and the bins already staged are skipped, so an interrupted traversal is
+// resumed by traversing the same bins again rather than restoring them from a tracker. Merge
+// records each staging file it appends in a log next to the output, with the output's size, and
+// deletes the staging file once both are synced; an interrupted merge truncates the output back to
+// the last entry and carries on from there. The merged file is renamed into place once complete.
+//
+// Bins are identified by their end paths, and merged in their order. The bins must be disjoint
+// and cover the trie for the output to hold each node once, as SubtrieIteratorsDedup's do; for a
+// leaves export, the bins of SubtrieIterators are enough.
+package staged
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/export"
+)
+
+var (
+	// ErrUnknownBin is returned by Visit for an iterator whose end path is not that of a bin.
+	ErrUnknownBin = errors.New("iterator is not over a staged bin")
+	// ErrIncomplete is returned by Merge when some bins have not been staged.
+	ErrIncomplete = errors.New("bins have not all been staged")
+)
+
+// NewSink returns the sink writing a bin to its staging file. If the sink is an io.Closer, it is
+// closed once the bin is visited, e.g. to end a gzip stream, otherwise it is flushed.
+type NewSink = func(out io.Writer) (export.Sink, error)
+
+// Option configures a Writer.
+type Option func(*Writer)
+
+// WithHeader writes header at the start of the merged file, before the output of the first bin.
+func WithHeader(header []byte) Option {
+	return func(w *Writer) {
+		w.header = header
+	}
+}
+
+// WithStagingDir sets the directory holding the staging files, which defaults to the output's path
+// with a ".staging" suffix. The default directory is removed once the files are merged.
+func WithStagingDir(dir string) Option {
+	return func(w *Writer) {
+		w.dir = dir
+	}
+}
+
+// Writer writes each bin of a traversal to a staging file, and merges them into its output. Visit
+// is safe for concurrent use by distinct bins.
+type Writer struct {
+	path    string
+	dir     string
+	header  []byte
+	newSink NewSink
+
+	ends [][]byte       // end paths of the bins, in path order
+	bins map[string]int // index of each bin, by end path
+}
+
+var _ export.Sink = &Writer{}
+
+// NewWriter returns a writer which merges bins with the given end paths into the file at path,
+// staging each with a sink returned by newSink. The staging directory is created if needed.
+func NewWriter(path string, ends [][]byte, newSink NewSink, opts ...Option) (*Writer, error) {
+	w := &Writer{path: path, dir: path + ".staging", newSink: newSink, bins: map[string]int{}}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.ends = append(w.ends, ends...)
+	sort.Slice(w.ends, func(i, j int) bool { return endBefore(w.ends[i], w.ends[j]) })
+	for i, end := range w.ends {
+		if _, ok := w.bins[string(end)]; ok {
+			return nil, fmt.Errorf("duplicate bin ending at %x", end)
+		}
+		w.bins[string(end)] = i
+	}
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// endBefore orders end paths, with the unbounded end last.
+func endBefore(a, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(b) == 0 && len(a) != 0
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// stagingPath returns the path of the staging file of the i'th bin.
+func (w *Writer) stagingPath(i int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%05d-%x.stage", i, w.ends[i]))
+}
+
+func (w *Writer) logPath() string     { return w.path + ".merge" }
+func (w *Writer) partialPath() string { return w.path + ".partial" }
+
+// Visit writes the nodes of a bin to its staging file, unless it was staged by an earlier run or
+// the bins are being or have been merged.
+func (w *Writer) Visit(it trie.NodeIterator) error {
+	_, end, err := iter.Bounds(it)
+	if err != nil {
+		return err
+	}
+	i, ok := w.bins[string(end)]
+	if !ok {
+		return fmt.Errorf("%w: no bin ends at %x", ErrUnknownBin, end)
+	}
+	for _, path := range []string{w.stagingPath(i), w.logPath(), w.path} {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeFileAtomic(w.stagingPath(i), func(file *os.File) error {
+		sink, err := w.newSink(file)
+		if err != nil {
+			return err
+		}
+		if err := sink.Visit(it); err != nil {
+			return err
+		}
+		if closer, ok := sink.(io.Closer); ok {
+			return closer.Close()
+		}
+		return sink.Flush()
+	})
+}
+
+// Flush does nothing, as each staging file is complete once its bin is visited.
+func (w *Writer) Flush() error {
+	return nil
+}
+
+// Staged returns the number of bins whose staging files are written and not yet merged.
+func (w *Writer) Staged() (int, error) {
+	n := 0
+	for i := range w.ends {
+		if _, err := os.Stat(w.stagingPath(i)); err == nil {
+			n++
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Merge appends the staging files to the output in path order, deleting each once it is merged,
+// and moves the output into place. It resumes a merge which was interrupted, and does nothing if
+// the output already exists. Returns ErrIncomplete if a bin has not been staged.
+func (w *Writer) Merge() error {
+	entry, err := w.readLog()
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		if _, err := os.Stat(w.path); err == nil {
+			return nil
+		}
+		if n, err := w.Staged(); err != nil {
+			return err
+		} else if n != len(w.ends) {
+			return fmt.Errorf("%w: %d of %d bins staged", ErrIncomplete, n, len(w.ends))
+		}
+		if entry, err = w.start(); err != nil {
+			return err
+		}
+	}
+	merged, offset := entry.merged, entry.offset
+	if merged == len(w.ends) {
+		if _, err := os.Stat(w.partialPath()); os.IsNotExist(err) {
+			return w.finish() // moved into place before the log was removed
+		}
+	}
+
+	out, err := os.OpenFile(w.partialPath(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	logFile, err := os.OpenFile(w.logPath(), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	if err := logFile.Truncate(entry.end); err != nil {
+		return err
+	}
+	if _, err := logFile.Seek(entry.end, io.SeekStart); err != nil {
+		return err
+	}
+
+	// output written past the last entry is rolled back, and the files already merged are deleted
+	if err := out.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	for i := 0; i < merged; i++ {
+		if err := os.Remove(w.stagingPath(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for i := merged; i < len(w.ends); i++ {
+		n, err := appendFile(out, w.stagingPath(i))
+		if err != nil {
+			return err
+		}
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		offset += n
+		if _, err := logFile.WriteString(logEntry{merged: i + 1, offset: offset}.String()); err != nil {
+			return err
+		}
+		if err := logFile.Sync(); err != nil {
+			return err
+		}
+		if err := os.Remove(w.stagingPath(i)); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.partialPath(), w.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		return err
+	}
+	return w.finish()
+}
+
+// start creates the output with its header, and the log with its first entry.
+func (w *Writer) start() (*logEntry, error) {
+	err := writeFileAtomic(w.partialPath(), func(file *os.File) error {
+		_, err := file.Write(w.header)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	entry := &logEntry{offset: int64(len(w.header))}
+	line := entry.String()
+	entry.end = int64(len(line))
+	return entry, writeFileAtomic(w.logPath(), func(file *os.File) error {
+		_, err := file.WriteString(line)
+		return err
+	})
+}
+
+// finish removes the log of a completed merge, and the default staging directory.
+func (w *Writer) finish() error {
+	if err := os.Remove(w.logPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if w.dir == w.path+".staging" {
+		if err := os.Remove(w.dir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+// logEntry is a line of the merge log, recorded once a bin is merged.
+type logEntry struct {
+	merged int   // number of bins merged
+	offset int64 // size of the output holding them
+	end    int64 // offset of the end of the line in the log
+}
+
+// String encodes the entry as a line of the log: the size of the output and the number of bins.
+func (e logEntry) String() string {
+	return fmt.Sprintf("%d %d\n", e.offset, e.merged)
+}
+
+// readLog returns the last entry of the merge log, or nil if there is no log. A torn last line,
+// left by a crash while it was written, is ignored.
+func (w *Writer) readLog() (*logEntry, error) {
+	file, err := os.Open(w.logPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var last *logEntry
+	end := int64(0)
+	lines := bufio.NewReader(file)
+	for n := 1; ; n++ {
+		line, err := lines.ReadString('\n')
+		if err == io.EOF {
+			break // a line without its newline was not completely written
+		}
+		if err != nil {
+			return nil, err
+		}
+		end += int64(len(line))
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: malformed entry %q", file.Name(), n, strings.TrimSpace(line))
+		}
+		e := &logEntry{end: end}
+		if e.offset, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file.Name(), n, err)
+		}
+		if e.merged, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file.Name(), n, err)
+		}
+		if e.merged > len(w.ends) {
+			return nil, fmt.Errorf("%s:%d: %d bins merged, of %d", file.Name(), n, e.merged, len(w.ends))
+		}
+		last = e
+	}
+	if last == nil {
+		return nil, fmt.Errorf("%s: no entries", file.Name())
+	}
+	return last, nil
+}
+
+// appendFile copies the file at path to out, returning the number of bytes copied.
+func appendFile(out io.Writer, path string) (int64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	return io.Copy(out, in)
+}
+
+// writeFileAtomic writes a file through a temporary one, which is synced and renamed into place.
+func writeFileAtomic(path string, write func(*os.File) error) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = write(file); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,172 @@
+package staged_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/export"
+	"github.com/cerc-io/eth-iterator-utils/export/leaves"
+	"github.com/cerc-io/eth-iterator-utils/export/staged"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+const header = "key,value\n"
+
+// expectedOutput returns the CSV of the trie's leaves, in key order.
+func expectedOutput(t *testing.T, makeIterator iter.IteratorConstructor) string {
+	it, err := makeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for it.Next(true) {
+		if it.Leaf() {
+			rows = append(rows, hexutil.Encode(it.LeafKey())+","+hexutil.Encode(it.LeafBlob())+"\n")
+		}
+	}
+	if !sort.StringsAreSorted(rows) {
+		t.Fatal("fixture leaves are not in key order")
+	}
+	return header + strings.Join(rows, "")
+}
+
+func TestWriter(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+	expected := expectedOutput(t, tree.NodeIterator)
+
+	var (
+		mu    sync.Mutex
+		sinks int
+	)
+	newSink := func(out io.Writer) (export.Sink, error) {
+		mu.Lock()
+		sinks++
+		mu.Unlock()
+		return leaves.NewWriter(out, leaves.CSV, leaves.WithoutHeader(), leaves.WithBatchSize(3))
+	}
+	_, ends := iter.SubtrieBounds(16)
+	path := filepath.Join(t.TempDir(), "leaves.csv")
+	open := func() *staged.Writer {
+		w, err := staged.NewWriter(path, ends, newSink, staged.WithHeader([]byte(header)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	// an interrupted traversal leaves the bins it finished staged
+	w := open()
+	its, err := iter.SubtrieIterators(tree.NodeIterator, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range its[:10] {
+		if err := w.Visit(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Merge(); !errors.Is(err, staged.ErrIncomplete) {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+
+	// and is resumed by traversing again, skipping them
+	w = open()
+	if err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, w.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if sinks != 16 {
+		t.Fatalf("expected 16 bins to be staged, got %d", sinks)
+	}
+	if n, err := w.Staged(); err != nil || n != 16 {
+		t.Fatalf("expected 16 staged bins, got %d (err: %v)", n, err)
+	}
+
+	// a merge which fails part way through is resumed where it stopped
+	stagingDir := path + ".staging"
+	files, err := filepath.Glob(filepath.Join(stagingDir, "*.stage"))
+	if err != nil || len(files) != 16 {
+		t.Fatalf("expected 16 staging files, got %v (err: %v)", files, err)
+	}
+	sort.Strings(files)
+	broken := files[8]
+	if err := os.Rename(broken, broken+".aside"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(broken, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Merge(); err == nil {
+		t.Fatal("expected merge of unreadable staging file to fail")
+	}
+	if n, err := w.Staged(); err != nil || n != 8 {
+		t.Fatalf("expected 8 bins left to merge, got %d (err: %v)", n, err)
+	}
+	if err := os.Remove(broken); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(broken+".aside", broken); err != nil {
+		t.Fatal(err)
+	}
+	// a torn write of the output and the log is rolled back
+	for _, file := range []string{path + ".partial", path + ".merge"} {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString("123"); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no output before the merge completes, got %v", err)
+	}
+	if err := open().Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Fatalf("expected sorted leaves\n%s\ngot\n%s", expected, data)
+	}
+	for _, file := range []string{stagingDir, path + ".partial", path + ".merge"} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", file, err)
+		}
+	}
+
+	// a finished export is not written again
+	w = open()
+	if err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, w.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if sinks != 16 {
+		t.Fatalf("expected no more bins to be staged, got %d", sinks-16)
+	}
+	if again, err := os.ReadFile(path); err != nil || !bytes.Equal(again, data) {
+		t.Fatalf("expected output to be unchanged (err: %v)", err)
+	}
+
+	other := iter.NewPrefixBoundIterator(its[0], []byte{3, 3})
+	if err := w.Visit(other); !errors.Is(err, staged.ErrUnknownBin) {
+		t.Fatalf("expected ErrUnknownBin, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package wal
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// gateIterator holds the log's gate while the visitor handles its current node, so that a
+// checkpoint finds every bin either before or after writing it. The gate is released while the
+// wrapped iterator moves, which may wait for the tracker.
+type gateIterator struct {
+	trie.NodeIterator
+	log *Log
+
+	point   point // progress of the bin, only modified with the gate held
+	skip    bool  // whether the bin was restored and has not passed its point
+	current bool  // whether the iterator is at a node
+	holding bool
+}
+
+func (l *Log) newGate(it trie.NodeIterator) *gateIterator {
+	_, end, _ := iter.Bounds(it) // an iterator without bounds is unbounded
+	g := &gateIterator{NodeIterator: it, log: l, point: point{end: end}}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pt, ok := l.resume[string(end)]; ok {
+		delete(l.resume, string(end))
+		g.point, g.skip = *pt, true
+	}
+	l.gates = append(l.gates, g)
+	return g
+}
+
+// Next marks the current node as written and moves to the next, skipping the nodes written before
+// the checkpoint the bin was restored from.
+func (g *gateIterator) Next(descend bool) bool {
+	g.done()
+	if g.holding {
+		g.log.gate.RUnlock()
+		g.holding = false
+	}
+	ok := g.NodeIterator.Next(descend)
+	for ok && g.skip {
+		// nodes are yielded in path order, so those before the point were written
+		path := g.NodeIterator.Path()
+		cmp := bytes.Compare(path, g.point.path)
+		if cmp > 0 || cmp == 0 && !g.point.written {
+			g.skip = false
+			break
+		}
+		ok = g.NodeIterator.Next(cmp == 0 || bytes.HasPrefix(g.point.path, path))
+	}
+	g.log.gate.RLock()
+	g.holding = true
+	g.current = ok
+	return ok
+}
+
+// done updates the point of the bin once its current node was handled. It must be called with the
+// gate held.
+func (g *gateIterator) done() {
+	if g.current {
+		g.point.path = append(g.point.path[:0], g.NodeIterator.Path()...)
+		g.point.written = true
+	}
+}
+
+// release marks the current node as written once the visitor returns, and releases the gate.
+func (g *gateIterator) release() {
+	g.done()
+	g.current = false
+	if g.holding {
+		g.log.gate.RUnlock()
+		g.holding = false
+	}
+}
+
+// Unwrap returns the wrapped iterator.
+func (g *gateIterator) Unwrap() trie.NodeIterator {
+	return g.NodeIterator
+}
@@ -0,0 +1,386 @@
+// Package wal adds a write-ahead log to sinks which write to a plain file, or another output that
+// can't take part in a transaction with the tracker's recovery store, so that an interrupted export
+// can be resumed into the same file with every record written exactly once.
+//
+// Each checkpoint of the tracker is recorded in the log with the offset of the output once the
+// sink is flushed, and the last node written by each bin. On resume, the output is truncated back
+// to the offset of the checkpoint in the recovery store, and the nodes which restored iterators
+// yield again are skipped:
+//
+//	log, err := wal.Open(file.Name()+".wal", file)
+//	if err != nil { ... }
+//	tr := tracker.NewWithStore(log.Store(tracker.NewFileStore(recoveryFile)), 100)
+//	resumed, err := log.Recover()
+//	if err != nil { ... }
+//	var opts []car.Option
+//	if resumed {
+//		opts = append(opts, car.WithoutHeader())
+//	}
+//	w, err := car.NewWriter(file, car.EthStateTrie, []common.Hash{root}, opts...)
+//	if err != nil { ... }
+//	visit := log.Visitor(w)
+//
+// The traversal then visits the bins with visit, either restored with tr.Restore or made with
+// iter.Traverse and iter.WithTracker(tr), and the tracker saves its positions as usual, e.g. with
+// tracker.WithAutoCheckpoint and CloseAndSave.
+//
+// Recording a checkpoint pauses the bins between nodes, so that the offset matches the nodes
+// written. This needs a sink which writes each record before its iterator moves on, such as a
+// car.Writer or a leaves.Writer with a batch size of 1, and visitors which call Next(true) only.
+// Compressed output can't be resumed, and positions of storage iterators are not supported.
+//
+// Every bin is paused while a checkpoint is recorded, which waits for the bins to finish their
+// current node, flushes the sink, and syncs first the output and then the log; the tracker's store
+// is saved once the bins resume. On a disk where a sync takes milliseconds, each checkpoint stalls
+// the whole traversal for about twice that, so the tracker should checkpoint every few seconds at
+// most, rather than on every node.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/export"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+var (
+	// ErrNoCheckpoint is returned by Recover when the saved positions were not recorded in the log,
+	// e.g. because the log belongs to another output.
+	ErrNoCheckpoint = errors.New("recovery state has no checkpoint in the log")
+	// ErrStoragePosition is returned by Recover when the saved positions include a storage iterator.
+	ErrStoragePosition = errors.New("storage iterator positions can't be resumed from the log")
+)
+
+// Output is the destination of a sink which the log can roll back, such as an *os.File.
+type Output interface {
+	io.Writer
+	io.Seeker
+	// Sync commits the written data to stable storage.
+	Sync() error
+	// Truncate discards the data past size.
+	Truncate(size int64) error
+}
+
+// entry is a checkpoint recorded in the log.
+type entry struct {
+	offset int64
+	digest [sha256.Size]byte
+	points []point
+}
+
+// point records the progress of a bin, identified by its end bound: the nodes before path were
+// written, and the node at path if written is set.
+type point struct {
+	end, path []byte
+	written   bool
+}
+
+// Log records the checkpoints of a tracker against the offset of an output. It is safe for
+// concurrent use.
+type Log struct {
+	file  *os.File
+	out   Output
+	store tracker.RecoveryStore
+	sink  export.Sink
+
+	// gate is held shared by the bins while they visit a node, and exclusively to record a
+	// checkpoint
+	gate sync.RWMutex
+
+	gates  []*gateIterator
+	resume map[string]*point // by end bound, for bins not yet visited
+	mu     sync.Mutex        // guards file, sink, gates and resume
+}
+
+// Open opens the log at path, creating it if needed, for the sink writing to out.
+func Open(path string, out Output) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: file, out: out}, nil
+}
+
+// Store returns a recovery store which records each save in the log before saving the positions
+// to store. The tracker must use it for the log to be able to resume the output.
+func (l *Log) Store(store tracker.RecoveryStore) tracker.RecoveryStore {
+	l.store = store
+	return &logStore{RecoveryStore: store, log: l}
+}
+
+// Recover rolls the output back to the checkpoint of the positions saved in the store, and
+// returns whether there were any, i.e. the output is resumed rather than written from the start.
+// It must be called before the tracker restores its iterators, and before the sink is created, as
+// it moves the output's offset.
+func (l *Log) Recover() (bool, error) {
+	if l.store == nil {
+		return false, errors.New("log has no recovery store")
+	}
+	positions, err := l.store.Load()
+	if err != nil {
+		return false, err
+	}
+	for _, pos := range positions {
+		if pos.Owner != (common.Hash{}) {
+			return false, ErrStoragePosition
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(positions) == 0 {
+		if err := l.reset(0, 0); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	entries, ends, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	digest := digestPositions(positions)
+	match := -1
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].digest == digest {
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		return false, ErrNoCheckpoint
+	}
+	if err := l.reset(ends[match], entries[match].offset); err != nil {
+		return false, err
+	}
+
+	points := make(map[string]point, len(entries[match].points))
+	for _, pt := range entries[match].points {
+		points[string(pt.end)] = pt
+	}
+	l.resume = make(map[string]*point, len(positions))
+	for _, pos := range positions {
+		// a bin may have written past its saved position before the checkpoint was recorded
+		pt, ok := points[string(pos.EndPath)]
+		if !ok {
+			pt = point{end: pos.EndPath, path: pos.Path}
+		}
+		l.resume[string(pos.EndPath)] = &pt
+	}
+	return true, nil
+}
+
+// reset truncates the log and the output to the given sizes, and moves to their ends.
+func (l *Log) reset(logSize, outSize int64) error {
+	if err := l.file.Truncate(logSize); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(logSize, io.SeekStart); err != nil {
+		return err
+	}
+	if err := l.out.Truncate(outSize); err != nil {
+		return err
+	}
+	if _, err := l.out.Seek(outSize, io.SeekStart); err != nil {
+		return err
+	}
+	return l.out.Sync()
+}
+
+// Visitor returns a visitor which passes each bin to the sink, pausing it between nodes while a
+// checkpoint is taken, and skipping the nodes already written before the checkpoint it was
+// restored from. The sink must write to the log's output, and is flushed by every checkpoint.
+func (l *Log) Visitor(sink export.Sink) iter.Visitor {
+	l.mu.Lock()
+	l.sink = sink
+	l.mu.Unlock()
+	return func(it trie.NodeIterator) error {
+		gated := l.newGate(it)
+		defer gated.release()
+		return sink.Visit(gated)
+	}
+}
+
+// Close closes the log. It does not close the output.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// record pauses the bins, flushes the sink and appends a checkpoint of the positions to the log.
+// The bins stay paused until both the output and the log are synced.
+// The positions may have been read before the bins were paused, so they can be behind the points
+// of the bins.
+func (l *Log) record(positions []tracker.Position) error {
+	l.gate.Lock()
+	defer l.gate.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sink != nil {
+		if err := l.sink.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := l.out.Sync(); err != nil {
+		return err
+	}
+	offset, err := l.out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	e := entry{offset: offset, digest: digestPositions(positions)}
+	for _, g := range l.gates {
+		e.points = append(e.points, g.point)
+	}
+	for _, pt := range l.resume {
+		e.points = append(e.points, *pt)
+	}
+	if _, err := l.file.WriteString(e.String() + "\n"); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// read parses the entries of the log, and the offset of the end of each. A torn last line, left by
+// a crash while it was written, is ignored.
+func (l *Log) read() ([]entry, []int64, error) {
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	var (
+		entries []entry
+		ends    []int64
+		offset  int64
+	)
+	lines := bufio.NewReader(l.file)
+	for n := 1; ; n++ {
+		line, err := lines.ReadString('\n')
+		if err == io.EOF {
+			break // a line without its newline was not completely written
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := parseEntry(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", l.file.Name(), n, err)
+		}
+		offset += int64(len(line))
+		entries = append(entries, e)
+		ends = append(ends, offset)
+	}
+	return entries, ends, nil
+}
+
+// String encodes the entry as a line of the log: the offset, the digest of the positions and the
+// end, path and written flag of each bin's point, separated by spaces.
+func (e entry) String() string {
+	fields := []string{strconv.FormatInt(e.offset, 10), hex.EncodeToString(e.digest[:])}
+	for _, pt := range e.points {
+		written := "0"
+		if pt.written {
+			written = "1"
+		}
+		fields = append(fields, hex.EncodeToString(pt.end)+":"+hex.EncodeToString(pt.path)+":"+written)
+	}
+	return strings.Join(fields, " ")
+}
+
+func parseEntry(line string) (entry, error) {
+	var e entry
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return e, fmt.Errorf("malformed log entry %q", line)
+	}
+	offset, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return e, err
+	}
+	digest, err := hex.DecodeString(fields[1])
+	if err != nil || len(digest) != sha256.Size {
+		return e, fmt.Errorf("malformed digest %q", fields[1])
+	}
+	e.offset = offset
+	copy(e.digest[:], digest)
+	for _, field := range fields[2:] {
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 || parts[2] != "0" && parts[2] != "1" {
+			return e, fmt.Errorf("malformed point %q", field)
+		}
+		endHex, pathHex := parts[0], parts[1]
+		end, err := hex.DecodeString(endHex)
+		if err != nil {
+			return e, err
+		}
+		path, err := hex.DecodeString(pathHex)
+		if err != nil {
+			return e, err
+		}
+		e.points = append(e.points, point{end: end, path: path, written: parts[2] == "1"})
+	}
+	return e, nil
+}
+
+// digestPositions returns a digest of the positions which does not depend on their order.
+func digestPositions(positions []tracker.Position) [sha256.Size]byte {
+	encoded := make([][]byte, len(positions))
+	for i, pos := range positions {
+		var buf []byte
+		buf = append(buf, byte(pos.Kind))
+		buf = append(buf, pos.Root[:]...)
+		buf = append(buf, pos.Owner[:]...)
+		buf = append(buf, pos.StorageRoot[:]...)
+		buf = binary.AppendUvarint(buf, uint64(len(pos.Path)))
+		buf = append(buf, pos.Path...)
+		buf = binary.AppendUvarint(buf, uint64(len(pos.EndPath)))
+		encoded[i] = append(buf, pos.EndPath...)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	hash := sha256.New()
+	for _, buf := range encoded {
+		hash.Write(buf)
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest
+}
+
+// logStore records the positions saved by a tracker in the log before saving them.
+type logStore struct {
+	tracker.RecoveryStore
+	log *Log
+}
+
+// Save records the positions in the log, then saves them to the wrapped store. If the save is
+// interrupted, the positions left in the store are those of an earlier entry.
+func (s *logStore) Save(positions []tracker.Position) error {
+	if err := s.log.record(positions); err != nil {
+		return err
+	}
+	return s.RecoveryStore.Save(positions)
+}
+
+// Close closes the wrapped store, if it is an io.Closer, as trackers close their store.
+func (s *logStore) Close() error {
+	if closer, ok := s.RecoveryStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
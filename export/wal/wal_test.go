@@ -0,0 +1,265 @@
+package wal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/export"
+	"github.com/cerc-io/eth-iterator-utils/export/leaves"
+	"github.com/cerc-io/eth-iterator-utils/export/wal"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// interruptingSink asks for a checkpoint every few leaves visited, and cancels the traversal once
+// a number of leaves have been, while a last checkpoint is taken.
+type interruptingSink struct {
+	export.Sink
+	checkpoint func() error
+	cancel     func()
+	every      int
+	limit      int
+
+	leaves int
+	errs   []error
+	wg     sync.WaitGroup // checkpoints in progress
+	mu     sync.Mutex     // guards leaves and errs
+}
+
+func (s *interruptingSink) Visit(it trie.NodeIterator) error {
+	return s.Sink.Visit(&countingIterator{NodeIterator: it, sink: s})
+}
+
+// wait waits for the checkpoints to finish, and returns their first error.
+func (s *interruptingSink) wait() error {
+	s.wg.Wait()
+	for _, err := range s.errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type countingIterator struct {
+	trie.NodeIterator
+	sink *interruptingSink
+}
+
+func (it *countingIterator) Next(descend bool) bool {
+	if !it.NodeIterator.Next(descend) {
+		return false
+	}
+	if it.Leaf() {
+		s := it.sink
+		s.mu.Lock()
+		s.leaves++
+		if s.leaves%s.every == 0 || s.leaves == s.limit {
+			s.wg.Add(1)
+			go func() { // the checkpoint waits for this bin to move on
+				defer s.wg.Done()
+				err := s.checkpoint()
+				s.mu.Lock()
+				s.errs = append(s.errs, err)
+				s.mu.Unlock()
+			}()
+		}
+		if s.leaves == s.limit {
+			s.cancel()
+		}
+		s.mu.Unlock()
+	}
+	return true
+}
+
+// openOutput opens the log, tracker and leaf writer for the output in dir.
+func openOutput(t *testing.T, dir string) (*os.File, *wal.Log, *tracker.FileStore, *tracker.Tracker, bool) {
+	file, err := os.OpenFile(filepath.Join(dir, "leaves.csv"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := wal.Open(filepath.Join(dir, "leaves.csv.wal"), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := tracker.NewFileStore(filepath.Join(dir, "recovery.csv"))
+	tr := tracker.NewWithStore(log.Store(store), 1)
+	resumed, err := log.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, log, store, tr, resumed
+}
+
+func TestResume(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	t.Run("two checkpoints", func(t *testing.T) {
+		testResume(t, tree, 4, 2)
+	})
+	// checkpoints race with each other and with the bins, and the traversal is cancelled while one
+	// is taken
+	t.Run("concurrent checkpoints", func(t *testing.T) {
+		testResume(t, tree, 16, 50)
+	})
+}
+
+// testResume interrupts an export over 16 bins with some number of checkpoints, and checks that
+// resuming it writes every leaf exactly once.
+func testResume(t *testing.T, tree state.Trie, workers uint, checkpoints int) {
+	// the leaves of an uninterrupted traversal, which yields those at the shared bounds of bins twice
+	var expected [][]string
+	var mu sync.Mutex
+	err := iter.Traverse(context.Background(), tree.NodeIterator, 16, workers, func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			if it.Leaf() {
+				mu.Lock()
+				expected = append(expected, []string{hexutil.Encode(it.LeafKey()), hexutil.Encode(it.LeafBlob())})
+				mu.Unlock()
+			}
+		}
+		return it.Error()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	file, log, store, tr, resumed := openOutput(t, dir)
+	if resumed {
+		t.Fatal("expected a new output")
+	}
+	w, err := leaves.NewWriter(file, leaves.CSV, leaves.WithBatchSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	limit := len(expected) / 2
+	sink := &interruptingSink{
+		Sink:       w,
+		checkpoint: tr.Checkpoint,
+		cancel:     cancel,
+		every:      limit / checkpoints,
+		limit:      limit,
+	}
+	err = iter.Traverse(ctx, tree.NodeIterator, 16, workers, log.Visitor(sink), iter.WithTracker(tr))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected traversal to be cancelled, got %v", err)
+	}
+	if err := sink.wait(); err != nil {
+		t.Fatal(err)
+	}
+	// crash: rows written since the checkpoint are left, and a torn row
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString("0xdead"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	log.Close()
+	file.Close()
+	entries, err := os.ReadFile(filepath.Join(dir, "leaves.csv.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(entries, []byte("\n")); n < checkpoints {
+		t.Fatalf("expected at least %d checkpoints in the log, got %d", checkpoints, n)
+	}
+
+	file, log, _, tr, resumed = openOutput(t, dir)
+	defer file.Close()
+	defer log.Close()
+	if !resumed {
+		t.Fatal("expected output to be resumed")
+	}
+	w, err = leaves.NewWriter(file, leaves.CSV, leaves.WithBatchSize(1), leaves.WithoutHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) == 0 {
+		t.Fatal("expected restored iterators")
+	}
+	iters := make([]trie.NodeIterator, len(restored))
+	for i, it := range restored {
+		iters[i] = it
+	}
+	if err := iter.TraverseIterators(context.Background(), iters, workers, log.Visitor(w)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || records[0][0] != "key" {
+		t.Fatalf("expected header, got %v", records)
+	}
+	records = records[1:]
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(records))
+	}
+	count := map[string]int{}
+	for _, row := range expected {
+		count[row[0]+","+row[1]]++
+	}
+	for _, row := range records {
+		count[row[0]+","+row[1]]--
+	}
+	for row, n := range count {
+		if n != 0 {
+			t.Fatalf("row %s: written %d times too few", row, n)
+		}
+	}
+}
+
+func TestRecoverUnknownCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	log, err := wal.Open(filepath.Join(dir, "out.wal"), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	// positions saved without the log
+	store := tracker.NewFileStore(filepath.Join(dir, "recovery.csv"))
+	defer store.Close()
+	if err := store.Save([]tracker.Position{{Path: []byte{1, 2}, EndPath: []byte{2}}}); err != nil {
+		t.Fatal(err)
+	}
+	log.Store(store)
+	if _, err := log.Recover(); !errors.Is(err, wal.ErrNoCheckpoint) {
+		t.Fatalf("expected ErrNoCheckpoint, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package iterator
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// NodePredicate selects the nodes yielded by a FilterIterator.
+type NodePredicate = func(trie.NodeIterator) bool
+
+// IsLeaf selects leaf (value) nodes.
+func IsLeaf(it trie.NodeIterator) bool { return it.Leaf() }
+
+// IsBranch selects internal nodes, i.e. full and short nodes, embedded or not.
+func IsBranch(it trie.NodeIterator) bool { return !it.Leaf() }
+
+// IsHashed selects nodes stored by hash, i.e. those which are not embedded in their parent.
+func IsHashed(it trie.NodeIterator) bool { return it.Hash() != (common.Hash{}) }
+
+// IsEmbedded selects internal nodes which are embedded in their parent, having no hash.
+func IsEmbedded(it trie.NodeIterator) bool { return !it.Leaf() && it.Hash() == (common.Hash{}) }
+
+// FilterIterator is a NodeIterator which only yields the nodes accepted by a predicate. Rejected
+// nodes are still descended into, so the filter affects which nodes are seen, not the traversal.
+type FilterIterator struct {
+	trie.NodeIterator
+	pred NodePredicate
+}
+
+// NewFilterIterator returns an iterator yielding the nodes of `it` for which `pred` returns true.
+func NewFilterIterator(it trie.NodeIterator, pred NodePredicate) *FilterIterator {
+	return &FilterIterator{NodeIterator: it, pred: pred}
+}
+
+// Next moves to the next accepted node. Passing descend=false skips the children of the current
+// node, as for the underlying iterator.
+func (it *FilterIterator) Next(descend bool) bool {
+	for it.NodeIterator.Next(descend) {
+		if it.pred(it.NodeIterator) {
+			return true
+		}
+		descend = true
+	}
+	return false
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *FilterIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
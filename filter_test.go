@@ -0,0 +1,87 @@
+package iterator_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestFilterIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	// count the nodes of each kind with an unfiltered traversal
+	var leaves, hashed, embedded int
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for nodeit.Next(true) {
+		switch {
+		case nodeit.Leaf():
+			leaves++
+		case nodeit.Hash() != (common.Hash{}):
+			hashed++
+		default:
+			embedded++
+		}
+	}
+	if nodeit.Error() != nil {
+		t.Fatal(nodeit.Error())
+	}
+	if leaves+hashed+embedded != len(internal.FixtureNodePaths) {
+		t.Fatalf("expected %d nodes, got %d", len(internal.FixtureNodePaths), leaves+hashed+embedded)
+	}
+
+	cases := []struct {
+		name     string
+		pred     iter.NodePredicate
+		expected int
+	}{
+		{"leaves", iter.IsLeaf, leaves},
+		{"branches", iter.IsBranch, hashed + embedded},
+		{"hashed", iter.IsHashed, hashed},
+		{"embedded", iter.IsEmbedded, embedded},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeit, err := tree.NodeIterator(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			it := iter.NewFilterIterator(nodeit, tc.pred)
+			count := 0
+			for it.Next(true) {
+				if !tc.pred(it) {
+					t.Fatalf("filter yielded rejected node at %x", it.Path())
+				}
+				count++
+			}
+			if it.Error() != nil {
+				t.Fatal(it.Error())
+			}
+			if count != tc.expected {
+				t.Fatalf("expected %d nodes, got %d", tc.expected, count)
+			}
+		})
+	}
+
+	t.Run("skip subtrie", func(t *testing.T) {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// not descending from the root yields nothing beneath it
+		it := iter.NewFilterIterator(nodeit, func(it trie.NodeIterator) bool { return len(it.Path()) == 0 })
+		if !it.Next(true) {
+			t.Fatal("expected root node")
+		}
+		if it.Next(false) {
+			t.Fatalf("expected no nodes after skipping root, got %x", it.Path())
+		}
+	})
+}
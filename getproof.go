@@ -0,0 +1,170 @@
+package iterator
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// ProofRequest names an account, and the slots of its storage, to be proven.
+type ProofRequest struct {
+	Address     common.Address
+	StorageKeys []common.Hash
+}
+
+// AccountResult is an account proof, in the format returned by eth_getProof (EIP-1186).
+type AccountResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []string        `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []StorageResult `json:"storageProof"`
+}
+
+// StorageResult is a storage slot proof, in the format returned by eth_getProof.
+type StorageResult struct {
+	Key   string       `json:"key"`
+	Value *hexutil.Big `json:"value"`
+	Proof []string     `json:"proof"`
+}
+
+// BatchProofs proves a batch of accounts, and slots of their storage, in the state with the given
+// root. Rather than resolving each proof from the root, each trie is traversed once, descending
+// only into nodes on the path to some requested key, so nodes shared by several proofs are read
+// once. Results are in the order of the requests, and match those of eth_getProof, including for
+// accounts and slots which don't exist.
+func BatchProofs(db *triedb.Database, root common.Hash, requests []ProofRequest) ([]*AccountResult, error) {
+	accountKeys := make([][]byte, len(requests))
+	for i, req := range requests {
+		accountKeys[i] = crypto.Keccak256(req.Address.Bytes())
+	}
+	proofs, leaves, err := proveKeys(NewTrieDBConstructor(db, trie.StateTrieID(root)), accountKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*AccountResult, len(requests))
+	for i, req := range requests {
+		res := &AccountResult{
+			Address:      req.Address,
+			AccountProof: proofs[i],
+			Balance:      new(hexutil.Big),
+			StorageProof: make([]StorageResult, len(req.StorageKeys)),
+		}
+		if leaves[i] != nil {
+			var acct types.StateAccount
+			if err := rlp.DecodeBytes(leaves[i], &acct); err != nil {
+				return nil, err
+			}
+			res.Balance = (*hexutil.Big)(acct.Balance.ToBig())
+			res.CodeHash = common.BytesToHash(acct.CodeHash)
+			res.Nonce = hexutil.Uint64(acct.Nonce)
+			res.StorageHash = acct.Root
+		}
+		if err := proveStorage(db, root, common.BytesToHash(accountKeys[i]), res, req.StorageKeys); err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+func proveStorage(db *triedb.Database, root, owner common.Hash, res *AccountResult, keys []common.Hash) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if res.StorageHash == (common.Hash{}) || res.StorageHash == types.EmptyRootHash {
+		for i, key := range keys {
+			res.StorageProof[i] = StorageResult{hexutil.Encode(key[:]), new(hexutil.Big), []string{}}
+		}
+		return nil
+	}
+	slotKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		slotKeys[i] = crypto.Keccak256(key[:])
+	}
+	makeIterator := NewTrieDBConstructor(db, trie.StorageTrieID(root, owner, res.StorageHash))
+	proofs, leaves, err := proveKeys(makeIterator, slotKeys)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		value := new(big.Int)
+		if leaves[i] != nil {
+			_, content, _, err := rlp.Split(leaves[i])
+			if err != nil {
+				return err
+			}
+			value.SetBytes(content)
+		}
+		res.StorageProof[i] = StorageResult{hexutil.Encode(key[:]), (*hexutil.Big)(value), proofs[i]}
+	}
+	return nil
+}
+
+// proveKeys traverses the nodes on the paths to the given keys, returning the proof of each key
+// as hex-encoded nodes, and its leaf value (or nil, if it is absent).
+func proveKeys(makeIterator IteratorConstructor, keys [][]byte) ([][]string, [][]byte, error) {
+	targets := make([][]byte, len(keys))
+	for i, key := range keys {
+		targets[i] = KeyBytesToHex(key)
+	}
+	sorted := append([][]byte(nil), targets...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	type provenNode struct {
+		path, blob []byte
+	}
+	var nodes []provenNode
+	values := map[string][]byte{}
+
+	it, err := makeIterator(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for descend := true; it.Next(descend); {
+		path := it.Path()
+		// descend only towards the requested keys
+		if descend = hasPrefixed(sorted, path); !descend {
+			continue
+		}
+		if it.Hash() != (common.Hash{}) {
+			nodes = append(nodes, provenNode{append([]byte(nil), path...), it.NodeBlob()})
+		}
+		if it.Leaf() {
+			values[string(path)] = it.LeafBlob()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, nil, err
+	}
+
+	proofs := make([][]string, len(keys))
+	leaves := make([][]byte, len(keys))
+	for i, target := range targets {
+		proofs[i] = []string{}
+		for _, node := range nodes {
+			if bytes.HasPrefix(target, node.path) {
+				proofs[i] = append(proofs[i], hexutil.Encode(node.blob))
+			}
+		}
+		leaves[i] = values[string(target)]
+	}
+	return proofs, leaves, nil
+}
+
+// hasPrefixed returns whether any of the sorted paths has the given prefix.
+func hasPrefixed(sorted [][]byte, prefix []byte) bool {
+	i := sort.Search(len(sorted), func(i int) bool { return bytes.Compare(sorted[i], prefix) >= 0 })
+	return i < len(sorted) && bytes.HasPrefix(sorted[i], prefix)
+}
@@ -0,0 +1,115 @@
+package iterator_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// proofList collects proof nodes as hex strings, as eth_getProof does
+type proofList []string
+
+func (l *proofList) Put(key, value []byte) error {
+	*l = append(*l, hexutil.Encode(value))
+	return nil
+}
+
+func (l *proofList) Delete([]byte) error { panic("not supported") }
+
+func TestBatchProofs(t *testing.T) {
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := func(i int) common.Address { return common.BytesToAddress([]byte{byte(i + 1)}) }
+	slot := func(j int) common.Hash { return common.BytesToHash([]byte{byte(j + 1)}) }
+	for i := 0; i < 200; i++ {
+		statedb.SetBalance(addr(i), uint256.NewInt(uint64(i)))
+		for j := 0; j < i%4; j++ {
+			statedb.SetState(addr(i), slot(j), common.BytesToHash([]byte{byte(i)}))
+		}
+	}
+	root, err := statedb.Commit(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sdb.TrieDB()
+
+	// include accounts with and without storage, missing accounts and missing slots
+	requests := []iter.ProofRequest{
+		{Address: addr(3), StorageKeys: []common.Hash{slot(0), slot(2), slot(9)}},
+		{Address: addr(4), StorageKeys: []common.Hash{slot(0)}},
+		{Address: addr(1000)},
+		{Address: addr(1001), StorageKeys: []common.Hash{slot(0)}},
+		{Address: addr(7), StorageKeys: []common.Hash{slot(1)}},
+		{Address: addr(3)},
+	}
+	results, err := iter.BatchProofs(db, root, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateTrie, err := trie.NewStateTrie(trie.StateTrieID(root), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, req := range requests {
+		var accountProof proofList
+		if err := stateTrie.Prove(crypto.Keccak256(req.Address.Bytes()), &accountProof); err != nil {
+			t.Fatal(err)
+		}
+		expected := &iter.AccountResult{
+			Address:      req.Address,
+			AccountProof: []string(accountProof),
+			Balance:      (*hexutil.Big)(statedb.GetBalance(req.Address).ToBig()),
+			CodeHash:     statedb.GetCodeHash(req.Address),
+			Nonce:        hexutil.Uint64(statedb.GetNonce(req.Address)),
+			StorageHash:  statedb.GetStorageRoot(req.Address),
+			StorageProof: []iter.StorageResult{},
+		}
+		for _, key := range req.StorageKeys {
+			res := iter.StorageResult{
+				Key:   hexutil.Encode(key[:]),
+				Value: (*hexutil.Big)(statedb.GetState(req.Address, key).Big()),
+				Proof: []string{},
+			}
+			if root := expected.StorageHash; root != (common.Hash{}) && root != types.EmptyRootHash {
+				id := trie.StorageTrieID(root, crypto.Keccak256Hash(req.Address.Bytes()), root)
+				id.StateRoot = stateTrie.Hash()
+				storageTrie, err := trie.NewStateTrie(id, db)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var proof proofList
+				if err := storageTrie.Prove(crypto.Keccak256(key.Bytes()), &proof); err != nil {
+					t.Fatal(err)
+				}
+				res.Proof = []string(proof)
+			}
+			expected.StorageProof = append(expected.StorageProof, res)
+		}
+		// compare encodings, as eth_getProof clients would see them
+		expectedJSON, err := json.Marshal(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actualJSON, err := json.Marshal(results[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(expectedJSON) != string(actualJSON) {
+			t.Fatalf("wrong proof for request %d\nexpected:\t%s\nactual:\t\t%s", i, expectedJSON, actualJSON)
+		}
+	}
+}
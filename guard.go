@@ -0,0 +1,101 @@
+package iterator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ErrLimitExceeded is matched by the errors of a GuardIterator which reached a node outside its
+// limits.
+var ErrLimitExceeded = errors.New("trie node exceeds guard limit")
+
+// PathDepthError is returned by a GuardIterator which reached a node whose path is too long.
+type PathDepthError struct {
+	Path     []byte
+	MaxDepth int
+}
+
+func (e *PathDepthError) Error() string {
+	return fmt.Sprintf("path %x has depth %d, limit is %d", e.Path, len(e.Path), e.MaxDepth)
+}
+
+func (e *PathDepthError) Unwrap() error { return ErrLimitExceeded }
+
+// NodeSizeError is returned by a GuardIterator which reached a node whose encoding is too large.
+type NodeSizeError struct {
+	Path    []byte
+	Hash    common.Hash
+	Size    int
+	MaxSize int
+}
+
+func (e *NodeSizeError) Error() string {
+	return fmt.Sprintf("node %x at path %x has size %d, limit is %d", e.Hash, e.Path, e.Size, e.MaxSize)
+}
+
+func (e *NodeSizeError) Unwrap() error { return ErrLimitExceeded }
+
+// GuardLimits are sanity limits on the nodes of a trie. A zero value disables the respective limit.
+type GuardLimits struct {
+	// MaxDepth is the maximum length of a node path, in nibbles. Paths in a well-formed trie
+	// with 32-byte keys are at most 65 nibbles long, including the terminator.
+	MaxDepth int
+	// MaxNodeSize is the maximum size of an encoded node, in bytes.
+	MaxNodeSize int
+}
+
+// GuardIterator is a NodeIterator which stops with a *PathDepthError or *NodeSizeError on reaching
+// a node outside its limits, rather than descending without bound into corrupted or adversarial
+// trie data.
+type GuardIterator struct {
+	trie.NodeIterator
+	limits GuardLimits
+	err    error
+}
+
+// NewGuardIterator wraps an iterator with sanity limits. Checking MaxNodeSize reads the blob of
+// each hashed node visited.
+func NewGuardIterator(it trie.NodeIterator, limits GuardLimits) *GuardIterator {
+	return &GuardIterator{NodeIterator: it, limits: limits}
+}
+
+func (it *GuardIterator) Next(descend bool) bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.NodeIterator.Next(descend) {
+		return false
+	}
+	path := it.Path()
+	if it.limits.MaxDepth > 0 && len(path) > it.limits.MaxDepth {
+		it.err = &PathDepthError{Path: append([]byte(nil), path...), MaxDepth: it.limits.MaxDepth}
+		return false
+	}
+	if it.limits.MaxNodeSize > 0 && it.Hash() != (common.Hash{}) {
+		if size := len(it.NodeBlob()); size > it.limits.MaxNodeSize {
+			it.err = &NodeSizeError{
+				Path:    append([]byte(nil), path...),
+				Hash:    it.Hash(),
+				Size:    size,
+				MaxSize: it.limits.MaxNodeSize,
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *GuardIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+func (it *GuardIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
@@ -0,0 +1,68 @@
+package iterator_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestGuardIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	// find the actual extent of the trie
+	var maxDepth, maxSize int
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for nodeit.Next(true) {
+		if len(nodeit.Path()) > maxDepth {
+			maxDepth = len(nodeit.Path())
+		}
+		if nodeit.Hash() != (common.Hash{}) && len(nodeit.NodeBlob()) > maxSize {
+			maxSize = len(nodeit.NodeBlob())
+		}
+	}
+
+	traverse := func(limits iter.GuardLimits) (int, error) {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := iter.NewGuardIterator(nodeit, limits)
+		count := 0
+		for it.Next(true) {
+			count++
+		}
+		return count, it.Error()
+	}
+
+	if count, err := traverse(iter.GuardLimits{MaxDepth: maxDepth, MaxNodeSize: maxSize}); err != nil {
+		t.Fatal(err)
+	} else if count != len(internal.FixtureNodePaths) {
+		t.Fatalf("expected %d nodes within limits, got %d", len(internal.FixtureNodePaths), count)
+	}
+
+	_, err = traverse(iter.GuardLimits{MaxDepth: maxDepth - 1})
+	var depthErr *iter.PathDepthError
+	if !errors.As(err, &depthErr) || !errors.Is(err, iter.ErrLimitExceeded) {
+		t.Fatalf("expected PathDepthError, got %v", err)
+	}
+	if len(depthErr.Path) != maxDepth {
+		t.Fatalf("expected error at depth %d, got path %x", maxDepth, depthErr.Path)
+	}
+
+	_, err = traverse(iter.GuardLimits{MaxNodeSize: maxSize - 1})
+	var sizeErr *iter.NodeSizeError
+	if !errors.As(err, &sizeErr) || !errors.Is(err, iter.ErrLimitExceeded) {
+		t.Fatalf("expected NodeSizeError, got %v", err)
+	}
+	if sizeErr.Size != maxSize {
+		t.Fatalf("expected error for node of size %d, got %d", maxSize, sizeErr.Size)
+	}
+}
@@ -0,0 +1,41 @@
+package hashset
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	bloomfilter "github.com/holiman/bloomfilter/v2"
+)
+
+// Bloom is a HashMembership backed by a Bloom filter. Contains may return true for hashes which
+// were never added, but never returns false for one which was. The hashes are assumed to be
+// uniformly distributed, as Keccak hashes are.
+type Bloom struct {
+	filter *bloomfilter.Filter
+}
+
+// NewBloom returns a Bloom filter sized so that, holding up to maxElements hashes, its false
+// positive rate is at most falsePositiveRate.
+func NewBloom(maxElements uint64, falsePositiveRate float64) (*Bloom, error) {
+	if maxElements == 0 || falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("invalid bloom filter parameters: %d elements, %v false positive rate",
+			maxElements, falsePositiveRate)
+	}
+	filter, err := bloomfilter.NewOptimal(maxElements, falsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	return &Bloom{filter: filter}, nil
+}
+
+func (s *Bloom) Add(hash common.Hash) error {
+	s.filter.AddHash(binary.BigEndian.Uint64(hash[:8]))
+	return nil
+}
+
+func (s *Bloom) Contains(hash common.Hash) (bool, error) {
+	return s.filter.ContainsHash(binary.BigEndian.Uint64(hash[:8])), nil
+}
+
+func (s *Bloom) Flush() error { return nil }
@@ -0,0 +1,92 @@
+package hashset
+
+import (
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Disk is an exact HashMembership stored in a temporary pebble database, for sets too large to
+// hold in memory. Additions are buffered in memory until the buffer reaches ethdb.IdealBatchSize,
+// or Flush is called.
+type Disk struct {
+	dir     string
+	db      ethdb.Database
+	batch   ethdb.Batch
+	pending map[common.Hash]struct{}
+	mu      sync.RWMutex
+}
+
+// NewDisk creates a set in a new temporary directory under dir (or the default temporary
+// directory, if dir is empty). Close must be called to remove it.
+func NewDisk(dir string) (*Disk, error) {
+	tmp, err := os.MkdirTemp(dir, "hashset-")
+	if err != nil {
+		return nil, err
+	}
+	// the set is discarded on close, so writes don't need to be synced
+	db, err := rawdb.NewPebbleDBDatabase(tmp, 16, 16, "", false, true)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	return &Disk{
+		dir:     tmp,
+		db:      db,
+		batch:   db.NewBatch(),
+		pending: map[common.Hash]struct{}{},
+	}, nil
+}
+
+func (s *Disk) Add(hash common.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, has := s.pending[hash]; has {
+		return nil
+	}
+	if err := s.batch.Put(hash.Bytes(), nil); err != nil {
+		return err
+	}
+	s.pending[hash] = struct{}{}
+	if s.batch.ValueSize() >= ethdb.IdealBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *Disk) Contains(hash common.Hash) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, has := s.pending[hash]; has {
+		return true, nil
+	}
+	return s.db.Has(hash.Bytes())
+}
+
+func (s *Disk) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flush must be called with mu held.
+func (s *Disk) flush() error {
+	if err := s.batch.Write(); err != nil {
+		return err
+	}
+	s.batch.Reset()
+	s.pending = map[common.Hash]struct{}{}
+	return nil
+}
+
+// Close closes the database and removes its directory. The set can't be used afterwards.
+func (s *Disk) Close() error {
+	err := s.db.Close()
+	if rerr := os.RemoveAll(s.dir); err == nil {
+		err = rerr
+	}
+	return err
+}
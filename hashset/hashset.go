@@ -0,0 +1,62 @@
+// Package hashset provides sets of hashes for recording which trie nodes or keys have been seen
+// during traversal, e.g. to deduplicate output, cache visited nodes, or mark reachable nodes.
+//
+// Implementations trade accuracy for memory: Memory is exact, Bloom uses a fixed amount of memory
+// but may report false positives, and Disk is exact and bounded only by disk space.
+package hashset
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// HashMembership is a set of hashes. Implementations are safe for concurrent use.
+type HashMembership interface {
+	// Add inserts a hash into the set.
+	Add(common.Hash) error
+	// Contains reports whether a hash may be in the set.
+	Contains(common.Hash) (bool, error)
+	// Flush writes any buffered additions to the set's backing storage.
+	Flush() error
+}
+
+var (
+	_ HashMembership = &Memory{}
+	_ HashMembership = &Bloom{}
+	_ HashMembership = &Disk{}
+)
+
+// Memory is an exact HashMembership held in memory.
+type Memory struct {
+	hashes map[common.Hash]struct{}
+	mu     sync.RWMutex
+}
+
+// NewMemory returns an empty in-memory set.
+func NewMemory() *Memory {
+	return &Memory{hashes: map[common.Hash]struct{}{}}
+}
+
+func (s *Memory) Add(hash common.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes[hash] = struct{}{}
+	return nil
+}
+
+func (s *Memory) Contains(hash common.Hash) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, has := s.hashes[hash]
+	return has, nil
+}
+
+func (s *Memory) Flush() error { return nil }
+
+// Len returns the number of hashes in the set.
+func (s *Memory) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.hashes)
+}
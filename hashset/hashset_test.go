@@ -0,0 +1,87 @@
+package hashset_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/cerc-io/eth-iterator-utils/hashset"
+)
+
+func hashOf(i int) common.Hash {
+	return crypto.Keccak256Hash(binary.BigEndian.AppendUint64(nil, uint64(i)))
+}
+
+// enough additions to fill several disk batches
+const count = 10000
+
+func testMembership(t *testing.T, set hashset.HashMembership, exact bool) {
+	for i := 0; i < count; i++ {
+		if err := set.Add(hashOf(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func() {
+		for i := 0; i < count; i++ {
+			if has, err := set.Contains(hashOf(i)); err != nil {
+				t.Fatal(err)
+			} else if !has {
+				t.Fatalf("added hash %d is missing", i)
+			}
+		}
+		falsePositives := 0
+		for i := count; i < 2*count; i++ {
+			has, err := set.Contains(hashOf(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has {
+				falsePositives++
+			}
+		}
+		if exact && falsePositives != 0 {
+			t.Fatalf("exact set contains %d hashes which weren't added", falsePositives)
+		}
+		// the bloom filters are sized for a 1% rate
+		if falsePositives > count/20 {
+			t.Fatalf("too many false positives: %d of %d", falsePositives, count)
+		}
+	}
+	check()
+	if err := set.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestMemory(t *testing.T) {
+	set := hashset.NewMemory()
+	testMembership(t, set, true)
+	if set.Len() != count {
+		t.Fatalf("expected %d hashes, got %d", count, set.Len())
+	}
+}
+
+func TestBloom(t *testing.T) {
+	if _, err := hashset.NewBloom(0, 0.01); err == nil {
+		t.Fatal("expected error for empty bloom filter")
+	}
+	set, err := hashset.NewBloom(count, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMembership(t, set, false)
+}
+
+func TestDisk(t *testing.T) {
+	set, err := hashset.NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMembership(t, set, true)
+	if err := set.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,213 @@
+//go:build integration
+
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/ethereum/go-ethereum/triedb/pathdb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// initCode deploys a contract with no code, having set storage slots 0 and 1.
+const initCode = "0x6001600055600160015500"
+
+// The test runs geth in dev mode to generate a datadir for each state scheme, then traverses and
+// verifies its head state. It is only built with the integration tag, and needs a geth binary,
+// found in GETH_BINARY or on the PATH:
+//
+//	GETH_BINARY=/path/to/geth go test -tags integration -run TestGethDevNode .
+func TestGethDevNode(t *testing.T) {
+	geth := os.Getenv("GETH_BINARY")
+	if geth == "" {
+		geth = "geth"
+	}
+	geth, err := exec.LookPath(geth)
+	if err != nil {
+		t.Skip("geth binary not found")
+	}
+
+	for _, scheme := range []string{rawdb.HashScheme, rawdb.PathScheme} {
+		scheme := scheme
+		t.Run(scheme, func(t *testing.T) {
+			datadir := t.TempDir()
+			runDevNode(t, geth, datadir, scheme)
+			verifyHeadState(t, datadir, scheme)
+		})
+	}
+}
+
+// runDevNode starts a dev mode node, sends some transfers and contract deployments to it, and
+// stops it once they are mined.
+func runDevNode(t *testing.T, geth, datadir, scheme string) {
+	ipcPath := filepath.Join(datadir, "geth.ipc")
+	cmd := exec.Command(geth,
+		"--dev", "--datadir", datadir, "--state.scheme", scheme, "--ipcpath", ipcPath,
+		"--nodiscover", "--maxpeers", "0", "--port", "0", "--authrpc.port", "0")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cmd.Process.Signal(os.Interrupt)
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("geth exited with error: %v", err)
+		}
+	}
+	defer stop()
+
+	var client *rpc.Client
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if _, err := os.Stat(ipcPath); err == nil {
+			if client, err = rpc.Dial(ipcPath); err == nil {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for geth to start")
+		}
+	}
+	defer client.Close()
+
+	var accounts []common.Address
+	if err := client.Call(&accounts, "eth_accounts"); err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) == 0 {
+		t.Fatal("dev node has no accounts")
+	}
+	// send transactions one at a time, as each is sealed in its own block
+	for i := 0; i < 20; i++ {
+		arg := map[string]interface{}{"from": accounts[0]}
+		if i%4 == 0 {
+			arg["data"] = initCode
+		} else {
+			arg["to"] = common.BytesToAddress([]byte{byte(i + 1)})
+			arg["value"] = (*hexutil.Big)(common.Big1)
+		}
+		var hash common.Hash
+		if err := client.Call(&hash, "eth_sendTransaction", arg); err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+			// lookups fail while the transaction index is initialized
+			var receipt *types.Receipt
+			err := client.Call(&receipt, "eth_getTransactionReceipt", hash)
+			if err == nil && receipt != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for transaction %d to be mined (err: %v)", i, err)
+			}
+		}
+	}
+	client.Close()
+	stop()
+}
+
+// verifyHeadState traverses the head state of a datadir in parallel, checking that the leaves of
+// the state trie hash to its root, and that the deployed contracts' storage is found.
+func verifyHeadState(t *testing.T, datadir, scheme string) {
+	chaindata := filepath.Join(datadir, "geth", "chaindata")
+	db, err := rawdb.Open(rawdb.OpenOptions{
+		Directory:         chaindata,
+		AncientsDirectory: filepath.Join(chaindata, "ancient"),
+		ReadOnly:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if found := rawdb.ReadStateScheme(db); found != scheme {
+		t.Fatalf("expected %s scheme database, found %q", scheme, found)
+	}
+	config := triedb.HashDefaults
+	if scheme == rawdb.PathScheme {
+		config = &triedb.Config{PathDB: pathdb.ReadOnly}
+	}
+	tdb := triedb.NewDatabase(db, config)
+	defer tdb.Close()
+
+	head := rawdb.ReadHeadBlock(db)
+	if head == nil {
+		t.Fatal("no head block")
+	}
+	root := head.Root()
+	makeIterator := iter.NewTrieDBConstructor(tdb, trie.StateTrieID(root))
+
+	type leaf struct{ key, value []byte }
+	var leaves []leaf
+	var mu sync.Mutex
+	err = iter.Traverse(context.Background(), makeIterator, 16, 4, func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			if it.Leaf() {
+				mu.Lock()
+				leaves = append(leaves, leaf{it.LeafKey(), it.LeafBlob()})
+				mu.Unlock()
+			}
+		}
+		return it.Error()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(leaves, func(i, j int) bool { return bytes.Compare(leaves[i].key, leaves[j].key) < 0 })
+	stack := trie.NewStackTrie(nil)
+	for i, leaf := range leaves {
+		if i > 0 && bytes.Equal(leaves[i-1].key, leaf.key) {
+			t.Fatalf("leaf %x visited twice", leaf.key)
+		}
+		if err := stack.Update(leaf.key, leaf.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hash := stack.Hash(); hash != root {
+		t.Fatalf("traversed state hashes to %x, expected %x", hash, root)
+	}
+
+	accounts, err := makeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slots int
+	err = iter.TraverseStorage(context.Background(), tdb, root, accounts,
+		func(_ common.Hash, it trie.NodeIterator) error {
+			for it.Next(true) {
+				if it.Leaf() {
+					mu.Lock()
+					slots++
+					mu.Unlock()
+				}
+			}
+			return it.Error()
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each of the 5 contracts sets 2 slots
+	if slots != 10 {
+		t.Fatalf("expected 10 storage slots, found %d", slots)
+	}
+}
@@ -18,7 +18,8 @@ package iterator
 
 import (
 	"bytes"
-	"math/bits"
+	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/trie"
 )
@@ -31,6 +32,8 @@ type IteratorConstructor = func(startKey []byte) (trie.NodeIterator, error)
 type PrefixBoundIterator struct {
 	trie.NodeIterator
 	StartPath, EndPath []byte
+	done               bool
+	exclusive          bool // whether the node at EndPath is excluded
 }
 
 // NewPrefixBoundIterator returns an iterator with an upper bound value (hex path prefix)
@@ -39,103 +42,123 @@ func NewPrefixBoundIterator(it trie.NodeIterator, to []byte) *PrefixBoundIterato
 }
 
 func (it *PrefixBoundIterator) Next(descend bool) bool {
-	if it.EndPath == nil {
-		return it.NodeIterator.Next(descend)
-	}
 	if !it.NodeIterator.Next(descend) {
+		it.done = it.NodeIterator.Error() == nil
 		return false
 	}
+	if it.EndPath == nil {
+		return true
+	}
 	// Stop if underlying iterator went past upper bound.
 	// Note: this results in a single node of overlap between binned iterators. The more correct
 	// behavior would be to make this a strict less-than, so that iterators cover mutually disjoint
 	// subtries. Unfortunately, the NodeIterator constructor takes a compact path, meaning
-	// odd-length paths must be padded with a 0, so e.g. [8] becomes [8, 0], which means we would
-	// skip [8]. So, we use <= here to cover that node for the "next" bin.
-	return bytes.Compare(it.Path(), it.EndPath) <= 0
+	// odd-length paths must be padded with a 0 (see MinPathUnder), so e.g. [8] becomes [8, 0],
+	// which means we would skip [8]. So, we use <= here to cover that node for the "next" bin.
+	// SubtrieIteratorsDedup uses a strict bound where the next bin starts exactly at EndPath.
+	if cmp := bytes.Compare(it.Path(), it.EndPath); cmp > 0 || (cmp == 0 && it.exclusive) {
+		it.done = true
+		return false
+	}
+	return true
 }
 
-func (it *PrefixBoundIterator) Bounds() ([]byte, []byte) {
-	return it.StartPath, it.EndPath
+// Unwrap returns the wrapped iterator.
+func (it *PrefixBoundIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
 }
 
-// generates nibble slice prefixes at uniform intervals
-type prefixGenerator struct {
-	current   []byte
-	step      byte
-	stepIndex uint
+func (it *PrefixBoundIterator) Bounds() ([]byte, []byte) {
+	return it.StartPath, it.EndPath
 }
 
-func newPrefixGenerator(nbins uint) prefixGenerator {
-	if bits.OnesCount(nbins) != 1 {
-		panic("nbins must be a power of 2")
+// NewKeyRangeIterator returns an iterator over the part of a trie holding the leaves with keys from
+// `start`, inclusive, to `end`, exclusive, e.g. a range of account hashes. A nil start or end is
+// unbounded. Adjacent ranges yield each node once: nodes above the leaves belong to the range
+// holding their path, so one whose path is a prefix of `start` precedes the range.
+//
+// Keys must have an even number of nibbles, i.e. be whole bytes, and the bounds are reported by
+// Bounds as the paths of the keys, without a terminator.
+func NewKeyRangeIterator(makeIterator IteratorConstructor, start, end []byte) (*PrefixBoundIterator, error) {
+	it, err := makeIterator(start)
+	if err != nil {
+		return nil, err
 	}
-	// determine step dist. and path index at which to step
-	var step byte
-	var stepIndex uint
-	for ; nbins != 0; stepIndex++ {
-		divisor := byte(nbins & 0xF)
-		if divisor != 0 {
-			step = 0x10 / divisor
-		}
-		nbins = nbins >> 4
+	bounded := &PrefixBoundIterator{NodeIterator: it, exclusive: true}
+	if start != nil {
+		startPath := KeyBytesToHex(start)
+		bounded.StartPath = startPath[:len(startPath)-1]
 	}
-	return prefixGenerator{
-		current:   make([]byte, stepIndex),
-		step:      step,
-		stepIndex: stepIndex - 1,
+	if end != nil {
+		endPath := KeyBytesToHex(end)
+		bounded.EndPath = endPath[:len(endPath)-1]
 	}
+	return bounded, nil
 }
 
-func (gen *prefixGenerator) Value() []byte {
-	return gen.current
-}
-
-func (gen *prefixGenerator) HasNext() bool {
-	return gen.current[0] < 0x10
-}
-
-func (gen *prefixGenerator) Next() {
-	// increment the cursor, and handle overflow
-	gen.current[gen.stepIndex] += gen.step
-	overflow := false
-	for ix := 0; ix < len(gen.current); ix++ {
-		rix := len(gen.current) - 1 - ix // index in prefix is reverse
-		if overflow {                    // apply overflow
-			gen.current[rix]++
-			overflow = false
-		}
-		// detect overflow at this index
-		if rix != 0 && gen.current[rix] > 0xf {
-			gen.current[rix] = 0
-			overflow = true
+// MakePaths generates paths that cut trie domain into `nbins` conterminous bins (w/ opt. prefix).
+// Paths have the fewest nibbles below the prefix which can represent nbins, i.e. the least depth
+// such that 16^depth >= nbins, and the bins are uniform when nbins is a power of 2, e.g.
+// MakePaths([], 2) => [[0] [8]]
+// MakePaths([4], 32) => [[4 0 0] [4 0 8] [4 1 0]... [4 f 8]]
+// MakePaths([], 4096) => [[0 0 0] [0 0 1]... [f f f]]
+// Otherwise, their sizes differ by at most one path of that length, e.g.
+// MakePaths([], 24) => [[0 0] [0 a] [1 5] [2 0] [2 a]... [f 5]]
+func MakePaths(prefix []byte, nbins uint) [][]byte {
+	if nbins == 0 {
+		panic("nbins must be positive")
+	}
+	depth := 0
+	for span := uint64(1); span < uint64(nbins); span <<= 4 {
+		depth++
+		if depth == 16 {
+			break // 16^16 exceeds any bin count
 		}
 	}
+	return MakePathsAtDepth(prefix, nbins, depth)
 }
 
-// MakePaths generates paths that cut trie domain into `nbins` uniform conterminous bins (w/ opt. prefix)
-// eg. MakePaths([], 2) => [[0] [8]]
-// MakePaths([4], 32) => [[4 0 0] [4 0 8] [4 1 0]... [4 f 8]]
-func MakePaths(prefix []byte, nbins uint) [][]byte {
-	var res [][]byte
-	for it := newPrefixGenerator(nbins); it.HasNext(); it.Next() {
-		next := make([]byte, len(prefix))
+// MakePathsAtDepth is like MakePaths, but the paths have `depth` nibbles below the prefix, which
+// must be enough to represent nbins. A greater depth than MakePaths uses gives bins closer to
+// uniform when nbins is not a power of 2, e.g.
+// MakePathsAtDepth([], 3, 1) => [[0] [5] [a]]
+// MakePathsAtDepth([], 3, 4) => [[0 0 0 0] [5 5 5 5] [a a a a]]
+func MakePathsAtDepth(prefix []byte, nbins uint, depth int) [][]byte {
+	if nbins == 0 {
+		panic("nbins must be positive")
+	}
+	if depth < 0 || depth < 16 && uint64(nbins) > uint64(1)<<(4*depth) {
+		panic(fmt.Sprintf("depth %d cannot represent %d bins", depth, nbins))
+	}
+	span := new(big.Int).Lsh(big.NewInt(1), uint(4*depth))
+	count := new(big.Int).SetUint64(uint64(nbins))
+	nibble := big.NewInt(0xf)
+	res := make([][]byte, nbins)
+	for i := range res {
+		// bin i starts at the path with index floor(i * span / nbins)
+		index := new(big.Int).SetUint64(uint64(i))
+		index.Quo(index.Mul(index, span), count)
+		next := make([]byte, len(prefix)+depth)
 		copy(next, prefix)
-		next = append(next, it.Value()...)
-		res = append(res, next)
+		for j := len(next) - 1; j >= len(prefix); j-- {
+			next[j] = byte(new(big.Int).And(index, nibble).Uint64())
+			index.Rsh(index, 4)
+		}
+		res[i] = next
 	}
 	return res
 }
 
 func eachPrefixRange(prefix []byte, nbins uint, callback func([]byte, []byte) error) error {
-	prefixes := MakePaths(prefix, nbins)
-	prefixes = append(prefixes, nil) // include tail
-	prefixes[0] = nil                // set bin 0 left bound to nil to include root
+	return eachRange(MakePaths(prefix, nbins), callback)
+}
+
+// calls back with the bounds of each range starting at a path in `starts` and ending at the next
+func eachRange(starts [][]byte, callback func([]byte, []byte) error) error {
+	prefixes := append(starts, nil) // include tail
+	prefixes[0] = nil               // set bin 0 left bound to nil to include root
 	for i := 0; i < len(prefixes)-1; i++ {
-		key := prefixes[i]
-		if len(key)%2 != 0 { // zero-pad for odd-length keys
-			key = append(key, 0)
-		}
-		err := callback(key, prefixes[i+1])
+		err := callback(MinPathUnder(prefixes[i]), prefixes[i+1])
 		if err != nil {
 			return err
 		}
@@ -143,15 +166,42 @@ func eachPrefixRange(prefix []byte, nbins uint, callback func([]byte, []byte) er
 	return nil
 }
 
-// SubtrieIterators cuts a trie by path prefix, returning `nbins` iterators covering its subtries
+// SubtrieIterators cuts a trie by path prefix, returning `nbins` iterators covering its subtries.
+// Any bin count is accepted, so it can be matched to a worker count; see MakePaths.
 func SubtrieIterators(makeIterator IteratorConstructor, nbins uint) ([]trie.NodeIterator, error) {
+	return rangeIterators(makeIterator, MakePaths(nil, nbins), false)
+}
+
+// SubtrieIteratorsDedup is like SubtrieIterators, but yields each node exactly once: when a bin
+// ends at an even-length path, the node there is left to the next bin, which starts with it.
+//
+// Only the iterators' positions are saved by a tracker, so an iterator restored from one bounds
+// its range inclusively again, and may repeat the node at its end.
+func SubtrieIteratorsDedup(makeIterator IteratorConstructor, nbins uint) ([]trie.NodeIterator, error) {
+	return rangeIterators(makeIterator, MakePaths(nil, nbins), true)
+}
+
+// SubtrieBounds returns the start and end paths of the `nbins` subtries which SubtrieIterators
+// divides a trie into, e.g. to construct their iterators lazily.
+func SubtrieBounds(nbins uint) (starts, ends [][]byte) {
+	eachRange(MakePaths(nil, nbins), func(from, to []byte) error {
+		starts, ends = append(starts, from), append(ends, to)
+		return nil
+	})
+	return starts, ends
+}
+
+func rangeIterators(makeIterator IteratorConstructor, starts [][]byte, dedup bool) ([]trie.NodeIterator, error) {
 	var iters []trie.NodeIterator
-	err := eachPrefixRange(nil, nbins, func(from []byte, to []byte) error {
+	err := eachRange(starts, func(from []byte, to []byte) error {
 		it, err := makeIterator(HexToKeyBytes(from))
 		if err != nil {
 			return err
 		}
-		iters = append(iters, NewPrefixBoundIterator(it, to))
+		bounded := NewPrefixBoundIterator(it, to)
+		// the next bin starts at an even-length bound itself, rather than under it
+		bounded.exclusive = dedup && len(to)&1 == 0
+		iters = append(iters, bounded)
 		return nil
 	})
 	return iters, err
@@ -18,6 +18,73 @@ func TestMakePaths(t *testing.T) {
 			t.Errorf("wrong number of paths; expected %d, have %d", nbins, len(paths))
 		}
 	}
+
+	// other bin counts are split as evenly as possible
+	paths := iter.MakePaths(nil, 24)
+	if len(paths) != 24 {
+		t.Fatalf("wrong number of paths; expected 24, have %d", len(paths))
+	}
+	expected := [][]byte{{0, 0}, {0, 10}, {1, 5}, {2, 0}, {2, 10}}
+	for i, path := range expected {
+		if !bytes.Equal(paths[i], path) {
+			t.Errorf("wrong path %d; expected %x, have %x", i, path, paths[i])
+		}
+	}
+	for i := 1; i < len(paths); i++ {
+		if gap := int(paths[i][0])*16 + int(paths[i][1]) - int(paths[i-1][0])*16 - int(paths[i-1][1]); gap != 10 && gap != 11 {
+			t.Errorf("uneven gap of %d before path %d", gap, i)
+		}
+	}
+}
+
+func TestMakePathsDepth(t *testing.T) {
+	// powers of 16 fill their depth
+	for depth, nbins := range []uint{1, 16, 256, 4096} {
+		paths := iter.MakePaths([]byte{7}, nbins)
+		for i, path := range paths {
+			if len(path) != 1+depth || path[0] != 7 {
+				t.Fatalf("%d bins: wrong path %d: %x", nbins, i, path)
+			}
+			if i > 0 && bytes.Compare(paths[i-1], path) >= 0 {
+				t.Fatalf("%d bins: path %x does not follow %x", nbins, path, paths[i-1])
+			}
+		}
+		if nbins == 4096 && !bytes.Equal(paths[4095], []byte{7, 15, 15, 15}) {
+			t.Fatalf("wrong last path: %x", paths[4095])
+		}
+	}
+
+	paths := iter.MakePathsAtDepth(nil, 3, 4)
+	expected := [][]byte{{0, 0, 0, 0}, {5, 5, 5, 5}, {10, 10, 10, 10}}
+	for i, path := range expected {
+		if !bytes.Equal(paths[i], path) {
+			t.Errorf("wrong path %d; expected %x, have %x", i, path, paths[i])
+		}
+	}
+	// depths beyond a uint64 index
+	paths = iter.MakePathsAtDepth(nil, 2, 64)
+	if len(paths[1]) != 64 || paths[1][0] != 8 || !bytes.Equal(paths[1][1:], make([]byte, 63)) {
+		t.Errorf("wrong path at depth 64: %x", paths[1])
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for insufficient depth")
+		}
+	}()
+	iter.MakePathsAtDepth(nil, 17, 1)
+}
+
+func TestSubtrieBounds(t *testing.T) {
+	starts, ends := iter.SubtrieBounds(4)
+	expectedStarts := [][]byte{nil, {4, 0}, {8, 0}, {12, 0}}
+	expectedEnds := [][]byte{{4}, {8}, {12}, nil}
+	for i := range expectedStarts {
+		if !bytes.Equal(starts[i], expectedStarts[i]) || !bytes.Equal(ends[i], expectedEnds[i]) ||
+			(ends[i] == nil) != (expectedEnds[i] == nil) {
+			t.Fatalf("wrong bounds for bin %d: [%x, %x]", i, starts[i], ends[i])
+		}
+	}
 }
 
 func TestIterator(t *testing.T) {
@@ -59,7 +126,7 @@ func TestIterator(t *testing.T) {
 
 	t.Run("trie is covered", func(t *testing.T) {
 		allPaths := internal.FixtureNodePaths
-		cases := []uint{1, 2, 4, 8, 16, 32}
+		cases := []uint{1, 2, 3, 4, 8, 16, 24, 32, 100}
 		runCase := func(t *testing.T, nbins uint) {
 			iters, err := iter.SubtrieIterators(tree.NodeIterator, nbins)
 			if err != nil {
@@ -84,4 +151,80 @@ func TestIterator(t *testing.T) {
 			t.Run(fmt.Sprintf("%d bins", tc), func(t *testing.T) { runCase(t, tc) })
 		}
 	})
+
+	t.Run("trie is covered once", func(t *testing.T) {
+		allPaths := internal.FixtureNodePaths
+		for _, nbins := range []uint{1, 3, 16, 24, 256} {
+			iters, err := iter.SubtrieIteratorsDedup(tree.NodeIterator, nbins)
+			if err != nil {
+				t.Fatalf("failed to create subtrie iterators: %v", err)
+			}
+			ix := 0
+			for b, it := range iters {
+				for ; it.Next(true); ix++ {
+					if ix >= len(allPaths) || !bytes.Equal(allPaths[ix], it.Path()) {
+						t.Fatalf("wrong path value in bin %d of %d (index %d): %v", b, nbins, ix, it.Path())
+					}
+				}
+			}
+			if ix != len(allPaths) {
+				t.Fatalf("expected %d paths for %d bins, got %d", len(allPaths), nbins, ix)
+			}
+		}
+	})
+}
+
+func TestKeyRangeIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var keys [][]byte
+	var nodes int
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+		nodes++
+		if it.Leaf() {
+			keys = append(keys, append([]byte(nil), it.LeafKey()...))
+		}
+	}
+	// bounds at a leaf key, between leaf keys, and unbounded
+	mid := append([]byte(nil), keys[len(keys)/2]...)
+	between := append([]byte(nil), keys[len(keys)/4]...)
+	between[31]++
+	bounds := [][]byte{nil, between, mid, nil}
+
+	var yielded, leaves int
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		it, err := iter.NewKeyRangeIterator(tree.NodeIterator, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from, to := it.Bounds(); start != nil && len(from) != 64 || end != nil && len(to) != 64 {
+			t.Fatalf("wrong bounds %x, %x", from, to)
+		}
+		for it.Next(true) {
+			yielded++
+			if !it.Leaf() {
+				continue
+			}
+			leaves++
+			key := it.LeafKey()
+			if start != nil && bytes.Compare(key, start) < 0 || end != nil && bytes.Compare(key, end) >= 0 {
+				t.Fatalf("leaf %x outside range %x-%x", key, start, end)
+			}
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+	}
+	if leaves != len(keys) {
+		t.Fatalf("expected %d leaves, got %d", len(keys), leaves)
+	}
+	if yielded != nodes {
+		t.Fatalf("expected %d nodes, got %d", nodes, yielded)
+	}
 }
@@ -0,0 +1,145 @@
+package iterator
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// KeyListIterator is a NodeIterator which only yields the nodes on the paths to a list of leaf
+// keys, down to their leaves, e.g. to re-export the rows of an earlier export which failed
+// validation. Subtries which hold none of the keys are skipped without being read, so the cost is
+// that of a seek to each key, with the nodes shared by neighbouring keys read once.
+type KeyListIterator struct {
+	trie.NodeIterator
+	keys, paths [][]byte
+	next        int // index of the first key whose leaf has not been reached or passed
+	missing     [][]byte
+}
+
+// NewKeyListIterator returns an iterator over the paths to the leaves with the given keys, which
+// starts at the first of them. The keys are sorted, and duplicates dropped.
+func NewKeyListIterator(makeIterator IteratorConstructor, keys [][]byte) (*KeyListIterator, error) {
+	keys = sortedKeys(keys)
+	var start []byte
+	if len(keys) != 0 {
+		start = keys[0]
+	}
+	it, err := makeIterator(start)
+	if err != nil {
+		return nil, err
+	}
+	kit := &KeyListIterator{NodeIterator: it, keys: keys}
+	for _, key := range keys {
+		kit.paths = append(kit.paths, KeyBytesToHex(key))
+	}
+	return kit, nil
+}
+
+func sortedKeys(keys [][]byte) [][]byte {
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	unique := sorted[:0]
+	for i, key := range sorted {
+		if i == 0 || !bytes.Equal(key, sorted[i-1]) {
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
+// Next moves to the next node on the path to a key, and returns false once every key's leaf has
+// been reached or passed. Passing descend=false skips the children of the current node, as for the
+// underlying iterator.
+func (it *KeyListIterator) Next(descend bool) bool {
+	for it.next < len(it.paths) && it.NodeIterator.Next(descend) {
+		// nodes are visited in path order, so a key before the node is not in the trie
+		path := it.NodeIterator.Path()
+		for it.next < len(it.paths) && bytes.Compare(it.paths[it.next], path) < 0 {
+			it.missing = append(it.missing, it.keys[it.next])
+			it.next++
+		}
+		if it.next == len(it.paths) {
+			return false
+		}
+		if bytes.HasPrefix(it.paths[it.next], path) {
+			if it.NodeIterator.Leaf() {
+				it.next++
+			}
+			return true
+		}
+		descend = false
+	}
+	if it.next < len(it.paths) && it.NodeIterator.Error() == nil {
+		it.missing = append(it.missing, it.keys[it.next:]...)
+		it.next = len(it.paths)
+	}
+	return false
+}
+
+// Missing returns the keys passed by the iterator which are not in the trie. Once the iterator is
+// exhausted without error, these are all the keys whose leaves it did not yield.
+func (it *KeyListIterator) Missing() [][]byte {
+	return it.missing
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *KeyListIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+// Bounds returns the path at which the iterator starts, and the path of the last key's leaf.
+func (it *KeyListIterator) Bounds() ([]byte, []byte) {
+	if len(it.paths) == 0 {
+		return nil, nil
+	}
+	start := it.paths[0]
+	return start[:len(start)-1], it.paths[len(it.paths)-1]
+}
+
+// TraverseKeys calls visit with iterators over the paths to the leaves with the given keys, as
+// KeyListIterators, and returns the keys which are not in the trie. The keys are sorted and divided
+// into up to nbins batches of neighbouring keys, which are visited on a pool of `workers`
+// goroutines as by TraverseIterators, with the same options. Visiting with an export.Sink writes a
+// patch holding only the leaves of the keys, e.g. to replace the rows of an earlier export which
+// failed validation, without traversing the bins which held them.
+func TraverseKeys(
+	ctx context.Context, makeIterator IteratorConstructor, keys [][]byte, nbins, workers uint, visit Visitor,
+	opts ...TraverseOption,
+) ([][]byte, error) {
+	keys = sortedKeys(keys)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	if nbins == 0 {
+		nbins = 1
+	}
+	size := (len(keys) + int(nbins) - 1) / int(nbins)
+	var (
+		bins  []*KeyListIterator
+		iters []trie.NodeIterator
+	)
+	for start := 0; start < len(keys); start += size {
+		end := start + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		it, err := NewKeyListIterator(makeIterator, keys[start:end])
+		if err != nil {
+			return nil, err
+		}
+		bins = append(bins, it)
+		iters = append(iters, it)
+	}
+	if err := TraverseIterators(ctx, iters, workers, visit, opts...); err != nil {
+		return nil, err
+	}
+	var missing [][]byte
+	for _, it := range bins {
+		missing = append(missing, it.Missing()...)
+	}
+	return missing, nil
+}
@@ -0,0 +1,101 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestKeyListIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+	makeIterator := func(start []byte) (trie.NodeIterator, error) { return tree.NodeIterator(start) }
+
+	leafKeys := internal.FixtureLeafKeys
+	present := [][]byte{leafKeys[len(leafKeys)-1], leafKeys[0], leafKeys[len(leafKeys)/2], leafKeys[0]}
+	absent := [][]byte{
+		common.HexToHash("0x00").Bytes(),
+		incremented(leafKeys[len(leafKeys)/2]),
+		common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff").Bytes(),
+	}
+
+	it, err := iter.NewKeyListIterator(makeIterator, append(append([][]byte{}, present...), absent...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var leaves [][]byte
+	nodes := 0
+	for it.Next(true) {
+		nodes++
+		if it.Leaf() {
+			leaves = append(leaves, it.LeafKey())
+		}
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	// each key is yielded once, in order
+	expected := [][]byte{leafKeys[0], leafKeys[len(leafKeys)/2], leafKeys[len(leafKeys)-1]}
+	checkKeys(t, "leaves", leaves, expected)
+	checkKeys(t, "missing", it.Missing(), absent)
+	if nodes >= len(internal.FixtureNodePaths)/2 {
+		t.Fatalf("expected only the paths to the keys, got %d of %d nodes", nodes, len(internal.FixtureNodePaths))
+	}
+
+	// the traversal is split into batches of neighbouring keys
+	var mu sync.Mutex
+	leaves = nil
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			if it.Leaf() {
+				mu.Lock()
+				leaves = append(leaves, it.LeafKey())
+				mu.Unlock()
+			}
+		}
+		return it.Error()
+	}
+	keys := append(append([][]byte{}, leafKeys...), absent...)
+	missing, err := iter.TraverseKeys(context.Background(), makeIterator, keys, 5, 3, visit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(leaves, func(i, j int) bool { return bytes.Compare(leaves[i], leaves[j]) < 0 })
+	checkKeys(t, "traversed leaves", leaves, leafKeys)
+	checkKeys(t, "traversed missing", missing, absent)
+
+	if missing, err := iter.TraverseKeys(context.Background(), makeIterator, nil, 5, 3, visit); err != nil || missing != nil {
+		t.Fatalf("expected no keys to be traversed, got %x (%v)", missing, err)
+	}
+}
+
+// incremented returns the key after the given one.
+func incremented(key []byte) []byte {
+	next := common.CopyBytes(key)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func checkKeys(t *testing.T, name string, got, expected [][]byte) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("%s: expected %d keys, got %d", name, len(expected), len(got))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], expected[i]) {
+			t.Fatalf("%s: key %d is %x, expected %x", name, i, got[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Package metrics reports the progress of trie traversals to a metrics backend.
+//
+// Iterators are wrapped with NewIterator, which reports each visited node and completed bin to a
+// Collector. RegistryCollector is a Collector which exports counters and gauges through a go-ethereum
+// metrics registry, from which they can be served to Prometheus:
+//
+//	registry := gethmetrics.NewRegistry()
+//	collector := metrics.NewRegistryCollector(registry, "iterator")
+//	http.Handle("/metrics", prometheus.Handler(registry))
+//
+//	for i, it := range iters {
+//		it := metrics.NewIterator(it, collector, i)
+//		// ... traverse
+//	}
+//
+// To find slow regions of the keyspace, iterators can also be wrapped with NewTimingIterator,
+// which records the latency of each node, and the counts and sizes of leaves, in a Heatmap by path
+// prefix. Heat maps are exported with WriteCSV or WriteJSON.
+package metrics
+
+import (
+	"fmt"
+	"sync"
+
+	gethmetrics "github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// Collector receives traversal events. Implementations must be safe for concurrent use.
+type Collector interface {
+	// NodeVisited is called for each node reached by the iterator over a bin. The path is only
+	// valid for the duration of the call.
+	NodeVisited(bin int, path []byte, leaf bool)
+	// BinCompleted is called when the iterator over a bin is exhausted.
+	BinCompleted(bin int)
+	// CheckpointSaved is called when a tracker has saved the positions of its iterators.
+	CheckpointSaved()
+}
+
+// Iterator is a NodeIterator which reports its progress to a Collector.
+type Iterator struct {
+	trie.NodeIterator
+	collector Collector
+	bin       int
+}
+
+// NewIterator wraps the iterator over a bin of a traversal, identified by its index.
+func NewIterator(it trie.NodeIterator, collector Collector, bin int) *Iterator {
+	return &Iterator{NodeIterator: it, collector: collector, bin: bin}
+}
+
+func (it *Iterator) Next(descend bool) bool {
+	if !it.NodeIterator.Next(descend) {
+		if it.NodeIterator.Error() == nil {
+			it.collector.BinCompleted(it.bin)
+		}
+		return false
+	}
+	it.collector.NodeVisited(it.bin, it.Path(), it.Leaf())
+	return true
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *Iterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+var _ Collector = &RegistryCollector{}
+
+// RegistryCollector is a Collector which exports metrics through a go-ethereum metrics registry.
+// Under the given prefix, it registers:
+//
+//   - nodes, leaves, bins/completed and checkpoints: counters of each event
+//   - bin/<index>/path: the current path of each bin, as a label
+//   - bin/<index>/position: the keyspace position of each bin's current path, from iter.Progress
+//
+// Metrics are recorded whether or not go-ethereum metrics are enabled.
+type RegistryCollector struct {
+	registry                      gethmetrics.Registry
+	prefix                        string
+	nodes, leaves, completed, cps gethmetrics.Counter
+
+	bins   map[int]*binPosition
+	binsMu sync.Mutex
+}
+
+// NewRegistryCollector registers traversal metrics named with prefix in a registry.
+func NewRegistryCollector(registry gethmetrics.Registry, prefix string) *RegistryCollector {
+	return &RegistryCollector{
+		registry:  registry,
+		prefix:    prefix,
+		nodes:     gethmetrics.NewRegisteredCounterForced(prefix+"/nodes", registry),
+		leaves:    gethmetrics.NewRegisteredCounterForced(prefix+"/leaves", registry),
+		completed: gethmetrics.NewRegisteredCounterForced(prefix+"/bins/completed", registry),
+		cps:       gethmetrics.NewRegisteredCounterForced(prefix+"/checkpoints", registry),
+		bins:      map[int]*binPosition{},
+	}
+}
+
+func (c *RegistryCollector) NodeVisited(bin int, path []byte, leaf bool) {
+	c.nodes.Inc(1)
+	if leaf {
+		c.leaves.Inc(1)
+	}
+	c.bin(bin).update(path)
+}
+
+func (c *RegistryCollector) BinCompleted(bin int) {
+	c.completed.Inc(1)
+}
+
+func (c *RegistryCollector) CheckpointSaved() {
+	c.cps.Inc(1)
+}
+
+// bin returns the position of a bin, registering its gauges when it is first seen.
+func (c *RegistryCollector) bin(bin int) *binPosition {
+	c.binsMu.Lock()
+	defer c.binsMu.Unlock()
+	pos, has := c.bins[bin]
+	if !has {
+		pos = &binPosition{}
+		name := fmt.Sprintf("%s/bin/%d", c.prefix, bin)
+		c.registry.Register(name+"/path", pathGauge{pos})
+		c.registry.Register(name+"/position", positionGauge{pos})
+		c.bins[bin] = pos
+	}
+	return pos
+}
+
+// binPosition holds the current path of a bin. The gauges reading it are only evaluated when the
+// registry is read, so updating it is cheap.
+type binPosition struct {
+	path []byte
+	mu   sync.Mutex
+}
+
+func (p *binPosition) update(path []byte) {
+	p.mu.Lock()
+	p.path = append(p.path[:0], path...)
+	p.mu.Unlock()
+}
+
+type pathGauge struct{ pos *binPosition }
+
+func (g pathGauge) Update(gethmetrics.GaugeInfoValue) {}
+
+func (g pathGauge) Snapshot() gethmetrics.GaugeInfoSnapshot {
+	g.pos.mu.Lock()
+	defer g.pos.mu.Unlock()
+	return infoSnapshot{"path": fmt.Sprintf("%x", g.pos.path)}
+}
+
+type infoSnapshot gethmetrics.GaugeInfoValue
+
+func (s infoSnapshot) Value() gethmetrics.GaugeInfoValue { return gethmetrics.GaugeInfoValue(s) }
+
+type positionGauge struct{ pos *binPosition }
+
+func (g positionGauge) Update(float64) {}
+
+func (g positionGauge) Snapshot() gethmetrics.GaugeFloat64Snapshot {
+	g.pos.mu.Lock()
+	defer g.pos.mu.Unlock()
+	return floatSnapshot(iter.Progress(g.pos.path))
+}
+
+type floatSnapshot float64
+
+func (s floatSnapshot) Value() float64 { return float64(s) }
@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gethmetrics "github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/metrics"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestRegistryCollector(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	registry := gethmetrics.NewRegistry()
+	collector := metrics.NewRegistryCollector(registry, "test")
+	tr := tracker.New(t.TempDir()+"/recovery.csv", 4, tracker.WithCollector(collector))
+
+	iters, err := iter.SubtrieIterators(tree.NodeIterator, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes, leaves int64
+	for i, it := range iters {
+		it := metrics.NewIterator(tr.Tracked(it), collector, i)
+		// leave the last bin unfinished, after its first node
+		if i == 3 {
+			it.Next(true)
+			nodes++
+			continue
+		}
+		for it.Next(true) {
+			nodes++
+			if it.Leaf() {
+				leaves++
+			}
+		}
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	counters := map[string]int64{
+		"test/nodes":          nodes,
+		"test/leaves":         leaves,
+		"test/bins/completed": 3,
+		"test/checkpoints":    1,
+	}
+	for name, expected := range counters {
+		value := registry.Get(name).(gethmetrics.Counter).Snapshot().Count()
+		if value != expected {
+			t.Fatalf("expected %s = %d, got %d", name, expected, value)
+		}
+	}
+	position := registry.Get("test/bin/3/position").(gethmetrics.GaugeFloat64).Snapshot().Value()
+	if position < 0.75 || position >= 1 {
+		t.Fatalf("expected last bin position in [0.75, 1), got %v", position)
+	}
+
+	rec := httptest.NewRecorder()
+	prometheus.Handler(registry).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, name := range []string{"test_nodes", "test_bin_0_path", "test_bin_3_position"} {
+		if !strings.Contains(rec.Body.String(), name) {
+			t.Fatalf("metric %s is not exported:\n%s", name, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// TimingBuckets are the upper bounds of the latency histogram buckets of a heat map cell. A final
+// bucket counts the latencies above the last bound.
+var TimingBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// HeatmapCell holds the latencies of the nodes visited under one path prefix.
+type HeatmapCell struct {
+	Prefix []byte
+	Count  uint64
+	// Leaves counts the leaves among the nodes, and LeafBytes sums the sizes of their values.
+	Leaves, LeafBytes uint64
+	// Total and Max are the sum and maximum of the latencies.
+	Total, Max time.Duration
+	// Buckets counts latencies by histogram bucket, as bounded by TimingBuckets.
+	Buckets []uint64
+}
+
+// Mean returns the mean latency of the cell.
+func (c HeatmapCell) Mean() time.Duration {
+	if c.Count == 0 {
+		return 0
+	}
+	return c.Total / time.Duration(c.Count)
+}
+
+// Heatmap records node latencies, and leaf counts and sizes, bucketed by path prefix, to localize
+// slow or dense regions of the keyspace, e.g. those backed by slow storage. It is safe for concurrent use, so one heat map can
+// be shared by the iterators of all bins.
+type Heatmap struct {
+	depth int
+	cells map[string]*HeatmapCell
+	mu    sync.Mutex
+}
+
+// NewHeatmap returns a heat map with a cell per path prefix of `depth` nibbles, i.e. 16^depth
+// cells at most. Nodes with shorter paths are recorded in the cell of their own path.
+func NewHeatmap(depth int) *Heatmap {
+	return &Heatmap{depth: depth, cells: map[string]*HeatmapCell{}}
+}
+
+// Record adds the latency of reaching the node at path.
+func (h *Heatmap) Record(path []byte, latency time.Duration) {
+	h.RecordNode(path, latency, 0)
+}
+
+// RecordNode adds the latency of reaching the node at path, and if it is a leaf (its path ends
+// with the terminator), the size of its value.
+func (h *Heatmap) RecordNode(path []byte, latency time.Duration, leafBytes int) {
+	leaf := len(path) > 0 && path[len(path)-1] == 16
+	if leaf {
+		path = path[:len(path)-1]
+	}
+	if len(path) > h.depth {
+		path = path[:h.depth]
+	}
+	bucket := sort.Search(len(TimingBuckets), func(i int) bool { return latency <= TimingBuckets[i] })
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cell, has := h.cells[string(path)]
+	if !has {
+		cell = &HeatmapCell{
+			Prefix:  append([]byte(nil), path...),
+			Buckets: make([]uint64, len(TimingBuckets)+1),
+		}
+		h.cells[string(path)] = cell
+	}
+	cell.Count++
+	if leaf {
+		cell.Leaves++
+		cell.LeafBytes += uint64(leafBytes)
+	}
+	cell.Total += latency
+	if latency > cell.Max {
+		cell.Max = latency
+	}
+	cell.Buckets[bucket]++
+}
+
+// Cells returns a copy of the recorded cells, ordered by prefix.
+func (h *Heatmap) Cells() []HeatmapCell {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cells := make([]HeatmapCell, 0, len(h.cells))
+	for _, cell := range h.cells {
+		cp := *cell
+		cp.Buckets = append([]uint64(nil), cell.Buckets...)
+		cells = append(cells, cp)
+	}
+	sort.Slice(cells, func(i, j int) bool { return bytes.Compare(cells[i].Prefix, cells[j].Prefix) < 0 })
+	return cells
+}
+
+// WriteCSV writes the heat map as CSV, with a header row and a row per cell giving its prefix,
+// node count, leaf count and bytes, total, mean and maximum latency in nanoseconds, and its bucket
+// counts.
+func (h *Heatmap) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	header := []string{"prefix", "count", "leaves", "leaf_bytes", "total_ns", "mean_ns", "max_ns"}
+	for _, bound := range TimingBuckets {
+		header = append(header, "le_"+bound.String())
+	}
+	header = append(header, "gt_"+TimingBuckets[len(TimingBuckets)-1].String())
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, cell := range h.Cells() {
+		row := []string{
+			fmt.Sprintf("%x", cell.Prefix),
+			strconv.FormatUint(cell.Count, 10),
+			strconv.FormatUint(cell.Leaves, 10),
+			strconv.FormatUint(cell.LeafBytes, 10),
+			strconv.FormatInt(int64(cell.Total), 10),
+			strconv.FormatInt(int64(cell.Mean()), 10),
+			strconv.FormatInt(int64(cell.Max), 10),
+		}
+		for _, count := range cell.Buckets {
+			row = append(row, strconv.FormatUint(count, 10))
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// heatmapJSON is the document written by WriteJSON.
+type heatmapJSON struct {
+	Depth          int               `json:"depth"`
+	BucketBoundsNs []int64           `json:"bucketBoundsNs"`
+	Cells          []heatmapCellJSON `json:"cells"`
+}
+
+type heatmapCellJSON struct {
+	Prefix    string   `json:"prefix"`
+	Count     uint64   `json:"count"`
+	Leaves    uint64   `json:"leaves"`
+	LeafBytes uint64   `json:"leafBytes"`
+	TotalNs   int64    `json:"totalNs"`
+	MeanNs    int64    `json:"meanNs"`
+	MaxNs     int64    `json:"maxNs"`
+	Buckets   []uint64 `json:"buckets"`
+}
+
+// WriteJSON writes the heat map as a JSON document, for plotting in notebooks or dashboards. It
+// holds the prefix depth, the bucket bounds in nanoseconds, and the cells with the columns of
+// WriteCSV, each with an array of bucket counts whose last entry counts latencies above the last
+// bound.
+func (h *Heatmap) WriteJSON(w io.Writer) error {
+	doc := heatmapJSON{Depth: h.depth, Cells: []heatmapCellJSON{}}
+	for _, bound := range TimingBuckets {
+		doc.BucketBoundsNs = append(doc.BucketBoundsNs, int64(bound))
+	}
+	for _, cell := range h.Cells() {
+		doc.Cells = append(doc.Cells, heatmapCellJSON{
+			Prefix:    fmt.Sprintf("%x", cell.Prefix),
+			Count:     cell.Count,
+			Leaves:    cell.Leaves,
+			LeafBytes: cell.LeafBytes,
+			TotalNs:   int64(cell.Total),
+			MeanNs:    int64(cell.Mean()),
+			MaxNs:     int64(cell.Max),
+			Buckets:   cell.Buckets,
+		})
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// TimingIterator is a NodeIterator which records the latency of each call to Next in a heat map,
+// against the path of the node it reached, along with the sizes of the leaves it reaches.
+type TimingIterator struct {
+	trie.NodeIterator
+	heatmap *Heatmap
+}
+
+// NewTimingIterator wraps an iterator to record its latencies in a heat map.
+func NewTimingIterator(it trie.NodeIterator, heatmap *Heatmap) *TimingIterator {
+	return &TimingIterator{NodeIterator: it, heatmap: heatmap}
+}
+
+func (it *TimingIterator) Next(descend bool) bool {
+	start := time.Now()
+	if !it.NodeIterator.Next(descend) {
+		return false
+	}
+	latency := time.Since(start)
+	var leafBytes int
+	if it.Leaf() {
+		leafBytes = len(it.LeafBlob())
+	}
+	it.heatmap.RecordNode(it.Path(), latency, leafBytes)
+	return true
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *TimingIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
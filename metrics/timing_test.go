@@ -0,0 +1,124 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/metrics"
+)
+
+func TestHeatmap(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	heatmap := metrics.NewHeatmap(1)
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := metrics.NewTimingIterator(nodeit, heatmap)
+	for it.Next(true) {
+	}
+	if it.Error() != nil {
+		t.Fatal(it.Error())
+	}
+
+	cells := heatmap.Cells()
+	var count, leaves uint64
+	for i, cell := range cells {
+		if len(cell.Prefix) > 1 {
+			t.Fatalf("expected prefixes of at most 1 nibble, got %x", cell.Prefix)
+		}
+		if i > 0 && bytes.Compare(cells[i-1].Prefix, cell.Prefix) >= 0 {
+			t.Fatalf("cells not ordered by prefix: %x before %x", cells[i-1].Prefix, cell.Prefix)
+		}
+		var bucketed uint64
+		for _, n := range cell.Buckets {
+			bucketed += n
+		}
+		if bucketed != cell.Count {
+			t.Fatalf("cell %x has %d nodes, but %d bucketed", cell.Prefix, cell.Count, bucketed)
+		}
+		if cell.Leaves > 0 && cell.LeafBytes == 0 {
+			t.Fatalf("cell %x has %d leaves, but no leaf bytes", cell.Prefix, cell.Leaves)
+		}
+		count += cell.Count
+		leaves += cell.Leaves
+	}
+	if count != uint64(len(internal.FixtureNodePaths)) {
+		t.Fatalf("expected %d nodes recorded, got %d", len(internal.FixtureNodePaths), count)
+	}
+	if leaves != uint64(len(internal.FixtureLeafKeys)) {
+		t.Fatalf("expected %d leaves recorded, got %d", len(internal.FixtureLeafKeys), leaves)
+	}
+
+	var buf bytes.Buffer
+	if err := heatmap.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(cells)+1 {
+		t.Fatalf("expected %d rows, got %d", len(cells)+1, len(rows))
+	}
+
+	buf.Reset()
+	if err := heatmap.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Depth int
+		Cells []struct {
+			Prefix  string
+			Leaves  uint64
+			Buckets []uint64
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Depth != 1 || len(doc.Cells) != len(cells) {
+		t.Fatalf("wrong JSON heat map: depth %d, %d cells", doc.Depth, len(doc.Cells))
+	}
+	for i, cell := range doc.Cells {
+		if cell.Prefix != fmt.Sprintf("%x", cells[i].Prefix) || cell.Leaves != cells[i].Leaves ||
+			len(cell.Buckets) != len(metrics.TimingBuckets)+1 {
+			t.Fatalf("wrong JSON cell %d: %+v", i, cell)
+		}
+	}
+}
+
+func TestHeatmapRecord(t *testing.T) {
+	heatmap := metrics.NewHeatmap(2)
+	heatmap.RecordNode([]byte{1, 2, 3, 16}, 5*time.Microsecond, 10)
+	heatmap.Record([]byte{1, 2}, time.Second)
+	heatmap.Record([]byte{1, 16}, time.Microsecond)
+
+	cells := heatmap.Cells()
+	if len(cells) != 2 {
+		t.Fatalf("expected 2 cells, got %d", len(cells))
+	}
+	short, cell := cells[0], cells[1]
+	if !bytes.Equal(short.Prefix, []byte{1}) || short.Count != 1 || short.Buckets[0] != 1 {
+		t.Fatalf("wrong cell for short path: %+v", short)
+	}
+	if !bytes.Equal(cell.Prefix, []byte{1, 2}) || cell.Count != 2 {
+		t.Fatalf("wrong cell for prefix 12: %+v", cell)
+	}
+	if cell.Max != time.Second || cell.Mean() != (time.Second+5*time.Microsecond)/2 {
+		t.Fatalf("wrong latencies for prefix 12: max %v, mean %v", cell.Max, cell.Mean())
+	}
+	if cell.Leaves != 1 || cell.LeafBytes != 10 || short.Leaves != 1 {
+		t.Fatalf("wrong leaves: %+v, %+v", short, cell)
+	}
+	if cell.Buckets[1] != 1 || cell.Buckets[len(metrics.TimingBuckets)] != 1 {
+		t.Fatalf("wrong buckets for prefix 12: %v", cell.Buckets)
+	}
+}
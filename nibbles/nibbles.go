@@ -0,0 +1,139 @@
+// Package nibbles provides arithmetic over trie node paths, as returned by NodeIterator.Path: paths
+// of hex nibbles, each in [0, 16), where the path of a leaf ends with the terminator 16.
+//
+// Paths are ordered as a NodeIterator visits them (pre-order), which is lexicographic order of
+// their nibbles. Successor and Predecessor step through the paths which can be used as iterator
+// start and bound paths, i.e. those without a terminator and no longer than MaxLength.
+package nibbles
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MaxLength is the length in nibbles of a leaf path in a trie with 32-byte keys, excluding the
+// terminator.
+const MaxLength = 64
+
+// Terminator is the last nibble of the path of a leaf.
+const Terminator = 16
+
+// Compare compares two paths in the order they are visited by a NodeIterator, returning -1, 0 or
+// +1. A node precedes the nodes under it.
+func Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+// HasTerm returns whether a path ends with the terminator.
+func HasTerm(path []byte) bool {
+	return len(path) > 0 && path[len(path)-1] == Terminator
+}
+
+// TrimTerm returns the path without its terminator, if any.
+func TrimTerm(path []byte) []byte {
+	if HasTerm(path) {
+		return path[:len(path)-1]
+	}
+	return path
+}
+
+// Validate returns an error if a path contains a nibble out of range, or a terminator other than
+// at its end, or is longer than MaxLength excluding the terminator.
+func Validate(path []byte) error {
+	for i, n := range path {
+		if n > Terminator || (n == Terminator && i != len(path)-1) {
+			return fmt.Errorf("invalid nibble %#x at index %d of path %x", n, i, path)
+		}
+	}
+	if len(TrimTerm(path)) > MaxLength {
+		return fmt.Errorf("path of length %d exceeds maximum of %d", len(path), MaxLength)
+	}
+	return nil
+}
+
+// CommonPrefix returns the longest prefix shared by two paths, as a slice of a.
+func CommonPrefix(a, b []byte) []byte {
+	var i int
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// IsProperPrefix returns whether prefix is a prefix of path and shorter than it, i.e. whether the
+// node at path lies under the node at prefix.
+func IsProperPrefix(prefix, path []byte) bool {
+	return len(prefix) < len(path) && bytes.HasPrefix(path, prefix)
+}
+
+// PadMin returns the smallest path under prefix which can be used as an iterator's start key,
+// i.e. the prefix padded with a 0 nibble to an even length. The prefix is not modified.
+//
+// E.g. PadMin([8]) = [8 0], PadMin([8 1]) = [8 1].
+func PadMin(prefix []byte) []byte {
+	path := make([]byte, len(prefix), len(prefix)+1)
+	copy(path, prefix)
+	if len(path)%2 != 0 {
+		path = append(path, 0)
+	}
+	return path
+}
+
+// PadMax returns the largest path under prefix, ignoring terminators, i.e. the prefix padded with
+// 0xf nibbles to MaxLength. The prefix is not modified.
+//
+// E.g. PadMax([8]) = [8 f f ... f], 64 nibbles long.
+func PadMax(prefix []byte) []byte {
+	if len(prefix) >= MaxLength {
+		return append([]byte(nil), prefix...)
+	}
+	path := make([]byte, MaxLength)
+	for i := copy(path, prefix); i < len(path); i++ {
+		path[i] = 0xf
+	}
+	return path
+}
+
+// Successor returns the first path after the given one which has no terminator and is at most
+// MaxLength long, or false if there is none. The path is not modified.
+//
+// E.g. Successor([1]) = [1 0], Successor([1 f f ... f]) = [2].
+func Successor(path []byte) ([]byte, bool) {
+	next := append([]byte(nil), path...)
+	if !HasTerm(next) && len(next) < MaxLength {
+		return append(next, 0), true
+	}
+	next = TrimTerm(next)
+	if len(next) > MaxLength {
+		next = next[:MaxLength]
+	}
+	// increment, carrying into the parent
+	for len(next) > 0 && next[len(next)-1] == 0xf {
+		next = next[:len(next)-1]
+	}
+	if len(next) == 0 {
+		return nil, false
+	}
+	next[len(next)-1]++
+	return next, true
+}
+
+// Predecessor returns the last path before the given one which has no terminator and is at most
+// MaxLength long, or false if there is none (i.e. for the root). Successor and Predecessor are
+// inverses over such paths. The path is not modified.
+//
+// E.g. Predecessor([1 0]) = [1], Predecessor([1]) = [0 f f ... f].
+func Predecessor(path []byte) ([]byte, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	if path[len(path)-1] == 0 {
+		return append([]byte(nil), path[:len(path)-1]...), true
+	}
+	prev := append([]byte(nil), path...)
+	prev[len(prev)-1]--
+	if len(prev) >= MaxLength { // e.g. a leaf path, whose terminator was decremented
+		return prev[:MaxLength], true
+	}
+	return PadMax(prev), true
+}
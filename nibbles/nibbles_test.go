@@ -0,0 +1,114 @@
+package nibbles_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/nibbles"
+)
+
+func maxPath(prefix ...byte) []byte {
+	path := append([]byte(nil), prefix...)
+	for len(path) < nibbles.MaxLength {
+		path = append(path, 0xf)
+	}
+	return path
+}
+
+func TestSuccessorPredecessor(t *testing.T) {
+	leaf := append(maxPath(3), nibbles.Terminator)
+	lastDecremented := func(path []byte) []byte {
+		return append(path[:len(path)-1:len(path)-1], path[len(path)-1]-1)
+	}
+	cases := []struct {
+		path, prev, next []byte
+	}{
+		{[]byte{}, nil, []byte{0}},
+		{[]byte{1}, maxPath(0), []byte{1, 0}},
+		{[]byte{1, 0}, []byte{1}, []byte{1, 0, 0}},
+		{[]byte{1, 5}, maxPath(1, 4), []byte{1, 5, 0}},
+		{maxPath(1), lastDecremented(maxPath(1)), []byte{2}},
+		{maxPath(), lastDecremented(maxPath()), nil},
+		{leaf, maxPath(3), []byte{4}},
+	}
+	for _, tc := range cases {
+		path := append([]byte(nil), tc.path...)
+		prev, ok := nibbles.Predecessor(path)
+		if ok != (tc.prev != nil) || !bytes.Equal(prev, tc.prev) {
+			t.Errorf("Predecessor(%x): expected %x, got %x", tc.path, tc.prev, prev)
+		}
+		next, ok := nibbles.Successor(path)
+		if ok != (tc.next != nil) || !bytes.Equal(next, tc.next) {
+			t.Errorf("Successor(%x): expected %x, got %x", tc.path, tc.next, next)
+		}
+		if !bytes.Equal(path, tc.path) {
+			t.Errorf("path %x was modified: %x", tc.path, path)
+		}
+		// they are inverses, apart from terminators
+		if ok && !nibbles.HasTerm(path) {
+			if back, _ := nibbles.Predecessor(next); !bytes.Equal(back, path) {
+				t.Errorf("Predecessor(Successor(%x)) = %x", path, back)
+			}
+		}
+	}
+
+	// each node path of a trie lies between its predecessor and successor
+	paths := internal.FixtureNodePaths
+	for i := 1; i+1 < len(paths); i++ {
+		if prev, ok := nibbles.Predecessor(paths[i]); !ok || nibbles.Compare(prev, paths[i]) >= 0 ||
+			nibbles.Compare(prev, paths[i-1]) < 0 {
+			t.Fatalf("bad predecessor of %x: %x", paths[i], prev)
+		}
+		if next, ok := nibbles.Successor(paths[i]); !ok || nibbles.Compare(next, paths[i]) <= 0 ||
+			nibbles.Compare(next, nibbles.TrimTerm(paths[i+1])) > 0 {
+			t.Fatalf("bad successor of %x: %x", paths[i], next)
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	if min := nibbles.PadMin([]byte{8}); !bytes.Equal(min, []byte{8, 0}) {
+		t.Errorf("wrong PadMin: %x", min)
+	}
+	if min := nibbles.PadMin([]byte{8, 1}); !bytes.Equal(min, []byte{8, 1}) {
+		t.Errorf("wrong PadMin: %x", min)
+	}
+	if max := nibbles.PadMax([]byte{8}); !bytes.Equal(max, maxPath(8)) {
+		t.Errorf("wrong PadMax: %x", max)
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	cases := []struct {
+		a, b, prefix []byte
+	}{
+		{nil, []byte{1}, []byte{}},
+		{[]byte{1, 2, 3}, []byte{1, 2, 4}, []byte{1, 2}},
+		{[]byte{1, 2}, []byte{1, 2, 4}, []byte{1, 2}},
+		{[]byte{5}, []byte{6}, []byte{}},
+	}
+	for _, tc := range cases {
+		if prefix := nibbles.CommonPrefix(tc.a, tc.b); !bytes.Equal(prefix, tc.prefix) {
+			t.Errorf("CommonPrefix(%x, %x): expected %x, got %x", tc.a, tc.b, tc.prefix, prefix)
+		}
+	}
+	if !nibbles.IsProperPrefix([]byte{1}, []byte{1, 2}) || nibbles.IsProperPrefix([]byte{1}, []byte{1}) {
+		t.Error("wrong IsProperPrefix")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := [][]byte{nil, {0, 0xf}, {nibbles.Terminator}, maxPath(), append(maxPath(), nibbles.Terminator)}
+	for _, path := range valid {
+		if err := nibbles.Validate(path); err != nil {
+			t.Errorf("path %x is valid: %v", path, err)
+		}
+	}
+	invalid := [][]byte{{17}, {nibbles.Terminator, 0}, append(maxPath(), 0)}
+	for _, path := range invalid {
+		if nibbles.Validate(path) == nil {
+			t.Errorf("path %x is invalid", path)
+		}
+	}
+}
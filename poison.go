@@ -0,0 +1,76 @@
+package iterator
+
+import (
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// PoisonByte is written over the buffers handed out by a PoisonIterator once they are no longer
+// valid. It is not a valid nibble, so a poisoned path fails nibbles.Validate.
+const PoisonByte = 0xff
+
+// PoisonIterator is a NodeIterator for debugging consumers which retain the buffers returned by
+// Path, LeafKey, LeafBlob or NodeBlob past the next call to Next. Geth's iterators reuse these
+// buffers, so retained slices are silently overwritten; here each call returns a fresh copy, which
+// is overwritten with PoisonByte when the iterator moves on, so the bug shows at once. Copies are
+// poisoned on the goroutine calling Next, so a consumer reading one concurrently, e.g. from a
+// goroutine it was passed to, is reported by the race detector.
+//
+// Consumers which need a buffer across calls must copy it, e.g. with common.CopyBytes.
+type PoisonIterator struct {
+	trie.NodeIterator
+
+	lent [][]byte // copies handed out at the current node
+}
+
+// NewPoisonIterator wraps an iterator to poison the buffers it returns once they are invalid.
+func NewPoisonIterator(it trie.NodeIterator) *PoisonIterator {
+	return &PoisonIterator{NodeIterator: it}
+}
+
+// WithPoisoning passes the iterator of every bin to the visitor in a PoisonIterator, to detect
+// visitors which retain the buffers of the nodes they visit. It is WithBufferPolicy(PoisonBuffers).
+func WithPoisoning() TraverseOption {
+	return WithBufferPolicy(PoisonBuffers)
+}
+
+// Next poisons the buffers returned at the current node, and moves to the next.
+func (it *PoisonIterator) Next(descend bool) bool {
+	for _, buf := range it.lent {
+		for i := range buf {
+			buf[i] = PoisonByte
+		}
+	}
+	it.lent = it.lent[:0]
+	return it.NodeIterator.Next(descend)
+}
+
+func (it *PoisonIterator) lend(buf []byte) []byte {
+	cp := copyBuffer(buf)
+	it.lent = append(it.lent, cp)
+	return cp
+}
+
+// Path returns a copy of the current path, valid until the next call to Next.
+func (it *PoisonIterator) Path() []byte {
+	return it.lend(it.NodeIterator.Path())
+}
+
+// LeafKey returns a copy of the current leaf key, valid until the next call to Next.
+func (it *PoisonIterator) LeafKey() []byte {
+	return it.lend(it.NodeIterator.LeafKey())
+}
+
+// LeafBlob returns a copy of the current leaf value, valid until the next call to Next.
+func (it *PoisonIterator) LeafBlob() []byte {
+	return it.lend(it.NodeIterator.LeafBlob())
+}
+
+// NodeBlob returns a copy of the current node blob, valid until the next call to Next.
+func (it *PoisonIterator) NodeBlob() []byte {
+	return it.lend(it.NodeIterator.NodeBlob())
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *PoisonIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
@@ -0,0 +1,87 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestPoisonIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	base, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := iter.NewPoisonIterator(base)
+	var retained, copied [][]byte
+	for it.Next(true) {
+		path := it.Path()
+		retained = append(retained, path)
+		copied = append(copied, common.CopyBytes(path))
+		if it.Leaf() {
+			key := it.LeafKey()
+			it.Next(true)
+			for _, b := range key {
+				if b != iter.PoisonByte {
+					t.Fatalf("leaf key not poisoned: %x", key)
+				}
+			}
+			break
+		}
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if len(retained) < 2 {
+		t.Fatal("expected more than one node")
+	}
+	for i, path := range retained {
+		if len(path) != 0 && path[0] != iter.PoisonByte {
+			t.Fatalf("path %x not poisoned", copied[i])
+		}
+		if bytes.Contains(copied[i], []byte{iter.PoisonByte}) {
+			t.Fatalf("copy %x was poisoned", copied[i])
+		}
+	}
+}
+
+func TestTraverseWithPoisoning(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var (
+		paths [][]byte
+		mu    sync.Mutex
+	)
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			mu.Lock()
+			paths = append(paths, it.Path()) // retained without copying
+			mu.Unlock()
+		}
+		return it.Error()
+	}
+	err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, visit,
+		iter.WithPoisoning(), iter.WithStrictCheck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	poisoned := 0
+	for _, path := range paths {
+		if len(path) != 0 && path[0] == iter.PoisonByte {
+			poisoned++
+		}
+	}
+	if poisoned == 0 || poisoned != len(paths)-1 {
+		t.Fatalf("expected all but the root path poisoned, got %d of %d", poisoned, len(paths))
+	}
+}
@@ -0,0 +1,184 @@
+package iterator
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// PrefetchIterator is a NodeIterator which reads ahead of its consumer: a background goroutine
+// advances the wrapped iterator and resolves the nodes it reaches, including the blobs of hashed
+// nodes, while the consumer processes the current one.
+//
+// The read-ahead always descends, so skipping a subtrie with Next(false) discards its prefetched
+// nodes rather than avoiding their reads. LeafProof is not supported and returns nil.
+type PrefetchIterator struct {
+	trie.NodeIterator
+	depth int
+
+	results <-chan NodeResult
+	errs    <-chan error
+	cancel  context.CancelFunc
+
+	cur  *NodeResult
+	done bool
+	err  error
+}
+
+// NewPrefetchIterator wraps an iterator to read up to `depth` nodes ahead of the consumer. The
+// read-ahead starts with the first call to Next, which is when ownership of the wrapped iterator
+// passes to the prefetcher; until then, its methods (e.g. Path and AddResolver) are available.
+// Close must be called if the iterator is not exhausted, to stop the read-ahead, unless it is a bin
+// of Traverse, which closes it.
+func NewPrefetchIterator(it trie.NodeIterator, depth int) *PrefetchIterator {
+	return &PrefetchIterator{NodeIterator: it, depth: depth}
+}
+
+func (it *PrefetchIterator) Next(descend bool) bool {
+	if it.done {
+		return false
+	}
+	if it.results == nil {
+		var ctx context.Context
+		ctx, it.cancel = context.WithCancel(context.Background())
+		it.results, it.errs = Stream(ctx, it.NodeIterator, it.depth)
+	}
+	skip := !descend && it.cur != nil
+	for res := range it.results {
+		// the descendants of a skipped node immediately follow it
+		if skip && len(res.Path) > len(it.cur.Path) && bytes.HasPrefix(res.Path, it.cur.Path) {
+			continue
+		}
+		it.cur = &res
+		return true
+	}
+	it.finish(<-it.errs)
+	return false
+}
+
+// AddResolver adds a resolver to the wrapped iterator. It panics once prefetching has started, as the
+// wrapped iterator then belongs to the prefetcher; AddResolver (the function) returns an error
+// instead.
+func (it *PrefetchIterator) AddResolver(resolver trie.NodeResolver) {
+	if it.started() {
+		panic("AddResolver after prefetching started")
+	}
+	it.NodeIterator.AddResolver(resolver)
+}
+
+// Unwrap returns the wrapped iterator, which runs ahead of this one once prefetching starts.
+func (it *PrefetchIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+// Close stops the read-ahead and waits for it to release the wrapped iterator. The iterator is
+// then exhausted, with no error, but stays at its current node, so that e.g. a tracker wrapping it
+// still saves its position.
+func (it *PrefetchIterator) Close() {
+	if it.done {
+		return
+	}
+	if it.results != nil {
+		it.cancel()
+		for range it.results {
+		}
+		<-it.errs
+	}
+	cur := it.cur
+	it.finish(nil)
+	it.cur = cur
+}
+
+func (it *PrefetchIterator) finish(err error) {
+	if it.cancel != nil {
+		it.cancel()
+	}
+	it.done, it.err, it.cur = true, err, nil
+}
+
+func (it *PrefetchIterator) started() bool {
+	return it.results != nil || it.done
+}
+
+func (it *PrefetchIterator) Error() error {
+	if !it.started() {
+		return it.NodeIterator.Error()
+	}
+	return it.err
+}
+
+func (it *PrefetchIterator) Hash() common.Hash {
+	if !it.started() {
+		return it.NodeIterator.Hash()
+	}
+	if it.cur == nil {
+		return common.Hash{}
+	}
+	return it.cur.Hash
+}
+
+func (it *PrefetchIterator) Parent() common.Hash {
+	if !it.started() {
+		return it.NodeIterator.Parent()
+	}
+	if it.cur == nil {
+		return common.Hash{}
+	}
+	return it.cur.Parent
+}
+
+func (it *PrefetchIterator) Path() []byte {
+	if !it.started() {
+		return it.NodeIterator.Path()
+	}
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.Path
+}
+
+func (it *PrefetchIterator) NodeBlob() []byte {
+	if !it.started() {
+		return it.NodeIterator.NodeBlob()
+	}
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.NodeBlob
+}
+
+func (it *PrefetchIterator) Leaf() bool {
+	if !it.started() {
+		return it.NodeIterator.Leaf()
+	}
+	return it.cur != nil && it.cur.Leaf
+}
+
+func (it *PrefetchIterator) LeafKey() []byte {
+	if !it.started() {
+		return it.NodeIterator.LeafKey()
+	}
+	if it.cur == nil || !it.cur.Leaf {
+		panic("not at leaf")
+	}
+	return it.cur.LeafKey
+}
+
+func (it *PrefetchIterator) LeafBlob() []byte {
+	if !it.started() {
+		return it.NodeIterator.LeafBlob()
+	}
+	if it.cur == nil || !it.cur.Leaf {
+		panic("not at leaf")
+	}
+	return it.cur.LeafBlob
+}
+
+func (it *PrefetchIterator) LeafProof() [][]byte {
+	if !it.started() {
+		return it.NodeIterator.LeafProof()
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestPrefetchIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	open := func() trie.NodeIterator {
+		it, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+	// compares the nodes of a prefetching iterator with those of a plain one, given the same
+	// descend decisions
+	compare := func(t *testing.T, descend func(trie.NodeIterator) bool) {
+		expected, actual := open(), iter.NewPrefetchIterator(open(), 8)
+		count := 0
+		for next := true; ; count++ {
+			more := expected.Next(next)
+			if actual.Next(next) != more {
+				t.Fatalf("iterators ended at different nodes, after %d", count)
+			}
+			if !more {
+				break
+			}
+			if !bytes.Equal(expected.Path(), actual.Path()) || expected.Hash() != actual.Hash() ||
+				expected.Parent() != actual.Parent() || expected.Leaf() != actual.Leaf() ||
+				!bytes.Equal(expected.NodeBlob(), actual.NodeBlob()) {
+				t.Fatalf("wrong node at %x: got %x", expected.Path(), actual.Path())
+			}
+			if expected.Leaf() && (!bytes.Equal(expected.LeafKey(), actual.LeafKey()) ||
+				!bytes.Equal(expected.LeafBlob(), actual.LeafBlob())) {
+				t.Fatalf("wrong leaf at %x", expected.Path())
+			}
+			next = descend(expected)
+		}
+		if actual.Error() != nil {
+			t.Fatal(actual.Error())
+		}
+	}
+
+	t.Run("full", func(t *testing.T) {
+		compare(t, func(trie.NodeIterator) bool { return true })
+	})
+	t.Run("skip subtries", func(t *testing.T) {
+		compare(t, func(it trie.NodeIterator) bool { return len(it.Path()) < 2 || it.Path()[1]%2 == 0 })
+	})
+	t.Run("skip root", func(t *testing.T) {
+		compare(t, func(trie.NodeIterator) bool { return false })
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		// the read-ahead starts on the first Next, so bounds see the start path
+		nodeit, err := tree.NodeIterator(iter.HexToKeyBytes([]byte{4, 0}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		startPath := append([]byte(nil), nodeit.Path()...)
+		prefetch := iter.NewPrefetchIterator(nodeit, 8)
+		// stopping at the bound leaves the read-ahead running
+		defer prefetch.Close()
+		it := iter.NewPrefixBoundIterator(prefetch, []byte{8})
+		if start, _ := it.Bounds(); !bytes.Equal(start, startPath) {
+			t.Fatalf("expected start path %x, got %x", startPath, start)
+		}
+		for it.Next(true) {
+			if bytes.Compare(it.Path(), []byte{8}) > 0 {
+				t.Fatalf("iterator passed bound at %x", it.Path())
+			}
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		it := iter.NewPrefetchIterator(open(), 1)
+		if !it.Next(true) {
+			t.Fatal("expected a node")
+		}
+		it.Close()
+		if it.Next(true) {
+			t.Fatal("expected closed iterator to be exhausted")
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+		it.Close()
+	})
+}
+
+// checkGoroutines fails the test unless the number of goroutines returns to what it was when
+// called, once the returned function is.
+func checkGoroutines(t *testing.T) func() {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<20)
+				t.Fatalf("leaked %d goroutines:\n%s", runtime.NumGoroutine()-before, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestPrefetchIteratorLeak(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	t.Run("close", func(t *testing.T) {
+		check := checkGoroutines(t)
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := iter.NewPrefetchIterator(nodeit, 4)
+		for i := 0; i < 10 && it.Next(true); i++ {
+		}
+		path := append([]byte(nil), it.Path()...)
+		iter.Close(iter.NewPrefixBoundIterator(it, nil))
+		check()
+		if !bytes.Equal(path, it.Path()) {
+			t.Fatalf("expected closed iterator to stay at %x, got %x", path, it.Path())
+		}
+	})
+
+	// bins which stop early, as the traversal fails, are closed by Traverse
+	t.Run("traverse", func(t *testing.T) {
+		check := checkGoroutines(t)
+		makeIterator := func(start []byte) (trie.NodeIterator, error) {
+			it, err := tree.NodeIterator(start)
+			if err != nil {
+				return nil, err
+			}
+			return iter.NewPrefetchIterator(it, 4), nil
+		}
+		failed := errors.New("failed")
+		err := iter.Traverse(context.Background(), makeIterator, 16, 4, func(it trie.NodeIterator) error {
+			for i := 0; i < 10 && it.Next(true); i++ {
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Fatalf("expected traversal to fail, got %v", err)
+		}
+		check()
+	})
+}
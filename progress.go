@@ -0,0 +1,41 @@
+package iterator
+
+// Progress returns the position of a hex path within the trie's keyspace, as a fraction between 0
+// (the root) and 1 (the end of the keyspace). Paths are ordered as the nodes of a traversal are
+// visited, so this estimates how much of a full traversal is complete. Only the leading 13 nibbles
+// are significant, which is the precision of a float64.
+func Progress(path []byte) float64 {
+	var pos, scale float64 = 0, 1
+	for i := 0; i < len(path) && i < 13; i++ {
+		if path[i] >= 16 { // terminator
+			break
+		}
+		scale /= 16
+		pos += float64(path[i]) * scale
+	}
+	return pos
+}
+
+// Progress estimates the fraction of the iterator's range which has been traversed, based on the
+// position of the current node between its start and end paths. It returns 1 once the iterator is
+// exhausted.
+func (it *PrefixBoundIterator) Progress() float64 {
+	if it.done {
+		return 1
+	}
+	start, end := Progress(it.StartPath), 1.0
+	if it.EndPath != nil {
+		end = Progress(it.EndPath)
+	}
+	if end <= start {
+		return 0
+	}
+	frac := (Progress(it.Path()) - start) / (end - start)
+	switch {
+	case frac < 0:
+		return 0
+	case frac > 1:
+		return 1
+	}
+	return frac
+}
@@ -0,0 +1,48 @@
+package iterator_test
+
+import (
+	"testing"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestProgress(t *testing.T) {
+	cases := []struct {
+		path     []byte
+		expected float64
+	}{
+		{nil, 0},
+		{[]byte{8}, 0.5},
+		{[]byte{8, 0, 16}, 0.5},
+		{[]byte{4, 8}, 0.28125},
+		{[]byte{0xf, 0xf}, 0.99609375},
+	}
+	for _, c := range cases {
+		if p := iter.Progress(c.path); p != c.expected {
+			t.Fatalf("progress of %x: expected %v, got %v", c.path, c.expected, p)
+		}
+	}
+
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	iters, err := iter.SubtrieIterators(tree.NodeIterator, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range iters {
+		it := it.(*iter.PrefixBoundIterator)
+		prev := it.Progress()
+		for it.Next(true) {
+			p := it.Progress()
+			if p < prev || p > 1 {
+				t.Fatalf("progress went from %v to %v at path %x", prev, p, it.Path())
+			}
+			prev = p
+		}
+		if it.Progress() != 1 {
+			t.Fatalf("expected progress 1 for exhausted iterator, got %v", it.Progress())
+		}
+	}
+}
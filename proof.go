@@ -0,0 +1,127 @@
+package iterator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// ErrUnlinkedProofNode is returned when a proof bundle holds a node which is not reachable from
+// the claimed root.
+var ErrUnlinkedProofNode = errors.New("proof node is not linked to root")
+
+// NewProofConstructor returns an IteratorConstructor over a trie view built from a bundle of
+// encoded trie nodes, such as the proofs from eth_getProof or a snap sync range. The bundle must
+// contain the root, and every node in it must be reachable from the root.
+//
+// Only the nodes of the bundle can be iterated: an iterator stops with a *trie.MissingNodeError on
+// reaching a node outside it, whose Path identifies the gap. Bound iterators to ranges covered by
+// the bundle (e.g. a subtrie from a range proof) to traverse them completely.
+func NewProofConstructor(root common.Hash, nodes [][]byte) (IteratorConstructor, error) {
+	db := rawdb.NewMemoryDatabase()
+	blobs := make(map[common.Hash][]byte, len(nodes))
+	for _, blob := range nodes {
+		hash := crypto.Keccak256Hash(blob)
+		blobs[hash] = blob
+		rawdb.WriteLegacyTrieNode(db, hash, blob)
+	}
+	if _, has := blobs[root]; !has {
+		return nil, fmt.Errorf("proof does not contain root %x", root)
+	}
+
+	// walk hash references from the root to find unlinked nodes
+	linked := map[common.Hash]struct{}{root: {}}
+	queue := []common.Hash{root}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		refs, err := nodeReferences(blobs[hash])
+		if err != nil {
+			return nil, fmt.Errorf("invalid proof node %x: %w", hash, err)
+		}
+		for _, ref := range refs {
+			if _, seen := linked[ref]; seen {
+				continue
+			}
+			if _, has := blobs[ref]; has {
+				linked[ref] = struct{}{}
+				queue = append(queue, ref)
+			}
+		}
+	}
+	for hash := range blobs {
+		if _, ok := linked[hash]; !ok {
+			return nil, fmt.Errorf("%w: %x", ErrUnlinkedProofNode, hash)
+		}
+	}
+
+	tdb := triedb.NewDatabase(db, triedb.HashDefaults)
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		tree, err := trie.New(trie.StateTrieID(root), tdb)
+		if err != nil {
+			return nil, err
+		}
+		return tree.NodeIterator(startKey)
+	}, nil
+}
+
+// nodeReferences returns the hashes of the child nodes referenced by an encoded trie node,
+// including those referenced from embedded nodes.
+func nodeReferences(blob []byte) ([]common.Hash, error) {
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return nil, err
+	}
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return nil, err
+	}
+	var children [][]byte
+	switch count {
+	case 2: // short node: a leaf's value is not a reference
+		key, rest, err := rlp.SplitString(elems)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) > 0 && key[0]&0x20 != 0 {
+			return nil, nil
+		}
+		children = append(children, rest)
+	case 17: // full node: the 17th element is a value
+		for i := 0; i < 16; i++ {
+			_, _, rest, err := rlp.Split(elems)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, elems[:len(elems)-len(rest)])
+			elems = rest
+		}
+	default:
+		return nil, fmt.Errorf("invalid number of list elements: %d", count)
+	}
+
+	var refs []common.Hash
+	for _, child := range children {
+		kind, content, _, err := rlp.Split(child)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case kind == rlp.List:
+			embedded, err := nodeReferences(child)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, embedded...)
+		case len(content) == common.HashLength:
+			refs = append(refs, common.BytesToHash(content))
+		}
+	}
+	return refs, nil
+}
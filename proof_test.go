@@ -0,0 +1,136 @@
+package iterator_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+func TestProofConstructor(t *testing.T) {
+	const leaves = 200
+	// short keys and values, so that leaves are embedded in their parents
+	tree, _ := committedTrie(t, leaves, func(i int) ([]byte, []byte) {
+		return []byte{byte(i)}, []byte{byte(i)}
+	})
+	root := tree.Hash()
+
+	var all [][]byte
+	nodeit := tree.MustNodeIterator(nil)
+	for nodeit.Next(true) {
+		if nodeit.Hash() != (common.Hash{}) {
+			all = append(all, nodeit.NodeBlob())
+		}
+	}
+
+	t.Run("complete bundle", func(t *testing.T) {
+		makeIterator, err := iter.NewProofConstructor(root, all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iters, err := iter.SubtrieIterators(makeIterator, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for _, it := range iters {
+			for it.Next(true) {
+				if it.Leaf() {
+					count++
+				}
+			}
+			if it.Error() != nil {
+				t.Fatal(it.Error())
+			}
+		}
+		if count != leaves {
+			t.Fatalf("expected %d leaves, got %d", leaves, count)
+		}
+	})
+
+	t.Run("single key proof", func(t *testing.T) {
+		key := []byte{7}
+		proofdb := memorydb.New()
+		if err := tree.Prove(key, proofdb); err != nil {
+			t.Fatal(err)
+		}
+		var proof [][]byte
+		dbit := proofdb.NewIterator(nil, nil)
+		for dbit.Next() {
+			proof = append(proof, common.CopyBytes(dbit.Value()))
+		}
+		dbit.Release()
+
+		makeIterator, err := iter.NewProofConstructor(root, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := makeIterator(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the proven leaf is reachable, and iteration stops at the first node outside the proof
+		found := false
+		for it.Next(true) {
+			if it.Leaf() && bytes.Equal(it.LeafKey(), key) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("proven leaf %x wasn't iterated", key)
+		}
+		var missing *trie.MissingNodeError
+		if !errors.As(it.Error(), &missing) {
+			t.Fatalf("expected MissingNodeError, got %v", it.Error())
+		}
+	})
+
+	t.Run("invalid bundles", func(t *testing.T) {
+		if _, err := iter.NewProofConstructor(root, all[1:]); err == nil {
+			t.Fatal("expected error for bundle without root")
+		}
+		other, _ := committedTrie(t, 1, func(int) ([]byte, []byte) {
+			return crypto.Keccak256([]byte("other")), bytes.Repeat([]byte{1}, 40)
+		})
+		otherit := other.MustNodeIterator(nil)
+		otherit.Next(true)
+		unlinked := append(all[:len(all):len(all)], otherit.NodeBlob())
+		if _, err := iter.NewProofConstructor(root, unlinked); !errors.Is(err, iter.ErrUnlinkedProofNode) {
+			t.Fatalf("expected ErrUnlinkedProofNode, got %v", err)
+		}
+	})
+}
+
+// committedTrie builds a trie holding n leaves and commits it to an in-memory database.
+func committedTrie(t *testing.T, n int, leaf func(int) ([]byte, []byte)) (*trie.Trie, *triedb.Database) {
+	db := triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil)
+	tree := trie.NewEmpty(db)
+	for i := 0; i < n; i++ {
+		tree.MustUpdate(leaf(i))
+	}
+	root, nodes, err := tree.Commit(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(root, types.EmptyRootHash, 0, trienode.NewWithNodeSet(nodes), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Commit(root, false); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = trie.New(trie.StateTrieID(root), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, db
+}
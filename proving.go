@@ -0,0 +1,119 @@
+package iterator
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ProvingIterator is a NodeIterator which keeps the blobs of the hashed nodes on the path from the
+// root to its current node, so that the proof of every leaf can be produced as the trie is
+// traversed, without proving each key from the root again. Unlike NodeIterator.LeafProof, this
+// works through any wrapper which yields every node, e.g. a PrefetchIterator, so it should be the
+// outermost of those, and within any FilterIterator.
+//
+// An iterator which starts below the root has not yielded the nodes above its start, so they are
+// read with the constructor passed to NewProvingIterator before its first node.
+type ProvingIterator struct {
+	trie.NodeIterator
+	makeIterator IteratorConstructor
+
+	stack   []provingNode // hashed nodes from the root to the current node
+	started bool
+	err     error
+}
+
+type provingNode struct {
+	path, blob []byte
+}
+
+// NewProvingIterator returns an iterator which produces the proofs of the leaves of `it`.
+// makeIterator is used to read the nodes above the iterator's first node, and may be nil if it
+// starts at the root.
+func NewProvingIterator(it trie.NodeIterator, makeIterator IteratorConstructor) *ProvingIterator {
+	return &ProvingIterator{NodeIterator: it, makeIterator: makeIterator}
+}
+
+// Next moves to the next node, tracking the nodes on its path.
+func (it *ProvingIterator) Next(descend bool) bool {
+	if it.err != nil || !it.NodeIterator.Next(descend) {
+		return false
+	}
+	path := it.Path()
+	if !it.started {
+		it.started = true
+		if len(path) != 0 {
+			if it.err = it.seed(path); it.err != nil {
+				return false
+			}
+		}
+	}
+	// the stack holds ancestors of the new node only
+	for n := len(it.stack); n > 0 && !bytes.HasPrefix(path, it.stack[n-1].path); n-- {
+		it.stack = it.stack[:n-1]
+	}
+	if it.Hash() != (common.Hash{}) {
+		it.stack = append(it.stack, provingNode{
+			path: common.CopyBytes(path),
+			blob: common.CopyBytes(it.NodeBlob()),
+		})
+	}
+	return true
+}
+
+// seed pushes the hashed nodes above the given path, descending from the root only into its
+// ancestors.
+func (it *ProvingIterator) seed(path []byte) error {
+	if it.makeIterator == nil {
+		return unsupported(it.NodeIterator, "proofs of an iterator starting below the root")
+	}
+	root, err := it.makeIterator(nil)
+	if err != nil {
+		return err
+	}
+	for descend := true; root.Next(descend); {
+		at := root.Path()
+		if bytes.Compare(at, path) >= 0 && !bytes.HasPrefix(path, at) {
+			break
+		}
+		if len(at) == len(path) {
+			break
+		}
+		descend = bytes.HasPrefix(path, at)
+		if descend && root.Hash() != (common.Hash{}) {
+			it.stack = append(it.stack, provingNode{
+				path: common.CopyBytes(at),
+				blob: common.CopyBytes(root.NodeBlob()),
+			})
+		}
+	}
+	return root.Error()
+}
+
+// LeafProof returns the proof of the current leaf: the blobs of the hashed nodes from the root to
+// the node containing it, as NodeIterator.LeafProof does. It panics if the iterator is not at a
+// leaf.
+func (it *ProvingIterator) LeafProof() [][]byte {
+	if !it.Leaf() {
+		panic("not at leaf")
+	}
+	proof := make([][]byte, len(it.stack))
+	for i, node := range it.stack {
+		proof[i] = node.blob
+	}
+	return proof
+}
+
+// Error returns the error of the wrapped iterator, or of reading the nodes above its start.
+func (it *ProvingIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *ProvingIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
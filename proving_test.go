@@ -0,0 +1,103 @@
+package iterator_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestProvingIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	// reference proofs from the unwrapped iterator
+	expected := map[string][][]byte{}
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+		if it.Leaf() {
+			expected[string(it.LeafKey())] = it.LeafProof()
+		}
+	}
+
+	check := func(t *testing.T, its []trie.NodeIterator) {
+		leaves := 0
+		for _, it := range its {
+			for it.Next(true) {
+				if !it.Leaf() {
+					continue
+				}
+				leaves++
+				proof, err := iter.LeafProof(it)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !equalProofs(proof, expected[string(it.LeafKey())]) {
+					t.Fatalf("wrong proof for leaf %x", it.LeafKey())
+				}
+				db := rawdb.NewMemoryDatabase()
+				for _, node := range proof {
+					db.Put(crypto.Keccak256(node), node)
+				}
+				value, err := trie.VerifyProof(tree.Hash(), it.LeafKey(), db)
+				if err != nil || !bytes.Equal(value, it.LeafBlob()) {
+					t.Fatalf("proof of %x does not verify: %v", it.LeafKey(), err)
+				}
+			}
+			if err := it.Error(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if leaves != len(expected) {
+			t.Fatalf("expected %d leaves, got %d", len(expected), leaves)
+		}
+	}
+
+	t.Run("from root", func(t *testing.T) {
+		it, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, []trie.NodeIterator{iter.NewProvingIterator(it, nil)})
+	})
+	// bins start below the root, and a prefetching iterator has no proofs of its own
+	t.Run("prefetched bins", func(t *testing.T) {
+		bins, err := iter.SubtrieIteratorsDedup(tree.NodeIterator, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, bin := range bins {
+			bins[i] = iter.NewProvingIterator(iter.NewPrefetchIterator(bin, 8), tree.NodeIterator)
+		}
+		check(t, bins)
+	})
+
+	bins, err := iter.SubtrieIterators(tree.NodeIterator, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unseeded := iter.NewProvingIterator(bins[1], nil)
+	if unseeded.Next(true) || unseeded.Error() == nil {
+		t.Fatal("expected iterator below the root to fail without a constructor")
+	}
+}
+
+func equalProofs(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
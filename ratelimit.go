@@ -0,0 +1,69 @@
+package iterator
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedIterator is a NodeIterator which waits on a rate limiter before each call to Next,
+// e.g. to keep a traversal on a production node from starving block processing of disk I/O. A
+// limiter may be shared by many iterators to limit their total rate.
+type RateLimitedIterator struct {
+	trie.NodeIterator
+	ctx     context.Context
+	limiter *rate.Limiter
+	err     error
+}
+
+// NewRateLimitedIterator throttles an iterator with a limiter. The iterator stops with the
+// limiter's error if it can't allow any events, e.g. if its burst is zero. If it wraps a
+// ContextIterator, waits on the limiter end once that iterator's context is done, and the iterator
+// stops with the context's error, so a traversal can be cancelled while throttled:
+//
+//	it = NewRateLimitedIterator(NewContextIterator(ctx, it), limiter)
+//
+// As with ContextIterator, this should be applied beneath any PrefixBoundIterator (e.g. in the
+// IteratorConstructor passed to SubtrieIterators), so that a tracker can still see the bounds.
+func NewRateLimitedIterator(it trie.NodeIterator, limiter *rate.Limiter) *RateLimitedIterator {
+	ctx := context.Background()
+	for inner := it; inner != nil; {
+		if ctxit, ok := inner.(*ContextIterator); ok {
+			ctx = ctxit.ctx
+			break
+		}
+		wrapper, ok := inner.(Wrapper)
+		if !ok {
+			break
+		}
+		inner = wrapper.Unwrap()
+	}
+	return &RateLimitedIterator{NodeIterator: it, ctx: ctx, limiter: limiter}
+}
+
+func (it *RateLimitedIterator) Next(descend bool) bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.limiter.Wait(it.ctx); err != nil {
+		// a wait which would outlast the context's deadline fails before it is done
+		if it.err = it.ctx.Err(); it.err == nil {
+			it.err = err
+		}
+		return false
+	}
+	return it.NodeIterator.Next(descend)
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *RateLimitedIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+func (it *RateLimitedIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
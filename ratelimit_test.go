@@ -0,0 +1,82 @@
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/time/rate"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestRateLimitedIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	// bins share one limiter, beneath their bounds
+	const interval = time.Millisecond
+	limiter := rate.NewLimiter(rate.Every(interval), 1)
+	iters, err := iter.SubtrieIterators(func(startKey []byte) (trie.NodeIterator, error) {
+		it, err := tree.NodeIterator(startKey)
+		if err != nil {
+			return nil, err
+		}
+		return iter.NewRateLimitedIterator(it, limiter), nil
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	count := 0
+	for _, it := range iters {
+		for it.Next(true) {
+			count++
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+	}
+	// bins may overlap by one node
+	if count < len(internal.FixtureNodePaths) {
+		t.Fatalf("expected at least %d nodes, got %d", len(internal.FixtureNodePaths), count)
+	}
+	if elapsed, min := time.Since(start), time.Duration(count-1)*interval; elapsed < min {
+		t.Fatalf("traversal took %v, expected at least %v", elapsed, min)
+	}
+
+	// a limiter which allows no events stops the iterator
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := iter.NewRateLimitedIterator(nodeit, rate.NewLimiter(rate.Every(interval), 0))
+	if it.Next(true) || it.Error() == nil {
+		t.Fatal("expected iterator to fail with zero burst")
+	}
+
+	// cancelling the context of a wrapped ContextIterator ends a wait on the limiter
+	nodeit, err = tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	it = iter.NewRateLimitedIterator(iter.NewContextIterator(ctx, nodeit), rate.NewLimiter(rate.Every(time.Hour), 1))
+	if !it.Next(true) {
+		t.Fatal(it.Error())
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	done := make(chan bool)
+	go func() { done <- it.Next(true) }()
+	select {
+	case ok := <-done:
+		if ok || !errors.Is(it.Error(), context.Canceled) {
+			t.Fatalf("expected iterator to stop with context.Canceled, got %v", it.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled iterator is still waiting on the limiter")
+	}
+}
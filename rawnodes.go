@@ -0,0 +1,114 @@
+package iterator
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"golang.org/x/sync/errgroup"
+)
+
+// RawNodeVisitor consumes a node read by TraverseRawNodes. It is called concurrently from the
+// workers, and the blob follows the traversal's buffer policy.
+type RawNodeVisitor = func(hash common.Hash, blob []byte) error
+
+// TraverseRawNodes reads every trie node stored under the hash scheme, i.e. keyed by its hash,
+// straight from the key space of a database, rather than structurally with NodeIterators. The key
+// space is divided into `nbins` ranges, each scanned by one of `workers` goroutines, so nodes are
+// visited in no particular order, and nodes of every trie in the database are visited once,
+// including those no longer referenced by any root. This is much faster when the structure is not
+// needed, e.g. for exporting a whole database.
+//
+// Keys of 32 bytes are only yielded if they are the hash of their value, which also matches
+// contract code stored under its hash by old versions of geth. Nodes stored under the path scheme
+// are not read. Of the options, only WithMonitor and WithBufferPolicy apply.
+func TraverseRawNodes(
+	ctx context.Context, db ethdb.Iteratee, nbins, workers uint, visit RawNodeVisitor, opts ...TraverseOption,
+) error {
+	conf := newTraverseConfig(opts)
+	if nbins == 0 || nbins > 1<<16 {
+		return fmt.Errorf("invalid bin count: %d", nbins)
+	}
+	if workers == 0 {
+		return fmt.Errorf("invalid worker count: %d", workers)
+	}
+	group, ctx := errgroup.WithContext(ctx)
+	group.SetLimit(int(workers))
+	monitor := conf.monitor
+	if monitor != nil {
+		monitor.queued.Add(int64(nbins))
+	}
+	for i := uint(0); i < nbins; i++ {
+		i := i
+		start, end := rawBinBound(i, nbins), rawBinBound(i+1, nbins)
+		group.Go(func() error {
+			monitor.start()
+			err := scanRawNodes(ctx, db, start, end, visit, conf.buffers)
+			monitor.finish(err)
+			if err != nil {
+				return fmt.Errorf("bin %d: %w", i, err)
+			}
+			return nil
+		})
+	}
+	return group.Wait()
+}
+
+// rawBinBound returns the first key of the i'th of n ranges of the key space, or nil for the end
+// of the last.
+func rawBinBound(i, n uint) []byte {
+	if i == n {
+		return nil
+	}
+	return binary.BigEndian.AppendUint16(nil, uint16(i<<16/n))
+}
+
+// rawCheckInterval is the number of keys scanned between checks of the context.
+const rawCheckInterval = 1024
+
+func scanRawNodes(
+	ctx context.Context, db ethdb.Iteratee, start, end []byte, visit RawNodeVisitor, policy BufferPolicy,
+) error {
+	it := db.NewIterator(nil, start)
+	defer it.Release()
+
+	hasher := crypto.NewKeccakState()
+	var hash common.Hash
+	for n := 0; it.Next(); n++ {
+		if n%rawCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		key := it.Key()
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		if len(key) != common.HashLength {
+			continue
+		}
+		blob := it.Value()
+		hasher.Reset()
+		hasher.Write(blob)
+		hasher.Read(hash[:])
+		if !bytes.Equal(key, hash[:]) {
+			continue
+		}
+		if policy != BorrowBuffers {
+			blob = copyBuffer(blob)
+		}
+		if err := visit(hash, blob); err != nil {
+			return err
+		}
+		if policy == PoisonBuffers {
+			for i := range blob {
+				blob[i] = PoisonByte
+			}
+		}
+	}
+	return it.Error()
+}
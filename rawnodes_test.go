@@ -0,0 +1,72 @@
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestTraverseRawNodes(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	collect := func(nbins uint) map[common.Hash][]byte {
+		nodes := map[common.Hash][]byte{}
+		var mu sync.Mutex
+		err := iter.TraverseRawNodes(context.Background(), edb, nbins, 4, func(hash common.Hash, blob []byte) error {
+			if crypto.Keccak256Hash(blob) != hash {
+				t.Errorf("node %x has blob of hash %x", hash, crypto.Keccak256Hash(blob))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := nodes[hash]; ok {
+				t.Errorf("node %x visited twice", hash)
+			}
+			nodes[hash] = blob
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nodes
+	}
+
+	nodes := collect(1)
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+		if it.Hash() == (common.Hash{}) {
+			continue
+		}
+		if _, ok := nodes[it.Hash()]; !ok {
+			t.Fatalf("node %x at path %x not visited", it.Hash(), it.Path())
+		}
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	for _, nbins := range []uint{3, 16, 1000} {
+		if binned := collect(nbins); len(binned) != len(nodes) {
+			t.Fatalf("%d bins: expected %d nodes, got %d", nbins, len(nodes), len(binned))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = iter.TraverseRawNodes(ctx, edb, 4, 2, func(common.Hash, []byte) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if err := iter.TraverseRawNodes(ctx, edb, 0, 2, nil); err == nil {
+		t.Fatal("expected invalid bin count")
+	}
+}
@@ -0,0 +1,329 @@
+// Package record defines the records written by the outputs of a traversal, so that every output
+// shares one schema. The schema is defined as protobuf messages in record.proto, which the types
+// here mirror field for field; JSON names follow the protobuf JSON mapping, so records marshalled
+// with encoding/json can be read by protobuf consumers in other languages.
+//
+// A RunManifest carries the SchemaVersion of the records it describes. Readers should refuse a
+// manifest with a newer version than they know, with CheckVersion. It also lists the files the
+// records were written to with their SHA-256 digests, so that copies can be checked with Verify.
+package record
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// SchemaVersion is the version of the schema defined by this package.
+const SchemaVersion = 1
+
+// ErrSchemaVersion is returned for a manifest written with a newer schema than this package's.
+var ErrSchemaVersion = errors.New("unsupported record schema version")
+
+// ErrChecksum is returned when a chunk file does not match its digest.
+var ErrChecksum = errors.New("chunk checksum mismatch")
+
+// ErrNotLeaf is returned when a leaf record is made from an iterator which is not at a leaf.
+var ErrNotLeaf = errors.New("iterator not at leaf")
+
+// NodeRecord is a node of the state trie, or of a storage trie.
+type NodeRecord struct {
+	// Owner is the account hash owning the storage trie, or empty for the state trie.
+	Owner []byte `json:"owner,omitempty"`
+	// Path is the hex path of the node, one byte per nibble.
+	Path []byte `json:"path,omitempty"`
+	// Hash is the hash of the node, or empty for a node embedded in its parent.
+	Hash []byte `json:"hash,omitempty"`
+	Leaf bool   `json:"leaf,omitempty"`
+	// LeafKey is the key of a leaf.
+	LeafKey []byte `json:"leafKey,omitempty"`
+	// Seq is the position of the node in the output of its bin, if numbered.
+	Seq *Sequence `json:"seq,omitempty"`
+}
+
+// AccountRecord is an account leaf of the state trie.
+type AccountRecord struct {
+	Path []byte `json:"path,omitempty"`
+	// LeafKey is the hash of the account address.
+	LeafKey []byte `json:"leafKey,omitempty"`
+	Nonce   uint64 `json:"nonce,string,omitempty"`
+	// Balance is the big-endian balance in wei.
+	Balance     []byte `json:"balance,omitempty"`
+	StorageRoot []byte `json:"storageRoot,omitempty"`
+	CodeHash    []byte `json:"codeHash,omitempty"`
+	// Class is the kind of account, if it was classified, e.g. by package classify.
+	Class AccountClass `json:"class,omitempty"`
+	// Implementation is the address a proxy delegates its calls to.
+	Implementation []byte `json:"implementation,omitempty"`
+	// Deleted marks a tombstone, for an account of an earlier state which was deleted. Only its
+	// path and key are set.
+	Deleted bool `json:"deleted,omitempty"`
+	// Seq is the position of the leaf in the output of its bin, if numbered.
+	Seq *Sequence `json:"seq,omitempty"`
+}
+
+// AccountClass is the kind of an account, as judged from its code.
+type AccountClass int32
+
+const (
+	// ClassUnspecified is the class of an account which was not classified.
+	ClassUnspecified AccountClass = iota
+	// ClassEOA is an externally owned account, which has no code.
+	ClassEOA
+	// ClassContract is a contract of no more specific class.
+	ClassContract
+	// ClassERC20 is a contract which looks like an ERC-20 token.
+	ClassERC20
+	// ClassProxy is an EIP-1167 minimal proxy.
+	ClassProxy
+)
+
+var accountClassNames = []string{
+	"ACCOUNT_CLASS_UNSPECIFIED",
+	"ACCOUNT_CLASS_EOA",
+	"ACCOUNT_CLASS_CONTRACT",
+	"ACCOUNT_CLASS_ERC20",
+	"ACCOUNT_CLASS_PROXY",
+}
+
+// String returns the name of the class in the protobuf schema.
+func (c AccountClass) String() string {
+	if c >= 0 && int(c) < len(accountClassNames) {
+		return accountClassNames[c]
+	}
+	return fmt.Sprintf("%d", int32(c))
+}
+
+// MarshalJSON encodes the class by name, as the protobuf JSON mapping does for enums.
+func (c AccountClass) MarshalJSON() ([]byte, error) {
+	if c < 0 || int(c) >= len(accountClassNames) {
+		return json.Marshal(int32(c))
+	}
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON decodes a class from its name or number.
+func (c *AccountClass) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return json.Unmarshal(data, (*int32)(c))
+	}
+	for i, known := range accountClassNames {
+		if name == known {
+			*c = AccountClass(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown account class %q", name)
+}
+
+// StorageRecord is a slot leaf of a storage trie.
+type StorageRecord struct {
+	// Owner is the account hash owning the storage trie.
+	Owner []byte `json:"owner,omitempty"`
+	Path  []byte `json:"path,omitempty"`
+	// LeafKey is the hash of the slot key.
+	LeafKey []byte `json:"leafKey,omitempty"`
+	// Value is the slot's value, with its RLP encoding removed.
+	Value []byte `json:"value,omitempty"`
+	// Deleted marks a tombstone, for a slot of an earlier state which was cleared. Only its owner,
+	// path and key are set.
+	Deleted bool `json:"deleted,omitempty"`
+	// Seq is the position of the leaf in the output of its bin, if numbered.
+	Seq *Sequence `json:"seq,omitempty"`
+}
+
+// Sequence is the position of a record in the output of a traversal's bin, as numbered by a
+// tracker made with tracker.WithSequence. A restored bin yields the same nodes with the same
+// indexes, so a consumer can key records by the run ID and their sequence to ingest each once.
+type Sequence struct {
+	// Bin is the end path of the bin, one byte per nibble, or empty for the last bin.
+	Bin []byte `json:"bin,omitempty"`
+	// Index is the index of the node in the bin, counting from 1.
+	Index uint64 `json:"index,string,omitempty"`
+}
+
+// NewSequence returns the sequence of the iterator's current node, or nil if its nodes are not
+// numbered.
+func NewSequence(it trie.NodeIterator) *Sequence {
+	bin, index, err := iter.Sequence(it)
+	if err != nil {
+		return nil
+	}
+	return &Sequence{Bin: common.CopyBytes(bin), Index: index}
+}
+
+// RunManifest describes the run which wrote a set of records.
+type RunManifest struct {
+	SchemaVersion uint32 `json:"schemaVersion,omitempty"`
+	Root          []byte `json:"root,omitempty"`
+	BlockNumber   uint64 `json:"blockNumber,string,omitempty"`
+	Bins          uint32 `json:"bins,omitempty"`
+	// StartedAt and FinishedAt are unix times, in seconds.
+	StartedAt  int64  `json:"startedAt,string,omitempty"`
+	FinishedAt int64  `json:"finishedAt,string,omitempty"`
+	Nodes      uint64 `json:"nodes,string,omitempty"`
+	Leaves     uint64 `json:"leaves,string,omitempty"`
+	// Chunks are the files the records were written to.
+	Chunks []*ChunkDigest `json:"chunks,omitempty"`
+}
+
+// ChunkDigest is the size and SHA-256 digest of a file written by a run.
+type ChunkDigest struct {
+	// Path is the path of the file, relative to the manifest's directory unless absolute.
+	Path   string `json:"path,omitempty"`
+	Size   uint64 `json:"size,string,omitempty"`
+	Sha256 []byte `json:"sha256,omitempty"`
+}
+
+// NewNodeRecord returns a record of the iterator's current node, in the trie owned by owner (zero
+// for the state trie).
+func NewNodeRecord(owner common.Hash, it trie.NodeIterator) *NodeRecord {
+	rec := &NodeRecord{
+		Owner: ownerBytes(owner),
+		Path:  common.CopyBytes(it.Path()),
+		Leaf:  it.Leaf(),
+		Seq:   NewSequence(it),
+	}
+	if hash := it.Hash(); hash != (common.Hash{}) {
+		rec.Hash = hash.Bytes()
+	}
+	if rec.Leaf {
+		rec.LeafKey = common.CopyBytes(it.LeafKey())
+	}
+	return rec
+}
+
+// NewAccountRecord returns a record of the account at the iterator's current leaf.
+func NewAccountRecord(it trie.NodeIterator) (*AccountRecord, error) {
+	if !it.Leaf() {
+		return nil, fmt.Errorf("%w: %x", ErrNotLeaf, it.Path())
+	}
+	var account types.StateAccount
+	if err := rlp.DecodeBytes(it.LeafBlob(), &account); err != nil {
+		return nil, fmt.Errorf("failed to decode account at %x: %w", it.Path(), err)
+	}
+	rec := &AccountRecord{
+		Path:        common.CopyBytes(it.Path()),
+		LeafKey:     common.CopyBytes(it.LeafKey()),
+		Nonce:       account.Nonce,
+		StorageRoot: account.Root.Bytes(),
+		CodeHash:    common.CopyBytes(account.CodeHash),
+		Seq:         NewSequence(it),
+	}
+	if account.Balance != nil && !account.Balance.IsZero() {
+		rec.Balance = account.Balance.Bytes()
+	}
+	return rec, nil
+}
+
+// NewStorageRecord returns a record of the slot at the iterator's current leaf, in the storage
+// trie owned by owner.
+func NewStorageRecord(owner common.Hash, it trie.NodeIterator) (*StorageRecord, error) {
+	if !it.Leaf() {
+		return nil, fmt.Errorf("%w: %x", ErrNotLeaf, it.Path())
+	}
+	_, value, _, err := rlp.Split(it.LeafBlob())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode slot at %x: %w", it.Path(), err)
+	}
+	return &StorageRecord{
+		Owner:   ownerBytes(owner),
+		Path:    common.CopyBytes(it.Path()),
+		LeafKey: common.CopyBytes(it.LeafKey()),
+		Value:   common.CopyBytes(value),
+		Seq:     NewSequence(it),
+	}, nil
+}
+
+// NewAccountTombstone returns a tombstone of the account at the iterator's current leaf, e.g. one
+// yielded by an iterator from iter.NewDeletionConstructor.
+func NewAccountTombstone(it trie.NodeIterator) (*AccountRecord, error) {
+	if !it.Leaf() {
+		return nil, fmt.Errorf("%w: %x", ErrNotLeaf, it.Path())
+	}
+	return &AccountRecord{
+		Path:    common.CopyBytes(it.Path()),
+		LeafKey: common.CopyBytes(it.LeafKey()),
+		Deleted: true,
+		Seq:     NewSequence(it),
+	}, nil
+}
+
+// NewStorageTombstone returns a tombstone of the slot at the iterator's current leaf, in the
+// storage trie owned by owner.
+func NewStorageTombstone(owner common.Hash, it trie.NodeIterator) (*StorageRecord, error) {
+	if !it.Leaf() {
+		return nil, fmt.Errorf("%w: %x", ErrNotLeaf, it.Path())
+	}
+	return &StorageRecord{
+		Owner:   ownerBytes(owner),
+		Path:    common.CopyBytes(it.Path()),
+		LeafKey: common.CopyBytes(it.LeafKey()),
+		Deleted: true,
+		Seq:     NewSequence(it),
+	}, nil
+}
+
+// NewRunManifest returns a manifest of the current schema version, for a run over the state with
+// the given root.
+func NewRunManifest(root common.Hash, blockNumber uint64, bins uint) *RunManifest {
+	return &RunManifest{
+		SchemaVersion: SchemaVersion,
+		Root:          root.Bytes(),
+		BlockNumber:   blockNumber,
+		Bins:          uint32(bins),
+	}
+}
+
+// CheckVersion returns ErrSchemaVersion if the manifest was written with a newer schema than this
+// package's. Older versions can be read, since fields are only ever added.
+func (m *RunManifest) CheckVersion() error {
+	if m.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("%w: %d (supported: %d)", ErrSchemaVersion, m.SchemaVersion, SchemaVersion)
+	}
+	return nil
+}
+
+// NewChunkDigest returns the digest of the chunk file at path, whose contents are read from r.
+func NewChunkDigest(path string, r io.Reader) (*ChunkDigest, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", path, err)
+	}
+	return &ChunkDigest{Path: path, Size: uint64(n), Sha256: h.Sum(nil)}, nil
+}
+
+// Verify returns ErrChecksum if the contents of the chunk read from r do not match its size and
+// digest, e.g. if a copy of the file was truncated.
+func (c *ChunkDigest) Verify(r io.Reader) error {
+	got, err := NewChunkDigest(c.Path, r)
+	if err != nil {
+		return err
+	}
+	if got.Size != c.Size {
+		return fmt.Errorf("%w: %s has %d bytes, expected %d", ErrChecksum, c.Path, got.Size, c.Size)
+	}
+	if !bytes.Equal(got.Sha256, c.Sha256) {
+		return fmt.Errorf("%w: %s has SHA-256 %x, expected %x", ErrChecksum, c.Path, got.Sha256, c.Sha256)
+	}
+	return nil
+}
+
+func ownerBytes(owner common.Hash) []byte {
+	if owner == (common.Hash{}) {
+		return nil
+	}
+	return owner.Bytes()
+}
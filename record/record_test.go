@@ -0,0 +1,288 @@
+package record_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/record"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// The Go types must have the fields of their messages, in the same order.
+func TestSchema(t *testing.T) {
+	proto, err := os.ReadFile("record.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := regexp.MustCompile(`(?s)message (\w+) \{(.*?)\n\}`).FindAllSubmatch(proto, -1)
+	field := regexp.MustCompile(`(?m)^\s+(?:repeated )?\w+ (\w+) = \d+;`)
+	types := map[string]interface{}{
+		"NodeRecord":    record.NodeRecord{},
+		"AccountRecord": record.AccountRecord{},
+		"StorageRecord": record.StorageRecord{},
+		"RunManifest":   record.RunManifest{},
+		"ChunkDigest":   record.ChunkDigest{},
+		"Sequence":      record.Sequence{},
+	}
+	if len(messages) != len(types) {
+		t.Fatalf("expected %d messages, got %d", len(types), len(messages))
+	}
+	for _, msg := range messages {
+		typ := reflect.TypeOf(types[string(msg[1])])
+		if typ == nil {
+			t.Fatalf("no type for message %s", msg[1])
+		}
+		var fields, tags []string
+		for _, f := range field.FindAllSubmatch(msg[2], -1) {
+			fields = append(fields, lowerCamel(string(f[1])))
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			tags = append(tags, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+		}
+		if !reflect.DeepEqual(fields, tags) {
+			t.Errorf("%s: fields %v, expected %v", msg[1], tags, fields)
+		}
+	}
+}
+
+func lowerCamel(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func TestRecords(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes, accounts int
+	for it.Next(true) {
+		nodes++
+		node := record.NewNodeRecord(common.Hash{}, it)
+		if node.Owner != nil || string(node.Path) != string(it.Path()) {
+			t.Fatalf("wrong node record: %+v", node)
+		}
+		if !it.Leaf() {
+			if _, err := record.NewAccountRecord(it); !errors.Is(err, record.ErrNotLeaf) {
+				t.Fatalf("expected ErrNotLeaf, got %v", err)
+			}
+			continue
+		}
+		account, err := record.NewAccountRecord(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(account.StorageRoot) != common.HashLength || len(account.CodeHash) != common.HashLength {
+			t.Fatalf("wrong account record: %+v", account)
+		}
+		accounts++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if nodes != len(internal.FixtureNodePaths) || accounts != len(internal.FixtureLeafKeys) {
+		t.Fatalf("expected %d nodes and %d accounts, got %d and %d",
+			len(internal.FixtureNodePaths), len(internal.FixtureLeafKeys), nodes, accounts)
+	}
+
+	owner := common.HexToHash("0x01")
+	storage := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	value, _ := rlp.EncodeToBytes([]byte{0x2a})
+	storage.MustUpdate(common.HexToHash("0x02").Bytes(), value)
+	it, err = storage.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		slot, err := record.NewStorageRecord(owner, it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(slot.Value) != "\x2a" || common.BytesToHash(slot.Owner) != owner {
+			t.Fatalf("wrong storage record: %+v", slot)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	manifest := record.NewRunManifest(common.HexToHash("0x01"), 1000, 16)
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 64-bit integers are strings in protobuf JSON
+	if !strings.Contains(string(data), `"schemaVersion":1,`) || !strings.Contains(string(data), `"blockNumber":"1000"`) {
+		t.Fatalf("unexpected encoding: %s", data)
+	}
+	var decoded record.RunManifest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.CheckVersion(); err != nil {
+		t.Fatal(err)
+	}
+	decoded.SchemaVersion = record.SchemaVersion + 1
+	if err := decoded.CheckVersion(); !errors.Is(err, record.ErrSchemaVersion) {
+		t.Fatalf("expected ErrSchemaVersion, got %v", err)
+	}
+}
+
+func TestChunkDigest(t *testing.T) {
+	data := []byte("leaf\t00\t01\n")
+	chunk, err := record.NewChunkDigest("leaves.tsv", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Size != uint64(len(data)) || len(chunk.Sha256) != 32 {
+		t.Fatalf("wrong digest: %+v", chunk)
+	}
+	if err := chunk.Verify(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte{}, data...)
+	corrupt[0] ^= 1
+	for _, bad := range [][]byte{data[:len(data)-1], corrupt} {
+		if err := chunk.Verify(bytes.NewReader(bad)); !errors.Is(err, record.ErrChecksum) {
+			t.Fatalf("expected ErrChecksum, got %v", err)
+		}
+	}
+}
+
+// The names of the account classes must be those of the enum's values.
+func TestAccountClass(t *testing.T) {
+	proto, err := os.ReadFile("record.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enum := regexp.MustCompile(`(?s)enum AccountClass \{(.*?)\n\}`).FindSubmatch(proto)
+	if enum == nil {
+		t.Fatal("no AccountClass enum")
+	}
+	for _, value := range regexp.MustCompile(`(?m)^\s+(\w+) = (\d+);`).FindAllSubmatch(enum[1], -1) {
+		var class record.AccountClass
+		if err := json.Unmarshal([]byte(`"`+string(value[1])+`"`), &class); err != nil {
+			t.Fatal(err)
+		}
+		if class.String() != string(value[1]) || fmt.Sprint(int32(class)) != string(value[2]) {
+			t.Errorf("%s = %s decoded as %d", value[1], value[2], class)
+		}
+	}
+
+	data, err := json.Marshal(&record.AccountRecord{Class: record.ClassProxy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"class":"ACCOUNT_CLASS_PROXY"}` {
+		t.Fatalf("unexpected encoding: %s", data)
+	}
+	// numbers are accepted too, as by protobuf JSON parsers
+	var account record.AccountRecord
+	if err := json.Unmarshal([]byte(`{"class":3}`), &account); err != nil || account.Class != record.ClassERC20 {
+		t.Fatalf("decoded %v (%v)", account.Class, err)
+	}
+	if err := json.Unmarshal([]byte(`{"class":"ACCOUNT_CLASS_BOT"}`), &account); err == nil {
+		t.Fatal("expected unknown class to be refused")
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	tree := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	tree.MustUpdate(common.HexToHash("0x02").Bytes(), []byte{0x2a})
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := record.NewAccountTombstone(it); !errors.Is(err, record.ErrNotLeaf) {
+		t.Fatalf("expected ErrNotLeaf, got %v", err)
+	}
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		account, err := record.NewAccountTombstone(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slot, err := record.NewStorageTombstone(common.HexToHash("0x01"), it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !account.Deleted || !slot.Deleted || common.BytesToHash(slot.LeafKey) != common.HexToHash("0x02") {
+			t.Fatalf("wrong tombstones: %+v, %+v", account, slot)
+		}
+		data, err := json.Marshal(slot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(data), `,"deleted":true}`) || strings.Contains(string(data), "value") {
+			t.Fatalf("unexpected encoding: %s", data)
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	tr := tracker.New(filepath.Join(t.TempDir(), "recovery.csv"), 1, tracker.WithSequence())
+	defer tr.CloseAndSave()
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := tr.Tracked(iter.NewPrefixBoundIterator(nodeit, []byte{8}))
+	for index := uint64(1); it.Next(true); index++ {
+		node := record.NewNodeRecord(common.Hash{}, it)
+		if node.Seq == nil || !bytes.Equal(node.Seq.Bin, []byte{8}) || node.Seq.Index != index {
+			t.Fatalf("node %x: expected index %d of bin 08, got %+v", it.Path(), index, node.Seq)
+		}
+		if !it.Leaf() {
+			continue
+		}
+		account, err := record.NewAccountRecord(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(account.Seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf(`{"bin":"CA==","index":"%d"}`, index); string(data) != expected {
+			t.Fatalf("expected %s, got %s", expected, data)
+		}
+	}
+
+	// nodes of an iterator which doesn't number them have no sequence
+	if nodeit, err = tree.NodeIterator(nil); err != nil {
+		t.Fatal(err)
+	}
+	nodeit.Next(true)
+	if node := record.NewNodeRecord(common.Hash{}, nodeit); node.Seq != nil {
+		t.Fatalf("expected no sequence, got %+v", node.Seq)
+	}
+}
@@ -0,0 +1,30 @@
+package iterator
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// NewCachingResolver returns a read-through node resolver, which reads nodes of the given scheme
+// from db and keeps up to maxBytes of them in an LRU cache, keyed by hash. Shared by the iterators
+// of a traversal (see WithResolver), the nodes above the bins, which each iterator reads on its way
+// to its start key, are read once.
+//
+// Nodes which are not on disk, e.g. those of path scheme diff layers, are not resolved, so the
+// iterator reads them itself.
+func NewCachingResolver(db ethdb.KeyValueReader, scheme string, maxBytes uint64) trie.NodeResolver {
+	cache := lru.NewSizeConstrainedCache[common.Hash, []byte](maxBytes)
+	return func(owner common.Hash, path []byte, hash common.Hash) []byte {
+		if blob, ok := cache.Get(hash); ok {
+			return blob
+		}
+		blob := rawdb.ReadTrieNode(db, owner, path, hash, scheme)
+		if len(blob) != 0 {
+			cache.Add(hash, blob)
+		}
+		return blob
+	}
+}
@@ -0,0 +1,82 @@
+package iterator_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestCachingResolver(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var calls, resolved atomic.Int64
+	cached := iter.NewCachingResolver(edb, rawdb.HashScheme, 1<<20)
+	resolver := func(owner common.Hash, path []byte, hash common.Hash) []byte {
+		calls.Add(1)
+		blob := cached(owner, path, hash)
+		if blob != nil {
+			resolved.Add(1)
+			if crypto.Keccak256Hash(blob) != hash {
+				t.Errorf("resolved wrong node for %x", hash)
+			}
+		}
+		return blob
+	}
+
+	var paths [][]byte
+	var mu sync.Mutex
+	err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			mu.Lock()
+			paths = append(paths, append([]byte(nil), it.Path()...))
+			mu.Unlock()
+		}
+		return nil
+	}, iter.WithResolver(resolver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) < len(internal.FixtureNodePaths) {
+		t.Fatalf("expected at least %d paths, got %d", len(internal.FixtureNodePaths), len(paths))
+	}
+	if calls.Load() == 0 || resolved.Load() != calls.Load() {
+		t.Fatalf("expected every node to be resolved, got %d of %d", resolved.Load(), calls.Load())
+	}
+
+	// geth's composed iterators can't take a resolver, but this module's can
+	a, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	union, _ := trie.NewUnionIterator([]trie.NodeIterator{a, b})
+	if err := iter.AddResolver(union, resolver); err == nil {
+		t.Fatal("expected geth union iterator to be unsupported")
+	}
+	bounded, _ := iter.NewBoundedUnionIterator([]trie.NodeIterator{a, b}, nil)
+	if err := iter.AddResolver(bounded, resolver); err != nil {
+		t.Fatal(err)
+	}
+	before := calls.Load()
+	for bounded.Next(true) {
+	}
+	if calls.Load() == before {
+		t.Fatal("resolver not used by union iterator")
+	}
+	if err := bounded.Error(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,207 @@
+package iterator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// RetryPolicy configures the retries of a RetryIterator.
+type RetryPolicy struct {
+	// MaxRetries is the number of consecutive retries after which an error is returned, or
+	// negative to retry for ever.
+	MaxRetries int
+	// MinBackoff is the wait before the first retry, which doubles with each consecutive retry up
+	// to MaxBackoff.
+	MinBackoff, MaxBackoff time.Duration
+	// IsTransient decides which errors are retried. It defaults to IsTransientError.
+	IsTransient func(error) bool
+}
+
+// DefaultRetryPolicy retries an error up to 10 times over about 4 minutes.
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 10, MinBackoff: 500 * time.Millisecond, MaxBackoff: time.Minute}
+
+// IsTransientError returns whether an iterator error may be resolved by retrying, i.e. it is or
+// wraps an error reported as temporary or as a timeout (as by net.Error), or EAGAIN, EBUSY or
+// EINTR. A node missing from the database is not transient, unless reading it failed with such
+// an error, and neither is cancellation.
+func IsTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EINTR)
+}
+
+// RetryIterator is a NodeIterator which recovers from transient database errors, so that a long
+// traversal is not aborted by e.g. a database which is briefly unavailable. When the wrapped
+// iterator fails with a transient error, it waits, then opens a new iterator at the last node
+// yielded, and continues from the node after it, as the failed iterator would have.
+//
+// It should wrap the iterator of a trie directly, beneath any PrefixBoundIterator, which is done
+// by using the IteratorConstructor from NewRetryConstructor.
+type RetryIterator struct {
+	trie.NodeIterator
+	ctx          context.Context
+	makeIterator IteratorConstructor
+	policy       RetryPolicy
+
+	startKey  []byte
+	last      []byte // path of the last node yielded
+	started   bool
+	resolvers []trie.NodeResolver
+	retries   int
+	err       error
+}
+
+// NewRetryConstructor returns a constructor of iterators which retry the transient errors of
+// those from makeIterator, as configured by the policy. Waits between retries end when ctx is
+// cancelled.
+func NewRetryConstructor(ctx context.Context, makeIterator IteratorConstructor, policy RetryPolicy) IteratorConstructor {
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		return NewRetryIterator(ctx, makeIterator, startKey, policy)
+	}
+}
+
+// NewRetryIterator opens an iterator at startKey with makeIterator, which is used again to reopen
+// it after each transient error.
+func NewRetryIterator(
+	ctx context.Context, makeIterator IteratorConstructor, startKey []byte, policy RetryPolicy,
+) (*RetryIterator, error) {
+	it, err := makeIterator(startKey)
+	if err != nil {
+		return nil, err
+	}
+	if policy.IsTransient == nil {
+		policy.IsTransient = IsTransientError
+	}
+	return &RetryIterator{
+		NodeIterator: it,
+		ctx:          ctx,
+		makeIterator: makeIterator,
+		policy:       policy,
+		startKey:     append([]byte(nil), startKey...),
+	}, nil
+}
+
+func (it *RetryIterator) Next(descend bool) bool {
+	if it.err != nil {
+		return false
+	}
+	ok := it.NodeIterator.Next(descend)
+	for attempt := 0; !ok; attempt++ {
+		err := it.NodeIterator.Error()
+		if err == nil || !it.policy.IsTransient(err) {
+			return false
+		}
+		if it.policy.MaxRetries >= 0 && attempt >= it.policy.MaxRetries {
+			return false
+		}
+		if it.err = it.wait(attempt); it.err != nil {
+			return false
+		}
+		if ok, it.err = it.reopen(descend); it.err != nil {
+			return false
+		}
+	}
+	it.last = append(it.last[:0], it.Path()...)
+	it.started = true
+	return true
+}
+
+// wait sleeps before a retry, returning the context's error if it is cancelled.
+func (it *RetryIterator) wait(attempt int) error {
+	backoff := it.policy.MinBackoff
+	for i := 0; i < attempt && backoff < it.policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > it.policy.MaxBackoff {
+		backoff = it.policy.MaxBackoff
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-it.ctx.Done():
+		return it.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reopen replaces the iterator with one at the last node yielded, and moves it to the node which
+// follows when moving from there with the given descend flag.
+func (it *RetryIterator) reopen(descend bool) (bool, error) {
+	key := it.startKey
+	if it.started {
+		key = seekKey(it.last)
+	}
+	next, err := it.makeIterator(key)
+	if err != nil {
+		return false, err
+	}
+	for _, resolver := range it.resolvers {
+		next.AddResolver(resolver)
+	}
+	it.NodeIterator = next
+	it.retries++
+	if !it.started {
+		return next.Next(descend), nil
+	}
+	for into := true; next.Next(into); {
+		path := next.Path()
+		switch cmp := bytes.Compare(path, it.last); {
+		case cmp < 0:
+			// only the ancestors of the last node lead to it
+			into = bytes.HasPrefix(it.last, path)
+		case cmp == 0:
+			into = descend
+		case !descend && bytes.HasPrefix(path, it.last):
+			into = false
+		default:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// seekKey returns a start key which opens an iterator at or before the node at a path.
+func seekKey(path []byte) []byte {
+	if hasTerm(path) {
+		path = path[:len(path)-1]
+	}
+	return HexToKeyBytes(path[:len(path)&^1])
+}
+
+// AddResolver adds a resolver to the iterator, and to those opened by retries.
+func (it *RetryIterator) AddResolver(resolver trie.NodeResolver) {
+	it.resolvers = append(it.resolvers, resolver)
+	it.NodeIterator.AddResolver(resolver)
+}
+
+// Retries returns the number of times the iterator has been reopened.
+func (it *RetryIterator) Retries() int {
+	return it.retries
+}
+
+func (it *RetryIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
+
+// Unwrap returns the current wrapped iterator.
+func (it *RetryIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
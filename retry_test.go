@@ -0,0 +1,172 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+// flakyIterator fails with an error after yielding a number of nodes.
+type flakyIterator struct {
+	trie.NodeIterator
+	after  int
+	err    error
+	failed bool
+}
+
+func (it *flakyIterator) Next(descend bool) bool {
+	if it.after == 0 {
+		it.failed = true
+		return false
+	}
+	it.after--
+	return it.NodeIterator.Next(descend)
+}
+
+func (it *flakyIterator) Error() error {
+	if it.failed {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
+
+// flakyConstructor returns iterators which each fail after a few nodes, or none if `after` is
+// zero, the first `failures` times.
+func flakyConstructor(makeIterator iter.IteratorConstructor, failures, after int, err error) iter.IteratorConstructor {
+	opened := 0
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		it, ierr := makeIterator(startKey)
+		if ierr != nil || opened >= failures {
+			return it, ierr
+		}
+		opened++
+		if after != 0 {
+			after = 20 + opened%7
+		}
+		return &flakyIterator{NodeIterator: it, after: after, err: err}, nil
+	}
+}
+
+var errTransient = fmt.Errorf("read failed: %w", syscall.EAGAIN)
+
+var fastRetries = iter.RetryPolicy{MaxRetries: 3, MinBackoff: time.Microsecond, MaxBackoff: time.Millisecond}
+
+func TestRetryIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	// visit all nodes, skipping the subtries below a depth
+	walk := func(it trie.NodeIterator, depth int) ([]string, error) {
+		var paths []string
+		for descend := true; it.Next(descend); {
+			paths = append(paths, fmt.Sprintf("%x", it.Path()))
+			descend = len(it.Path()) < depth
+		}
+		return paths, it.Error()
+	}
+	for _, depth := range []int{64, 3} {
+		base, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := walk(base, depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		flaky := flakyConstructor(tree.NodeIterator, 50, 1, errTransient)
+		it, err := iter.NewRetryIterator(context.Background(), flaky, nil, fastRetries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths, err := walk(it, depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.Retries() < 10 {
+			t.Fatalf("expected retries, got %d", it.Retries())
+		}
+		if fmt.Sprint(paths) != fmt.Sprint(expected) {
+			t.Fatalf("depth %d: expected %d nodes, got %d", depth, len(expected), len(paths))
+		}
+	}
+
+	// errors which are not transient, or persist, are returned
+	errFatal := errors.New("corrupt")
+	for _, test := range []struct {
+		failures int
+		err      error
+	}{{1, errFatal}, {100, errTransient}} {
+		it, err := iter.NewRetryIterator(context.Background(),
+			flakyConstructor(tree.NodeIterator, test.failures, 0, test.err), nil, fastRetries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.Next(true) {
+		}
+		if !errors.Is(it.Error(), test.err) {
+			t.Fatalf("expected %v, got %v", test.err, it.Error())
+		}
+		if test.err == errFatal && it.Retries() != 0 || test.err == errTransient && it.Retries() != 3 {
+			t.Fatalf("%v: unexpected retry count %d", test.err, it.Retries())
+		}
+	}
+
+	// waits are cancelled with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	it, err := iter.NewRetryIterator(ctx, flakyConstructor(tree.NodeIterator, 1, 0, errTransient), nil,
+		iter.RetryPolicy{MaxRetries: -1, MinBackoff: time.Hour, MaxBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+	}
+	if !errors.Is(it.Error(), context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", it.Error())
+	}
+}
+
+func TestRetryConstructor(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	// every bin fails, and is reopened within its bounds
+	var leaves [][]byte
+	flaky := flakyConstructor(tree.NodeIterator, 200, 1, errTransient)
+	makeIterator := iter.NewRetryConstructor(context.Background(), flaky, fastRetries)
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			if it.Leaf() {
+				leaves = append(leaves, common.CopyBytes(it.LeafKey()))
+			}
+		}
+		return it.Error()
+	}
+	if err := iter.Traverse(context.Background(), makeIterator, 16, 1, visit, iter.WithStrictCheck()); err != nil {
+		t.Fatal(err)
+	}
+	if len(leaves) != len(internal.FixtureLeafKeys) {
+		t.Fatalf("expected %d leaves, got %d", len(internal.FixtureLeafKeys), len(leaves))
+	}
+	for i := 1; i < len(leaves); i++ {
+		if bytes.Compare(leaves[i-1], leaves[i]) >= 0 {
+			t.Fatalf("leaf %x out of order", leaves[i])
+		}
+	}
+
+	if iter.IsTransientError(context.Canceled) || iter.IsTransientError(&trie.MissingNodeError{}) ||
+		!iter.IsTransientError(errTransient) {
+		t.Fatal("unexpected transient errors")
+	}
+}
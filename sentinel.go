@@ -0,0 +1,118 @@
+package iterator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ErrTrieMutated is returned when the backing data of a trie changes while it is being traversed.
+var ErrTrieMutated = errors.New("trie was modified during traversal")
+
+// ErrMissingRoot is returned by NewSentinelIterator when the root node of the trie is not stored,
+// so changes to it could not be detected.
+var ErrMissingRoot = errors.New("trie root node is missing")
+
+// RootReader reads the hash of the root node currently stored for the trie being traversed.
+type RootReader = func() (common.Hash, error)
+
+// NewRootReader returns a RootReader which looks up the root node of the trie owned by `owner`
+// (zero for the state trie) directly from the database. Under the hash scheme, the reader returns
+// the zero hash once `root` is no longer present (e.g. it was pruned); under the path scheme it
+// returns the hash of whichever node is stored at the root path.
+func NewRootReader(db ethdb.KeyValueReader, owner, root common.Hash, scheme string) RootReader {
+	return func() (common.Hash, error) {
+		switch scheme {
+		case rawdb.HashScheme:
+			if rawdb.HasLegacyTrieNode(db, root) {
+				return root, nil
+			}
+			return common.Hash{}, nil
+		case rawdb.PathScheme:
+			var hash common.Hash
+			if owner == (common.Hash{}) {
+				_, hash = rawdb.ReadAccountTrieNode(db, nil)
+			} else {
+				_, hash = rawdb.ReadStorageTrieNode(db, owner, nil)
+			}
+			return hash, nil
+		}
+		return common.Hash{}, fmt.Errorf("unknown state scheme: %q", scheme)
+	}
+}
+
+// SentinelIterator is a NodeIterator which periodically re-reads its trie's root node and stops
+// with ErrTrieMutated if it has changed since the traversal started.
+type SentinelIterator struct {
+	trie.NodeIterator
+	readRoot RootReader
+	root     common.Hash
+	interval uint
+	count    uint
+	err      error
+}
+
+// NewSentinelIterator wraps an iterator, checking the root every `interval` nodes. The root is also
+// checked when the underlying iterator fails, so that a missing node caused by a concurrent
+// modification is reported as such. Returns ErrMissingRoot if the root is not stored to begin with,
+// e.g. it was already pruned, as its later removal would then go unnoticed.
+func NewSentinelIterator(it trie.NodeIterator, readRoot RootReader, interval uint) (*SentinelIterator, error) {
+	if interval == 0 {
+		return nil, errors.New("sentinel check interval must be positive")
+	}
+	root, err := readRoot()
+	if err != nil {
+		return nil, err
+	}
+	if root == (common.Hash{}) {
+		return nil, ErrMissingRoot
+	}
+	return &SentinelIterator{NodeIterator: it, readRoot: readRoot, root: root, interval: interval}, nil
+}
+
+func (it *SentinelIterator) Next(descend bool) bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.NodeIterator.Next(descend) {
+		if it.NodeIterator.Error() != nil {
+			it.check()
+		}
+		return false
+	}
+	it.count++
+	if it.count%it.interval == 0 {
+		return it.check()
+	}
+	return true
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *SentinelIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+// check re-reads the root, recording an error if it can't be read or has changed.
+func (it *SentinelIterator) check() bool {
+	root, err := it.readRoot()
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if root != it.root {
+		it.err = fmt.Errorf("%w: root %x is now %x (at path %x)", ErrTrieMutated, it.root, root, it.Path())
+		return false
+	}
+	return true
+}
+
+func (it *SentinelIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.NodeIterator.Error()
+}
@@ -0,0 +1,82 @@
+package iterator_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestSentinelIterator(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	t.Run("unmodified", func(t *testing.T) {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readRoot := iter.NewRootReader(edb, common.Hash{}, tree.Hash(), rawdb.HashScheme)
+		it, err := iter.NewSentinelIterator(nodeit, readRoot, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for ; it.Next(true); count++ {
+		}
+		if it.Error() != nil {
+			t.Fatal(it.Error())
+		}
+		if count != len(internal.FixtureNodePaths) {
+			t.Fatalf("expected %d nodes, got %d", len(internal.FixtureNodePaths), count)
+		}
+	})
+
+	t.Run("missing root", func(t *testing.T) {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readRoot := iter.NewRootReader(edb, common.Hash{}, common.HexToHash("0x01"), rawdb.HashScheme)
+		if _, err := iter.NewSentinelIterator(nodeit, readRoot, 1); !errors.Is(err, iter.ErrMissingRoot) {
+			t.Fatalf("expected ErrMissingRoot, got %v", err)
+		}
+		readRoot = iter.NewRootReader(rawdb.NewMemoryDatabase(), common.Hash{}, tree.Hash(), rawdb.PathScheme)
+		if _, err := iter.NewSentinelIterator(nodeit, readRoot, 1); !errors.Is(err, iter.ErrMissingRoot) {
+			t.Fatalf("expected ErrMissingRoot, got %v", err)
+		}
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const mutateAt = 5
+		reads := 0
+		readRoot := func() (common.Hash, error) {
+			reads++
+			if reads > mutateAt {
+				return common.Hash{}, nil
+			}
+			return tree.Hash(), nil
+		}
+		it, err := iter.NewSentinelIterator(nodeit, readRoot, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for ; it.Next(true); count++ {
+		}
+		if !errors.Is(it.Error(), iter.ErrTrieMutated) {
+			t.Fatalf("expected ErrTrieMutated, got %v", it.Error())
+		}
+		if expected := 2*mutateAt - 1; count != expected {
+			t.Fatalf("expected traversal to stop after %d nodes, got %d", expected, count)
+		}
+	})
+}
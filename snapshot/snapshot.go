@@ -0,0 +1,213 @@
+// Package snapshot generates geth state snapshots, i.e. the flat account and storage layout used
+// by the snapshot tree, from a parallel traversal of the state trie.
+//
+// Generation is split into bins by Traverse, and can be tracked and resumed like any traversal:
+//
+//	gen := snapshot.NewGenerator(triedb, diskdb, root)
+//	if err := gen.Begin(); err != nil { ... }
+//	err := iter.Traverse(ctx, makeIterator, 16, 8, gen.Visit, iter.WithTracker(tr))
+//	if err == nil {
+//		err = gen.Finish()
+//	}
+//
+// To resume, restore the tracked iterators and pass them to TraverseIterators with the same
+// Visit, then call Finish. Begin must not be called again, since it wipes the partial snapshot.
+//
+// On startup, geth only loads a snapshot whose root is the state root of its head block, and
+// otherwise discards it and generates its own. The root must therefore be that of the head of the
+// database the snapshot is written to, and geth must not import blocks into it until Finish.
+package snapshot
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// journalGenerator is the generator status saved by geth's snapshot package, which decides
+// whether a snapshot is complete.
+type journalGenerator struct {
+	Wiping   bool // deprecated, always false
+	Done     bool
+	Marker   []byte
+	Accounts uint64
+	Slots    uint64
+	Storage  uint64
+}
+
+// Generator writes the accounts and storage slots of a state trie into a database in geth's
+// snapshot layout. It counts the entries it writes, which are not saved with the progress of the
+// traversal, so the counts of a resumed generation only cover the entries written since the resume.
+type Generator struct {
+	db     *triedb.Database
+	diskdb ethdb.KeyValueStore
+	root   common.Hash
+
+	accounts, slots, storage atomic.Uint64
+}
+
+// NewGenerator returns a generator of the snapshot of the state with the given root, whose tries
+// are read from db and whose snapshot is written to diskdb.
+func NewGenerator(db *triedb.Database, diskdb ethdb.KeyValueStore, root common.Hash) *Generator {
+	return &Generator{db: db, diskdb: diskdb, root: root}
+}
+
+// Begin invalidates any existing snapshot in the database, so that geth does not use the snapshot
+// while it is incomplete, and removes its flat data.
+func (g *Generator) Begin() error {
+	batch := g.diskdb.NewBatch()
+	rawdb.DeleteSnapshotRoot(batch)
+	if err := writeGenerator(batch, journalGenerator{Marker: []byte{}}); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	// snapshot keys are identified by prefix and length
+	if err := g.wipe(rawdb.SnapshotAccountPrefix, 1+common.HashLength); err != nil {
+		return err
+	}
+	return g.wipe(rawdb.SnapshotStoragePrefix, 1+2*common.HashLength)
+}
+
+func (g *Generator) wipe(prefix []byte, keyLen int) error {
+	it := g.diskdb.NewIterator(prefix, nil)
+	defer it.Release()
+
+	batch := g.diskdb.NewBatch()
+	for it.Next() {
+		if len(it.Key()) != keyLen {
+			continue
+		}
+		if err := batch.Delete(it.Key()); err != nil {
+			return err
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// Visit is an iter.Visitor which writes the accounts reached by an iterator over the state trie,
+// and the full storage of each of them. Entries are written in batches, and all written before
+// Visit returns, so a tracker's state saved after the traversal stops (e.g. by CloseAndSave) never
+// skips unwritten entries. Auto-checkpointing does not have this guarantee.
+func (g *Generator) Visit(it trie.NodeIterator) error {
+	batch := g.diskdb.NewBatch()
+	err := g.visit(it, batch)
+	if werr := batch.Write(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (g *Generator) visit(it trie.NodeIterator, batch ethdb.Batch) error {
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		account := common.BytesToHash(it.LeafKey())
+		var data types.StateAccount
+		if err := rlp.DecodeBytes(it.LeafBlob(), &data); err != nil {
+			return fmt.Errorf("account %x: invalid account: %w", account, err)
+		}
+		slim := types.SlimAccountRLP(data)
+		rawdb.WriteAccountSnapshot(batch, account, slim)
+		g.accounts.Add(1)
+		g.storage.Add(uint64(1 + common.HashLength + len(slim)))
+
+		if data.Root != types.EmptyRootHash {
+			if err := g.writeStorage(account, data.Root, batch); err != nil {
+				return fmt.Errorf("account %x: %w", account, err)
+			}
+		}
+		if err := flush(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) writeStorage(account, storageRoot common.Hash, batch ethdb.Batch) error {
+	makeIterator := iter.NewTrieDBConstructor(g.db, trie.StorageTrieID(g.root, account, storageRoot))
+	it, err := makeIterator(nil)
+	if err != nil {
+		return err
+	}
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		rawdb.WriteStorageSnapshot(batch, account, common.BytesToHash(it.LeafKey()), it.LeafBlob())
+		g.slots.Add(1)
+		g.storage.Add(uint64(1 + 2*common.HashLength + len(it.LeafBlob())))
+		if err := flush(batch); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// flush writes a batch once it reaches the ideal size.
+func flush(batch ethdb.Batch) error {
+	if batch.ValueSize() < ethdb.IdealBatchSize {
+		return nil
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	batch.Reset()
+	return nil
+}
+
+// Finish marks the snapshot as complete, so that geth loads it for the generator's root, if that is
+// the root of its head block. It must only be called once every bin of the traversal has been
+// visited. The generator's counts of accounts, slots and bytes written are recorded in the
+// snapshot's generator status, and logged; geth only uses them for its own logs. They are counts
+// of this run: after a resume, they omit the entries written before it, and include the entries
+// at the resumed positions, which are written again.
+func (g *Generator) Finish() error {
+	batch := g.diskdb.NewBatch()
+	stats := journalGenerator{
+		Done:     true,
+		Accounts: g.accounts.Load(),
+		Slots:    g.slots.Load(),
+		Storage:  g.storage.Load(),
+	}
+	if err := writeGenerator(batch, stats); err != nil {
+		return err
+	}
+	rawdb.WriteSnapshotRoot(batch, g.root)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Generated state snapshot", "root", g.root,
+		"accounts", stats.Accounts, "slots", stats.Slots, "storage", common.StorageSize(stats.Storage))
+	return nil
+}
+
+func writeGenerator(db ethdb.KeyValueWriter, gen journalGenerator) error {
+	blob, err := rlp.EncodeToBytes(gen)
+	if err != nil {
+		return err
+	}
+	rawdb.WriteSnapshotGenerator(db, blob)
+	return nil
+}
@@ -0,0 +1,94 @@
+package snapshot_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	gethsnapshot "github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/snapshot"
+)
+
+func TestGenerator(t *testing.T) {
+	diskdb := rawdb.NewMemoryDatabase()
+	sdb := state.NewDatabase(diskdb)
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const accounts = 100
+	for i := 0; i < accounts; i++ {
+		addr := common.BytesToAddress([]byte{byte(i + 1)})
+		statedb.SetNonce(addr, uint64(i+1))
+		for j := 0; j < i%5; j++ {
+			statedb.SetState(addr, common.BytesToHash([]byte{byte(j + 1)}), common.BytesToHash([]byte{byte(i)}))
+		}
+	}
+	root, err := statedb.Commit(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sdb.TrieDB()
+	if err := db.Commit(root, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// flat data of a stale snapshot is removed
+	stale := crypto.Keccak256Hash([]byte("stale"))
+	rawdb.WriteAccountSnapshot(diskdb, stale, []byte{1})
+
+	gen := snapshot.NewGenerator(db, diskdb, root)
+	if err := gen.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	makeIterator := iter.NewTrieDBConstructor(db, trie.StateTrieID(root))
+	if err := iter.Traverse(context.Background(), makeIterator, 4, 2, gen.Visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	snaps, err := gethsnapshot.New(gethsnapshot.Config{CacheSize: 1, NoBuild: true}, diskdb, db, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := snaps.Snapshot(root)
+	if snap == nil {
+		t.Fatal("snapshot wasn't loaded")
+	}
+	if acct, err := snap.Account(stale); err != nil || acct != nil {
+		t.Fatalf("stale account wasn't removed: %v, err: %v", acct, err)
+	}
+	for i := 0; i < accounts; i++ {
+		addr := common.BytesToAddress([]byte{byte(i + 1)})
+		hash := crypto.Keccak256Hash(addr.Bytes())
+		acct, err := snap.Account(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acct == nil || acct.Nonce != uint64(i+1) {
+			t.Fatalf("wrong snapshot of account %d: %v", i, acct)
+		}
+		for j := 0; j < i%5; j++ {
+			slot := common.BytesToHash([]byte{byte(j + 1)})
+			blob, err := snap.Storage(hash, crypto.Keccak256Hash(slot.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, _ := rlp.EncodeToBytes(common.TrimLeftZeroes([]byte{byte(i)}))
+			if !bytes.Equal(blob, expected) {
+				t.Fatalf("wrong snapshot of slot %d of account %d: %x", j, i, blob)
+			}
+		}
+	}
+}
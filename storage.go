@@ -0,0 +1,173 @@
+package iterator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"golang.org/x/sync/errgroup"
+)
+
+// StorageVisitor consumes the iterator over the storage trie owned by the given account hash.
+type StorageVisitor = func(account common.Hash, it trie.NodeIterator) error
+
+// StorageOption configures a storage traversal.
+type StorageOption func(*storageConfig)
+
+type storageConfig struct {
+	workers  uint
+	startKey []byte
+	endPath  []byte
+	decoder  AccountDecoder
+	tracker  StorageTracker
+	skipped  map[common.Hash]struct{}
+}
+
+// StorageTracker registers storage iterators so that their state can be saved along with the
+// storage trie they belong to, e.g. a tracker.Tracker.
+type StorageTracker interface {
+	TrackedStorage(owner, storageRoot common.Hash, it trie.NodeIterator) trie.NodeIterator
+}
+
+// AccountDecoder interprets the leaves of a state trie, so that tries with a different account
+// encoding or key hashing than Ethereum's can be traversed.
+type AccountDecoder interface {
+	// DecodeAccount returns the owner of an account's storage trie, i.e. the hash identifying it in
+	// the database, and the trie's root. A zero or empty root means the account has no storage.
+	DecodeAccount(leafKey, leafBlob []byte) (owner, storageRoot common.Hash, err error)
+}
+
+// EthereumAccountDecoder decodes Ethereum state accounts, whose storage is owned by their key.
+type EthereumAccountDecoder struct{}
+
+func (EthereumAccountDecoder) DecodeAccount(leafKey, leafBlob []byte) (common.Hash, common.Hash, error) {
+	var data types.StateAccount
+	if err := rlp.DecodeBytes(leafBlob, &data); err != nil {
+		return common.Hash{}, common.Hash{}, err
+	}
+	return common.BytesToHash(leafKey), data.Root, nil
+}
+
+// WithStorageWorkers visits up to n storage tries at once. By default they are visited one at a
+// time, in the order their accounts are reached.
+func WithStorageWorkers(n uint) StorageOption {
+	return func(conf *storageConfig) {
+		conf.workers = n
+	}
+}
+
+// WithAccountDecoder sets the decoder for account leaves. The default is EthereumAccountDecoder.
+func WithAccountDecoder(decoder AccountDecoder) StorageOption {
+	return func(conf *storageConfig) {
+		conf.decoder = decoder
+	}
+}
+
+// WithStorageTracker registers each storage iterator with a tracker before it is visited.
+func WithStorageTracker(tr StorageTracker) StorageOption {
+	return func(conf *storageConfig) {
+		conf.tracker = tr
+	}
+}
+
+// WithSkippedAccounts skips the storage tries of the given owners. When resuming a traversal,
+// these are the accounts whose storage iterators were restored by a tracker, and which are
+// visited separately.
+func WithSkippedAccounts(owners ...common.Hash) StorageOption {
+	return func(conf *storageConfig) {
+		if conf.skipped == nil {
+			conf.skipped = map[common.Hash]struct{}{}
+		}
+		for _, owner := range owners {
+			conf.skipped[owner] = struct{}{}
+		}
+	}
+}
+
+// WithStorageBounds limits each storage iterator to start at startKey and stop at endPath, as for
+// NewPrefixBoundIterator.
+func WithStorageBounds(startKey, endPath []byte) StorageOption {
+	return func(conf *storageConfig) {
+		conf.startKey = startKey
+		conf.endPath = endPath
+	}
+}
+
+// TraverseStorage advances `accounts`, an iterator over the state trie with root `stateRoot`, and
+// for each account leaf with non-empty storage calls visit with an iterator over the account's
+// storage trie, read from db. Traversal stops when ctx is cancelled. If visit returns an error or
+// an iterator fails, the remaining storage tries are cancelled and the first error is returned.
+//
+// Only account leaves are consumed from `accounts`, so it can be any state trie iterator, e.g. one
+// bin of a Traverse, or a tracked iterator.
+func TraverseStorage(
+	ctx context.Context, db *triedb.Database, stateRoot common.Hash, accounts trie.NodeIterator,
+	visit StorageVisitor, opts ...StorageOption,
+) error {
+	conf := storageConfig{workers: 1, decoder: EthereumAccountDecoder{}}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	if conf.workers == 0 {
+		return fmt.Errorf("invalid worker count: %d", conf.workers)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	group, ctx := errgroup.WithContext(ctx)
+	group.SetLimit(int(conf.workers))
+
+	var err error
+	it := NewContextIterator(ctx, accounts)
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		account, root, derr := conf.decoder.DecodeAccount(it.LeafKey(), it.LeafBlob())
+		if derr != nil {
+			err = fmt.Errorf("account %x: invalid account: %w", it.LeafKey(), derr)
+			cancel()
+			break
+		}
+		if root == (common.Hash{}) || root == types.EmptyRootHash {
+			continue
+		}
+		if _, skip := conf.skipped[account]; skip {
+			continue
+		}
+		storage, serr := NewTrieDBConstructor(db, trie.StorageTrieID(stateRoot, account, root))(conf.startKey)
+		if serr != nil {
+			err = fmt.Errorf("account %x: %w", account, serr)
+			cancel()
+			break
+		}
+		// as in Traverse, the context is checked beneath the bound iterator
+		storage = NewPrefixBoundIterator(NewContextIterator(ctx, storage), conf.endPath)
+		// the storage iterator is tracked before the account iterator moves on, so that its
+		// position is always saved
+		if conf.tracker != nil {
+			storage = conf.tracker.TrackedStorage(account, root, storage)
+		}
+		group.Go(func() error {
+			err := visit(account, storage)
+			if err == nil {
+				err = storage.Error()
+			}
+			if err != nil {
+				return fmt.Errorf("account %x: %w", account, err)
+			}
+			return nil
+		})
+	}
+	// a cancellation caused by a failure is reported as that failure
+	if werr := group.Wait(); err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = it.Error()
+	}
+	return err
+}
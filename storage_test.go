@@ -0,0 +1,178 @@
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+func TestTraverseStorage(t *testing.T) {
+	// accounts with an even index have i+1 storage slots, the others have none
+	const accounts = 8
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[common.Hash]int{}
+	for i := 0; i < accounts; i++ {
+		addr := common.BytesToAddress([]byte{byte(i + 1)})
+		statedb.SetNonce(addr, 1)
+		if i%2 == 1 {
+			continue
+		}
+		for j := 0; j <= i; j++ {
+			statedb.SetState(addr, common.BytesToHash([]byte{byte(j + 1)}), common.BytesToHash([]byte{1}))
+		}
+		expected[crypto.Keccak256Hash(addr.Bytes())] = i + 1
+	}
+	root, err := statedb.Commit(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sdb.TrieDB()
+	accountIterator := func() trie.NodeIterator {
+		it, err := iter.NewTrieDBConstructor(db, trie.StateTrieID(root))(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+
+	for _, workers := range []uint{1, 4} {
+		var mu sync.Mutex
+		slots := map[common.Hash]int{}
+		err := iter.TraverseStorage(context.Background(), db, root, accountIterator(),
+			func(account common.Hash, it trie.NodeIterator) error {
+				count := 0
+				for it.Next(true) {
+					if it.Leaf() {
+						count++
+					}
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				slots[account] = count
+				return nil
+			}, iter.WithStorageWorkers(workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(slots) != len(expected) {
+			t.Fatalf("with %d workers: expected %d storage tries, got %d", workers, len(expected), len(slots))
+		}
+		for account, count := range expected {
+			if slots[account] != count {
+				t.Fatalf("with %d workers: expected %d slots for %x, got %d", workers, count, account, slots[account])
+			}
+		}
+	}
+
+	t.Run("custom account decoder", func(t *testing.T) {
+		testCustomAccountDecoder(t)
+	})
+
+	errVisit := errors.New("visit failed")
+	err = iter.TraverseStorage(context.Background(), db, root, accountIterator(),
+		func(common.Hash, trie.NodeIterator) error {
+			return errVisit
+		})
+	if !errors.Is(err, errVisit) {
+		t.Fatalf("expected visit error, got %v", err)
+	}
+}
+
+// customAccount is an account encoding with a different field layout than Ethereum's
+type customAccount struct {
+	Extra uint64
+	Root  common.Hash
+}
+
+type customDecoder struct{}
+
+func (customDecoder) DecodeAccount(leafKey, leafBlob []byte) (common.Hash, common.Hash, error) {
+	var acct customAccount
+	if err := rlp.DecodeBytes(leafBlob, &acct); err != nil {
+		return common.Hash{}, common.Hash{}, err
+	}
+	return common.BytesToHash(leafKey), acct.Root, nil
+}
+
+func testCustomAccountDecoder(t *testing.T) {
+	db := triedb.NewDatabase(rawdb.NewMemoryDatabase(), triedb.HashDefaults)
+	commit := func(tree *trie.Trie) common.Hash {
+		root, nodes, err := tree.Commit(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes != nil {
+			if err := db.Update(root, types.EmptyRootHash, 0, trienode.NewWithNodeSet(nodes), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return root
+	}
+
+	stateTrie := trie.NewEmpty(db)
+	expected := map[common.Hash]int{}
+	for i := 1; i <= 4; i++ {
+		owner := crypto.Keccak256Hash([]byte{byte(i)})
+		storage := trie.NewEmpty(db)
+		for j := 0; j < i; j++ {
+			storage.MustUpdate(crypto.Keccak256([]byte{byte(j)}), []byte{1})
+		}
+		blob, err := rlp.EncodeToBytes(customAccount{Extra: uint64(i), Root: commit(storage)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stateTrie.MustUpdate(owner.Bytes(), blob)
+		expected[owner] = i
+	}
+	root := commit(stateTrie)
+
+	accounts, err := iter.NewTrieDBConstructor(db, trie.StateTrieID(root))(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots := map[common.Hash]int{}
+	err = iter.TraverseStorage(context.Background(), db, root, accounts,
+		func(account common.Hash, it trie.NodeIterator) error {
+			for it.Next(true) {
+				if it.Leaf() {
+					slots[account]++
+				}
+			}
+			return nil
+		}, iter.WithAccountDecoder(customDecoder{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, slots) {
+		t.Fatalf("wrong storage slots\nexpected:\t%v\nactual:\t\t%v", expected, slots)
+	}
+
+	// the default decoder can't read these accounts
+	accounts, err = iter.NewTrieDBConstructor(db, trie.StateTrieID(root))(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iter.TraverseStorage(context.Background(), db, root, accounts,
+		func(common.Hash, trie.NodeIterator) error { return nil })
+	if err == nil {
+		t.Fatal("expected error decoding custom accounts")
+	}
+}
@@ -0,0 +1,69 @@
+package iterator
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// NodeResult is a copy of the state of a NodeIterator at one node.
+type NodeResult struct {
+	Path   []byte
+	Hash   common.Hash // zero for embedded and value nodes
+	Parent common.Hash
+	// NodeBlob is the encoding of a hashed node, or nil
+	NodeBlob []byte
+	Leaf     bool
+	// LeafKey and LeafBlob are only set for leaves
+	LeafKey, LeafBlob []byte
+}
+
+// Stream advances an iterator on a new goroutine, sending a copy of each node to the returned
+// channel, which holds up to `buffer` nodes. Once the iterator is exhausted, fails or ctx is
+// cancelled, the node channel is closed, then the error (if any) is sent on the error channel,
+// which is closed in turn.
+//
+// The stream owns the iterator until the node channel is closed. Consumers which stop reading
+// early must cancel ctx so that the goroutine exits; it then does so without the channels being
+// drained, at the latest once the iterator's current Next returns.
+func Stream(ctx context.Context, it trie.NodeIterator, buffer int) (<-chan NodeResult, <-chan error) {
+	results := make(chan NodeResult, buffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		err := stream(ctx, it, results)
+		close(results)
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return results, errs
+}
+
+func stream(ctx context.Context, it trie.NodeIterator, results chan<- NodeResult) error {
+	for it.Next(true) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res := NodeResult{
+			Path:   append([]byte(nil), it.Path()...),
+			Hash:   it.Hash(),
+			Parent: it.Parent(),
+			Leaf:   it.Leaf(),
+		}
+		if res.Hash != (common.Hash{}) {
+			res.NodeBlob = append([]byte(nil), it.NodeBlob()...)
+		}
+		if res.Leaf {
+			res.LeafKey = append([]byte(nil), it.LeafKey()...)
+			res.LeafBlob = append([]byte(nil), it.LeafBlob()...)
+		}
+		select {
+		case results <- res:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return it.Error()
+}
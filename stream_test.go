@@ -0,0 +1,78 @@
+package iterator_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestStream(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	t.Run("all nodes", func(t *testing.T) {
+		it, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, errs := iter.Stream(context.Background(), it, 4)
+		var i, leaves int
+		for res := range results {
+			if !bytes.Equal(res.Path, internal.FixtureNodePaths[i]) {
+				t.Fatalf("wrong path at node %d: expected %v, got %v", i, internal.FixtureNodePaths[i], res.Path)
+			}
+			if res.Leaf {
+				leaves++
+			}
+			i++
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if i != len(internal.FixtureNodePaths) || leaves != len(internal.FixtureLeafKeys) {
+			t.Fatalf("expected %d nodes and %d leaves, got %d and %d",
+				len(internal.FixtureNodePaths), len(internal.FixtureLeafKeys), i, leaves)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		it, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		results, errs := iter.Stream(ctx, it, 0)
+		<-results
+		cancel()
+		// the stream stops without the remaining nodes being read
+		for range results {
+		}
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if _, open := <-errs; open {
+			t.Fatal("error channel wasn't closed")
+		}
+	})
+}
+
+func TestStreamLeak(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	check := checkGoroutines(t)
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the consumer stops reading with the buffer full, and cancels without draining
+	ctx, cancel := context.WithCancel(context.Background())
+	results, _ := iter.Stream(ctx, it, 1)
+	<-results
+	cancel()
+	check()
+}
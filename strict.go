@@ -0,0 +1,167 @@
+package iterator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ErrCoverage is matched by the CoverageError returned by a traversal with WithStrictCheck.
+var ErrCoverage = errors.New("traversal coverage differs from reference")
+
+// CoverageError describes how the nodes yielded by the bins of a traversal differ from those of a
+// single iterator over the whole trie. Paths are sorted.
+type CoverageError struct {
+	// Missing are the paths of nodes which no bin yielded.
+	Missing [][]byte
+	// Extra are the paths yielded by some bin which are not in the trie.
+	Extra [][]byte
+	// Duplicated are the paths yielded more than once. The node at the shared bound of adjacent
+	// bins, which both yield (see SubtrieIteratorsDedup), is not counted.
+	Duplicated [][]byte
+}
+
+// maxReportedPaths is the number of paths of each kind listed by CoverageError.Error.
+const maxReportedPaths = 8
+
+func (e *CoverageError) Error() string {
+	var parts []string
+	for _, kind := range []struct {
+		name  string
+		paths [][]byte
+	}{{"missing", e.Missing}, {"extra", e.Extra}, {"duplicated", e.Duplicated}} {
+		if len(kind.paths) == 0 {
+			continue
+		}
+		var listed []string
+		for i, path := range kind.paths {
+			if i == maxReportedPaths {
+				listed = append(listed, "...")
+				break
+			}
+			listed = append(listed, fmt.Sprintf("%x", path))
+		}
+		parts = append(parts, fmt.Sprintf("%d %s [%s]", len(kind.paths), kind.name, strings.Join(listed, " ")))
+	}
+	return fmt.Sprintf("%v: %s", ErrCoverage, strings.Join(parts, ", "))
+}
+
+func (e *CoverageError) Is(target error) bool {
+	return target == ErrCoverage
+}
+
+// WithStrictCheck makes Traverse record the path of every node its bins yield, and once they
+// finish, compare them with a single-threaded traversal of the whole trie, returning a
+// *CoverageError if any node was skipped or yielded twice. This is meant for CI and canary runs,
+// e.g. after upgrading this package or geth: paths are kept in memory, and the trie is read twice.
+// Visitors must descend into every node, i.e. only call Next(true).
+func WithStrictCheck() TraverseOption {
+	return func(conf *traverseConfig) {
+		conf.strict = true
+	}
+}
+
+// coverageRecorder records the bins which yield each path.
+type coverageRecorder struct {
+	bins map[string][]int
+	ends [][]byte // end bound of each bin
+	mu   sync.Mutex
+}
+
+func newCoverageRecorder(iters []trie.NodeIterator) (*coverageRecorder, error) {
+	rec := &coverageRecorder{bins: make(map[string][]int), ends: make([][]byte, len(iters))}
+	for i, it := range iters {
+		_, end, err := Bounds(it)
+		if err != nil {
+			return nil, err
+		}
+		rec.ends[i] = end
+	}
+	return rec, nil
+}
+
+// recordingIterator records the nodes it yields with a coverageRecorder.
+type recordingIterator struct {
+	trie.NodeIterator
+	rec *coverageRecorder
+	bin int
+}
+
+func (rec *coverageRecorder) wrap(iters []trie.NodeIterator) []trie.NodeIterator {
+	wrapped := make([]trie.NodeIterator, len(iters))
+	for i, it := range iters {
+		wrapped[i] = &recordingIterator{NodeIterator: it, rec: rec, bin: i}
+	}
+	return wrapped
+}
+
+func (it *recordingIterator) Next(descend bool) bool {
+	if !it.NodeIterator.Next(descend) {
+		return false
+	}
+	path := string(it.Path())
+	it.rec.mu.Lock()
+	it.rec.bins[path] = append(it.rec.bins[path], it.bin)
+	it.rec.mu.Unlock()
+	return true
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *recordingIterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+// check compares the recorded paths with those of a traversal of the whole trie.
+func (rec *coverageRecorder) check(ctx context.Context, makeIterator IteratorConstructor) error {
+	it, err := makeIterator(nil)
+	if err != nil {
+		return err
+	}
+	it = NewContextIterator(ctx, it)
+	var cov CoverageError
+	seen := make(map[string]bool, len(rec.bins))
+	for it.Next(true) {
+		path := string(it.Path())
+		seen[path] = true
+		bins, ok := rec.bins[path]
+		if !ok {
+			cov.Missing = append(cov.Missing, []byte(path))
+		} else if len(bins) > 1 && !rec.sharedBound(path, bins) {
+			cov.Duplicated = append(cov.Duplicated, []byte(path))
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for path := range rec.bins {
+		if !seen[path] {
+			cov.Extra = append(cov.Extra, []byte(path))
+		}
+	}
+	if len(cov.Missing) == 0 && len(cov.Extra) == 0 && len(cov.Duplicated) == 0 {
+		return nil
+	}
+	for _, paths := range [][][]byte{cov.Missing, cov.Extra, cov.Duplicated} {
+		sort.Slice(paths, func(i, j int) bool { return bytes.Compare(paths[i], paths[j]) < 0 })
+	}
+	return &cov
+}
+
+// sharedBound returns whether a path was yielded by two adjacent bins because it is the end bound
+// of the first, from which the second starts.
+func (rec *coverageRecorder) sharedBound(path string, bins []int) bool {
+	if len(bins) != 2 {
+		return false
+	}
+	lo, hi := bins[0], bins[1]
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return hi == lo+1 && rec.ends[lo] != nil && string(rec.ends[lo]) == path
+}
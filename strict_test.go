@@ -0,0 +1,61 @@
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestStrictCheck(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	drain := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+		}
+		return nil
+	}
+	for _, nbins := range []uint{1, 2, 16, 100, 4096} {
+		err := iter.Traverse(context.Background(), tree.NodeIterator, nbins, 4, drain, iter.WithStrictCheck())
+		if err != nil {
+			t.Fatalf("%d bins: %v", nbins, err)
+		}
+	}
+
+	// a visitor which skips a subtree
+	skipping := func(it trie.NodeIterator) error {
+		for descend := true; it.Next(descend); {
+			descend = len(it.Path()) != 3
+		}
+		return nil
+	}
+	err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, skipping, iter.WithStrictCheck())
+	var cov *iter.CoverageError
+	if !errors.As(err, &cov) || !errors.Is(err, iter.ErrCoverage) {
+		t.Fatalf("expected CoverageError, got %v", err)
+	}
+	if len(cov.Missing) == 0 || len(cov.Duplicated) != 0 || len(cov.Extra) != 0 {
+		t.Fatalf("expected only missing nodes, got %v", err)
+	}
+	for _, path := range cov.Missing {
+		if len(path) <= 3 {
+			t.Fatalf("unexpected missing node %x", path)
+		}
+	}
+
+	// a constructor which ignores the start key makes bins overlap
+	fromRoot := func([]byte) (trie.NodeIterator, error) { return tree.NodeIterator(nil) }
+	err = iter.Traverse(context.Background(), fromRoot, 4, 4, drain, iter.WithStrictCheck())
+	if !errors.As(err, &cov) || len(cov.Duplicated) == 0 || len(cov.Missing) != 0 {
+		t.Fatalf("expected duplicated nodes, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "duplicated") {
+		t.Fatalf("expected duplicates in message: %v", err)
+	}
+}
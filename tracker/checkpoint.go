@@ -0,0 +1,279 @@
+package tracker
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/metrics"
+)
+
+// Option configures optional tracker behavior.
+type Option func(*TrackerImpl)
+
+// WithRoot sets the root of the trie whose iterators are tracked. The root is saved with the
+// recovery state, and Restore fails with ErrRootMismatch if the saved root is different.
+func WithRoot(root common.Hash) Option {
+	return func(tr *TrackerImpl) {
+		tr.root = root
+	}
+}
+
+// RestoreTransform adjusts a saved trie iterator position before Restore reopens it, returning
+// false to drop the position, e.g. to skip a range which another job has already covered. It must
+// not change the trie the position belongs to.
+type RestoreTransform = func(Position) (Position, bool)
+
+// WithRestoreTransform applies a transform to each position restored by Restore and
+// RestoreWithStorage, narrowing or widening the recovered range in place of the saved end path.
+// Dropped positions are not restored, and so are cleared from the store with the rest.
+func WithRestoreTransform(transform RestoreTransform) Option {
+	return func(tr *TrackerImpl) {
+		tr.restoreTransform = transform
+	}
+}
+
+// WithAutoCheckpoint makes the tracker save the positions of all tracked iterators every interval
+// while they run, so that progress survives a crash or SIGKILL. Checkpointing starts when the
+// first iterator is tracked, and stops when the tracker is closed.
+//
+// Positions are copied on a timer and written to the store by a separate goroutine, so a slow
+// store never holds up the iterators. If a write is still in progress when the next snapshot is
+// taken, only the newest pending snapshot is kept.
+func WithAutoCheckpoint(interval time.Duration) Option {
+	return func(tr *TrackerImpl) {
+		tr.checkpointInterval = interval
+	}
+}
+
+// WithAdaptiveCheckpoint is like WithAutoCheckpoint, but adapts the checkpoint interval to the
+// progress of the iterators, so that fast traversals are saved often and stalled ones rarely.
+// Positions are sampled every floor, and a checkpoint is taken once the fraction of the keyspace
+// traversed since the last one (as estimated by iter.Progress) reaches atRisk, or once ceiling
+// has passed regardless of progress.
+//
+// For example, WithAdaptiveCheckpoint(time.Second, time.Minute, 0.001) saves at most every second,
+// for each thousandth of the keyspace, and at least every minute.
+func WithAdaptiveCheckpoint(floor, ceiling time.Duration, atRisk float64) Option {
+	return func(tr *TrackerImpl) {
+		tr.checkpointInterval = floor
+		tr.checkpointCeiling = ceiling
+		tr.checkpointAtRisk = atRisk
+	}
+}
+
+// WithCollector reports each save of the tracker's state, by checkpoint or by Save, to a metrics
+// collector.
+func WithCollector(collector metrics.Collector) Option {
+	return func(tr *TrackerImpl) {
+		tr.collector = collector
+	}
+}
+
+// CheckpointStats describes the checkpoints saved by a tracker.
+type CheckpointStats struct {
+	// Saved is the number of checkpoints written to the store.
+	Saved uint64
+	// Coalesced is the number of checkpoints replaced by a newer one before being written.
+	Coalesced uint64
+	// Failed is the number of checkpoints which could not be written.
+	Failed uint64
+	// LastLatency and MaxLatency measure the time from taking a snapshot to having written it.
+	LastLatency, MaxLatency time.Duration
+	// LastInterval is the time between the last two checkpoints taken.
+	LastInterval time.Duration
+}
+
+// snapshot is a copy of the tracker's positions at some point in time.
+type snapshot struct {
+	seq       uint64
+	positions []Position
+	seeks     []seekEntry // indexed paths to the positions, if the tracker has a seek index
+	taken     time.Time
+}
+
+// Stats is a snapshot of a tracker's internal state, for debugging throughput problems.
+type Stats struct {
+	// Tracked is the number of registered iterators which have not finished. Registrations still
+	// pending in the start channel are not included.
+	Tracked int
+	// Finished is the number of tracked iterators which have finished.
+	Finished uint64
+	// PendingStarts and PendingStops are the numbers of notifications waiting in the start and
+	// stop channels, whose capacity is ChannelSize.
+	PendingStarts, PendingStops, ChannelSize int
+	// LockedStarts and LockedStops count the notifications which found their channel full, and
+	// were registered under the tracker's lock instead.
+	LockedStarts, LockedStops uint64
+	Checkpoints               CheckpointStats
+}
+
+// Stats returns a snapshot of the tracker's internal state. Pending notifications are not
+// collected, so this does not affect the tracker's state.
+func (tr *TrackerImpl) Stats() Stats {
+	tr.stateMu.Lock()
+	stats := Stats{
+		Tracked:       len(tr.started),
+		Finished:      tr.finishedN,
+		PendingStarts: len(tr.startChan),
+		PendingStops:  len(tr.stopChan),
+		ChannelSize:   cap(tr.startChan),
+		LockedStarts:  tr.lockedStarts,
+		LockedStops:   tr.lockedStops,
+	}
+	tr.stateMu.Unlock()
+	stats.Checkpoints = tr.CheckpointStats()
+	return stats
+}
+
+// CheckpointStats returns statistics about the checkpoints saved so far.
+func (tr *TrackerImpl) CheckpointStats() CheckpointStats {
+	tr.statsMu.Lock()
+	defer tr.statsMu.Unlock()
+	return tr.stats
+}
+
+func (tr *TrackerImpl) startAutoCheckpoint() {
+	if tr.checkpointInterval <= 0 {
+		return
+	}
+	tr.checkpointOnce.Do(func() {
+		tr.checkpointQuit = make(chan struct{})
+		tr.checkpointDone = make(chan struct{})
+		tr.checkpointQueue = make(chan snapshot, 1)
+		go tr.autoCheckpoint()
+		go tr.checkpointWriter()
+	})
+}
+
+func (tr *TrackerImpl) stopAutoCheckpoint() {
+	// prevent checkpointing from starting after the tracker is closed
+	tr.checkpointOnce.Do(func() {})
+	if tr.checkpointQuit != nil {
+		close(tr.checkpointQuit)
+		<-tr.checkpointDone
+	}
+}
+
+func (tr *TrackerImpl) autoCheckpoint() {
+	ticker := time.NewTicker(tr.checkpointInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	var baseline float64 // keyspace remaining at the last checkpoint
+	for {
+		select {
+		case now := <-ticker.C:
+			snap := tr.checkpoint()
+			if tr.checkpointCeiling > 0 {
+				// newly tracked iterators add to the remaining keyspace, which is not progress
+				remaining := remainingKeyspace(snap.positions)
+				if remaining > baseline {
+					baseline = remaining
+				}
+				if baseline-remaining < tr.checkpointAtRisk && now.Sub(last) < tr.checkpointCeiling {
+					continue
+				}
+				baseline = remaining
+			}
+			tr.statsMu.Lock()
+			tr.stats.LastInterval = now.Sub(last)
+			tr.statsMu.Unlock()
+			last = now
+			tr.enqueue(snap)
+		case <-tr.checkpointQuit:
+			close(tr.checkpointQueue)
+			return
+		}
+	}
+}
+
+// remainingKeyspace sums the fractions of the keyspace which iterators have yet to traverse.
+func remainingKeyspace(positions []Position) float64 {
+	var remaining float64
+	for _, pos := range positions {
+		end := 1.0
+		if pos.EndPath != nil {
+			end = iter.Progress(pos.EndPath)
+		}
+		if done := iter.Progress(pos.Path); done < end {
+			remaining += end - done
+		}
+	}
+	return remaining
+}
+
+// enqueue passes a snapshot to the writer, replacing any snapshot which is still pending.
+func (tr *TrackerImpl) enqueue(snap snapshot) {
+	for {
+		select {
+		case tr.checkpointQueue <- snap:
+			return
+		default:
+		}
+		select {
+		case <-tr.checkpointQueue:
+			tr.statsMu.Lock()
+			tr.stats.Coalesced++
+			tr.statsMu.Unlock()
+		default:
+		}
+	}
+}
+
+func (tr *TrackerImpl) checkpointWriter() {
+	defer close(tr.checkpointDone)
+	for snap := range tr.checkpointQueue {
+		written, err := tr.write(snap)
+		tr.recordCheckpoint(snap, written, err)
+	}
+}
+
+// Checkpoint saves the positions of all tracked iterators to the store while they keep running,
+// unlike CloseAndSave, which closes the tracker. Each position is read while its iterator is not
+// being advanced, so it can be called from any goroutine. Returns ErrClosed once the tracker has
+// been closed.
+func (tr *TrackerImpl) Checkpoint() error {
+	tr.RLock()
+	defer tr.RUnlock()
+	if !tr.running {
+		return ErrClosed
+	}
+	snap := tr.checkpoint()
+	written, err := tr.write(snap)
+	tr.recordCheckpoint(snap, written, err)
+	return err
+}
+
+// recordCheckpoint updates the checkpoint stats for the result of writing a snapshot.
+func (tr *TrackerImpl) recordCheckpoint(snap snapshot, written bool, err error) {
+	latency := time.Since(snap.taken)
+
+	tr.statsMu.Lock()
+	if err != nil {
+		tr.stats.Failed++
+	} else if !written {
+		tr.stats.Coalesced++ // superseded by a newer save
+	} else {
+		tr.stats.Saved++
+		tr.stats.LastLatency = latency
+		if latency > tr.stats.MaxLatency {
+			tr.stats.MaxLatency = latency
+		}
+	}
+	tr.statsMu.Unlock()
+
+	if err != nil {
+		log.Error("Failed to checkpoint recovery state", "err", err)
+	} else if written {
+		log.Debug("Checkpointed recovery state", "positions", len(snap.positions), "latency", latency)
+		tr.reportSaved()
+	}
+}
+
+func (tr *TrackerImpl) reportSaved() {
+	if tr.collector != nil {
+		tr.collector.CheckpointSaved()
+	}
+}
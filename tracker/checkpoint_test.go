@@ -0,0 +1,247 @@
+package tracker_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestAutoCheckpoint(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	const interval = 10 * time.Millisecond
+	recoveryFile := filepath.Join(t.TempDir(), "checkpoint_test.csv")
+	store := tracker.NewFileStore(recoveryFile)
+	tr := tracker.NewWithStore(store, 1, tracker.WithAutoCheckpoint(interval))
+	defer tr.CloseAndSave()
+
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := tr.Tracked(nodeit)
+	done := make(chan struct{})
+	pause := make(chan struct{})
+	go func() {
+		defer close(done)
+		for count := 0; it.Next(true); count++ {
+			if count == 10 {
+				pause <- struct{}{} // let the main goroutine observe a checkpoint
+				<-pause
+			}
+		}
+	}()
+
+	<-pause
+	path := append([]byte(nil), it.Path()...)
+	waitFor(t, "checkpoint of paused iterator", func() bool {
+		positions, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(positions) == 1 && bytes.Equal(positions[0].Path, path)
+	})
+
+	// once the iterator finishes, a checkpoint clears the saved state
+	pause <- struct{}{}
+	<-done
+	waitFor(t, "removal of recovery file", func() bool { return !fileExists(recoveryFile) })
+}
+
+// blockingStore is a RecoveryStore whose saves wait until it is released.
+type blockingStore struct {
+	memoryStore
+	release chan struct{}
+}
+
+func (s *blockingStore) Save(positions []tracker.Position) error {
+	<-s.release
+	return s.memoryStore.Save(positions)
+}
+
+func TestCheckpointWriter(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	store := &blockingStore{release: make(chan struct{})}
+	tr := tracker.NewWithStore(store, 1, tracker.WithAutoCheckpoint(time.Millisecond))
+
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// iteration completes even though no checkpoint can be written
+	for it := tr.Tracked(nodeit); it.Next(true); {
+	}
+
+	// snapshots keep being taken while the write is blocked, and are coalesced
+	waitFor(t, "checkpoints to be coalesced", func() bool { return tr.CheckpointStats().Coalesced > 0 })
+	close(store.release)
+	waitFor(t, "checkpoint to be saved", func() bool { return tr.CheckpointStats().Saved > 0 })
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := tr.CheckpointStats()
+	if stats.MaxLatency == 0 || stats.MaxLatency < stats.LastLatency {
+		t.Fatalf("inconsistent latency stats: %+v", stats)
+	}
+	if len(store.positions) != 0 {
+		t.Fatalf("expected final state to be empty, got %v", store.positions)
+	}
+}
+
+func TestAdaptiveCheckpoint(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	t.Run("progress", func(t *testing.T) {
+		tr := tracker.NewWithStore(&memoryStore{}, 1,
+			tracker.WithAdaptiveCheckpoint(time.Millisecond, time.Hour, 0.25))
+		defer tr.CloseAndSave()
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := tr.Tracked(nodeit)
+		it.Next(true)
+
+		// a stalled iterator is not saved, once sampled
+		waitFor(t, "iterator to be sampled", func() bool { return tr.Stats().Tracked == 1 })
+		time.Sleep(20 * time.Millisecond)
+		if saved := tr.CheckpointStats().Saved; saved != 0 {
+			t.Fatalf("expected no checkpoints while stalled, got %d", saved)
+		}
+		for it.Next(true) && iter.Progress(it.Path()) < 0.3 {
+		}
+		waitFor(t, "checkpoint of progress", func() bool { return tr.CheckpointStats().Saved == 1 })
+		time.Sleep(20 * time.Millisecond)
+		if saved := tr.CheckpointStats().Saved; saved != 1 {
+			t.Fatalf("expected one checkpoint, got %d", saved)
+		}
+	})
+
+	t.Run("ceiling", func(t *testing.T) {
+		const ceiling = 10 * time.Millisecond
+		tr := tracker.NewWithStore(&memoryStore{}, 1,
+			tracker.WithAdaptiveCheckpoint(time.Millisecond, ceiling, 2))
+		defer tr.CloseAndSave()
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Tracked(nodeit).Next(true)
+		waitFor(t, "checkpoints at ceiling", func() bool { return tr.CheckpointStats().Saved >= 2 })
+		if interval := tr.CheckpointStats().LastInterval; interval < ceiling {
+			t.Fatalf("checkpoint interval %v below ceiling", interval)
+		}
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTrackerStats(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	tr := tracker.New(filepath.Join(t.TempDir(), "recovery.csv"), 1)
+	var iters []trie.NodeIterator
+	for i := 0; i < 3; i++ {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iters = append(iters, tr.Tracked(nodeit))
+	}
+	// the second registration found the channel full, and collected the first
+	expected := tracker.Stats{Tracked: 2, PendingStarts: 1, ChannelSize: 1, LockedStarts: 1}
+	if stats := tr.Stats(); stats != expected {
+		t.Fatalf("wrong stats\nexpected:\t%+v\nactual:\t\t%+v", expected, stats)
+	}
+
+	for iters[0].Next(true) {
+	}
+	expected.PendingStops = 1
+	if stats := tr.Stats(); stats != expected {
+		t.Fatalf("wrong stats\nexpected:\t%+v\nactual:\t\t%+v", expected, stats)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	expected = tracker.Stats{Tracked: 2, Finished: 1, ChannelSize: 1, LockedStarts: 1}
+	if stats := tr.Stats(); stats != expected {
+		t.Fatalf("wrong stats after close\nexpected:\t%+v\nactual:\t\t%+v", expected, stats)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	recoveryFile := filepath.Join(t.TempDir(), "checkpoint_test.csv")
+	store := tracker.NewFileStore(recoveryFile)
+	tr := tracker.NewWithStore(store, 4)
+	its, err := iter.SubtrieIterators(tree.NodeIterator, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	for _, it := range its {
+		it := tr.Tracked(it)
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for it.Next(true) {
+			}
+		}()
+	}
+	// checkpoints are taken while the iterators run
+	for i := 0; i < 10; i++ {
+		if err := tr.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		positions, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(positions) > len(its) {
+			t.Fatalf("expected at most %d positions, got %d", len(its), len(positions))
+		}
+	}
+	for range its {
+		<-done
+	}
+	if err := tr.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(recoveryFile) {
+		t.Fatal("expected checkpoint of finished iterators to clear the saved state")
+	}
+	if stats := tr.CheckpointStats(); stats.Saved == 0 {
+		t.Fatal("expected checkpoints to be counted")
+	}
+
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Checkpoint(); !errors.Is(err, tracker.ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package tracker
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// IteratorTrackerV2 extends IteratorTracker with tracking of storage iterators and saving of state.
+//
+// IteratorTracker is not changed, so that downstream implementations of it, e.g. mocks, keep
+// compiling. Methods which trackers gain are added to a new interface extending the last one
+// instead. Consumers which need them accept the new interface, or upgrade the IteratorTracker they
+// are given with Upgrade.
+type IteratorTrackerV2 interface {
+	IteratorTracker
+	RestoreWithStorage(iter.IteratorConstructor, StorageConstructor) ([]trie.NodeIterator, []trie.NodeIterator, error)
+	TrackedStorage(owner, storageRoot common.Hash, it trie.NodeIterator) trie.NodeIterator
+	Save() error
+	CloseAndSave() error
+}
+
+var _ IteratorTrackerV2 = &Tracker{}
+
+// Upgrade returns tr as an IteratorTrackerV2. If it does not implement IteratorTrackerV2 itself,
+// its methods are used where it has them, e.g. a Save method, and the adapter falls back otherwise:
+//   - RestoreWithStorage restores with Restore, so fails on state which includes storage iterators
+//     only if Restore does;
+//   - TrackedStorage tracks iterators with Tracked, without their storage trie;
+//   - Save and CloseAndSave do nothing.
+func Upgrade(tr IteratorTracker) IteratorTrackerV2 {
+	if v2, ok := tr.(IteratorTrackerV2); ok {
+		return v2
+	}
+	return trackerAdapter{tr}
+}
+
+type trackerAdapter struct {
+	IteratorTracker
+}
+
+func (a trackerAdapter) RestoreWithStorage(makeIterator iter.IteratorConstructor, makeStorageIterator StorageConstructor) (
+	[]trie.NodeIterator, []trie.NodeIterator, error,
+) {
+	if tr, ok := a.IteratorTracker.(interface {
+		RestoreWithStorage(iter.IteratorConstructor, StorageConstructor) ([]trie.NodeIterator, []trie.NodeIterator, error)
+	}); ok {
+		return tr.RestoreWithStorage(makeIterator, makeStorageIterator)
+	}
+	return a.Restore(makeIterator)
+}
+
+func (a trackerAdapter) TrackedStorage(owner, storageRoot common.Hash, it trie.NodeIterator) trie.NodeIterator {
+	if tr, ok := a.IteratorTracker.(interface {
+		TrackedStorage(common.Hash, common.Hash, trie.NodeIterator) trie.NodeIterator
+	}); ok {
+		return tr.TrackedStorage(owner, storageRoot, it)
+	}
+	return a.Tracked(it)
+}
+
+func (a trackerAdapter) Save() error {
+	if tr, ok := a.IteratorTracker.(interface{ Save() error }); ok {
+		return tr.Save()
+	}
+	return nil
+}
+
+func (a trackerAdapter) CloseAndSave() error {
+	if tr, ok := a.IteratorTracker.(interface{ CloseAndSave() error }); ok {
+		return tr.CloseAndSave()
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package tracker_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// minimalTracker implements only the original interface, as downstream mocks do.
+type minimalTracker struct {
+	tracked int
+}
+
+func (tr *minimalTracker) Restore(iter.IteratorConstructor) ([]trie.NodeIterator, []trie.NodeIterator, error) {
+	return nil, nil, nil
+}
+
+func (tr *minimalTracker) Tracked(it trie.NodeIterator) trie.NodeIterator {
+	tr.tracked++
+	return it
+}
+
+func TestUpgrade(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	tr := tracker.New(filepath.Join(t.TempDir(), "compat_test.csv"), 1)
+	if tracker.Upgrade(tr) != tracker.IteratorTrackerV2(tr) {
+		t.Fatal("expected Tracker to be returned as it is")
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mock minimalTracker
+	v2 := tracker.Upgrade(&mock)
+	// the upgraded tracker can be used where storage iterators are tracked
+	var _ iter.StorageTracker = v2
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2.TrackedStorage(common.Hash{1}, common.Hash{2}, it)
+	if mock.tracked != 1 {
+		t.Fatal("expected storage iterator to be tracked by Tracked")
+	}
+	if its, _, err := v2.RestoreWithStorage(tree.NodeIterator, nil); err != nil || len(its) != 0 {
+		t.Fatalf("expected nothing restored, got %d (%v)", len(its), err)
+	}
+	if err := v2.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := v2.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package tracker_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// Traverse a trie in parallel, interrupt the traversal, and resume it from the recovery file.
+func Example() {
+	dir, err := os.MkdirTemp("", "tracker-example")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	recoveryFile := filepath.Join(dir, "recovery.csv")
+
+	tree := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for i := 0; i < 1000; i++ {
+		tree.MustUpdate(crypto.Keccak256([]byte{byte(i >> 8), byte(i)}), []byte{1, byte(i)})
+	}
+
+	// traverse the bins concurrently, counting leaves, until a limit is reached
+	traverse := func(its []trie.NodeIterator, limit int) int {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		seen := 0
+		for _, it := range its {
+			wg.Add(1)
+			go func(it trie.NodeIterator) {
+				defer wg.Done()
+				for it.Next(true) {
+					if !it.Leaf() {
+						continue
+					}
+					mu.Lock()
+					if seen == limit {
+						mu.Unlock()
+						return // simulate an interruption
+					}
+					seen++
+					mu.Unlock()
+				}
+			}(it)
+		}
+		wg.Wait()
+		return seen
+	}
+
+	// first run: traversal is interrupted and the tracker saves each iterator's position
+	tr := tracker.New(recoveryFile, 8)
+	iters, err := iter.SubtrieIterators(tree.NodeIterator, 8)
+	if err != nil {
+		panic(err)
+	}
+	var tracked []trie.NodeIterator
+	for _, it := range iters {
+		tracked = append(tracked, tr.Tracked(it))
+	}
+	traverse(tracked, 100)
+	if err := tr.CloseAndSave(); err != nil {
+		panic(err)
+	}
+
+	// second run: restore the saved iterators and finish the traversal
+	tr = tracker.New(recoveryFile, 8)
+	restored, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("restored iterators:", len(restored))
+	traverse(restored, -1)
+	if err := tr.CloseAndSave(); err != nil {
+		panic(err)
+	}
+
+	_, err = os.Stat(recoveryFile)
+	fmt.Println("recovery file removed:", os.IsNotExist(err))
+	// Output:
+	// restored iterators: 8
+	// recovery file removed: true
+}
@@ -0,0 +1,105 @@
+package tracker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion is the version of the recovery file format written by FileStore. It is increased
+// whenever a file written in the new format would be misread by an older version of this package.
+// Files written before the format was versioned have no header, and are format 0, which is read
+// as format 1.
+const FormatVersion = 1
+
+// ErrIncompatibleFormat is matched by the FormatError returned when loading a recovery file written
+// in a format this version does not read.
+var ErrIncompatibleFormat = errors.New("incompatible recovery format")
+
+// FormatError is returned by FileStore.Load for a recovery file written by an incompatible version
+// of this package. Such a file can still be loaded, at the risk of misreading it, by a store
+// created WithForceMigrate.
+type FormatError struct {
+	// Path is the recovery file.
+	Path string
+	// Format is the format version of the file.
+	Format int
+	// Library is the version of this module which wrote the file, if known.
+	Library string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%v: %s has format %d (written by %s), but this version reads up to format %d",
+		ErrIncompatibleFormat, e.Path, e.Format, e.Library, FormatVersion)
+}
+
+func (e *FormatError) Is(target error) bool {
+	return target == ErrIncompatibleFormat
+}
+
+// LibraryVersion returns the version of this module in the running binary, as recorded in recovery
+// files, or "(devel)" if it was not built as a versioned dependency.
+func LibraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+const modulePath = "github.com/cerc-io/eth-iterator-utils"
+
+// appendHeader appends the header line of a recovery file. It is a CSV comment, which versions
+// predating the header fail to parse, rather than misreading.
+func appendHeader(buf []byte) []byte {
+	buf = append(buf, "#format="...)
+	buf = strconv.AppendInt(buf, FormatVersion, 10)
+	buf = append(append(buf, ",library="...), LibraryVersion()...)
+	return append(buf, '\n')
+}
+
+// readHeader reads the header line of a recovery file, if any, returning the format version and
+// producing library.
+func readHeader(in *bufio.Reader) (format int, library string, err error) {
+	if next, err := in.Peek(1); err != nil || next[0] != '#' {
+		if err == io.EOF {
+			err = nil
+		}
+		return 0, "unknown version", err
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	format = -1
+	for _, field := range strings.Split(strings.TrimSpace(line[1:]), ",") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "format":
+			if format, err = strconv.Atoi(value); err != nil {
+				return 0, "", fmt.Errorf("invalid recovery format %q", value)
+			}
+		case "library":
+			library = value
+		}
+	}
+	if format < 0 {
+		return 0, "", fmt.Errorf("recovery header has no format: %q", strings.TrimSpace(line))
+	}
+	return format, library, nil
+}
@@ -0,0 +1,79 @@
+package tracker_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestFileStoreFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "format_test.csv")
+	positions := []tracker.Position{{Path: []byte{1, 2}, EndPath: []byte{8}}}
+	load := func(contents string, opts ...tracker.FileStoreOption) ([]tracker.Position, error) {
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		store := tracker.NewFileStore(path, opts...)
+		defer store.Close()
+		return store.Load()
+	}
+
+	store := tracker.NewFileStore(path)
+	if err := store.Save(positions); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "#format=1,library=") {
+		t.Fatalf("expected format header, got %q", data)
+	}
+
+	// files predating the header are read as the current format
+	loaded, err := load("0102,08\n")
+	if err != nil || !reflect.DeepEqual(loaded, positions) {
+		t.Fatalf("failed to load unversioned file: %v %v", loaded, err)
+	}
+
+	newer := "#format=2,library=v9.0.0\n0102,08,,,,0,,extra\n"
+	_, err = load(newer)
+	var ferr *tracker.FormatError
+	if !errors.Is(err, tracker.ErrIncompatibleFormat) || !errors.As(err, &ferr) {
+		t.Fatalf("expected FormatError, got %v", err)
+	}
+	if ferr.Format != 2 || ferr.Library != "v9.0.0" || ferr.Path != path {
+		t.Fatalf("wrong FormatError: %+v", ferr)
+	}
+
+	// a forced migration ignores unknown columns, and saves in the current format
+	store = tracker.NewFileStore(path, tracker.WithForceMigrate())
+	defer store.Close()
+	if err := os.WriteFile(path, []byte(newer), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = store.Load()
+	if err != nil || !reflect.DeepEqual(loaded, positions) {
+		t.Fatalf("failed to migrate file: %v %v", loaded, err)
+	}
+	if err := store.Save(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasPrefix(string(data), "#format=1,") {
+		t.Fatalf("expected migrated file in current format, got %q", data)
+	}
+	store.Close()
+
+	if _, err := load("#library=v1\n0102,08\n"); err == nil {
+		t.Fatal("expected header without format to fail")
+	}
+	if _, err := load("#format=1,library=v1\n0102,zz\n"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package tracker
+
+// Directories can't be synced on every platform, e.g. on Windows, so renames and removals are
+// left to the filesystem.
+
+func syncDir(string) error { return nil }
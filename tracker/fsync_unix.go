@@ -0,0 +1,19 @@
+//go:build unix
+
+package tracker
+
+import "os"
+
+// syncDir commits the entries of a directory, e.g. a rename or removal within it, to stable
+// storage.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
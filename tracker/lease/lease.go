@@ -0,0 +1,300 @@
+// Package lease coordinates the ranges of a traversal across a fleet of workers, such as spot
+// instances which may disappear at any time.
+//
+// A Coordinator holds every range of the traversal, as a tracker.Position. Workers lease ranges
+// from it, and send heartbeats carrying the positions their iterators have reached. A worker which
+// stops sending heartbeats loses its leases once they expire, and its ranges are leased to other
+// workers from their last reported positions:
+//
+//	coord := lease.NewCoordinator(time.Minute, lease.WithStore(tracker.NewFileStore("ranges.csv")))
+//	coord.AddSubtries(256)
+//
+//	// on each worker
+//	w := lease.NewWorker(hostname, coord, 10*time.Second)
+//	defer w.Close()
+//	for {
+//		it, err := w.Acquire(makeIterator)
+//		if errors.Is(err, lease.ErrNoRanges) { break }
+//		for it.Next(true) { ... }
+//	}
+//
+// The protocol is at-least-once: nodes visited after a worker's last heartbeat are visited again
+// by the next worker to lease its range.
+package lease
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// ErrNoRanges is returned by Acquire when no range is available to lease. Ranges leased by other
+// workers may still become available, if those workers fail.
+var ErrNoRanges = errors.New("no ranges available")
+
+// ErrNotLeased is returned when a worker reports on a range it does not hold, e.g. because its
+// lease expired and the range was leased to another worker.
+var ErrNotLeased = errors.New("range is not leased by worker")
+
+// RangeID identifies a range of a traversal within its coordinator.
+type RangeID uint64
+
+// Range is a leased range of a traversal, and its current position.
+type Range struct {
+	ID       RangeID
+	Position tracker.Position
+}
+
+// Service is the coordinator side of the protocol, as used by workers. It is implemented by
+// Coordinator.
+type Service interface {
+	// Acquire leases the next available range to a worker.
+	Acquire(worker string) (Range, error)
+	// Heartbeat renews all leases held by a worker, recording the given positions of its ranges.
+	// Returns the IDs of any given ranges which the worker no longer holds.
+	Heartbeat(worker string, progress []Range) ([]RangeID, error)
+	// Complete marks a range held by a worker as finished.
+	Complete(worker string, id RangeID) error
+	// Release returns a range held by a worker to the coordinator, at the given position.
+	Release(worker string, progress Range) error
+}
+
+var _ Service = &Coordinator{}
+
+// Option configures a Coordinator.
+type Option func(*Coordinator)
+
+// WithStore saves the positions of unfinished ranges to a recovery store whenever they change,
+// so the coordinator itself can be restarted with Restore.
+func WithStore(store tracker.RecoveryStore) Option {
+	return func(c *Coordinator) {
+		c.store = store
+	}
+}
+
+// WithClock sets the source of the current time, which defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(c *Coordinator) {
+		c.now = now
+	}
+}
+
+// Coordinator leases the ranges of a traversal to workers. It is safe for concurrent use.
+type Coordinator struct {
+	ttl   time.Duration
+	store tracker.RecoveryStore
+	now   func() time.Time
+
+	ranges map[RangeID]*rangeState
+	nextID RangeID
+	mu     sync.Mutex // guards ranges and nextID, and serializes saves
+}
+
+type rangeState struct {
+	pos     tracker.Position
+	worker  string // holder of the lease, or empty if the range is available
+	expires time.Time
+	done    bool
+}
+
+// NewCoordinator returns a coordinator with no ranges, whose leases expire if not renewed by a
+// heartbeat within ttl.
+func NewCoordinator(ttl time.Duration, opts ...Option) *Coordinator {
+	c := &Coordinator{ttl: ttl, now: time.Now, ranges: map[RangeID]*rangeState{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Add adds ranges to be leased, returning their IDs.
+func (c *Coordinator) Add(positions ...tracker.Position) ([]RangeID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]RangeID, len(positions))
+	for i, pos := range positions {
+		ids[i] = c.add(pos)
+	}
+	return ids, c.save()
+}
+
+// AddSubtries adds the ranges of the `nbins` subtries which iter.SubtrieIterators would divide a
+// trie into.
+func (c *Coordinator) AddSubtries(nbins uint) ([]RangeID, error) {
+	starts, ends := iter.SubtrieBounds(nbins)
+	positions := make([]tracker.Position, len(starts))
+	for i := range starts {
+		positions[i] = tracker.Position{Path: starts[i], EndPath: ends[i]}
+	}
+	return c.Add(positions...)
+}
+
+// Restore adds the ranges saved to the coordinator's store, e.g. by a previous coordinator
+// process, returning their IDs. Ranges which were leased are available again.
+func (c *Coordinator) Restore() ([]RangeID, error) {
+	if c.store == nil {
+		return nil, errors.New("coordinator has no store")
+	}
+	positions, err := c.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]RangeID, len(positions))
+	for i, pos := range positions {
+		ids[i] = c.add(pos)
+	}
+	return ids, nil
+}
+
+func (c *Coordinator) add(pos tracker.Position) RangeID {
+	c.nextID++
+	c.ranges[c.nextID] = &rangeState{pos: pos}
+	return c.nextID
+}
+
+// Acquire leases the available range with the lowest ID to a worker. Ranges whose leases have
+// expired are available.
+func (c *Coordinator) Acquire(worker string) (Range, error) {
+	if worker == "" {
+		return Range{}, errors.New("empty worker ID")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	var ids []RangeID
+	for id, r := range c.ranges {
+		if !r.done && (r.worker == "" || now.After(r.expires)) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return Range{}, ErrNoRanges
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	r := c.ranges[ids[0]]
+	if r.worker != "" {
+		log.Info("Reassigning expired range lease", "range", ids[0], "from", r.worker, "to", worker)
+	}
+	r.worker, r.expires = worker, now.Add(c.ttl)
+	return Range{ID: ids[0], Position: r.pos}, nil
+}
+
+// Heartbeat renews all leases held by a worker, and records the positions of the given ranges.
+// Ranges which have expired but not been leased to another worker are renewed. Returns the IDs of
+// the given ranges which the worker no longer holds, which it must stop iterating.
+func (c *Coordinator) Heartbeat(worker string, progress []Range) ([]RangeID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, r := range c.ranges {
+		if r.worker == worker && !r.done {
+			r.expires = now.Add(c.ttl)
+		}
+	}
+	var lost []RangeID
+	for _, p := range progress {
+		r, err := c.held(worker, p.ID)
+		if err != nil {
+			lost = append(lost, p.ID)
+			continue
+		}
+		r.pos = p.Position
+	}
+	return lost, c.save()
+}
+
+// Complete marks a range held by a worker as finished, so it is never leased again.
+func (c *Coordinator) Complete(worker string, id RangeID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.held(worker, id)
+	if err != nil {
+		return err
+	}
+	r.done, r.worker = true, ""
+	return c.save()
+}
+
+// Release returns a range held by a worker, at the given position, so that it can be leased to
+// another worker immediately, e.g. when the worker shuts down gracefully.
+func (c *Coordinator) Release(worker string, progress Range) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.held(worker, progress.ID)
+	if err != nil {
+		return err
+	}
+	r.pos, r.worker = progress.Position, ""
+	return c.save()
+}
+
+// held returns a range if it is leased by the worker. A lease which has expired is still held
+// until the range is leased to another worker.
+func (c *Coordinator) held(worker string, id RangeID) (*rangeState, error) {
+	r, has := c.ranges[id]
+	if !has || r.done || r.worker != worker {
+		return nil, fmt.Errorf("%w: range %d, worker %q", ErrNotLeased, id, worker)
+	}
+	return r, nil
+}
+
+// Status describes a range of the traversal.
+type Status struct {
+	Range
+	// Worker holds the range's lease, or is empty if it is available or done.
+	Worker  string
+	Expires time.Time
+	Done    bool
+}
+
+// Status lists the ranges of the traversal, ordered by ID.
+func (c *Coordinator) Status() []Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var status []Status
+	for id, r := range c.ranges {
+		status = append(status, Status{Range{id, r.pos}, r.worker, r.expires, r.done})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].ID < status[j].ID })
+	return status
+}
+
+// Done returns whether every range has been completed.
+func (c *Coordinator) Done() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.ranges {
+		if !r.done {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes the positions of unfinished ranges to the store. It must be called with mu held.
+func (c *Coordinator) save() error {
+	if c.store == nil {
+		return nil
+	}
+	var ids []RangeID
+	for id, r := range c.ranges {
+		if !r.done {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	positions := make([]tracker.Position, len(ids))
+	for i, id := range ids {
+		positions[i] = c.ranges[id].pos
+	}
+	return c.store.Save(positions)
+}
@@ -0,0 +1,176 @@
+package lease_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+	"github.com/cerc-io/eth-iterator-utils/tracker/lease"
+)
+
+type fakeClock struct {
+	now time.Time
+	sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestReassignment(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	const ttl = time.Minute
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	coord := lease.NewCoordinator(ttl, lease.WithClock(clock.Now))
+	if _, err := coord.Add(tracker.Position{}); err != nil {
+		t.Fatal(err)
+	}
+	// heartbeats are sent explicitly
+	failing := lease.NewWorker("failing", coord, time.Hour)
+	defer failing.Close()
+	healthy := lease.NewWorker("healthy", coord, time.Hour)
+	defer healthy.Close()
+
+	it, err := failing.Acquire(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := healthy.Acquire(tree.NodeIterator); !errors.Is(err, lease.ErrNoRanges) {
+		t.Fatalf("expected ErrNoRanges, got %v", err)
+	}
+
+	var visited [][]byte
+	for i := 0; i < 100 && it.Next(true); i++ {
+		visited = append(visited, append([]byte(nil), it.Path()...))
+	}
+	if err := failing.Heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	// a renewed lease outlives the original TTL
+	clock.Advance(ttl / 2)
+	if err := failing.Heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(ttl)
+	if _, err := healthy.Acquire(tree.NodeIterator); !errors.Is(err, lease.ErrNoRanges) {
+		t.Fatalf("expected ErrNoRanges before expiry, got %v", err)
+	}
+	// nodes visited after the last heartbeat will be visited again
+	for i := 0; i < 100 && it.Next(true); i++ {
+	}
+
+	clock.Advance(ttl)
+	resumed, err := healthy.Acquire(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.ID() != it.ID() {
+		t.Fatalf("expected range %d to be reassigned, got %d", it.ID(), resumed.ID())
+	}
+	for resumed.Next(true) {
+		visited = append(visited, append([]byte(nil), resumed.Path()...))
+	}
+	if err := resumed.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if !coord.Done() {
+		t.Fatal("range not completed")
+	}
+
+	// the failed worker finds out at its next heartbeat
+	if err := failing.Heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	if it.Next(true) {
+		t.Fatal("iterator continued after its lease was lost")
+	}
+	if err := it.Error(); !errors.Is(err, lease.ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+
+	// every node is visited, in order, with at most one repeated
+	all := internal.FixtureNodePaths
+	ix := 0
+	for i, path := range visited {
+		if ix > 0 && i > 0 && bytes.Equal(path, visited[i-1]) {
+			continue
+		}
+		if ix >= len(all) || !bytes.Equal(path, all[ix]) {
+			t.Fatalf("wrong path at index %d: %v", ix, path)
+		}
+		ix++
+	}
+	if ix != len(all) {
+		t.Fatalf("expected %d paths, visited %d", len(all), ix)
+	}
+}
+
+func TestCoordinatorStore(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	recoveryFile := filepath.Join(t.TempDir(), "ranges.csv")
+	store := tracker.NewFileStore(recoveryFile)
+	coord := lease.NewCoordinator(time.Minute, lease.WithStore(store))
+	ids, err := coord.AddSubtries(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 4 {
+		t.Fatalf("expected 4 ranges, got %d", len(ids))
+	}
+
+	w := lease.NewWorker("worker", coord, time.Hour)
+	first, err := w.Acquire(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for first.Next(true) {
+	}
+	second, err := w.Acquire(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10 && second.Next(true); i++ {
+	}
+	path := append([]byte(nil), second.Path()...)
+	// closing the worker releases its lease at the current position
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	status := coord.Status()
+	if !status[0].Done || status[1].Worker != "" || !bytes.Equal(status[1].Position.Path, path) {
+		t.Fatalf("wrong status after release: %+v", status)
+	}
+
+	// a new coordinator picks up the unfinished ranges
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	restored := lease.NewCoordinator(time.Minute, lease.WithStore(tracker.NewFileStore(recoveryFile)))
+	ids, err = restored.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 restored ranges, got %d", len(ids))
+	}
+	if status := restored.Status(); !bytes.Equal(status[0].Position.Path, path) {
+		t.Fatalf("wrong restored position: %v", status[0].Position)
+	}
+}
@@ -0,0 +1,220 @@
+package lease
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// ErrLeaseLost is the error of an Iterator whose range was leased to another worker.
+var ErrLeaseLost = errors.New("range lease lost")
+
+// Worker leases ranges from a coordinator, and keeps them with a heartbeat reporting the
+// positions of their iterators.
+type Worker struct {
+	id       string
+	service  Service
+	interval time.Duration
+
+	leases map[RangeID]*Iterator
+	mu     sync.Mutex // guards leases
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewWorker returns a worker which sends a heartbeat to the service every interval, which should
+// be well within the coordinator's lease TTL.
+func NewWorker(id string, service Service, interval time.Duration) *Worker {
+	w := &Worker{
+		id:       id,
+		service:  service,
+		interval: interval,
+		leases:   map[RangeID]*Iterator{},
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.heartbeatLoop()
+	return w
+}
+
+// Iterator iterates a leased range. It stops with ErrLeaseLost if the lease is lost, and
+// completes the range when it is exhausted.
+type Iterator struct {
+	*iter.PrefixBoundIterator
+	worker *Worker
+	id     RangeID
+	pos    tracker.Position // the position when leased, whose bounds and trie are reported
+
+	err error // set once the lease is lost or can't be completed
+	sync.Mutex
+}
+
+// Acquire leases a range, and constructs an iterator resuming from its position. If the range is
+// of a storage trie, makeIterator must open that trie (see tracker.Position). Returns ErrNoRanges
+// if no range is available.
+func (w *Worker) Acquire(makeIterator iter.IteratorConstructor) (*Iterator, error) {
+	r, err := w.service.Acquire(w.id)
+	if err != nil {
+		return nil, err
+	}
+	it, err := tracker.OpenPosition(makeIterator, r.Position)
+	if err != nil {
+		// let another worker try
+		if rerr := w.service.Release(w.id, r); rerr != nil {
+			log.Error("Failed to release range lease", "range", r.ID, "err", rerr)
+		}
+		return nil, err
+	}
+	leased := &Iterator{PrefixBoundIterator: it, worker: w, id: r.ID, pos: r.Position}
+	w.mu.Lock()
+	w.leases[r.ID] = leased
+	w.mu.Unlock()
+	return leased, nil
+}
+
+// ID returns the ID of the leased range.
+func (it *Iterator) ID() RangeID {
+	return it.id
+}
+
+// Next advances the iterator, unless its lease has been lost. When the range is exhausted, it is
+// completed with the coordinator.
+func (it *Iterator) Next(descend bool) bool {
+	it.Lock()
+	if it.err != nil {
+		it.Unlock()
+		return false
+	}
+	ret := it.PrefixBoundIterator.Next(descend)
+	it.Unlock()
+
+	if !ret && it.PrefixBoundIterator.Error() == nil {
+		it.worker.complete(it)
+	}
+	return ret
+}
+
+// Unwrap returns the wrapped iterator.
+func (it *Iterator) Unwrap() trie.NodeIterator {
+	return it.PrefixBoundIterator
+}
+
+// Error returns ErrLeaseLost if the lease was lost, otherwise any error of the underlying
+// iterator.
+func (it *Iterator) Error() error {
+	it.Lock()
+	defer it.Unlock()
+	if it.err != nil {
+		return it.err
+	}
+	return it.PrefixBoundIterator.Error()
+}
+
+// position returns a copy of the iterator's current position.
+func (it *Iterator) position() Range {
+	it.Lock()
+	defer it.Unlock()
+	pos := it.pos
+	pos.Path = append([]byte(nil), it.Path()...)
+	return Range{ID: it.id, Position: pos}
+}
+
+func (it *Iterator) fail(err error) {
+	it.Lock()
+	defer it.Unlock()
+	if it.err == nil {
+		it.err = err
+	}
+}
+
+func (w *Worker) complete(it *Iterator) {
+	w.mu.Lock()
+	_, held := w.leases[it.id]
+	delete(w.leases, it.id)
+	w.mu.Unlock()
+	if !held {
+		return
+	}
+	if err := w.service.Complete(w.id, it.id); err != nil {
+		if errors.Is(err, ErrNotLeased) {
+			err = ErrLeaseLost
+		}
+		it.fail(err)
+	}
+}
+
+func (w *Worker) heartbeatLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+			if err := w.Heartbeat(); err != nil {
+				log.Error("Range lease heartbeat failed", "worker", w.id, "err", err)
+			}
+		}
+	}
+}
+
+// Heartbeat reports the positions of the worker's iterators to the coordinator, renewing their
+// leases. Iterators whose leases were lost are stopped. It is called periodically by the worker,
+// but can also be called directly.
+func (w *Worker) Heartbeat() error {
+	w.mu.Lock()
+	var progress []Range
+	for _, it := range w.leases {
+		progress = append(progress, it.position())
+	}
+	w.mu.Unlock()
+
+	lost, err := w.service.Heartbeat(w.id, progress)
+	if err != nil {
+		return err
+	}
+	for _, id := range lost {
+		w.mu.Lock()
+		it, held := w.leases[id]
+		delete(w.leases, id)
+		w.mu.Unlock()
+		if held {
+			log.Warn("Lost range lease", "worker", w.id, "range", id)
+			it.fail(ErrLeaseLost)
+		}
+	}
+	return nil
+}
+
+// Close stops the heartbeat, and releases the ranges of all unfinished iterators at their current
+// positions. The iterators are stopped with ErrLeaseLost.
+func (w *Worker) Close() error {
+	close(w.quit)
+	<-w.done
+
+	w.mu.Lock()
+	leases := w.leases
+	w.leases = map[RangeID]*Iterator{}
+	w.mu.Unlock()
+
+	var err error
+	for _, it := range leases {
+		progress := it.position()
+		it.fail(ErrLeaseLost)
+		if rerr := w.service.Release(w.id, progress); rerr != nil && !errors.Is(rerr, ErrNotLeased) {
+			log.Error("Failed to release range lease", "range", progress.ID, "err", rerr)
+			if err == nil {
+				err = rerr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tracker
+
+import "os"
+
+// Advisory locks are only supported on unix, so elsewhere recovery files are not locked.
+
+func lockFile(string) (*os.File, error) { return nil, nil }
+
+func unlockFile(*os.File) error { return nil }
@@ -0,0 +1,45 @@
+//go:build unix
+
+package tracker
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file at path, creating it if needed. The lock is only
+// held once the locked file is still the one at path, as a releasing holder removes it.
+func lockFile(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			file.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, ErrLocked
+			}
+			return nil, err
+		}
+		held, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err == nil && os.SameFile(held, current) {
+			return file, nil
+		}
+		file.Close()
+	}
+}
+
+// unlockFile removes and releases a file locked by lockFile.
+func unlockFile(file *os.File) error {
+	err := os.Remove(file.Name())
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Watch polls a store for the positions saved by the tracker of a run, calling fn with the
+// positions it holds at first and then each time they change, until ctx is done or fn returns an
+// error. The store should be opened for reading only, e.g. a FileStore WithReadOnly, so that the
+// run's tracker keeps its lock. Positions are only seen as often as the tracker saves them, e.g.
+// WithAutoCheckpoint, and no positions are passed once the run has finished. Positions are passed
+// ordered by their trie and path, as the tracker saves them in no particular order.
+//
+// Watch returns the error of fn or of the store, or that of ctx.
+func Watch(ctx context.Context, store RecoveryStore, interval time.Duration, fn func([]Position) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last []Position
+	for first := true; ; first = false {
+		positions, err := store.Load()
+		if err != nil {
+			return err
+		}
+		sortPositions(positions)
+		if first || !reflect.DeepEqual(positions, last) {
+			if err := fn(positions); err != nil {
+				return err
+			}
+			last = positions
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sortPositions orders positions by their trie, path, end path and kind.
+func sortPositions(positions []Position) {
+	sort.Slice(positions, func(i, j int) bool {
+		a, b := positions[i], positions[j]
+		if cmp := bytes.Compare(a.Owner[:], b.Owner[:]); cmp != 0 {
+			return cmp < 0
+		}
+		if cmp := bytes.Compare(a.Path, b.Path); cmp != 0 {
+			return cmp < 0
+		}
+		if cmp := bytes.Compare(a.EndPath, b.EndPath); cmp != 0 {
+			return cmp < 0
+		}
+		return a.Kind < b.Kind
+	})
+}
@@ -0,0 +1,107 @@
+package tracker_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recovery.csv")
+	primary := tracker.NewFileStore(path)
+	defer primary.Close()
+	saves := [][]tracker.Position{
+		{{Path: []byte{1}, EndPath: []byte{4}}, {Path: []byte{5}, EndPath: []byte{8}}},
+		{{Path: []byte{2}, EndPath: []byte{4}}, {Path: []byte{6}, EndPath: []byte{8}}},
+		nil, // the run finished
+	}
+	if err := primary.Save(saves[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the observer reads the positions while the primary holds the lock
+	observer := tracker.NewFileStore(path, tracker.WithReadOnly())
+	if err := observer.Save(saves[1]); !errors.Is(err, tracker.ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var seen [][]tracker.Position
+	done := errors.New("done")
+	err := tracker.Watch(ctx, observer, time.Millisecond, func(positions []tracker.Position) error {
+		seen = append(seen, positions)
+		if len(seen) == len(saves) {
+			return done
+		}
+		if err := primary.Save(saves[len(seen)]); err != nil {
+			t.Error(err)
+			return err
+		}
+		return nil
+	})
+	if !errors.Is(err, done) {
+		t.Fatalf("expected watch to end with the callback, got %v", err)
+	}
+	if !reflect.DeepEqual(saves, seen) {
+		t.Fatalf("watched wrong positions\nexpected:\t%v\nactual:\t\t%v", saves, seen)
+	}
+
+	// a watch is stopped by its context
+	cancel()
+	if err := tracker.Watch(ctx, observer, time.Millisecond, func([]tracker.Position) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// reorderingStore loads the same positions in a different order each time.
+type reorderingStore struct {
+	positions []tracker.Position
+	loads     int
+	cancel    func()
+}
+
+func (s *reorderingStore) Save([]tracker.Position) error { return nil }
+
+func (s *reorderingStore) Load() ([]tracker.Position, error) {
+	s.loads++
+	if s.loads == 20 {
+		s.cancel()
+	}
+	n := len(s.positions)
+	loaded := make([]tracker.Position, n)
+	for i := range s.positions {
+		loaded[(i+s.loads)%n] = s.positions[i]
+	}
+	return loaded, nil
+}
+
+func TestWatchOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &reorderingStore{cancel: cancel, positions: []tracker.Position{
+		{Path: []byte{1}, EndPath: []byte{4}},
+		{Path: []byte{5}, EndPath: []byte{8}},
+		{Path: []byte{2}, Owner: common.HexToHash("0x01")},
+	}}
+	var seen [][]tracker.Position
+	err := tracker.Watch(ctx, store, time.Millisecond, func(positions []tracker.Position) error {
+		seen = append(seen, positions)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(seen) != 1 {
+		t.Fatalf("expected unchanged positions to be passed once, got %d times", len(seen))
+	}
+	if expected := store.positions; !reflect.DeepEqual(expected, seen[0]) {
+		t.Fatalf("expected positions by trie and path\nexpected:\t%v\nactual:\t\t%v", expected, seen[0])
+	}
+}
@@ -0,0 +1,216 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+	"github.com/cerc-io/eth-iterator-utils/tracker/lease"
+)
+
+// DefaultClaimsTable is the default name of the table holding claimable ranges.
+const DefaultClaimsTable = "iterator_claims"
+
+// Claims is a table of the ranges of a job which workers claim one at a time, so that stateless
+// workers can pull work from the database without any local recovery state, e.g. when autoscaling:
+//
+//	claims := pgstore.NewClaims(db, pgstore.DefaultClaimsTable, "snapshot-20000000", time.Minute)
+//	r, err := claims.ClaimNextRange(workerID)
+//	it, err := tracker.OpenPosition(tree.NodeIterator, r.Position)
+//	for it.Next(true) {
+//		// ... periodically call claims.UpdateRange(workerID, ...)
+//	}
+//	err = claims.CompleteRange(r.ID)
+//
+// A claim expires unless renewed by UpdateRange within the TTL, and the range can then be claimed
+// by another worker from its last recorded position. Expiry is judged by the database's clock.
+type Claims struct {
+	db    *sql.DB
+	table string
+	jobID string
+	ttl   time.Duration
+}
+
+// NewClaims returns the claimable ranges of the given job in `table`, whose claims expire after
+// ttl.
+func NewClaims(db *sql.DB, table, jobID string, ttl time.Duration) *Claims {
+	return &Claims{db: db, table: quoteIdent(table), jobID: jobID, ttl: ttl}
+}
+
+// CreateTable creates the claims table if it does not exist.
+func (c *Claims) CreateTable(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		job_id       TEXT    NOT NULL,
+		range_id     BIGINT  NOT NULL,
+		path         BYTEA   NOT NULL,
+		end_path     BYTEA,
+		root         BYTEA,
+		owner        BYTEA,
+		storage_root BYTEA,
+		kind         SMALLINT NOT NULL DEFAULT 0,
+		worker       TEXT,
+		expires      TIMESTAMPTZ,
+		done         BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (job_id, range_id)
+	)`, c.table))
+	return err
+}
+
+// AddRanges adds ranges to the job, returning their IDs.
+func (c *Claims) AddRanges(ctx context.Context, positions []tracker.Position) ([]lease.RangeID, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// serialize additions to the job, so IDs are not reused
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, c.jobID); err != nil {
+		return nil, err
+	}
+	var next int64
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(
+		`SELECT COALESCE(MAX(range_id), 0) + 1 FROM %s WHERE job_id = $1`, c.table), c.jobID).Scan(&next)
+	if err != nil {
+		return nil, err
+	}
+	insert := fmt.Sprintf(
+		`INSERT INTO %s (job_id, range_id, path, end_path, root, owner, storage_root, kind)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		c.table)
+	ids := make([]lease.RangeID, len(positions))
+	for i, pos := range positions {
+		ids[i] = lease.RangeID(next + int64(i))
+		path, endPath := pathValues(pos)
+		if _, err = tx.ExecContext(ctx, insert, c.jobID, int64(ids[i]), path, endPath,
+			hashValue(pos.Root), hashValue(pos.Owner), hashValue(pos.StorageRoot), int(pos.Kind)); err != nil {
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
+}
+
+// ClaimNextRange claims the unfinished range with the lowest ID which is unclaimed or whose claim
+// has expired. A worker holds one range at a time: if it already holds one, that range is
+// returned and its claim renewed, so a worker which restarts under the same ID picks its range up
+// again. Returns lease.ErrNoRanges if no range can be claimed.
+func (c *Claims) ClaimNextRange(workerID string) (lease.Range, error) {
+	if workerID == "" {
+		return lease.Range{}, errors.New("empty worker ID")
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return lease.Range{}, err
+	}
+	defer tx.Rollback()
+
+	columns := `range_id, path, end_path, root, owner, storage_root, kind`
+	r, err := scanRange(tx.QueryRow(fmt.Sprintf(
+		`SELECT %s FROM %s WHERE job_id = $1 AND NOT done AND worker = $2
+		ORDER BY range_id LIMIT 1 FOR UPDATE`, columns, c.table),
+		c.jobID, workerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		r, err = scanRange(tx.QueryRow(fmt.Sprintf(
+			`SELECT %s FROM %s WHERE job_id = $1 AND NOT done AND (worker IS NULL OR expires < now())
+			ORDER BY range_id LIMIT 1 FOR UPDATE SKIP LOCKED`, columns, c.table),
+			c.jobID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return lease.Range{}, lease.ErrNoRanges
+		}
+	}
+	if err != nil {
+		return lease.Range{}, err
+	}
+	_, err = tx.Exec(fmt.Sprintf(
+		`UPDATE %s SET worker = $3, expires = now() + $4 * interval '1 microsecond'
+		WHERE job_id = $1 AND range_id = $2`, c.table),
+		c.jobID, int64(r.ID), workerID, c.ttl.Microseconds())
+	if err != nil {
+		return lease.Range{}, err
+	}
+	log.Debug("Claimed range", "table", c.table, "job", c.jobID, "range", r.ID, "worker", workerID)
+	return r, tx.Commit()
+}
+
+// UpdateRange records the position reached in a range claimed by a worker, and renews the claim.
+// Returns lease.ErrNotLeased if the worker no longer holds the range.
+func (c *Claims) UpdateRange(workerID string, r lease.Range) error {
+	path, endPath := pathValues(r.Position)
+	res, err := c.db.Exec(fmt.Sprintf(
+		`UPDATE %s SET path = $4, end_path = $5, expires = now() + $6 * interval '1 microsecond'
+		WHERE job_id = $1 AND range_id = $2 AND worker = $3 AND NOT done`, c.table),
+		c.jobID, int64(r.ID), workerID, path, endPath, c.ttl.Microseconds())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: range %d, worker %q", lease.ErrNotLeased, r.ID, workerID)
+	}
+	return nil
+}
+
+// CompleteRange marks a range as finished, so it is never claimed again. Completing a range more
+// than once is not an error, so may be retried.
+func (c *Claims) CompleteRange(id lease.RangeID) error {
+	res, err := c.db.Exec(fmt.Sprintf(
+		`UPDATE %s SET done = TRUE, worker = NULL, expires = NULL WHERE job_id = $1 AND range_id = $2`,
+		c.table),
+		c.jobID, int64(id))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("unknown range %d", id)
+	}
+	return nil
+}
+
+// Remaining returns the number of unfinished ranges of the job, claimed or not.
+func (c *Claims) Remaining() (int, error) {
+	var n int
+	err := c.db.QueryRow(fmt.Sprintf(
+		`SELECT COUNT(*) FROM %s WHERE job_id = $1 AND NOT done`, c.table), c.jobID).Scan(&n)
+	return n, err
+}
+
+func scanRange(row *sql.Row) (lease.Range, error) {
+	var r lease.Range
+	var id int64
+	var root, owner, storageRoot []byte
+	var kind int
+	if err := row.Scan(&id, &r.Position.Path, &r.Position.EndPath, &root, &owner, &storageRoot, &kind); err != nil {
+		return lease.Range{}, err
+	}
+	r.ID = lease.RangeID(id)
+	r.Position.Kind = tracker.PositionKind(kind)
+	r.Position.Root = common.BytesToHash(root)
+	r.Position.Owner = common.BytesToHash(owner)
+	r.Position.StorageRoot = common.BytesToHash(storageRoot)
+	if len(r.Position.Path) == 0 {
+		r.Position.Path = nil
+	}
+	return r, nil
+}
+
+// pathValues returns the column values of a position's paths. A NULL end path marks an unbounded
+// iterator or range.
+func pathValues(pos tracker.Position) (path []byte, endPath interface{}) {
+	path = pos.Path
+	if path == nil {
+		path = []byte{}
+	}
+	if pos.EndPath != nil {
+		endPath = pos.EndPath
+	}
+	return path, endPath
+}
@@ -0,0 +1,96 @@
+package pgstore_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+	"github.com/cerc-io/eth-iterator-utils/tracker/lease"
+	"github.com/cerc-io/eth-iterator-utils/tracker/pgstore"
+)
+
+func TestClaims(t *testing.T) {
+	dsn := os.Getenv("PGSTORE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("PGSTORE_TEST_DSN not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	const table = "pgstore_claims_test"
+	if _, err := db.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DROP TABLE IF EXISTS ` + table) })
+
+	const ttl = 100 * time.Millisecond
+	claims := pgstore.NewClaims(db, table, "job-a", ttl)
+	ctx := context.Background()
+	if err := claims.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	positions := []tracker.Position{
+		{Path: nil, EndPath: []byte{8}},
+		{Path: []byte{8, 0}, EndPath: nil},
+	}
+	ids, err := claims.AddRanges(ctx, positions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// claiming is idempotent for a worker
+	first, err := claims.ClaimNextRange("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := claims.ClaimNextRange("a"); err != nil || again.ID != first.ID {
+		t.Fatalf("expected worker to reclaim range %d, got %d (err: %v)", first.ID, again.ID, err)
+	}
+	if first.ID != ids[0] || !reflect.DeepEqual(first.Position, positions[0]) {
+		t.Fatalf("claimed wrong range: %+v", first)
+	}
+	second, err := claims.ClaimNextRange("b")
+	if err != nil || second.ID != ids[1] {
+		t.Fatalf("expected range %d, got %d (err: %v)", ids[1], second.ID, err)
+	}
+	if _, err := claims.ClaimNextRange("c"); !errors.Is(err, lease.ErrNoRanges) {
+		t.Fatalf("expected ErrNoRanges, got %v", err)
+	}
+
+	// an expired claim is taken over from its last recorded position
+	first.Position.Path = []byte{3, 4}
+	if err := claims.UpdateRange("a", first); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * ttl)
+	if err := claims.UpdateRange("b", second); err != nil {
+		t.Fatal(err)
+	}
+	taken, err := claims.ClaimNextRange("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taken.ID != first.ID || !reflect.DeepEqual(taken.Position, first.Position) {
+		t.Fatalf("took over wrong range: %+v", taken)
+	}
+	if err := claims.UpdateRange("a", first); !errors.Is(err, lease.ErrNotLeased) {
+		t.Fatalf("expected ErrNotLeased, got %v", err)
+	}
+
+	for _, id := range []lease.RangeID{taken.ID, second.ID, second.ID} {
+		if err := claims.CompleteRange(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := claims.Remaining(); err != nil || n != 0 {
+		t.Fatalf("expected no remaining ranges, got %d (err: %v)", n, err)
+	}
+}
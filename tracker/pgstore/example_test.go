@@ -0,0 +1,82 @@
+package pgstore_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	_ "github.com/lib/pq"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+	"github.com/cerc-io/eth-iterator-utils/tracker/pgstore"
+)
+
+// Traverse a trie in parallel, checkpointing to Postgres, so that a job interrupted e.g. by SIGINT
+// is resumed by running it again with the same job ID.
+func Example() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	tree := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for i := 0; i < 1000; i++ {
+		tree.MustUpdate(crypto.Keccak256([]byte{byte(i >> 8), byte(i)}), []byte{1, byte(i)})
+	}
+	root := tree.Hash()
+
+	db, err := sql.Open("postgres", os.Getenv("PGSTORE_DSN"))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	store := pgstore.New(db, pgstore.DefaultTable, "leaves-"+root.Hex())
+	if err := store.CreateTable(ctx); err != nil {
+		panic(err)
+	}
+	tr := tracker.NewWithStore(store, 16, tracker.WithRoot(root), tracker.WithAutoCheckpoint(time.Minute))
+
+	// resume from the saved positions, if there are any
+	restored, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		panic(err)
+	}
+	var leaves atomic.Int64
+	visit := func(it trie.NodeIterator) error {
+		for it.Next(true) {
+			if it.Leaf() {
+				leaves.Add(1) // or export the leaf
+			}
+		}
+		return it.Error()
+	}
+	if restored != nil {
+		iters := make([]trie.NodeIterator, len(restored))
+		for i, it := range restored {
+			iters[i] = it
+		}
+		err = iter.TraverseIterators(ctx, iters, 4, visit)
+	} else {
+		err = iter.Traverse(ctx, tree.NodeIterator, 16, 4, visit, iter.WithTracker(tr))
+	}
+
+	// saves the positions of unfinished bins, or clears the job's state once all are finished
+	if serr := tr.CloseAndSave(); serr != nil {
+		panic(serr)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("interrupted; run again to resume")
+	} else if err != nil {
+		panic(err)
+	}
+	fmt.Println("leaves visited:", leaves.Load())
+}
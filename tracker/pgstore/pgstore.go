@@ -0,0 +1,155 @@
+// Package pgstore provides a tracker.RecoveryStore which saves iterator positions to a PostgreSQL
+// table, keyed by job ID and iterator index.
+//
+// The store only depends on database/sql; callers register and open the driver of their choice:
+//
+//	db, err := sql.Open("postgres", dsn)
+//	store := pgstore.New(db, pgstore.DefaultTable, "snapshot-20000000")
+//	if err := store.CreateTable(ctx); err != nil { ... }
+//	tr := tracker.NewWithStore(store, 100)
+//
+// Claims keeps the ranges of a job in a table of their own, which stateless workers claim one at a
+// time.
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+// DefaultTable is the default name of the table holding recovery state.
+const DefaultTable = "iterator_recovery"
+
+var _ tracker.RecoveryStore = &Store{}
+
+// Store is a RecoveryStore backed by a PostgreSQL table. Multiple jobs may share one table.
+type Store struct {
+	db    *sql.DB
+	table string
+	jobID string
+}
+
+// New returns a store which saves the state of the given job into `table`.
+func New(db *sql.DB, table, jobID string) *Store {
+	return &Store{db: db, table: quoteIdent(table), jobID: jobID}
+}
+
+// CreateTable creates the recovery table if it does not exist, and adds any missing columns.
+func (s *Store) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		job_id         TEXT    NOT NULL,
+		iterator_index INTEGER NOT NULL,
+		path           BYTEA   NOT NULL,
+		end_path       BYTEA,
+		root           BYTEA,
+		owner          BYTEA,
+		storage_root   BYTEA,
+		kind           SMALLINT NOT NULL DEFAULT 0,
+		node_index     BIGINT   NOT NULL DEFAULT 0,
+		PRIMARY KEY (job_id, iterator_index)
+	)`, s.table))
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"root", "owner", "storage_root"} {
+		_, err = s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s BYTEA`, s.table, column))
+		if err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"kind SMALLINT", "node_index BIGINT"} {
+		_, err = s.db.ExecContext(ctx, fmt.Sprintf(
+			`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s NOT NULL DEFAULT 0`, s.table, column))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save replaces the job's saved positions in a single transaction.
+func (s *Store) Save(positions []tracker.Position) error {
+	log.Debug("Saving recovery state", "table", s.table, "job", s.jobID)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE job_id = $1`, s.table), s.jobID); err != nil {
+		return err
+	}
+	insert := fmt.Sprintf(
+		`INSERT INTO %s (job_id, iterator_index, path, end_path, root, owner, storage_root, kind, node_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		s.table)
+	for i, pos := range positions {
+		path, endPath := pathValues(pos)
+		if _, err = tx.Exec(insert, s.jobID, i, path, endPath,
+			hashValue(pos.Root), hashValue(pos.Owner), hashValue(pos.StorageRoot), int(pos.Kind), int64(pos.Index)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Load returns the job's saved positions in iterator index order.
+func (s *Store) Load() ([]tracker.Position, error) {
+	rows, err := s.db.Query(fmt.Sprintf(
+		`SELECT path, end_path, root, owner, storage_root, kind, node_index FROM %s
+		WHERE job_id = $1 ORDER BY iterator_index`, s.table),
+		s.jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []tracker.Position
+	for rows.Next() {
+		var pos tracker.Position
+		var root, owner, storageRoot []byte
+		var kind int
+		var index int64
+		if err := rows.Scan(&pos.Path, &pos.EndPath, &root, &owner, &storageRoot, &kind, &index); err != nil {
+			return nil, err
+		}
+		pos.Kind = tracker.PositionKind(kind)
+		pos.Index = uint64(index)
+		pos.Root = common.BytesToHash(root)
+		pos.Owner = common.BytesToHash(owner)
+		pos.StorageRoot = common.BytesToHash(storageRoot)
+		if len(pos.Path) == 0 {
+			pos.Path = nil
+		}
+		positions = append(positions, pos)
+	}
+	if len(positions) != 0 {
+		log.Debug("Restoring recovery state", "table", s.table, "job", s.jobID)
+	}
+	return positions, rows.Err()
+}
+
+// hashValue returns a column value for a hash, which is NULL for a zero hash.
+func hashValue(hash common.Hash) interface{} {
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return hash.Bytes()
+}
+
+// quoteIdent quotes a (possibly schema-qualified) SQL identifier.
+func quoteIdent(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
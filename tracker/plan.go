@@ -0,0 +1,67 @@
+package tracker
+
+import (
+	"errors"
+	"sync"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// PlannedBin is a range of a trie registered with a tracker before its iterator is constructed,
+// e.g. a bin of a traversal which is queued behind a limited pool of workers. Its range is saved
+// with the tracker's state until it is started, and restored by Restore as an iterator over the
+// whole range, so bins which never started are not lost.
+type PlannedBin struct {
+	tracker    *TrackerImpl
+	start, end []byte
+
+	started bool
+	mu      sync.Mutex // guards started
+}
+
+// Plan registers a range of the trie, from the start path up to the end path (as for
+// PrefixBoundIterator), to be iterated later with Start. A nil end leaves the range unbounded.
+func (tr *TrackerImpl) Plan(start, end []byte) *PlannedBin {
+	// odd paths are padded, so that the bin is restored without rewinding
+	bin := &PlannedBin{tracker: tr, start: iter.MinPathUnder(start), end: end}
+	tr.start(bin)
+	return bin
+}
+
+// PlanSubtries plans the bins that iter.SubtrieIterators would divide a trie into.
+func (tr *TrackerImpl) PlanSubtries(nbins uint) []*PlannedBin {
+	starts, ends := iter.SubtrieBounds(nbins)
+	bins := make([]*PlannedBin, len(starts))
+	for i := range starts {
+		bins[i] = tr.Plan(starts[i], ends[i])
+	}
+	return bins
+}
+
+// Bounds returns the start and end paths of the bin.
+func (bin *PlannedBin) Bounds() ([]byte, []byte) {
+	return bin.start, bin.end
+}
+
+// Start constructs a tracked iterator over the bin's range, which takes the place of the plan.
+// A bin can only be started once.
+func (bin *PlannedBin) Start(makeIterator iter.IteratorConstructor) (*Iterator, error) {
+	bin.mu.Lock()
+	defer bin.mu.Unlock()
+	if bin.started {
+		return nil, errors.New("planned bin already started")
+	}
+	it, err := makeIterator(iter.HexToKeyBytes(bin.start))
+	if err != nil {
+		return nil, err
+	}
+	// the iterator is registered before the plan is released, so the range is never unsaved
+	tracked := bin.tracker.Tracked(iter.NewPrefixBoundIterator(it, bin.end))
+	bin.tracker.stop(bin)
+	bin.started = true
+	return tracked, nil
+}
+
+func (bin *PlannedBin) position() Position {
+	return Position{Path: bin.start, EndPath: bin.end}
+}
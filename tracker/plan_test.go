@@ -0,0 +1,57 @@
+package tracker_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestPlannedBins(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+	recoveryFile := filepath.Join(t.TempDir(), "plan_test.csv")
+
+	tr := tracker.New(recoveryFile, 1)
+	bins := tr.PlanSubtries(4)
+	it, err := bins[1].Start(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bins[1].Start(tree.NodeIterator); err == nil {
+		t.Fatal("expected error starting bin twice")
+	}
+	leaves := 0
+	for it.Next(true) {
+		if it.Leaf() {
+			leaves++
+		}
+	}
+	// the other bins are saved though they never started
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr = tracker.New(recoveryFile, 1)
+	restored, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 3 {
+		t.Fatalf("expected 3 restored bins, got %d", len(restored))
+	}
+	for _, it := range restored {
+		for it.Next(true) {
+			if it.Leaf() {
+				leaves++
+			}
+		}
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if leaves != len(internal.FixtureLeafKeys) {
+		t.Fatalf("expected %d leaves, got %d", len(internal.FixtureLeafKeys), leaves)
+	}
+}
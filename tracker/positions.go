@@ -0,0 +1,99 @@
+package tracker
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// IteratorState is the state of a tracked iterator, as reported by Positions.
+type IteratorState uint8
+
+const (
+	// Planned is a bin registered with Plan which has not been started.
+	Planned IteratorState = iota
+	// Running is an iterator which has not finished. It may have stopped with an error.
+	Running
+	// Finished is an iterator which was exhausted.
+	Finished
+)
+
+func (s IteratorState) String() string {
+	switch s {
+	case Planned:
+		return "planned"
+	case Running:
+		return "running"
+	case Finished:
+		return "finished"
+	}
+	return "unknown"
+}
+
+// WithFinishedPositions makes Positions report the positions of up to limit of the iterators which
+// finished most recently. By default none are kept, as a traversal of storage tries finishes an
+// iterator for each of them; Stats counts finished iterators either way.
+func WithFinishedPositions(limit int) Option {
+	return func(tr *TrackerImpl) {
+		tr.finishedMax = limit
+	}
+}
+
+// addFinished counts a finished iterator, replacing the oldest of the positions kept once there
+// are finishedMax. It must be called with stateMu held.
+func (tr *TrackerImpl) addFinished(pos Position) {
+	if tr.finishedMax > 0 {
+		if len(tr.finished) < tr.finishedMax {
+			tr.finished = append(tr.finished, pos)
+		} else {
+			tr.finished[tr.finishedN%uint64(tr.finishedMax)] = pos
+		}
+	}
+	tr.finishedN++
+}
+
+// LivePosition is the current position of a tracked iterator, and its state.
+type LivePosition struct {
+	Position
+	State IteratorState
+}
+
+// Positions returns the current positions of all iterators which have not finished, and of those
+// which have finished if kept by WithFinishedPositions, ordered by their trie and path. Unlike Checkpoint, the recovery store is not used,
+// so it can be called as often as needed, e.g. to display the progress of each worker. Positions
+// are read as for Checkpoint, so it is safe to call while the iterators run.
+func (tr *TrackerImpl) Positions() []LivePosition {
+	tr.stateMu.Lock()
+	defer tr.stateMu.Unlock()
+	tr.drain()
+
+	positions := make([]LivePosition, 0, len(tr.started)+len(tr.finished))
+	add := func(pos Position, state IteratorState) {
+		if pos.Root == (common.Hash{}) {
+			pos.Root = tr.root
+		}
+		positions = append(positions, LivePosition{Position: pos, State: state})
+	}
+	for it := range tr.started {
+		if _, planned := it.(*PlannedBin); planned {
+			add(it.position(), Planned)
+		} else {
+			add(it.position(), Running)
+		}
+	}
+	for _, pos := range tr.finished {
+		add(pos, Finished)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		a, b := positions[i], positions[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if cmp := bytes.Compare(a.Owner[:], b.Owner[:]); cmp != 0 {
+			return cmp < 0
+		}
+		return bytes.Compare(a.Path, b.Path) < 0
+	})
+	return positions
+}
@@ -0,0 +1,126 @@
+package tracker_test
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestPositions(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+	recoveryFile := filepath.Join(t.TempDir(), "positions_test.csv")
+
+	tr := tracker.New(recoveryFile, 4, tracker.WithFinishedPositions(4))
+	defer tr.CloseAndSave()
+	bins := tr.PlanSubtries(4)
+	finished, err := bins[0].Start(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for finished.Next(true) {
+	}
+	running, err := bins[1].Start(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10 && running.Next(true); i++ {
+	}
+
+	counts := map[tracker.IteratorState]int{}
+	for _, pos := range tr.Positions() {
+		counts[pos.State]++
+		if pos.State == tracker.Running && string(pos.Path) != string(running.Path()) {
+			t.Fatalf("wrong path for running iterator: %x, expected %x", pos.Path, running.Path())
+		}
+	}
+	expected := map[tracker.IteratorState]int{tracker.Planned: 2, tracker.Running: 1, tracker.Finished: 1}
+	for state, n := range expected {
+		if counts[state] != n {
+			t.Fatalf("expected %d %s iterators, got %d", n, state, counts[state])
+		}
+	}
+	if fileExists(recoveryFile) {
+		t.Fatal("expected positions to be read without saving")
+	}
+}
+
+func TestPositionsReleaseFinished(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	tr := tracker.New(filepath.Join(t.TempDir(), "positions_test.csv"), 4, tracker.WithFinishedPositions(1))
+	defer tr.CloseAndSave()
+	released := make(chan struct{})
+	func() {
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := tr.Tracked(nodeit)
+		runtime.SetFinalizer(it, func(*tracker.Iterator) { close(released) })
+		for it.Next(true) {
+		}
+	}()
+	tr.Positions() // collects the stop
+
+	// the tracker keeps the position of a finished iterator, not the iterator
+	for i := 0; ; i++ {
+		runtime.GC()
+		select {
+		case <-released:
+		case <-time.After(10 * time.Millisecond):
+			if i < 100 {
+				continue
+			}
+			t.Fatal("finished iterator is still referenced by the tracker")
+		}
+		break
+	}
+	positions := tr.Positions()
+	if len(positions) != 1 || positions[0].State != tracker.Finished {
+		t.Fatalf("expected one finished position, got %v", positions)
+	}
+}
+
+func TestPositionsFinishedLimit(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	finish := func(tr *tracker.Tracker, count int) {
+		for i := 0; i < count; i++ {
+			nodeit, err := tree.NodeIterator(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for it := tr.Tracked(nodeit); it.Next(true); {
+			}
+		}
+	}
+
+	// by default finished iterators are only counted
+	tr := tracker.New(filepath.Join(t.TempDir(), "positions_test.csv"), 4)
+	defer tr.CloseAndSave()
+	finish(tr, 3)
+	if positions := tr.Positions(); len(positions) != 0 {
+		t.Fatalf("expected no positions, got %v", positions)
+	}
+	if stats := tr.Stats(); stats.Finished != 3 {
+		t.Fatalf("expected 3 finished iterators, got %d", stats.Finished)
+	}
+
+	tr = tracker.New(filepath.Join(t.TempDir(), "positions_test.csv"), 4, tracker.WithFinishedPositions(2))
+	defer tr.CloseAndSave()
+	finish(tr, 5)
+	positions := tr.Positions()
+	if len(positions) != 2 {
+		t.Fatalf("expected 2 finished positions, got %v", positions)
+	}
+	if stats := tr.Stats(); stats.Finished != 5 {
+		t.Fatalf("expected 5 finished iterators, got %d", stats.Finished)
+	}
+}
@@ -0,0 +1,180 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var runFilePattern = regexp.MustCompile(`^recovery-0x([0-9a-f]{64})-run(.+)\.csv$`)
+
+// Run identifies the saved recovery state of one traversal job.
+type Run struct {
+	// Root is the root hash of the trie being traversed.
+	Root common.Hash
+	// ID is the run ID the job was started with.
+	ID string
+	// Path is the path of the recovery file.
+	Path string
+}
+
+// RecoveryFileName returns the name of the recovery file for a run over the given root, e.g.
+// "recovery-0xabc...-run42.csv". The run ID must not contain path separators.
+func RecoveryFileName(root common.Hash, runID string) string {
+	return fmt.Sprintf("recovery-%s-run%s.csv", root.Hex(), runID)
+}
+
+// NewForRun creates a tracker which saves state to a file in dir named for the root and run ID, so
+// that concurrent jobs can share a directory without overwriting each other's state. The tracker
+// is configured WithRoot(root).
+func NewForRun(dir string, root common.Hash, runID string, bufsize uint, opts ...Option) *Tracker {
+	file := filepath.Join(dir, RecoveryFileName(root, runID))
+	return New(file, bufsize, append([]Option{WithRoot(root)}, opts...)...)
+}
+
+// FindRuns lists the runs with saved recovery state in dir, i.e. the runs which can be resumed by
+// passing their root and ID to NewForRun. Runs are ordered by file name.
+func FindRuns(dir string) ([]Run, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var runs []Run
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		match := runFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		runs = append(runs, Run{
+			Root: common.HexToHash(match[1]),
+			ID:   match[2],
+			Path: filepath.Join(dir, entry.Name()),
+		})
+	}
+	return runs, nil
+}
+
+// SeekIndexFile returns the conventional path of the seek index of a recovery file, e.g. for
+// WithSeekIndex(SeekIndexFile(file), reader). Cleanup removes an index at this path with its run.
+func SeekIndexFile(recoveryFile string) string {
+	return recoveryFile + ".seek"
+}
+
+// runArtifact returns the recovery file of the run which the file named name belongs to: the
+// recovery file itself, its lock, its seek index at SeekIndexFile, or a temporary file left by an
+// interrupted save of either.
+func runArtifact(name string) (string, bool) {
+	base := strings.TrimSuffix(name, ".tmp")
+	if trimmed := strings.TrimSuffix(base, ".seek"); trimmed != base {
+		base = trimmed
+	} else if name == base {
+		base = strings.TrimSuffix(base, ".lock")
+	}
+	return base, runFilePattern.MatchString(base)
+}
+
+// FindStale lists the recovery artifacts in dir which were last modified more than olderThan ago:
+// the recovery files of runs as found by FindRuns, their lock files and seek indexes at
+// SeekIndexFile, and temporary files left by interrupted saves. Other files are never listed.
+//
+// A run's file is only rewritten when its state is saved, so olderThan must exceed the interval
+// between saves of a live run: the checkpoint interval for runs using WithAutoCheckpoint, or the
+// longest expected run duration otherwise. Cleanup also skips the runs held by a live tracker.
+func FindStale(dir string, olderThan time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var stale []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if _, ok := runArtifact(entry.Name()); !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Before(cutoff) {
+			stale = append(stale, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return stale, nil
+}
+
+// Cleanup removes the stale recovery artifacts in dir, as listed by FindStale, and returns the
+// paths of the removed files. The artifacts of each run are removed under the lock of its recovery
+// file, which is then removed too. On unix, a run whose recovery file is locked by a live tracker
+// is skipped whatever its age, as are the artifacts of a run saved again since they were listed.
+func Cleanup(dir string, olderThan time.Duration) ([]string, error) {
+	stale, err := FindStale(dir, olderThan)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var files []string
+	runs := map[string][]string{}
+	for _, path := range stale {
+		file, _ := runArtifact(filepath.Base(path))
+		file = filepath.Join(dir, file)
+		if _, ok := runs[file]; !ok {
+			files = append(files, file)
+		}
+		runs[file] = append(runs[file], path)
+	}
+	var removed []string
+	for _, file := range files {
+		paths, err := removeStale(file, runs[file], cutoff)
+		removed = append(removed, paths...)
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// removeStale removes the artifacts of a run under the lock of its recovery file, unless the lock
+// is held, and returns the paths removed. Artifacts modified after cutoff are kept.
+func removeStale(file string, paths []string, cutoff time.Time) ([]string, error) {
+	lockPath := file + ".lock"
+	lock, err := lockFile(lockPath)
+	if errors.Is(err, ErrLocked) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// the lock file is removed on release
+	defer unlockFile(lock)
+
+	var removed []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return removed, err
+		}
+		if !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
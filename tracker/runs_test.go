@@ -0,0 +1,185 @@
+package tracker_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestFindRuns(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	dir := t.TempDir()
+	// unrelated files are ignored
+	if err := os.WriteFile(filepath.Join(dir, "recovery.csv"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// interrupt two runs over the same root, and complete a third
+	for _, runID := range []string{"1", "2", "3"} {
+		tr := tracker.NewForRun(dir, tree.Hash(), runID, 1)
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := tr.Tracked(nodeit)
+		it.Next(true)
+		if runID == "3" {
+			for it.Next(true) {
+			}
+		}
+		if err := tr.CloseAndSave(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runs, err := tracker.FindRuns(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []tracker.Run
+	for _, runID := range []string{"1", "2"} {
+		expected = append(expected, tracker.Run{
+			Root: tree.Hash(),
+			ID:   runID,
+			Path: filepath.Join(dir, tracker.RecoveryFileName(tree.Hash(), runID)),
+		})
+	}
+	if !reflect.DeepEqual(expected, runs) {
+		t.Fatalf("found wrong runs\nexpected:\t%v\nactual:\t\t%v", expected, runs)
+	}
+
+	// a run can only be resumed over its own root
+	tr := tracker.NewForRun(dir, common.HexToHash("0x01"), "1", 1)
+	if its, _, err := tr.Restore(tree.NodeIterator); err != nil || len(its) != 0 {
+		t.Fatalf("expected no state for a different root, got %d iterators, err: %v", len(its), err)
+	}
+	tr = tracker.NewForRun(dir, runs[0].Root, runs[0].ID, 1)
+	its, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(its) != 1 {
+		t.Fatalf("expected to restore 1 iterator, got %d", len(its))
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	dir := t.TempDir()
+	root := common.HexToHash("0x01")
+	old := time.Now().Add(-48 * time.Hour)
+	write := func(name string, modTime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	staleRun := write(tracker.RecoveryFileName(root, "1"), old)
+	staleLock := write(tracker.RecoveryFileName(root, "1")+".lock", old)
+	staleSeek := write(tracker.SeekIndexFile(tracker.RecoveryFileName(root, "1")), old)
+	staleSeekTmp := write(tracker.SeekIndexFile(tracker.RecoveryFileName(root, "1"))+".tmp", old)
+	staleTmp := write(tracker.RecoveryFileName(root, "2")+".tmp", old)
+	write(tracker.RecoveryFileName(root, "3"), time.Now())
+	write(tracker.SeekIndexFile(tracker.RecoveryFileName(root, "3")), time.Now())
+	// the lock of a run whose recovery file is gone
+	orphanLock := write(tracker.RecoveryFileName(root, "4")+".lock", old)
+	// files which are not recovery artifacts are kept regardless of age
+	write("recovery.csv", old)
+	write("recovery.csv.lock", old)
+	write("notes.txt", old)
+
+	expected := []string{staleRun, staleLock, staleSeek, staleSeekTmp, staleTmp, orphanLock}
+	stale, err := tracker.FindStale(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, stale) {
+		t.Fatalf("found wrong stale files\nexpected:\t%v\nactual:\t\t%v", expected, stale)
+	}
+
+	removed, err := tracker.Cleanup(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, removed) {
+		t.Fatalf("removed wrong files\nexpected:\t%v\nactual:\t\t%v", expected, removed)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 files to remain, got %d", len(entries))
+	}
+	runs, err := tracker.FindRuns(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ID != "3" {
+		t.Fatalf("expected only run 3 to remain, got %v", runs)
+	}
+}
+
+func TestCleanupLocked(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("recovery files are only locked on unix")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, tracker.RecoveryFileName(common.HexToHash("0x01"), "1"))
+	store := tracker.NewFileStore(path)
+	defer store.Close()
+	if err := store.Save([]tracker.Position{{Path: []byte{1}}}); err != nil {
+		t.Fatal(err)
+	}
+	seek := tracker.SeekIndexFile(path)
+	if err := os.WriteFile(seek, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, file := range []string{path, path + ".lock", seek} {
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the store holds the lock, so the run is live whatever its age
+	removed, err := tracker.Cleanup(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("expected locked run to be kept, removed %v", removed)
+	}
+	for _, file := range []string{path, path + ".lock", seek} {
+		if _, err := os.Stat(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	removed, err = tracker.Cleanup(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{path, seek}, removed) {
+		t.Fatalf("expected released run to be removed, got %v", removed)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/sync/errgroup"
+)
+
+// seekWorkers is the number of positions whose indexed nodes are read concurrently on restore.
+const seekWorkers = 16
+
+// ErrTrieChanged is returned by Restore when a node recorded in the seek index is missing from the
+// trie, or has changed, so the saved positions no longer describe it.
+var ErrTrieChanged = errors.New("trie changed since the checkpoint")
+
+// WithSeekIndex makes the tracker record the hashed nodes which each tracked trie iterator has
+// visited on the path to its position, and save them with each checkpoint to a sidecar file at
+// path. The nodes above the start of a bin are not visited by its iterator, so are only indexed
+// for iterators which were restored with the index.
+// Restore then reads the nodes of each saved position with reader, concurrently, before reopening
+// its iterator: this verifies that the trie is unchanged, failing with ErrTrieChanged if it is not,
+// and warms the database's caches for the iterator's descent to its position, which is otherwise
+// made one node at a time. This speeds up frequent pause and resume cycles.
+//
+// The reader returns the node stored at a path of a trie, or nil if there is none. A reader which
+// looks nodes up by hash, as for a hash-based trie database, only detects missing nodes. Failing
+// to save the index is logged, and does not fail the checkpoint; positions which are not indexed
+// are restored without verification. An index at SeekIndexFile(file) is cleaned up with its run.
+func WithSeekIndex(path string, reader trie.NodeResolver) Option {
+	return func(tr *TrackerImpl) {
+		tr.seekIndex = path
+		tr.seekReader = reader
+	}
+}
+
+// seekNode is a hashed node on the path to a saved position.
+type seekNode struct {
+	Path hexutil.Bytes `json:"path"`
+	Hash common.Hash   `json:"hash"`
+}
+
+// seekEntry is the indexed path of a saved position. Its end path is not recorded, as it does not
+// change the descent to the position.
+type seekEntry struct {
+	Owner common.Hash   `json:"owner"`
+	Path  hexutil.Bytes `json:"position"`
+	Nodes []seekNode    `json:"nodes"`
+}
+
+func (e *seekEntry) matches(pos Position) bool {
+	return e.Owner == pos.Owner && bytes.Equal(e.Path, pos.Path)
+}
+
+// appendSeekNode updates the path of hashed nodes leading to an iterator's current node, which is
+// added if it is hashed.
+func appendSeekNode(nodes []seekNode, it trie.NodeIterator) []seekNode {
+	path := it.Path()
+	for len(nodes) > 0 {
+		top := nodes[len(nodes)-1].Path
+		if len(top) < len(path) && bytes.HasPrefix(path, top) {
+			break
+		}
+		nodes = nodes[:len(nodes)-1]
+	}
+	if hash := it.Hash(); hash != (common.Hash{}) {
+		nodes = append(nodes, seekNode{Path: append([]byte(nil), path...), Hash: hash})
+	}
+	return nodes
+}
+
+// saveSeekIndex replaces the seek index with the entries of a snapshot. It must be called with
+// storeMu held, after the snapshot's positions are saved.
+func (tr *TrackerImpl) saveSeekIndex(entries []seekEntry) {
+	var err error
+	if len(entries) == 0 {
+		if err = os.Remove(tr.seekIndex); os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = writeFileAtomic(tr.seekIndex, func(file *os.File) error {
+			return json.NewEncoder(file).Encode(entries)
+		})
+	}
+	if err != nil {
+		log.Warn("Failed to save seek index", "path", tr.seekIndex, "err", err)
+	}
+}
+
+// loadSeekIndex reads the seek index, or returns nil if there is none or it can't be read.
+func (tr *TrackerImpl) loadSeekIndex() []seekEntry {
+	data, err := os.ReadFile(tr.seekIndex)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	var entries []seekEntry
+	if err == nil {
+		err = json.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		log.Warn("Failed to load seek index, restoring without it", "path", tr.seekIndex, "err", err)
+		return nil
+	}
+	return entries
+}
+
+// verifySeekIndex reads the indexed nodes of the positions being restored, concurrently, and
+// checks that they are unchanged. Returns the indexed nodes of each position, to keep indexing
+// them.
+func (tr *TrackerImpl) verifySeekIndex(positions []Position) ([][]seekNode, error) {
+	nodes := make([][]seekNode, len(positions))
+	if tr.seekIndex == "" || tr.seekReader == nil {
+		return nodes, nil
+	}
+	entries := tr.loadSeekIndex()
+	var group errgroup.Group
+	group.SetLimit(seekWorkers)
+	for p, pos := range positions {
+		for i := range entries {
+			entry := &entries[i]
+			if !entry.matches(pos) {
+				continue
+			}
+			nodes[p] = entry.Nodes
+			group.Go(func() error {
+				for _, node := range entry.Nodes {
+					blob := tr.seekReader(entry.Owner, node.Path, node.Hash)
+					if blob == nil || crypto.Keccak256Hash(blob) != node.Hash {
+						return fmt.Errorf("%w: node %x at path %x of position %x", ErrTrieChanged,
+							node.Hash, []byte(node.Path), []byte(entry.Path))
+					}
+				}
+				return nil
+			})
+			break
+		}
+	}
+	return nodes, group.Wait()
+}
@@ -0,0 +1,90 @@
+package tracker_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/trie"
+
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestSeekIndex(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	dir := t.TempDir()
+	recoveryFile := filepath.Join(dir, "recovery.csv")
+	indexFile := filepath.Join(dir, "recovery.seek")
+
+	var mu sync.Mutex
+	var read map[string]common.Hash // hashes read by path
+	reader := func(_ common.Hash, path []byte, hash common.Hash) []byte {
+		mu.Lock()
+		read[string(path)] = hash
+		mu.Unlock()
+		return rawdb.ReadLegacyTrieNode(edb, hash)
+	}
+
+	// advances the restored iterator, or a new one, by count nodes, and saves its position
+	advance := func(count int) []byte {
+		read = map[string]common.Hash{}
+		tr := tracker.New(recoveryFile, 1, tracker.WithSeekIndex(indexFile, reader))
+		restored, _, err := tr.Restore(tree.NodeIterator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var it trie.NodeIterator
+		if restored != nil {
+			it = restored[0]
+		} else {
+			nodeit, err := tree.NodeIterator(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			it = tr.Tracked(nodeit)
+		}
+		for i := 0; i < count && it.Next(true); i++ {
+		}
+		path := append([]byte(nil), it.Path()...)
+		if err := tr.CloseAndSave(); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := advance(50)
+	if _, err := os.Stat(indexFile); err != nil {
+		t.Fatalf("expected a seek index: %v", err)
+	}
+	advance(50)
+	if read[""] != tree.Hash() {
+		t.Fatalf("expected the root to be read on restore, read %v", read)
+	}
+	for p := range read {
+		if !bytes.HasPrefix(path, []byte(p)) {
+			t.Fatalf("read node at %x, not on the path to the position %x", p, path)
+		}
+	}
+
+	// the root is not visited by the restored iterator, but stays indexed
+	advance(50)
+	if read[""] != tree.Hash() {
+		t.Fatalf("expected the root to be read on the second restore, read %v", read)
+	}
+
+	// a changed trie fails the restore
+	reader = func(common.Hash, []byte, common.Hash) []byte { return []byte("changed") }
+	tr := tracker.New(recoveryFile, 1, tracker.WithSeekIndex(indexFile, reader))
+	defer tr.CloseAndSave()
+	if _, _, err := tr.Restore(tree.NodeIterator); !errors.Is(err, tracker.ErrTrieChanged) {
+		t.Fatalf("expected ErrTrieChanged, got %v", err)
+	}
+}
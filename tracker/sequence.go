@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"bytes"
+)
+
+// WithSequence makes tracked trie iterators number the nodes they yield, so that each node
+// emitted by a traversal is identified by its bin and index, e.g. for a consumer to drop the
+// records it already ingested. The index is saved with each position, and a restored iterator
+// skips the nodes it yielded before its position was saved, rather than rewinding to them, so that
+// the node at the position keeps its index. The same nodes get the same indexes across resumes as
+// long as the tracker is made WithSequence each time, and the bins are visited with Next(true).
+//
+// A bin is identified by its end path, which is nil for the last bin. Storage iterators number
+// their nodes in the same way, and are told apart by their owner.
+func WithSequence() Option {
+	return func(tr *TrackerImpl) {
+		tr.sequence = true
+	}
+}
+
+// Sequence returns the end path of the iterator's bin, and the index of its current node in the
+// bin, counting from 1. It returns false if the tracker was not made WithSequence.
+func (it *Iterator) Sequence() (bin []byte, index uint64, ok bool) {
+	it.Lock()
+	defer it.Unlock()
+	if !it.tracker.sequence {
+		return nil, 0, false
+	}
+	_, bin = it.Bounds()
+	return bin, it.index, true
+}
+
+// advance moves the wrapped iterator to its next node, skipping those before the node a restored
+// iterator resumes at. It must be called with the iterator locked.
+func (it *Iterator) advance(descend bool) bool {
+	for it.NodeIterator.Next(descend) {
+		if it.tracker.seekIndex != "" {
+			it.seek = appendSeekNode(it.seek, it.NodeIterator)
+		}
+		if !it.skipping {
+			if it.tracker.sequence {
+				it.index++
+			}
+			return true
+		}
+		// nodes are yielded in path order, so those before the resumed node were already yielded
+		path := it.NodeIterator.Path()
+		if cmp := bytes.Compare(path, it.resume); cmp >= 0 {
+			if cmp > 0 {
+				it.index++
+			}
+			it.skipping, it.resume = false, nil
+			return true
+		}
+		descend = bytes.HasPrefix(it.resume, path)
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package tracker_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestSequence(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+	recoveryFile := filepath.Join(t.TempDir(), "recovery.csv")
+
+	// the index of each node in an uninterrupted traversal
+	tr := tracker.New(recoveryFile, 1, tracker.WithSequence())
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := map[string]uint64{}
+	for it := iter.NewContextIterator(context.Background(), tr.Tracked(nodeit)); it.Next(true); {
+		bin, index, err := iter.Sequence(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bin != nil || index != uint64(len(indexes)+1) {
+			t.Fatalf("node %x: expected index %d of the unbounded bin, got %d of %x", it.Path(), len(indexes)+1, index, bin)
+		}
+		indexes[string(it.Path())] = index
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	// interrupt at a node whose odd path ends with 0, so that a restored iterator seeks to its parent
+	tr = tracker.New(recoveryFile, 1, tracker.WithSequence())
+	if nodeit, err = tree.NodeIterator(nil); err != nil {
+		t.Fatal(err)
+	}
+	var failedAt []byte
+	for it, count := tr.Tracked(nodeit), 0; it.Next(true); count++ {
+		if path := it.Path(); count > len(indexes)/2 && len(path)%2 == 1 && path[len(path)-1] == 0 {
+			failedAt = append([]byte{}, it.Path()...)
+			break
+		}
+	}
+	if failedAt == nil {
+		t.Fatal("traversal wasn't interrupted")
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		tr = tracker.New(recoveryFile, 1, tracker.WithSequence())
+		its, _, err := tr.Restore(tree.NodeIterator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(its) != 1 {
+			t.Fatalf("expected 1 restored iterator, got %d", len(its))
+		}
+		// every node keeps its index, resuming at the saved node
+		first := true
+		for it := its[0]; it.Next(true); first = false {
+			if first && !bytes.Equal(it.Path(), failedAt) {
+				t.Fatalf("expected to resume at %x, got %x", failedAt, it.Path())
+			}
+			if _, index, err := iter.Sequence(it); err != nil || index != indexes[string(it.Path())] {
+				t.Fatalf("node %x: expected index %d, got %d (err: %v)", it.Path(), indexes[string(it.Path())], index, err)
+			}
+			if i == 0 {
+				break // saved again at the resumed node
+			}
+		}
+		if err := tr.CloseAndSave(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr = tracker.New(filepath.Join(t.TempDir(), "unsequenced.csv"), 1)
+	defer tr.CloseAndSave()
+	if nodeit, err = tree.NodeIterator(nil); err != nil {
+		t.Fatal(err)
+	}
+	it := tr.Tracked(nodeit)
+	if _, _, err := iter.Sequence(it); !errors.Is(err, iter.ErrUnsupportedIterator) {
+		t.Fatalf("expected ErrUnsupportedIterator, got %v", err)
+	}
+}
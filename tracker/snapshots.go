@@ -0,0 +1,166 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	gethsnapshot "github.com/ethereum/go-ethereum/core/state/snapshot"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+// SnapshotTree opens iterators over a geth state snapshot. It is implemented by *snapshot.Tree.
+type SnapshotTree interface {
+	AccountIterator(root common.Hash, seek common.Hash) (gethsnapshot.AccountIterator, error)
+	StorageIterator(root common.Hash, account common.Hash, seek common.Hash) (gethsnapshot.StorageIterator, error)
+}
+
+var _ SnapshotTree = &gethsnapshot.Tree{}
+
+// AccountIterator is a tracked iterator over the accounts of a state snapshot.
+type AccountIterator struct {
+	gethsnapshot.AccountIterator
+	*snapshotCursor
+}
+
+// StorageIterator is a tracked iterator over the storage of an account in a state snapshot.
+type StorageIterator struct {
+	gethsnapshot.StorageIterator
+	*snapshotCursor
+}
+
+// TrackedAccounts wraps an iterator over the accounts of the snapshot with the given root. The
+// hash of the last account reached is saved, so that RestoreSnapshots can seek back to it.
+func (tr *TrackerImpl) TrackedAccounts(root common.Hash, it gethsnapshot.AccountIterator) *AccountIterator {
+	cursor := &snapshotCursor{tracker: tr, kind: AccountSnapshotIterator, root: root}
+	tr.start(cursor)
+	return &AccountIterator{AccountIterator: it, snapshotCursor: cursor}
+}
+
+// TrackedSlots wraps an iterator over the storage of an account (by hash) in the snapshot with the
+// given root, as for TrackedAccounts.
+func (tr *TrackerImpl) TrackedSlots(root, account common.Hash, it gethsnapshot.StorageIterator) *StorageIterator {
+	cursor := &snapshotCursor{tracker: tr, kind: StorageSnapshotIterator, root: root, owner: account}
+	tr.start(cursor)
+	return &StorageIterator{StorageIterator: it, snapshotCursor: cursor}
+}
+
+// Next advances the iterator, notifying its owning tracker when it finishes.
+func (it *AccountIterator) Next() bool {
+	return it.next(it.AccountIterator)
+}
+
+// Next advances the iterator, notifying its owning tracker when it finishes.
+func (it *StorageIterator) Next() bool {
+	return it.next(it.StorageIterator)
+}
+
+// Account returns the hash of the account whose storage is iterated.
+func (it *StorageIterator) Account() common.Hash {
+	return it.owner
+}
+
+// RestoreSnapshots restores the snapshot iterators of the saved state from a snapshot tree, each
+// seeking to the last hash it reached. Iterators are returned in the order they were saved. If
+// there is no saved state, returns no iterators and no error. The saved state must not include
+// trie iterators, which are restored by Restore.
+func (tr *TrackerImpl) RestoreSnapshots(tree SnapshotTree) ([]*AccountIterator, []*StorageIterator, error) {
+	// keep checkpoints from overwriting the saved state until all iterators are restored
+	tr.stateMu.Lock()
+	defer tr.stateMu.Unlock()
+
+	positions, err := tr.store.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(positions) == 0 {
+		return nil, nil, nil
+	}
+	if err := tr.checkRoots(positions); err != nil {
+		return nil, nil, err
+	}
+	for _, pos := range positions {
+		if pos.Kind != AccountSnapshotIterator && pos.Kind != StorageSnapshotIterator {
+			return nil, nil, ErrIteratorKind
+		}
+		if pos.Root == (common.Hash{}) {
+			return nil, nil, errors.New("snapshot iterator position has no root")
+		}
+	}
+
+	var accounts []*AccountIterator
+	var storage []*StorageIterator
+	for _, pos := range positions {
+		var seek common.Hash
+		if len(pos.Path) != 0 {
+			if len(pos.Path) != 2*common.HashLength {
+				return nil, nil, fmt.Errorf("invalid snapshot iterator path %x", pos.Path)
+			}
+			seek = common.BytesToHash(iter.HexToKeyBytes(pos.Path))
+		}
+		// stateMu is held, so the iterators are registered directly
+		cursor := &snapshotCursor{
+			tracker: tr,
+			kind:    pos.Kind,
+			root:    pos.Root,
+			owner:   pos.Owner,
+			path:    pos.Path,
+		}
+		switch pos.Kind {
+		case AccountSnapshotIterator:
+			it, err := tree.AccountIterator(pos.Root, seek)
+			if err != nil {
+				return nil, nil, err
+			}
+			accounts = append(accounts, &AccountIterator{AccountIterator: it, snapshotCursor: cursor})
+		case StorageSnapshotIterator:
+			it, err := tree.StorageIterator(pos.Root, pos.Owner, seek)
+			if err != nil {
+				return nil, nil, err
+			}
+			storage = append(storage, &StorageIterator{StorageIterator: it, snapshotCursor: cursor})
+		}
+		tr.addStarted(cursor)
+	}
+
+	tr.startAutoCheckpoint()
+	tr.seq++
+	_, err = tr.write(snapshot{seq: tr.seq})
+	return accounts, storage, err
+}
+
+// snapshotCursor records the position of a snapshot iterator. It is what the tracker registers,
+// since AccountIterator and StorageIterator have no common interface.
+type snapshotCursor struct {
+	tracker     *TrackerImpl
+	kind        PositionKind
+	root, owner common.Hash
+	path        []byte     // hex path of the last hash reached
+	mu          sync.Mutex // guards path
+}
+
+func (c *snapshotCursor) next(it gethsnapshot.Iterator) bool {
+	ret := it.Next()
+	if ret {
+		hash := it.Hash()
+		c.mu.Lock()
+		path := iter.KeyBytesToHex(hash[:])
+		c.path = path[:len(path)-1]
+		c.mu.Unlock()
+	} else if it.Error() == nil {
+		c.tracker.stop(c)
+	}
+	return ret
+}
+
+func (c *snapshotCursor) position() Position {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var path []byte
+	if c.path != nil {
+		path = append([]byte(nil), c.path...)
+	}
+	return Position{Path: path, Root: c.root, Owner: c.owner, Kind: c.kind}
+}
@@ -0,0 +1,137 @@
+package tracker_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	gethsnapshot "github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestTrackerSnapshots(t *testing.T) {
+	diskdb := rawdb.NewMemoryDatabase()
+	sdb := state.NewDatabase(diskdb)
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const accounts = 50
+	owner := common.BytesToAddress([]byte{1})
+	for i := 0; i < accounts; i++ {
+		statedb.SetNonce(common.BytesToAddress([]byte{byte(i + 1)}), 1)
+	}
+	for j := 0; j < 10; j++ {
+		statedb.SetState(owner, common.BytesToHash([]byte{byte(j + 1)}), common.BytesToHash([]byte{1}))
+	}
+	root, err := statedb.Commit(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sdb.TrieDB().Commit(root, false); err != nil {
+		t.Fatal(err)
+	}
+	snaps, err := gethsnapshot.New(gethsnapshot.Config{CacheSize: 1}, diskdb, sdb.TrieDB(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerHash := crypto.Keccak256Hash(owner.Bytes())
+
+	recoveryFile := filepath.Join(t.TempDir(), "tracker_test.csv")
+	tr := tracker.New(recoveryFile, 4)
+	seen := map[common.Hash]struct{}{}
+
+	accIt, err := snaps.AccountIterator(root, common.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := tr.TrackedAccounts(root, accIt)
+	var last common.Hash
+	for i := 0; i < accounts/2 && it.Next(); i++ {
+		last = it.Hash()
+		seen[last] = struct{}{}
+	}
+	slotIt, err := snaps.StorageIterator(root, ownerHash, common.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots := tr.TrackedSlots(root, ownerHash, slotIt)
+	if !slots.Next() {
+		t.Fatal("expected storage slots")
+	}
+	firstSlot := slots.Hash()
+	// a finished iterator is not saved
+	doneIt, err := snaps.AccountIterator(root, common.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := tr.TrackedAccounts(root, doneIt)
+	for done.Next() {
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	// trie iterators can't be restored from snapshot positions
+	tr = tracker.New(recoveryFile, 4)
+	if _, _, err := tr.Restore(nil); !errors.Is(err, tracker.ErrIteratorKind) {
+		t.Fatalf("expected ErrIteratorKind, got %v", err)
+	}
+
+	restoredAccounts, restoredSlots, err := tr.RestoreSnapshots(snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restoredAccounts) != 1 || len(restoredSlots) != 1 {
+		t.Fatalf("expected 1 account and 1 storage iterator, got %d and %d",
+			len(restoredAccounts), len(restoredSlots))
+	}
+	// iteration resumes at the last hash reached
+	it = restoredAccounts[0]
+	if !it.Next() || it.Hash() != last {
+		t.Fatalf("expected restored iterator at %x, got %x", last, it.Hash())
+	}
+	for it.Next() {
+		seen[it.Hash()] = struct{}{}
+	}
+	if len(seen) != accounts {
+		t.Fatalf("expected %d accounts, got %d", accounts, len(seen))
+	}
+	slots = restoredSlots[0]
+	if slots.Account() != ownerHash {
+		t.Fatalf("wrong account for storage iterator: %x", slots.Account())
+	}
+	if !slots.Next() || slots.Hash() != firstSlot {
+		t.Fatalf("expected restored storage iterator at %x, got %x", firstSlot, slots.Hash())
+	}
+	for slots.Next() {
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(recoveryFile) {
+		t.Fatal("recovery file wasn't removed")
+	}
+
+	// nor can snapshot iterators be restored from trie positions
+	tr = tracker.New(recoveryFile, 4)
+	nodeIt, err := iter.NewTrieDBConstructor(sdb.TrieDB(), trie.StateTrieID(root))(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Tracked(nodeIt).Next(true)
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tracker.New(recoveryFile, 4).RestoreSnapshots(snaps); !errors.Is(err, tracker.ErrIteratorKind) {
+		t.Fatalf("expected ErrIteratorKind, got %v", err)
+	}
+}
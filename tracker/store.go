@@ -0,0 +1,388 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// PositionKind identifies the kind of iterator a position was saved from.
+type PositionKind uint8
+
+const (
+	// TrieIterator is a trie node iterator.
+	TrieIterator PositionKind = iota
+	// AccountSnapshotIterator is an iterator over the accounts of a geth state snapshot.
+	AccountSnapshotIterator
+	// StorageSnapshotIterator is an iterator over the storage of an account in a state snapshot.
+	StorageSnapshotIterator
+)
+
+// Position is the saved state of a tracked iterator.
+type Position struct {
+	// Path is the hex path of the iterator's current node. For a snapshot iterator, it is the hex
+	// path of the last account or slot hash reached, or nil if none has been.
+	Path []byte
+	// EndPath is the iterator's upper bound, or nil if it is unbounded.
+	EndPath []byte
+	// Root is the root hash of the iterated trie, or zero if unknown.
+	Root common.Hash
+	// Owner and StorageRoot identify the storage trie iterated by a storage iterator. Both are zero
+	// for an iterator over the state trie. Storage snapshot iterators only set the owner.
+	Owner, StorageRoot common.Hash
+	// Kind is the kind of iterator saved.
+	Kind PositionKind
+	// Index is the index of the node at Path in the iterator's bin, for a tracker made WithSequence,
+	// or zero.
+	Index uint64
+}
+
+// RecoveryStore persists the positions of tracked iterators so they can be restored later.
+type RecoveryStore interface {
+	// Save replaces any saved state with the given positions. Saving no positions clears the state.
+	Save([]Position) error
+	// Load returns the saved positions, or none if there is no saved state.
+	Load() ([]Position, error)
+}
+
+// ErrLocked is returned by a FileStore when its recovery file is in use by another store, e.g. a
+// tracker in another process.
+var ErrLocked = errors.New("recovery file is locked by another tracker")
+
+// ErrReadOnly is returned by the Save of a FileStore opened WithReadOnly.
+var ErrReadOnly = errors.New("recovery store is read-only")
+
+var _ RecoveryStore = &FileStore{}
+
+// FileStore is a RecoveryStore which saves positions as rows of a CSV file. Each row holds the
+// path, end path and (if known) root as hex strings, followed by the owner and storage root for
+// storage iterators, the kind of snapshot iterators, and the index of the node at the path for
+// trackers made WithSequence. The rows follow a header recording the FormatVersion and
+// LibraryVersion, and files in a newer format are refused with a FormatError.
+//
+// On unix, the store holds an advisory lock on the recovery file from its first Load or Save until
+// it is closed, unless opened WithReadOnly, so that two trackers can't run against the same state.
+// The lock is taken on a separate file, named for the recovery file with a ".lock" suffix, since
+// saves replace the recovery file.
+//
+// The file moves through three states: absent before the first save, holding the positions of
+// each save, and empty once the state is cleared. Each save replaces the file atomically and
+// durably, so a crash leaves either the previous state or the new one. Clearing the state first
+// replaces the file with one which holds no positions, then removes it, so a crash can't bring back
+// the positions of a finished traversal, only leave the empty file, which loads as no state.
+type FileStore struct {
+	path         string
+	forceMigrate bool
+	readOnly     bool
+
+	lock   *os.File
+	locked bool
+	closed bool
+	lockMu sync.Mutex // guards lock, locked and closed
+}
+
+// FileStoreOption configures a FileStore.
+type FileStoreOption func(*FileStore)
+
+// WithForceMigrate loads recovery files in any format as the current one, rather than returning a
+// FormatError, and ignores any columns it does not know. The next save rewrites the file in the
+// current format. This is a last resort for a file written by a newer version, e.g. after a
+// downgrade, and positions may be misread.
+func WithForceMigrate() FileStoreOption {
+	return func(s *FileStore) {
+		s.forceMigrate = true
+	}
+}
+
+// WithReadOnly opens the store for an observer of a run, e.g. a dashboard in another process: it
+// loads the recovery file without taking its lock, so that it can be read while a tracker holds the
+// store, and refuses to save with ErrReadOnly. Saves replace the file atomically, so each Load sees
+// the positions of one save.
+func WithReadOnly() FileStoreOption {
+	return func(s *FileStore) {
+		s.readOnly = true
+	}
+}
+
+// NewFileStore returns a store which saves state to the given file. The file is emptied and then
+// removed when the state is cleared.
+func NewFileStore(path string, opts ...FileStoreOption) *FileStore {
+	s := &FileStore{path: path}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// LockPath returns the path of the lock file held by the store.
+func (s *FileStore) LockPath() string {
+	return s.path + ".lock"
+}
+
+// acquire takes the store's lock if it is not already held, returning ErrLocked if another store
+// holds it.
+func (s *FileStore) acquire() error {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	if s.closed {
+		return errors.New("recovery store is closed")
+	}
+	if s.locked || s.readOnly {
+		return nil
+	}
+	lock, err := lockFile(s.LockPath())
+	if err != nil {
+		return err
+	}
+	s.lock, s.locked = lock, true
+	return nil
+}
+
+// Close releases the store's lock, after which it can no longer be used. Trackers close their
+// store when they are closed.
+func (s *FileStore) Close() error {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if !s.locked {
+		return nil
+	}
+	return unlockFile(s.lock)
+}
+
+// Path returns the path of the recovery file.
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+func (s *FileStore) Save(positions []Position) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	log.Debug("Saving recovery state", "to", s.path)
+
+	// if the tracker state is empty, erase any existing recovery file
+	if len(positions) == 0 {
+		return s.clear()
+	}
+
+	return writeFileAtomic(s.path, func(file *os.File) error {
+		// rows are encoded directly, as they hold no characters which need quoting
+		out := bufio.NewWriter(file)
+		row := appendHeader(nil)
+		if _, err := out.Write(row); err != nil {
+			return err
+		}
+		for _, pos := range positions {
+			row = appendRow(row[:0], pos)
+			if _, err := out.Write(row); err != nil {
+				return err
+			}
+		}
+		return out.Flush()
+	})
+}
+
+// appendRow appends the CSV row of a position to buf, with its line ending.
+func appendRow(buf []byte, pos Position) []byte {
+	buf = appendHex(buf, pos.Path)
+	buf = append(buf, ',')
+	buf = appendHex(buf, pos.EndPath)
+	if pos.Root != (common.Hash{}) || pos.Owner != (common.Hash{}) || pos.Kind != TrieIterator || pos.Index != 0 {
+		buf = appendHashField(append(buf, ','), pos.Root)
+	}
+	if pos.Owner != (common.Hash{}) || pos.Kind != TrieIterator || pos.Index != 0 {
+		buf = appendHashField(append(buf, ','), pos.Owner)
+		buf = appendHashField(append(buf, ','), pos.StorageRoot)
+	}
+	if pos.Kind != TrieIterator || pos.Index != 0 {
+		buf = strconv.AppendUint(append(buf, ','), uint64(pos.Kind), 10)
+	}
+	if pos.Index != 0 {
+		buf = strconv.AppendUint(append(buf, ','), pos.Index, 10)
+	}
+	return append(buf, '\n')
+}
+
+func appendHex(buf, data []byte) []byte {
+	n := len(buf)
+	buf = append(buf, make([]byte, hex.EncodedLen(len(data)))...)
+	hex.Encode(buf[n:], data)
+	return buf
+}
+
+func (s *FileStore) Load() ([]Position, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	log.Debug("Restoring recovery state", "from", s.path)
+
+	buffered := bufio.NewReader(file)
+	format, library, err := readHeader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	if format > FormatVersion {
+		if !s.forceMigrate {
+			return nil, &FormatError{Path: s.path, Format: format, Library: library}
+		}
+		log.Warn("Migrating recovery state from an incompatible format", "file", s.path,
+			"format", format, "library", library)
+	}
+	in := csv.NewReader(buffered)
+	in.FieldsPerRecord = -1 // the root, storage, kind and index columns are optional
+	rows, err := in.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	header := 0
+	if format > 0 {
+		header = 1
+	}
+	var positions []Position
+	for i, row := range rows {
+		if s.forceMigrate && len(row) > 7 {
+			row = row[:7]
+		}
+		if len(row) != 2 && len(row) != 3 && len(row) != 5 && len(row) != 6 && len(row) != 7 {
+			return nil, fmt.Errorf("record on line %d: wrong number of fields", header+i+1)
+		}
+		var pos Position
+		if pos.Path, err = parseHexField(row[0]); err != nil {
+			return nil, fmt.Errorf("record on line %d: %w", header+i+1, err)
+		}
+		if pos.EndPath, err = parseHexField(row[1]); err != nil {
+			return nil, fmt.Errorf("record on line %d: %w", header+i+1, err)
+		}
+		if len(row) == 7 {
+			if row[6] != "" {
+				if pos.Index, err = strconv.ParseUint(row[6], 10, 64); err != nil {
+					return nil, fmt.Errorf("record on line %d: %w", header+i+1, err)
+				}
+			}
+			row = row[:6]
+		}
+		if len(row) == 6 {
+			kind, err := strconv.ParseUint(row[5], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("record on line %d: %w", header+i+1, err)
+			}
+			pos.Kind = PositionKind(kind)
+			row = row[:5]
+		}
+		hashes := []*common.Hash{&pos.Root, &pos.Owner, &pos.StorageRoot}
+		for j, field := range row[2:] {
+			if *hashes[j], err = parseHashField(field); err != nil {
+				return nil, fmt.Errorf("record on line %d: %w", header+i+1, err)
+			}
+		}
+		positions = append(positions, pos)
+	}
+	return positions, nil
+}
+
+// appendHashField appends a hash column, which is empty for a zero hash.
+func appendHashField(buf []byte, hash common.Hash) []byte {
+	if hash == (common.Hash{}) {
+		return buf
+	}
+	return appendHex(buf, hash[:])
+}
+
+// parseHexField parses a path column, which is empty for a nil path.
+func parseHexField(field string) ([]byte, error) {
+	if len(field) == 0 {
+		return nil, nil
+	}
+	return hex.DecodeString(field)
+}
+
+func parseHashField(field string) (common.Hash, error) {
+	if len(field) == 0 {
+		return common.Hash{}, nil
+	}
+	hash, err := hex.DecodeString(field)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if len(hash) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid hash %q", field)
+	}
+	return common.BytesToHash(hash), nil
+}
+
+// clear records the terminal state, a file without positions, before removing the recovery file.
+func (s *FileStore) clear() error {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err := writeFileAtomic(s.path, func(file *os.File) error {
+		_, err := file.Write(appendHeader(nil))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.remove()
+}
+
+func (s *FileStore) remove() error {
+	err := os.Remove(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(s.path))
+}
+
+// writeFileAtomic writes to a temporary file which is synced and then renamed over the target, so
+// a crash mid-write can never leave a truncated recovery file in place. The directory is synced
+// after the rename, so that a crash can't undo it once it returns.
+func writeFileAtomic(path string, write func(*os.File) error) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = write(file); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,223 @@
+package tracker_test
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/cerc-io/eth-iterator-utils/tracker"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store_test.csv")
+	store := tracker.NewFileStore(path)
+
+	positions, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 0 {
+		t.Fatalf("expected no positions from missing file, got %v", positions)
+	}
+
+	saved := []tracker.Position{
+		{Path: nil, EndPath: []byte{1, 0}},
+		{Path: []byte{1, 2, 3}, EndPath: []byte{8}},
+		{Path: []byte{8, 0xf}, EndPath: nil, Root: common.HexToHash("0xabcd")},
+		{Path: []byte{2}, Root: common.HexToHash("0xabcd"), Owner: common.HexToHash("0x01"), StorageRoot: common.HexToHash("0x02")},
+		{Path: []byte{3}, Owner: common.HexToHash("0x03"), StorageRoot: common.HexToHash("0x04")},
+		{Path: []byte{4, 5}, Root: common.HexToHash("0xabcd"), Kind: tracker.AccountSnapshotIterator},
+		{Root: common.HexToHash("0xabcd"), Owner: common.HexToHash("0x05"), Kind: tracker.StorageSnapshotIterator},
+		{Path: []byte{6, 7, 8}, EndPath: []byte{7}, Index: 42},
+	}
+	if err := store.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	positions, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, positions) {
+		t.Fatalf("loaded wrong positions\nexpected:\t%v\nactual:\t\t%v", saved, positions)
+	}
+
+	if err := store.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(path) {
+		t.Fatal("recovery file wasn't removed")
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("recovery files are only locked on unix")
+	}
+	path := filepath.Join(t.TempDir(), "store_test.csv")
+	store := tracker.NewFileStore(path)
+	if _, err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	other := tracker.NewFileStore(path)
+	if err := other.Save([]tracker.Position{{Path: []byte{1}}}); !errors.Is(err, tracker.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	tr := tracker.New(path, 1)
+	if _, _, err := tr.Restore(nil); !errors.Is(err, tracker.ErrLocked) {
+		t.Fatalf("expected ErrLocked from tracker, got %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.LockPath()); !os.IsNotExist(err) {
+		t.Fatalf("lock file not removed: %v", err)
+	}
+	if _, err := store.Load(); err == nil {
+		t.Fatal("expected error from closed store")
+	}
+	// the tracker takes the lock, and releases it when closed
+	if _, _, err := tr.Restore(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Load(); !errors.Is(err, tracker.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// benchmarkPositions returns n positions spread over the keyspace, as saved by a checkpoint of
+// many storage iterators.
+// TestFileStoreCrash checks the state loaded from the files a crash can leave at each step of a save
+// and of clearing the state.
+func TestFileStoreCrash(t *testing.T) {
+	saved := []tracker.Position{{Path: []byte{1, 2}, EndPath: []byte{2}}}
+	// the content of a recovery file without positions, recorded when the state is cleared
+	var terminal []byte
+	{
+		path := filepath.Join(t.TempDir(), "recovery.csv")
+		store := tracker.NewFileStore(path)
+		if err := store.Save(saved); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		terminal = data[:bytes.IndexByte(data, '\n')+1]
+		store.Close()
+	}
+
+	for _, crash := range []struct {
+		name     string
+		file     []byte // nil for no recovery file
+		tmp      []byte // nil for no temporary file
+		expected []tracker.Position
+	}{
+		// the temporary file is only renamed once complete
+		{"writing first save", nil, []byte("0102,"), nil},
+		{"writing save", []byte("0102,02\n"), []byte("0103,"), saved},
+		{"writing empty state", []byte("0102,02\n"), terminal[:len(terminal)/2], saved},
+		{"removing empty state", terminal, nil, nil},
+	} {
+		t.Run(crash.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "recovery.csv")
+			if crash.file != nil {
+				if err := os.WriteFile(path, crash.file, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if crash.tmp != nil {
+				if err := os.WriteFile(path+".tmp", crash.tmp, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store := tracker.NewFileStore(path)
+			defer store.Close()
+			positions, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(crash.expected, positions) {
+				t.Fatalf("wrong positions after crash\nexpected:\t%v\nactual:\t\t%v", crash.expected, positions)
+			}
+
+			// the next save recovers from the crash
+			if err := store.Save(saved); err != nil {
+				t.Fatal(err)
+			}
+			if positions, err = store.Load(); err != nil || !reflect.DeepEqual(saved, positions) {
+				t.Fatalf("expected saved positions, got %v (%v)", positions, err)
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Fatalf("expected temporary file to be replaced, got %v", err)
+			}
+			if err := store.Save(nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("expected cleared state to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func benchmarkPositions(n int) []tracker.Position {
+	positions := make([]tracker.Position, n)
+	for i := range positions {
+		hash := common.BigToHash(big.NewInt(int64(i) * 7919))
+		path := make([]byte, 2*common.HashLength)
+		for j, b := range hash {
+			path[2*j], path[2*j+1] = b>>4, b&0xf
+		}
+		positions[i] = tracker.Position{
+			Path:        path,
+			EndPath:     path[:8],
+			Root:        hash,
+			Owner:       hash,
+			StorageRoot: hash,
+		}
+	}
+	return positions
+}
+
+func BenchmarkFileStoreSave(b *testing.B) {
+	positions := benchmarkPositions(50000)
+	store := tracker.NewFileStore(filepath.Join(b.TempDir(), "bench.csv"))
+	defer store.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.Save(positions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileStoreLoad(b *testing.B) {
+	store := tracker.NewFileStore(filepath.Join(b.TempDir(), "bench.csv"))
+	defer store.Close()
+	if err := store.Save(benchmarkPositions(50000)); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Load(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
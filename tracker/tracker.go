@@ -1,6 +1,20 @@
 // This package provides a way to track multiple concurrently running trie iterators, save their
 // state to a file on failures or interruptions, and restore them at the positions where they
-// stopped.
+// stopped. State is saved to a CSV file by default, which the tracker locks until it is closed;
+// NewWithStore accepts any RecoveryStore.
+// Checkpoint saves state while iterators run, WithAutoCheckpoint does so periodically, and
+// WithAdaptiveCheckpoint as often as their progress warrants. NewForRun names the file for the
+// trie root and a run ID, and FindRuns lists the runs which can be resumed. Positions reports the
+// live position of every tracked iterator. WithSeekIndex saves the nodes on the path to each
+// position alongside, so that Restore can verify them and warm the reads of resumed iterators.
+// WithSequence numbers the nodes of each bin, so that every node keeps its index across resumes.
+// Bins whose iterators are constructed lazily can be registered up front with Plan or
+// PlanSubtries, so they are saved before they start. Storage trie iterators are tracked with
+// TrackedStorage, and restored with RestoreWithStorage; WithRestoreTransform adjusts the ranges
+// which are restored. Consumers needing more than IteratorTracker accept IteratorTrackerV2, which
+// Upgrade adapts any IteratorTracker to.
+// Iterators over geth state snapshots are tracked with TrackedAccounts and TrackedSlots, and
+// restored with RestoreSnapshots.
 //
 // Example usage:
 //
@@ -25,15 +39,19 @@
 package tracker
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/trie"
 
 	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/metrics"
+	"github.com/cerc-io/eth-iterator-utils/nibbles"
 )
 
 // IteratorTracker exposes a minimal interface to register and consume iterators.
@@ -44,23 +62,47 @@ type IteratorTracker interface {
 
 var _ IteratorTracker = &Tracker{}
 
-// Tracker is a trie iterator tracker which saves state to and restores it from a file.
+// ErrClosed is returned when a tracker is used after it has been closed.
+var ErrClosed = errors.New("tracker is closed")
+
+// ErrRootMismatch is returned by Restore when the saved state belongs to a different trie.
+var ErrRootMismatch = errors.New("recovery state was saved for a different root")
+
+// ErrIteratorKind is returned by Restore when the saved state includes snapshot iterators, and by
+// RestoreSnapshots when it includes trie iterators.
+var ErrIteratorKind = errors.New("recovery state includes iterators of another kind")
+
+// ErrNoStorageConstructor is returned by Restore when the saved state includes storage iterators,
+// which can only be restored by RestoreWithStorage.
+var ErrNoStorageConstructor = errors.New("recovery state includes storage iterators")
+
+// StorageConstructor returns the IteratorConstructor for the storage trie with the given owner and
+// root, e.g. using iter.NewTrieDBConstructor with trie.StorageTrieID.
+type StorageConstructor = func(owner, storageRoot common.Hash) iter.IteratorConstructor
+
+// Tracker is a trie iterator tracker which saves state to and restores it from a RecoveryStore.
 type Tracker struct {
 	*TrackerImpl
 }
 
 // New creates a new tracker which saves state to a given file. bufsize sets the size of the
-// channel buffers used internally to manage tracking. Note that passing a bufsize smaller than the expected
-// number of concurrent iterators could lead to deadlock.
-func New(file string, bufsize uint) *Tracker {
-	return &Tracker{NewImpl(file, bufsize)}
+// channel buffers used internally to manage tracking. Once the buffers are full, iterators are
+// registered under a lock instead, so bufsize should cover the expected number of concurrent
+// iterators.
+func New(file string, bufsize uint, opts ...Option) *Tracker {
+	return NewWithStore(NewFileStore(file), bufsize, opts...)
+}
+
+// NewWithStore creates a new tracker which saves state to the given store. bufsize is as for New.
+func NewWithStore(store RecoveryStore, bufsize uint, opts ...Option) *Tracker {
+	return &Tracker{NewImplWithStore(store, bufsize, opts...)}
 }
 
-// Restore attempts to read iterator state from the recovery file.
+// Restore attempts to read iterator state from the recovery store.
 // Returns:
 // - slice of tracked iterators
 // - slice of iterators originally returned by constructor
-// If the file doesn't exist, returns an empty slice with no error.
+// If there is no saved state, returns an empty slice with no error.
 // Restored iterators are constructed in the same order they appear in the returned slice.
 func (tr *Tracker) Restore(makeIterator iter.IteratorConstructor) (
 	[]trie.NodeIterator, []trie.NodeIterator, error,
@@ -77,198 +119,482 @@ func (tr *Tracker) Restore(makeIterator iter.IteratorConstructor) (
 	return ret, bases, nil
 }
 
+// RestoreWithStorage is like Restore, but also restores storage iterators, which are constructed
+// by makeStorageIterator(owner, storageRoot). Restored storage iterators are *Iterators, whose
+// StorageTrie method identifies the account they belong to.
+func (tr *Tracker) RestoreWithStorage(makeIterator iter.IteratorConstructor, makeStorageIterator StorageConstructor) (
+	[]trie.NodeIterator, []trie.NodeIterator, error,
+) {
+	its, bases, err := tr.TrackerImpl.RestoreWithStorage(makeIterator, makeStorageIterator)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ret []trie.NodeIterator
+	for _, it := range its {
+		ret = append(ret, it)
+	}
+	return ret, bases, nil
+}
+
 // Tracked wraps an iterator in a tracked iterator. This should not be called when the tracker can
 // potentially be closed.
 func (tr *Tracker) Tracked(it trie.NodeIterator) trie.NodeIterator {
 	return tr.TrackerImpl.Tracked(it)
 }
 
-func NewImpl(file string, bufsize uint) *TrackerImpl {
-	return &TrackerImpl{
-		recoveryFile: file,
-		startChan:    make(chan *Iterator, bufsize),
-		stopChan:     make(chan *Iterator, bufsize),
-		started:      map[*Iterator]struct{}{},
-		running:      true,
+// TrackedStorage wraps an iterator over a storage trie in a tracked iterator, as for Tracked.
+func (tr *Tracker) TrackedStorage(owner, storageRoot common.Hash, it trie.NodeIterator) trie.NodeIterator {
+	return tr.TrackerImpl.TrackedStorage(owner, storageRoot, it)
+}
+
+func NewImpl(file string, bufsize uint, opts ...Option) *TrackerImpl {
+	return NewImplWithStore(NewFileStore(file), bufsize, opts...)
+}
+
+func NewImplWithStore(store RecoveryStore, bufsize uint, opts ...Option) *TrackerImpl {
+	tr := &TrackerImpl{
+		store:     store,
+		startChan: make(chan tracked, bufsize),
+		stopChan:  make(chan tracked, bufsize),
+		started:   map[tracked]struct{}{},
+		stopped:   map[tracked]struct{}{},
+		running:   true,
 	}
+	for _, opt := range opts {
+		opt(tr)
+	}
+	return tr
 }
 
 type TrackerImpl struct {
-	recoveryFile string
+	store            RecoveryStore
+	root             common.Hash
+	restoreTransform RestoreTransform
 
-	startChan    chan *Iterator
-	stopChan     chan *Iterator
-	started      map[*Iterator]struct{}
-	stopped      []*Iterator
+	startChan    chan tracked
+	stopChan     chan tracked
 	running      bool
 	sync.RWMutex // guards closing of the tracker
+
+	started      map[tracked]struct{}
+	stopped      map[tracked]struct{} // stops collected before their start
+	finished     []Position           // positions of recently finished iterators, up to finishedMax
+	finishedMax  int                  // see WithFinishedPositions
+	finishedN    uint64               // number of finished iterators
+	seq          uint64               // sequence number of the latest snapshot
+	lockedStarts uint64               // registrations made under stateMu, as startChan was full
+	lockedStops  uint64               // as above, for stopChan
+	stateMu      sync.Mutex           // guards started/stopped/finished, seq and the counts
+
+	savedSeq uint64     // sequence number of the latest snapshot written to the store
+	storeMu  sync.Mutex // guards savedSeq and access to the store
+
+	checkpointInterval time.Duration
+	checkpointCeiling  time.Duration // for adaptive checkpoints
+	checkpointAtRisk   float64
+	checkpointOnce     sync.Once
+	checkpointQuit     chan struct{}
+	checkpointDone     chan struct{}
+	checkpointQueue    chan snapshot
+	stats              CheckpointStats
+	statsMu            sync.Mutex // guards stats
+	collector          metrics.Collector
+
+	seekIndex  string // path of the seek index, if enabled
+	seekReader trie.NodeResolver
+	sequence   bool // whether iterators number their nodes, see WithSequence
+}
+
+// tracked is an iterator registered with a tracker.
+type tracked interface {
+	// position returns a copy of the iterator's current position. It must be safe to call while
+	// the iterator is being advanced on another goroutine.
+	position() Position
 }
 
 type Iterator struct {
 	trie.NodeIterator
-	tracker *TrackerImpl
+	tracker            *TrackerImpl
+	owner, storageRoot common.Hash
+	seek               []seekNode // hashed nodes on the path to the current node, if indexed
+	index              uint64     // index of the current node in the bin, if sequenced
+	resume             []byte     // path of the node a restored iterator resumes at, while skipping
+	skipping           bool       // whether the nodes before resume are being skipped
+	sync.Mutex                    // guards the wrapped iterator while its position is read
 }
 
 func (tr *TrackerImpl) Tracked(it trie.NodeIterator) *Iterator {
-	ret := &Iterator{it, tr}
-	tr.startChan <- ret
-	return ret
+	return tr.track(&Iterator{NodeIterator: it, tracker: tr})
 }
 
-// Save dumps iterator path and bounds to a text file so it can be restored later.
+// TrackedStorage wraps an iterator over the storage trie with the given owner and root, which are
+// saved with its position so that Restore can reopen the trie.
+func (tr *TrackerImpl) TrackedStorage(owner, storageRoot common.Hash, it trie.NodeIterator) *Iterator {
+	return tr.track(&Iterator{NodeIterator: it, tracker: tr, owner: owner, storageRoot: storageRoot})
+}
+
+func (tr *TrackerImpl) track(it *Iterator) *Iterator {
+	tr.start(it)
+	return it
+}
+
+func (tr *TrackerImpl) start(it tracked) {
+	select {
+	case tr.startChan <- it:
+	default:
+		// collect the pending notifications to make room, e.g. when tracking many storage tries
+		tr.stateMu.Lock()
+		tr.drain()
+		tr.addStarted(it)
+		tr.lockedStarts++
+		tr.stateMu.Unlock()
+	}
+	tr.startAutoCheckpoint()
+}
+
+// stop notifies the tracker that an iterator has finished.
+func (tr *TrackerImpl) stop(it tracked) {
+	tr.RLock()
+	defer tr.RUnlock()
+	if !tr.running {
+		log.Error("Tracker was closed before iterator finished")
+		return
+	}
+	select {
+	case tr.stopChan <- it:
+	default:
+		tr.stateMu.Lock()
+		tr.drain()
+		tr.addStopped(it)
+		tr.lockedStops++
+		tr.stateMu.Unlock()
+	}
+}
+
+// Save dumps iterator path and bounds to the recovery store so they can be restored later.
 func (tr *TrackerImpl) Save() error {
-	log.Debug("Saving recovery state", "to", tr.recoveryFile)
+	tr.stateMu.Lock()
+	defer tr.stateMu.Unlock()
+	return tr.save()
+}
 
-	// if the tracker state is empty, erase any existing recovery file
-	if len(tr.started) == 0 {
-		return tr.removeRecoveryFile()
+// save must be called with stateMu held.
+func (tr *TrackerImpl) save() error {
+	written, err := tr.write(tr.snapshot())
+	if written {
+		tr.reportSaved()
 	}
+	return err
+}
 
-	var rows [][]string
+// snapshot copies the positions of all started iterators. It must be called with stateMu held.
+func (tr *TrackerImpl) snapshot() snapshot {
+	var positions []Position
+	var seeks []seekEntry
 	for it := range tr.started {
-		_, endPath := it.Bounds()
-		rows = append(rows, []string{
-			fmt.Sprintf("%x", it.Path()),
-			fmt.Sprintf("%x", endPath),
-		})
+		var pos Position
+		if indexed, ok := it.(*Iterator); ok && tr.seekIndex != "" {
+			var seek seekEntry
+			pos, seek = indexed.indexedPosition()
+			seeks = append(seeks, seek)
+		} else {
+			pos = it.position()
+		}
+		if pos.Root == (common.Hash{}) {
+			pos.Root = tr.root
+		}
+		positions = append(positions, pos)
 	}
+	tr.seq++
+	return snapshot{seq: tr.seq, positions: positions, seeks: seeks, taken: time.Now()}
+}
 
-	file, err := os.Create(tr.recoveryFile)
-	if err != nil {
-		return err
+// write saves a snapshot to the store, unless a newer one has already been saved. Returns whether
+// the snapshot was written.
+func (tr *TrackerImpl) write(snap snapshot) (bool, error) {
+	tr.storeMu.Lock()
+	defer tr.storeMu.Unlock()
+	if snap.seq <= tr.savedSeq {
+		return false, nil
 	}
-	defer file.Close()
-	out := csv.NewWriter(file)
+	// if the tracker state is empty, this erases any existing saved state
+	if err := tr.store.Save(snap.positions); err != nil {
+		return false, err
+	}
+	if tr.seekIndex != "" {
+		tr.saveSeekIndex(snap.seeks)
+	}
+	tr.savedSeq = snap.seq
+	return true, nil
+}
 
-	return out.WriteAll(rows)
+// checkpoint takes a snapshot of all running iterators without stopping them.
+func (tr *TrackerImpl) checkpoint() snapshot {
+	tr.stateMu.Lock()
+	defer tr.stateMu.Unlock()
+	tr.drain()
+	return tr.snapshot()
 }
 
-func (tr *TrackerImpl) removeRecoveryFile() error {
-	err := os.Remove(tr.recoveryFile)
-	if os.IsNotExist(err) {
-		err = nil
+// drain collects pending start and stop notifications without blocking. It must be called with
+// stateMu held.
+func (tr *TrackerImpl) drain() {
+	for {
+		select {
+		case it, ok := <-tr.startChan:
+			if ok {
+				tr.addStarted(it)
+				continue
+			}
+		default:
+		}
+		break
+	}
+	for {
+		select {
+		case it, ok := <-tr.stopChan:
+			if ok {
+				tr.addStopped(it)
+				continue
+			}
+		default:
+		}
+		break
+	}
+}
+
+// An iterator's stop can be collected before its start, so such stops are remembered until the
+// start is matched. Finished iterators are dropped, and only counted, or kept as positions with
+// WithFinishedPositions.
+func (tr *TrackerImpl) addStarted(it tracked) {
+	if _, done := tr.stopped[it]; done {
+		delete(tr.stopped, it)
+		return
+	}
+	tr.started[it] = struct{}{}
+}
+
+func (tr *TrackerImpl) addStopped(it tracked) {
+	if _, ok := tr.started[it]; ok {
+		delete(tr.started, it)
+	} else {
+		tr.stopped[it] = struct{}{}
+	}
+	// a started bin is replaced by its iterator
+	if _, planned := it.(*PlannedBin); !planned {
+		tr.addFinished(it.position())
 	}
-	return err
 }
 
 func (tr *TrackerImpl) Restore(makeIterator iter.IteratorConstructor) (
 	[]*Iterator, []trie.NodeIterator, error,
 ) {
-	file, err := os.Open(tr.recoveryFile)
+	return tr.RestoreWithStorage(makeIterator, nil)
+}
+
+func (tr *TrackerImpl) RestoreWithStorage(makeIterator iter.IteratorConstructor, makeStorageIterator StorageConstructor) (
+	[]*Iterator, []trie.NodeIterator, error,
+) {
+	// keep checkpoints from overwriting the saved state until all iterators are restored
+	tr.stateMu.Lock()
+	defer tr.stateMu.Unlock()
+
+	positions, err := tr.store.Load()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil, nil
-		}
 		return nil, nil, err
 	}
-	defer file.Close()
-	log.Debug("Restoring recovery state", "from", tr.recoveryFile)
-
-	in := csv.NewReader(file)
-	in.FieldsPerRecord = 2
-	rows, err := in.ReadAll()
-	if err != nil {
+	if len(positions) == 0 {
+		return nil, nil, nil
+	}
+	if err := tr.checkRoots(positions); err != nil {
 		return nil, nil, err
 	}
-
-	var wrapped []*Iterator
-	var base []trie.NodeIterator
-	for _, row := range rows {
-		// pick up where each recovered iterator left off
-		var recoveredPath []byte
-		var endPath []byte
-
-		if len(row[0]) != 0 {
-			if _, err = fmt.Sscanf(row[0], "%x", &recoveredPath); err != nil {
-				return nil, nil, err
+	for _, pos := range positions {
+		if pos.Kind != TrieIterator {
+			return nil, nil, ErrIteratorKind
+		}
+	}
+	if makeStorageIterator == nil {
+		for _, pos := range positions {
+			if pos.Owner != (common.Hash{}) {
+				return nil, nil, ErrNoStorageConstructor
 			}
 		}
-		if len(row[1]) != 0 {
-			if _, err = fmt.Sscanf(row[1], "%x", &endPath); err != nil {
-				return nil, nil, err
+	}
+
+	if tr.restoreTransform != nil {
+		var kept []Position
+		for _, pos := range positions {
+			pos, keep := tr.restoreTransform(pos)
+			if !keep {
+				log.Debug("Dropped restored position", "path", fmt.Sprintf("%x", pos.Path))
+				continue
 			}
+			kept = append(kept, pos)
 		}
+		positions = kept
+	}
+	seeks, err := tr.verifySeekIndex(positions)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// force the lower bound path to an even length (required by geth API/HexToKeyBytes)
-		if len(recoveredPath)&1 == 1 {
-			// to avoid skipped nodes, we must rewind by one index
-			recoveredPath = rewindPath(recoveredPath)
+	var wrapped []*Iterator
+	var base []trie.NodeIterator
+	for i, pos := range positions {
+		construct := makeIterator
+		if pos.Owner != (common.Hash{}) {
+			construct = makeStorageIterator(pos.Owner, pos.StorageRoot)
 		}
-		it, err := makeIterator(iter.HexToKeyBytes(recoveredPath))
+		boundIt, err := OpenPosition(construct, pos)
 		if err != nil {
 			return nil, nil, err
 		}
-		boundIt := iter.NewPrefixBoundIterator(it, endPath)
-		wrapped = append(wrapped, tr.Tracked(boundIt))
-		base = append(base, it)
+		// stateMu is held, so the iterator is registered directly
+		tracked := &Iterator{NodeIterator: boundIt, tracker: tr, owner: pos.Owner, storageRoot: pos.StorageRoot, seek: seeks[i]}
+		if tr.sequence && pos.Index != 0 {
+			tracked.index, tracked.resume, tracked.skipping = pos.Index, pos.Path, true
+		}
+		tr.addStarted(tracked)
+		wrapped = append(wrapped, tracked)
+		base = append(base, boundIt.NodeIterator)
 	}
 
-	return wrapped, base, tr.removeRecoveryFile()
+	tr.startAutoCheckpoint()
+	tr.seq++
+	_, err = tr.write(snapshot{seq: tr.seq})
+	return wrapped, base, err
+}
+
+// OpenPosition constructs an iterator which resumes from a saved trie iterator position, bounded
+// by its end path, as Restore does. For a storage iterator, makeIterator must open the storage
+// trie identified by the position.
+func OpenPosition(makeIterator iter.IteratorConstructor, pos Position) (*iter.PrefixBoundIterator, error) {
+	// pick up where the recovered iterator left off
+	recoveredPath := pos.Path
+
+	// force the lower bound path to an even length (required by geth API/HexToKeyBytes)
+	if len(recoveredPath)&1 == 1 {
+		// to avoid skipped nodes, we must rewind by one index
+		recoveredPath, _ = nibbles.Predecessor(recoveredPath)
+	}
+	it, err := makeIterator(iter.HexToKeyBytes(recoveredPath))
+	if err != nil {
+		return nil, err
+	}
+	return iter.NewPrefixBoundIterator(it, pos.EndPath), nil
+}
+
+// checkRoots verifies that saved positions belong to the tracker's root, if one is set. Positions
+// saved without a root are accepted.
+func (tr *TrackerImpl) checkRoots(positions []Position) error {
+	if tr.root == (common.Hash{}) {
+		return nil
+	}
+	for _, pos := range positions {
+		if pos.Root == (common.Hash{}) {
+			log.Warn("Recovery state has no root, unable to verify it", "expected", tr.root)
+			continue
+		}
+		if pos.Root != tr.root {
+			return fmt.Errorf("%w: expected %x, found %x", ErrRootMismatch, tr.root, pos.Root)
+		}
+	}
+	return nil
 }
 
 // CloseAndSave stops all tracked iterators and dumps their state to a file.
 // This closes the tracker, so adding a new iterator afterwards will fail.
 // A new Tracker must be constructed in order to restore state.
+// If the recovery store is an io.Closer, such as a FileStore holding its lock, it is closed.
 func (tr *TrackerImpl) CloseAndSave() error {
+	tr.stopAutoCheckpoint()
+
 	tr.Lock()
 	tr.running = false
 	close(tr.stopChan)
 	tr.Unlock()
 
+	tr.stateMu.Lock()
+	defer tr.stateMu.Unlock()
+
 	// drain any pending iterators
 	close(tr.startChan)
 	for start := range tr.startChan {
-		tr.started[start] = struct{}{}
+		tr.addStarted(start)
 	}
 	for stop := range tr.stopChan {
-		tr.stopped = append(tr.stopped, stop)
-	}
-	for _, stop := range tr.stopped {
-		delete(tr.started, stop)
+		tr.addStopped(stop)
 	}
 
-	return tr.Save()
+	err := tr.save()
+	if closer, ok := tr.store.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
-// Next advances the iterator, notifying its owning tracker when it finishes.
+// Next advances the iterator, notifying its owning tracker when it finishes. An iterator which
+// stops with an error (e.g. it was cancelled) has not finished, and stays tracked so that its
+// position is saved.
 func (it *Iterator) Next(descend bool) bool {
-	ret := it.NodeIterator.Next(descend)
+	it.Lock()
+	ret := it.advance(descend)
+	it.Unlock()
 
-	if !ret {
-		it.tracker.RLock()
-		defer it.tracker.RUnlock()
-		if it.tracker.running {
-			it.tracker.stopChan <- it
-		} else {
-			log.Error("Tracker was closed before iterator finished")
-		}
+	if !ret && it.NodeIterator.Error() == nil {
+		it.tracker.stop(it)
 	}
 	return ret
 }
 
+// Unwrap returns the wrapped iterator.
+func (it *Iterator) Unwrap() trie.NodeIterator {
+	return it.NodeIterator
+}
+
+// Bounds returns the bounds of the wrapped iterator, or nils if it is unbounded.
 func (it *Iterator) Bounds() ([]byte, []byte) {
-	if impl, ok := it.NodeIterator.(*iter.PrefixBoundIterator); ok {
-		return impl.Bounds()
-	}
-	return nil, nil
+	start, end, _ := iter.Bounds(it.NodeIterator)
+	return start, end
 }
 
-// Rewinds to the path of the previous (pre-order) node:
-// If the last byte of the path is zero, pops it (e.g. [1 0] => [1]).
-// Otherwise, decrements it and pads with 0xF to 64 bytes (e.g. [1] => [0 f f f ...]).
-// The passed slice is not modified.
-func rewindPath(path []byte) []byte {
-	if len(path) == 0 {
-		return path
-	}
-	if path[len(path)-1] == 0 {
-		return path[:len(path)-1]
+// position returns a copy of the iterator's current path and its upper bound.
+func (it *Iterator) position() Position {
+	it.Lock()
+	defer it.Unlock()
+	return it.currentPosition()
+}
+
+// indexedPosition returns the iterator's position, as for position, and the indexed path to it.
+func (it *Iterator) indexedPosition() (Position, seekEntry) {
+	it.Lock()
+	defer it.Unlock()
+	pos := it.currentPosition()
+	return pos, seekEntry{Owner: it.owner, Path: pos.Path, Nodes: append([]seekNode(nil), it.seek...)}
+}
+
+// currentPosition must be called with the iterator locked.
+func (it *Iterator) currentPosition() Position {
+	_, endPath := it.Bounds()
+	path := it.Path()
+	if it.skipping {
+		path = it.resume
 	}
-	path[len(path)-1]--
-	padded := make([]byte, 64)
-	i := copy(padded, path)
-	for ; i < len(padded); i++ {
-		padded[i] = 0xf
+	return Position{
+		Path:        append([]byte(nil), path...),
+		EndPath:     endPath,
+		Owner:       it.owner,
+		StorageRoot: it.storageRoot,
+		Index:       it.index,
 	}
-	return padded
+}
+
+// StorageTrie returns the owner and root of the storage trie being iterated, or zeros for an
+// iterator over the state trie.
+func (it *Iterator) StorageTrie() (owner, storageRoot common.Hash) {
+	return it.owner, it.storageRoot
 }
@@ -2,11 +2,21 @@ package tracker_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
 	"github.com/cerc-io/eth-iterator-utils/internal"
 	"github.com/cerc-io/eth-iterator-utils/tracker"
 )
@@ -21,7 +31,7 @@ func TestTracker(t *testing.T) {
 	// traverse trie and trigger error at some intermediate point
 	N := len(internal.FixtureNodePaths)
 	interrupt := rand.Intn(N/2) + N/4
-	failedTraverse := func() []byte {
+	failedTraverse := func() ([]byte, []byte) {
 		tr := tracker.New(recoveryFile, NumIters)
 		defer tr.CloseAndSave()
 
@@ -33,15 +43,15 @@ func TestTracker(t *testing.T) {
 		}
 		for it := tr.Tracked(nodeit); it.Next(true); {
 			if count == interrupt {
-				return prevPath // tracker rewinds one node to prevent gaps
+				return prevPath, append([]byte{}, it.Path()...)
 			}
-			prevPath = it.Path()
+			prevPath = append([]byte{}, it.Path()...)
 			count++
 		}
-		return nil
+		return nil, nil
 	}
 
-	failedAt := failedTraverse()
+	prevPath, failedAt := failedTraverse()
 	if failedAt == nil {
 		t.Fatal("traversal wasn't interrupted")
 	}
@@ -58,8 +68,13 @@ func TestTracker(t *testing.T) {
 	if uint(len(its)) != NumIters {
 		t.Fatalf("expected to restore %d iterators, got %d", NumIters, len(its))
 	}
-	if !bytes.Equal(failedAt, its[0].Path()) {
-		t.Fatalf("iterator restored to wrong position: expected %v, got %v", failedAt, its[0].Path())
+	// the tracker may rewind to an earlier node to prevent gaps, but never past the last visited node
+	if !its[0].Next(true) {
+		t.Fatal("restored iterator is exhausted")
+	}
+	if bytes.Compare(its[0].Path(), failedAt) > 0 || bytes.Compare(its[0].Path(), prevPath) < 0 {
+		t.Fatalf("iterator restored to wrong position: expected between %v and %v, got %v",
+			prevPath, failedAt, its[0].Path())
 	}
 
 	if fileExists(recoveryFile) {
@@ -71,3 +86,386 @@ func fileExists(file string) bool {
 	_, err := os.Stat(file)
 	return !os.IsNotExist(err)
 }
+
+func TestTrackerSave(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	dir := t.TempDir()
+	recoveryFile := filepath.Join(dir, "tracker_test.csv")
+
+	t.Run("interrupted", func(t *testing.T) {
+		tr := tracker.New(recoveryFile, 1)
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := tr.Tracked(nodeit)
+		it.Next(true)
+		if err := tr.CloseAndSave(); err != nil {
+			t.Fatal(err)
+		}
+		if !fileExists(recoveryFile) {
+			t.Fatal("recovery file wasn't created")
+		}
+		if fileExists(recoveryFile + ".tmp") {
+			t.Fatal("temporary recovery file wasn't cleaned up")
+		}
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		// a stale recovery file must not survive a run in which all iterators finish
+		tr := tracker.New(recoveryFile, 1)
+		nodeit, err := tree.NodeIterator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it := tr.Tracked(nodeit); it.Next(true); {
+		}
+		if err := tr.CloseAndSave(); err != nil {
+			t.Fatal(err)
+		}
+		if fileExists(recoveryFile) {
+			t.Fatal("recovery file wasn't removed")
+		}
+	})
+}
+
+// memoryStore is a RecoveryStore which keeps positions in memory.
+type memoryStore struct {
+	positions []tracker.Position
+}
+
+func (s *memoryStore) Save(positions []tracker.Position) error {
+	s.positions = positions
+	return nil
+}
+
+func (s *memoryStore) Load() ([]tracker.Position, error) {
+	return s.positions, nil
+}
+
+func TestTrackerWithStore(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	store := &memoryStore{}
+	tr := tracker.NewWithStore(store, 1)
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := tr.Tracked(nodeit)
+	for i := 0; i < 10; i++ {
+		it.Next(true)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.positions) != 1 {
+		t.Fatalf("expected 1 saved position, got %d", len(store.positions))
+	}
+
+	tr = tracker.NewWithStore(store, 1)
+	its, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(its) != 1 {
+		t.Fatalf("expected to restore 1 iterator, got %d", len(its))
+	}
+	if len(store.positions) != 0 {
+		t.Fatal("store wasn't cleared after restoring")
+	}
+}
+
+func TestTrackerBudget(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	const maxNodes = 10
+	store := &memoryStore{}
+	tr := tracker.NewWithStore(store, 1)
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := tr.Tracked(iter.NewBudgetIterator(nodeit, iter.NewBudget(0, maxNodes)))
+	for it.Next(true) {
+	}
+	if !errors.Is(it.Error(), iter.ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", it.Error())
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	// the interrupted iterator is saved rather than treated as finished
+	if len(store.positions) != 1 {
+		t.Fatalf("expected 1 saved position, got %d", len(store.positions))
+	}
+	// the node at which the budget ran out was not yielded, so it is where the iterator resumes
+	ref, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= maxNodes; i++ {
+		ref.Next(true)
+	}
+	if !bytes.Equal(store.positions[0].Path, ref.Path()) {
+		t.Fatalf("saved wrong position: expected %v, got %v", ref.Path(), store.positions[0].Path)
+	}
+}
+
+func TestTrackerRoot(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	store := &memoryStore{}
+	tr := tracker.NewWithStore(store, 1, tracker.WithRoot(tree.Hash()))
+	nodeit, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Tracked(nodeit).Next(true)
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr = tracker.NewWithStore(store, 1, tracker.WithRoot(common.HexToHash("0x01")))
+	if _, _, err := tr.Restore(tree.NodeIterator); !errors.Is(err, tracker.ErrRootMismatch) {
+		t.Fatalf("expected ErrRootMismatch, got %v", err)
+	}
+	if len(store.positions) != 1 {
+		t.Fatal("recovery state was modified by failed restore")
+	}
+
+	tr = tracker.NewWithStore(store, 1, tracker.WithRoot(tree.Hash()))
+	its, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(its) != 1 {
+		t.Fatalf("expected to restore 1 iterator, got %d", len(its))
+	}
+}
+
+func TestTrackerTraverse(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	const nbins = 8
+	var mu sync.Mutex
+	seen := map[string]struct{}{}
+	errInterrupt := errors.New("interrupted")
+	visit := func(limit int) iter.Visitor {
+		return func(it trie.NodeIterator) error {
+			for it.Next(true) {
+				if !it.Leaf() {
+					continue
+				}
+				mu.Lock()
+				if len(seen) == limit {
+					mu.Unlock()
+					return errInterrupt
+				}
+				seen[string(it.LeafKey())] = struct{}{}
+				mu.Unlock()
+			}
+			return nil
+		}
+	}
+
+	store := &memoryStore{}
+	tr := tracker.NewWithStore(store, nbins)
+	err := iter.Traverse(context.Background(), tree.NodeIterator, nbins, 2, visit(len(internal.FixtureLeafKeys)/2),
+		iter.WithTracker(tr))
+	if !errors.Is(err, errInterrupt) {
+		t.Fatalf("expected traversal to be interrupted, got %v", err)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.positions) == 0 {
+		t.Fatal("no positions were saved")
+	}
+
+	tr = tracker.NewWithStore(store, nbins)
+	its, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iter.TraverseIterators(context.Background(), its, 2, visit(-1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(internal.FixtureLeafKeys) {
+		t.Fatalf("expected %d leaves, got %d", len(internal.FixtureLeafKeys), len(seen))
+	}
+	if len(store.positions) != 0 {
+		t.Fatal("recovery state wasn't cleared")
+	}
+}
+
+func TestTrackerStorage(t *testing.T) {
+	// accounts each with a storage trie of `slots` slots
+	const accounts, slots = 4, 50
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(types.EmptyRootHash, sdb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < accounts; i++ {
+		addr := common.BytesToAddress([]byte{byte(i + 1)})
+		for j := 0; j < slots; j++ {
+			statedb.SetState(addr, common.BytesToHash([]byte{byte(j + 1)}), common.BytesToHash([]byte{1}))
+		}
+	}
+	root, err := statedb.Commit(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sdb.TrieDB()
+	makeIterator := iter.NewTrieDBConstructor(db, trie.StateTrieID(root))
+	makeStorageIterator := func(owner, storageRoot common.Hash) iter.IteratorConstructor {
+		return iter.NewTrieDBConstructor(db, trie.StorageTrieID(root, owner, storageRoot))
+	}
+
+	seen := map[common.Hash]map[string]struct{}{}
+	visits := 0
+	errInterrupt := errors.New("interrupted")
+	visit := func(limit int) iter.StorageVisitor {
+		return func(account common.Hash, it trie.NodeIterator) error {
+			if seen[account] == nil {
+				seen[account] = map[string]struct{}{}
+			}
+			for it.Next(true) {
+				if !it.Leaf() {
+					continue
+				}
+				if limit--; limit == 0 {
+					return errInterrupt
+				}
+				seen[account][string(it.LeafKey())] = struct{}{}
+				visits++
+			}
+			return nil
+		}
+	}
+
+	// interrupt the traversal midway through a storage trie
+	store := &memoryStore{}
+	tr := tracker.NewWithStore(store, 1, tracker.WithRoot(root))
+	accountIt, err := makeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iter.TraverseStorage(context.Background(), db, root, tr.Tracked(accountIt),
+		visit(accounts*slots/2), iter.WithStorageTracker(tr))
+	if !errors.Is(err, errInterrupt) {
+		t.Fatalf("expected traversal to be interrupted, got %v", err)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	// the next storage trie may have been tracked before the interruption, but not started
+	if len(store.positions) < 2 {
+		t.Fatalf("expected state and storage positions to be saved, got %v", store.positions)
+	}
+
+	tr = tracker.NewWithStore(store, 1, tracker.WithRoot(root))
+	if _, _, err := tr.Restore(makeIterator); !errors.Is(err, tracker.ErrNoStorageConstructor) {
+		t.Fatalf("expected ErrNoStorageConstructor, got %v", err)
+	}
+	its, _, err := tr.RestoreWithStorage(makeIterator, makeStorageIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// finish the restored storage tries, then resume the account traversal without them
+	var stateIts []trie.NodeIterator
+	var resumed []common.Hash
+	for _, it := range its {
+		owner, _ := it.(*tracker.Iterator).StorageTrie()
+		if owner == (common.Hash{}) {
+			stateIts = append(stateIts, it)
+			continue
+		}
+		if err := visit(-1)(owner, it); err != nil {
+			t.Fatal(err)
+		}
+		resumed = append(resumed, owner)
+	}
+	if len(stateIts) != 1 || len(resumed) == 0 {
+		t.Fatalf("expected 1 state and some storage iterators, got %d and %d", len(stateIts), len(resumed))
+	}
+	err = iter.TraverseStorage(context.Background(), db, root, stateIts[0], visit(-1),
+		iter.WithStorageTracker(tr), iter.WithSkippedAccounts(resumed...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != accounts {
+		t.Fatalf("expected storage of %d accounts, got %d", accounts, len(seen))
+	}
+	for account, keys := range seen {
+		if len(keys) != slots {
+			t.Fatalf("expected %d slots for %x, got %d", slots, account, len(keys))
+		}
+	}
+	// the interrupted storage trie was resumed, not walked again
+	if visits >= accounts*slots+slots/2 {
+		t.Fatalf("expected about %d slot visits, got %d", accounts*slots, visits)
+	}
+	if len(store.positions) != 0 {
+		t.Fatal("recovery state wasn't cleared")
+	}
+}
+
+func TestTrackerRestoreTransform(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	store := &memoryStore{}
+	tr := tracker.NewWithStore(store, 4)
+	tr.PlanSubtries(4)
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first bin has been covered elsewhere, and the last is narrowed to its first subtrie
+	skippedEnd, narrowed := []byte{4}, []byte{0xc, 0}
+	tr = tracker.NewWithStore(store, 4, tracker.WithRestoreTransform(func(pos tracker.Position) (tracker.Position, bool) {
+		if bytes.Equal(pos.EndPath, skippedEnd) {
+			return pos, false
+		}
+		if pos.EndPath == nil {
+			pos.EndPath = narrowed
+		}
+		return pos, true
+	}))
+	its, _, err := tr.Restore(tree.NodeIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(its) != 3 {
+		t.Fatalf("expected to restore 3 iterators, got %d", len(its))
+	}
+	for _, it := range its {
+		for it.Next(true) {
+			if bytes.Compare(it.Path(), []byte{4}) < 0 || bytes.Compare(it.Path(), narrowed) > 0 {
+				t.Fatalf("node at %x is outside the transformed ranges", it.Path())
+			}
+		}
+	}
+	if err := tr.CloseAndSave(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.positions) != 0 {
+		t.Fatalf("expected dropped position to be cleared, got %d positions", len(store.positions))
+	}
+}
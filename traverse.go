@@ -0,0 +1,263 @@
+package iterator
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/sync/errgroup"
+)
+
+// Tracker registers iterators so that their state can be saved, e.g. a tracker.Tracker.
+type Tracker interface {
+	Tracked(trie.NodeIterator) trie.NodeIterator
+}
+
+// Visitor consumes the iterator over one bin of a traversal.
+type Visitor = func(trie.NodeIterator) error
+
+// TraverseOption configures a traversal.
+type TraverseOption func(*traverseConfig)
+
+type traverseConfig struct {
+	tracker  Tracker
+	monitor  *TraverseMonitor
+	resolver trie.NodeResolver
+	root     common.Hash
+	strict   bool
+	buffers  BufferPolicy
+	budget   *Budget
+}
+
+// WithTracker registers every bin of a traversal with a tracker before any bin is started, so that
+// all bins (including those not yet started) are saved if the traversal is interrupted.
+func WithTracker(tr Tracker) TraverseOption {
+	return func(conf *traverseConfig) {
+		conf.tracker = tr
+	}
+}
+
+// WithMonitor reports the state of a traversal's bins to a monitor.
+func WithMonitor(m *TraverseMonitor) TraverseOption {
+	return func(conf *traverseConfig) {
+		conf.monitor = m
+	}
+}
+
+// WithResolver adds a node resolver to the iterator of every bin, e.g. one resolver shared by all
+// bins, from NewCachingResolver.
+func WithResolver(resolver trie.NodeResolver) TraverseOption {
+	return func(conf *traverseConfig) {
+		conf.resolver = resolver
+	}
+}
+
+// WithRoot records the root of the traversed trie in the IteratorErrors the traversal returns.
+func WithRoot(root common.Hash) TraverseOption {
+	return func(conf *traverseConfig) {
+		conf.root = root
+	}
+}
+
+// WithBudget binds the iterator of every bin to a budget, so that the traversal stops once the
+// budget runs out, returning an error matching ErrBudgetExceeded. With a tracker, the bins are
+// saved as if the traversal was cancelled, and can be resumed with a new budget.
+func WithBudget(budget *Budget) TraverseOption {
+	return func(conf *traverseConfig) {
+		conf.budget = budget
+	}
+}
+
+// TraverseMonitor counts the bins of a traversal in each state, so that a running traversal can be
+// inspected, e.g. to find a stalled worker pool. It is safe to read concurrently with the traversal,
+// and may be shared by several traversals.
+type TraverseMonitor struct {
+	queued, active, completed, failed atomic.Int64
+}
+
+// TraverseStats is a snapshot of the bin counts of a TraverseMonitor. Bins are queued until a
+// worker picks them up, and failed if their visit or iterator returned an error, including
+// cancellation.
+type TraverseStats struct {
+	Queued, Active, Completed, Failed int
+}
+
+// Stats returns the current bin counts. Counts are read individually, so a bin moving between
+// states may be missed or counted twice.
+func (m *TraverseMonitor) Stats() TraverseStats {
+	return TraverseStats{
+		Queued:    int(m.queued.Load()),
+		Active:    int(m.active.Load()),
+		Completed: int(m.completed.Load()),
+		Failed:    int(m.failed.Load()),
+	}
+}
+
+func (m *TraverseMonitor) start() {
+	if m != nil {
+		m.queued.Add(-1)
+		m.active.Add(1)
+	}
+}
+
+func (m *TraverseMonitor) finish(err error) {
+	if m != nil {
+		m.active.Add(-1)
+		if err != nil {
+			m.failed.Add(1)
+		} else {
+			m.completed.Add(1)
+		}
+	}
+}
+
+func newTraverseConfig(opts []TraverseOption) traverseConfig {
+	var conf traverseConfig
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	return conf
+}
+
+// Traverse divides a trie into `nbins` subtries, and calls visit with an iterator over each of them
+// on a pool of `workers` goroutines. The iterators stop when ctx is cancelled. If visit returns an
+// error or a bin's iterator fails, the remaining bins are cancelled and the first error is returned,
+// as an *IteratorError locating the failure. The buffers returned by the iterators are copies which
+// the visitor may retain, unless configured otherwise with WithBufferPolicy. Once visited, each
+// bin's iterator is closed with Close, stopping e.g. the read-ahead of a PrefetchIterator made by
+// makeIterator, however the visitor returned.
+func Traverse(
+	ctx context.Context, makeIterator IteratorConstructor, nbins, workers uint, visit Visitor,
+	opts ...TraverseOption,
+) error {
+	conf := newTraverseConfig(opts)
+	group, gctx := errgroup.WithContext(ctx)
+	makeIterator = conf.withResolver(makeIterator)
+	// the context is checked beneath the bound iterator, so that a tracker can still see its bounds
+	iters, err := SubtrieIterators(withContext(gctx, makeIterator), nbins)
+	if err != nil {
+		return err
+	}
+	if conf.tracker != nil {
+		for i, it := range iters {
+			iters[i] = conf.tracker.Tracked(it)
+		}
+	}
+	if !conf.strict {
+		return traverse(group, conf.lend(iters), workers, visitBins(visit), &conf)
+	}
+	rec, err := newCoverageRecorder(iters)
+	if err != nil {
+		return err
+	}
+	if err := traverse(group, conf.lend(rec.wrap(iters)), workers, visitBins(visit), &conf); err != nil {
+		return err
+	}
+	return rec.check(ctx, makeIterator)
+}
+
+// TraverseIterators calls visit on each iterator on a pool of `workers` goroutines, as Traverse
+// does. This can be used to resume a traversal from iterators restored by a tracker. Of the
+// options, only WithMonitor, WithResolver, WithRoot, WithBufferPolicy and WithBudget apply; the
+// iterators are assumed to be tracked already, and may not cover the whole trie, so cannot be
+// checked by WithStrictCheck.
+func TraverseIterators(
+	ctx context.Context, iters []trie.NodeIterator, workers uint, visit Visitor, opts ...TraverseOption,
+) error {
+	conf := newTraverseConfig(opts)
+	group, ctx := errgroup.WithContext(ctx)
+	wrapped, err := conf.wrap(ctx, iters)
+	if err != nil {
+		return err
+	}
+	return traverse(group, conf.lend(wrapped), workers, visitBins(visit), &conf)
+}
+
+// withResolver returns a constructor which adds the configured resolver, if any, to each iterator.
+func (conf *traverseConfig) withResolver(makeIterator IteratorConstructor) IteratorConstructor {
+	if conf.resolver == nil {
+		return makeIterator
+	}
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		it, err := makeIterator(startKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := AddResolver(it, conf.resolver); err != nil {
+			return nil, err
+		}
+		return it, nil
+	}
+}
+
+// withContext returns a constructor of iterators which stop when ctx is cancelled.
+func withContext(ctx context.Context, makeIterator IteratorConstructor) IteratorConstructor {
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		it, err := makeIterator(startKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewContextIterator(ctx, it), nil
+	}
+}
+
+// wrap prepares existing iterators as withResolver and withContext do.
+func (conf *traverseConfig) wrap(ctx context.Context, iters []trie.NodeIterator) ([]trie.NodeIterator, error) {
+	wrapped := make([]trie.NodeIterator, len(iters))
+	for i, it := range iters {
+		if conf.resolver != nil {
+			if err := AddResolver(it, conf.resolver); err != nil {
+				return nil, err
+			}
+		}
+		wrapped[i] = NewContextIterator(ctx, it)
+	}
+	return wrapped, nil
+}
+
+// binVisitor consumes the iterator over the bin at an index of a traversal.
+type binVisitor = func(int, trie.NodeIterator) error
+
+// visitBins adapts a Visitor to visit the bins of a traversal.
+func visitBins(visit Visitor) binVisitor {
+	return func(_ int, it trie.NodeIterator) error {
+		return visit(it)
+	}
+}
+
+// traverse visits each of the iterators, wrapped as configured, by their index. The visitor must
+// not rely on the identity of the iterators it is passed, as these may be wrapped.
+func traverse(
+	group *errgroup.Group, iters []trie.NodeIterator, workers uint, visit binVisitor, conf *traverseConfig,
+) error {
+	monitor := conf.monitor
+	if workers == 0 {
+		return fmt.Errorf("invalid worker count: %d", workers)
+	}
+	group.SetLimit(int(workers))
+	if monitor != nil {
+		monitor.queued.Add(int64(len(iters)))
+	}
+	for i, it := range iters {
+		i, it := i, it
+		if conf.budget != nil {
+			it = NewBudgetIterator(it, conf.budget)
+		}
+		group.Go(func() error {
+			monitor.start()
+			err := visit(i, it)
+			if err == nil {
+				err = it.Error()
+			}
+			monitor.finish(err)
+			if err != nil {
+				err = newIteratorError(err, i, it, conf.root)
+			}
+			Close(it)
+			return err
+		})
+	}
+	return group.Wait()
+}
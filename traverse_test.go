@@ -0,0 +1,128 @@
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/trie"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestTraverse(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	t.Run("all leaves", func(t *testing.T) {
+		var leaves atomic.Int64
+		err := iter.Traverse(context.Background(), tree.NodeIterator, 16, 4, func(it trie.NodeIterator) error {
+			for it.Next(true) {
+				if it.Leaf() {
+					leaves.Add(1)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(leaves.Load()) != len(internal.FixtureLeafKeys) {
+			t.Fatalf("expected %d leaves, got %d", len(internal.FixtureLeafKeys), leaves.Load())
+		}
+	})
+
+	t.Run("error cancels bins", func(t *testing.T) {
+		errVisit := errors.New("visit failed")
+		var cancelled, visited atomic.Int64
+		// with one worker, the first bin fails before any other starts
+		err := iter.Traverse(context.Background(), tree.NodeIterator, 8, 1, func(it trie.NodeIterator) error {
+			start, _, _ := iter.Bounds(it)
+			if start == nil {
+				return errVisit
+			}
+			for it.Next(true) {
+				visited.Add(1)
+			}
+			if errors.Is(it.Error(), context.Canceled) {
+				cancelled.Add(1)
+			}
+			return nil
+		})
+		if !errors.Is(err, errVisit) {
+			t.Fatalf("expected visit error, got %v", err)
+		}
+		if visited.Load() != 0 || cancelled.Load() != 7 {
+			t.Fatalf("expected remaining bins to be cancelled: visited %d nodes in %d cancelled bins",
+				visited.Load(), cancelled.Load())
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := iter.Traverse(ctx, tree.NodeIterator, 4, 2, func(it trie.NodeIterator) error {
+			for it.Next(true) {
+			}
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestTraverseMonitor(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	var monitor iter.TraverseMonitor
+	var bin int
+	var errs []error
+	errVisit := errors.New("visit failed")
+	// with one worker, bins run in order
+	err := iter.Traverse(context.Background(), tree.NodeIterator, 4, 1, func(it trie.NodeIterator) error {
+		expected := iter.TraverseStats{Queued: 3 - bin, Active: 1, Completed: bin}
+		if stats := monitor.Stats(); stats != expected {
+			errs = append(errs, fmt.Errorf("bin %d: expected %+v, got %+v", bin, expected, stats))
+		}
+		bin++
+		if bin == 4 {
+			return errVisit
+		}
+		for it.Next(true) {
+		}
+		return nil
+	}, iter.WithMonitor(&monitor))
+	if !errors.Is(err, errVisit) {
+		t.Fatalf("expected visit error, got %v", err)
+	}
+	for _, err := range errs {
+		t.Error(err)
+	}
+	expected := iter.TraverseStats{Completed: 3, Failed: 1}
+	if stats := monitor.Stats(); stats != expected {
+		t.Fatalf("expected %+v after traversal, got %+v", expected, stats)
+	}
+
+	// the monitor accumulates over traversals
+	iters, err := iter.SubtrieIterators(tree.NodeIterator, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iter.TraverseIterators(context.Background(), iters, 2, func(it trie.NodeIterator) error {
+		for it.Next(true) {
+		}
+		return nil
+	}, iter.WithMonitor(&monitor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected.Completed += 2
+	if stats := monitor.Stats(); stats != expected {
+		t.Fatalf("expected %+v after resumed traversal, got %+v", expected, stats)
+	}
+}
@@ -0,0 +1,48 @@
+package iterator
+
+import (
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/ethereum/go-ethereum/triedb/pathdb"
+)
+
+// NewTrieDBConstructor returns an IteratorConstructor for the trie identified by `id`, read through
+// a trie database. Nodes are resolved through the database's caches and in-memory layers before
+// reaching disk, so tries which have been passed to Update but not yet committed (e.g. the state
+// of a block being processed) can be traversed.
+func NewTrieDBConstructor(db *triedb.Database, id *trie.ID) IteratorConstructor {
+	return func(startKey []byte) (trie.NodeIterator, error) {
+		tree, err := trie.New(id, db)
+		if err != nil {
+			return nil, err
+		}
+		return tree.NodeIterator(startKey)
+	}
+}
+
+// OpenTrieDB opens a read-only trie database over a key-value database, in the state scheme the
+// database was written with: hash or path. A path scheme database serves the state of its disk
+// layer and of the diff layers in its journal, i.e. the most recent 128 blocks of a node which
+// has not pruned them.
+func OpenTrieDB(db ethdb.Database) *triedb.Database {
+	config := triedb.HashDefaults
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		config = &triedb.Config{PathDB: pathdb.ReadOnly}
+	}
+	return triedb.NewDatabase(db, config)
+}
+
+// OpenTrieConstructor opens a read-only trie database, as OpenTrieDB does, and returns an
+// IteratorConstructor for the trie identified by `id`. It fails if the trie's root is not
+// available, e.g. a path scheme state older than the database's layers. The trie database should
+// be closed once the iterators are done.
+func OpenTrieConstructor(db ethdb.Database, id *trie.ID) (IteratorConstructor, *triedb.Database, error) {
+	tdb := OpenTrieDB(db)
+	if _, err := trie.New(id, tdb); err != nil {
+		tdb.Close()
+		return nil, nil, err
+	}
+	return NewTrieDBConstructor(tdb, id), tdb, nil
+}
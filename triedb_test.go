@@ -0,0 +1,130 @@
+package iterator_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/ethereum/go-ethereum/triedb/pathdb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+)
+
+func TestTrieDBConstructor(t *testing.T) {
+	for _, scheme := range []string{rawdb.HashScheme, rawdb.PathScheme} {
+		t.Run(scheme, func(t *testing.T) {
+			diskdb := rawdb.NewMemoryDatabase()
+			config := triedb.HashDefaults
+			if scheme == rawdb.PathScheme {
+				config = &triedb.Config{PathDB: pathdb.Defaults}
+			}
+			db := triedb.NewDatabase(diskdb, config)
+
+			// build a trie and pass its nodes to the database without committing them to disk
+			const leaves = 500
+			tree := trie.NewEmpty(db)
+			for i := 0; i < leaves; i++ {
+				tree.MustUpdate(crypto.Keccak256([]byte{byte(i >> 8), byte(i)}), []byte{1, byte(i)})
+			}
+			root, nodes, err := tree.Commit(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Update(root, types.EmptyRootHash, 1, trienode.NewWithNodeSet(nodes), nil); err != nil {
+				t.Fatal(err)
+			}
+			if rawdb.HasTrieNode(diskdb, common.Hash{}, nil, root, scheme) {
+				t.Fatal("root node was written to disk")
+			}
+
+			makeIterator := iter.NewTrieDBConstructor(db, trie.StateTrieID(root))
+			iters, err := iter.SubtrieIterators(makeIterator, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			for _, it := range iters {
+				for it.Next(true) {
+					if it.Leaf() {
+						count++
+					}
+				}
+				if it.Error() != nil {
+					t.Fatal(it.Error())
+				}
+			}
+			if count != leaves {
+				t.Fatalf("expected %d leaves, got %d", leaves, count)
+			}
+		})
+	}
+}
+
+func TestOpenTrieConstructor(t *testing.T) {
+	for _, scheme := range []string{rawdb.HashScheme, rawdb.PathScheme} {
+		t.Run(scheme, func(t *testing.T) {
+			diskdb := rawdb.NewMemoryDatabase()
+			config := triedb.HashDefaults
+			if scheme == rawdb.PathScheme {
+				config = &triedb.Config{PathDB: pathdb.Defaults}
+			}
+			db := triedb.NewDatabase(diskdb, config)
+
+			// commit a trie to disk, and close the writing database
+			const leaves = 500
+			tree := trie.NewEmpty(db)
+			for i := 0; i < leaves; i++ {
+				tree.MustUpdate(crypto.Keccak256([]byte{byte(i >> 8), byte(i)}), []byte{1, byte(i)})
+			}
+			root, nodes, err := tree.Commit(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Update(root, types.EmptyRootHash, 1, trienode.NewWithNodeSet(nodes), nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Commit(root, false); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			makeIterator, tdb, err := iter.OpenTrieConstructor(diskdb, trie.StateTrieID(root))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tdb.Close()
+			if tdb.Scheme() != scheme {
+				t.Fatalf("opened %s scheme trie database", tdb.Scheme())
+			}
+			var count atomic.Int64
+			visit := func(it trie.NodeIterator) error {
+				for it.Next(true) {
+					if it.Leaf() {
+						count.Add(1)
+					}
+				}
+				return it.Error()
+			}
+			err = iter.Traverse(context.Background(), makeIterator, 300, 4, visit, iter.WithStrictCheck())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count.Load() != leaves {
+				t.Fatalf("expected %d leaves, got %d", leaves, count.Load())
+			}
+
+			if _, _, err := iter.OpenTrieConstructor(diskdb, trie.StateTrieID(common.HexToHash("0x01"))); err == nil {
+				t.Fatal("expected missing root to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package iterator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// TrieStatsVersion is the version of the schema of TrieStats as written by WriteJSON. Fields are
+// only added within a version, so a file of the current or an older version can always be read.
+const TrieStatsVersion = 1
+
+// ErrTrieStatsVersion is returned by ReadTrieStats for stats written in a newer schema.
+var ErrTrieStatsVersion = errors.New("unsupported trie stats version")
+
+// TrieStats describes the shape of a trie down to a sample depth, as collected by CollectTrieStats:
+// the kinds of nodes and the fanout of branches at each depth, and the number of nodes under each
+// sampled path. It is consumed by MakeWeightedPathsFromStats and EstimateTrieSize, and can be
+// saved with WriteJSON to plan later traversals of the same trie without sampling it again.
+type TrieStats struct {
+	Version int `json:"version"`
+	// Depth is the depth in nibbles down to which the trie was sampled.
+	Depth int `json:"depth"`
+	// Levels holds the stats of the nodes at each depth above Depth, by depth.
+	Levels []LevelStats `json:"levels"`
+	// Leaves is the number of leaves reached.
+	Leaves uint64 `json:"leaves"`
+	// Sample holds the paths of all nodes reached, in iteration order, truncated to Depth nibbles,
+	// with consecutive equal paths counted once.
+	Sample []PathCount `json:"sample"`
+}
+
+// LevelStats counts the nodes at one depth of a trie.
+type LevelStats struct {
+	Depth int `json:"depth"`
+	// Branches, Extensions and ShortLeaves count the hashed nodes by kind, and Embedded the nodes
+	// stored in their parent.
+	Branches    uint64 `json:"branches"`
+	Extensions  uint64 `json:"extensions"`
+	ShortLeaves uint64 `json:"shortLeaves"`
+	Embedded    uint64 `json:"embedded"`
+	// Fanout counts the hashed branches by their number of children, from 0 to 16.
+	Fanout [17]uint64 `json:"fanout"`
+}
+
+// MeanFanout returns the mean number of children of the hashed branches at the level.
+func (l LevelStats) MeanFanout() float64 {
+	if l.Branches == 0 {
+		return 0
+	}
+	var children uint64
+	for n, count := range l.Fanout {
+		children += uint64(n) * count
+	}
+	return float64(children) / float64(l.Branches)
+}
+
+// PathCount is a number of nodes sampled under a path.
+type PathCount struct {
+	Path  hexutil.Bytes `json:"path"`
+	Count uint64        `json:"count"`
+}
+
+// CollectTrieStats walks a trie down to `depth` nibbles, recording the stats of its nodes.
+func CollectTrieStats(makeIterator IteratorConstructor, depth int) (*TrieStats, error) {
+	if depth <= 0 || depth >= 64 {
+		return nil, fmt.Errorf("invalid sample depth: %d", depth)
+	}
+	it, err := makeIterator(nil)
+	if err != nil {
+		return nil, err
+	}
+	stats := &TrieStats{Version: TrieStatsVersion, Depth: depth, Levels: make([]LevelStats, depth)}
+	for i := range stats.Levels {
+		stats.Levels[i].Depth = i
+	}
+	for it.Next(len(it.Path()) < depth) {
+		path := it.Path()
+		if it.Leaf() {
+			stats.Leaves++
+		} else if len(path) < depth {
+			if err := stats.Levels[len(path)].add(it.NodeBlob()); err != nil {
+				return nil, fmt.Errorf("node at path %x: %w", path, err)
+			}
+		}
+		if hasTerm(path) {
+			path = path[:len(path)-1]
+		}
+		if len(path) > depth {
+			path = path[:depth]
+		}
+		if n := len(stats.Sample); n > 0 && bytes.Equal(stats.Sample[n-1].Path, path) {
+			stats.Sample[n-1].Count++
+		} else {
+			stats.Sample = append(stats.Sample, PathCount{Path: append([]byte(nil), path...), Count: 1})
+		}
+	}
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	return stats, nil
+}
+
+// add counts a node by the kind of its encoding, which is empty for an embedded node.
+func (l *LevelStats) add(blob []byte) error {
+	if len(blob) == 0 {
+		l.Embedded++
+		return nil
+	}
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return err
+	}
+	switch count, _ := rlp.CountValues(elems); count {
+	case 17:
+		children := 0
+		for i := 0; i < 16; i++ {
+			kind, val, rest, err := rlp.Split(elems)
+			if err != nil {
+				return err
+			}
+			if kind == rlp.List || len(val) > 0 {
+				children++
+			}
+			elems = rest
+		}
+		l.Branches++
+		l.Fanout[children]++
+	case 2:
+		_, key, _, err := rlp.Split(elems)
+		if err != nil {
+			return err
+		}
+		// the flag of a leaf's compact key
+		if len(key) > 0 && key[0]&0x20 != 0 {
+			l.ShortLeaves++
+		} else {
+			l.Extensions++
+		}
+	default:
+		return fmt.Errorf("invalid node with %d elements", count)
+	}
+	return nil
+}
+
+// WriteJSON writes the stats as a JSON document of the current schema version.
+func (s *TrieStats) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// ReadTrieStats reads stats written by WriteJSON.
+func ReadTrieStats(r io.Reader) (*TrieStats, error) {
+	var stats TrieStats
+	if err := json.NewDecoder(r).Decode(&stats); err != nil {
+		return nil, err
+	}
+	if stats.Version > TrieStatsVersion {
+		return nil, fmt.Errorf("%w: %d, this version reads up to %d", ErrTrieStatsVersion, stats.Version, TrieStatsVersion)
+	}
+	return &stats, nil
+}
+
+// EstimateTrieSize estimates the number of leaves of a trie with hashed keys from the number of
+// paths of its sample which reach the sample depth, as keys are spread uniformly over those paths.
+// The count is exact if every sampled path holds a single leaf. Returns false if every path at the
+// sample depth is occupied, so the trie is too large to estimate from the sample, which must then
+// be collected deeper.
+func EstimateTrieSize(stats *TrieStats) (uint64, bool) {
+	var occupied uint64
+	for _, pc := range stats.Sample {
+		if len(pc.Path) == stats.Depth {
+			occupied++
+		}
+	}
+	if occupied <= stats.Leaves {
+		return stats.Leaves, true
+	}
+	paths := math.Pow(16, float64(stats.Depth))
+	if float64(occupied) >= paths {
+		return 0, false
+	}
+	// the expected number of occupied paths for n keys is paths * (1 - e^(-n/paths))
+	return uint64(math.Round(-paths * math.Log1p(-float64(occupied)/paths))), true
+}
+
+// MakeWeightedPathsFromStats returns the starting paths of up to `nbins` conterminous bins holding
+// approximately equal numbers of the nodes sampled in stats, as for MakeWeightedPaths.
+func MakeWeightedPathsFromStats(stats *TrieStats, nbins uint) ([][]byte, error) {
+	if nbins == 0 {
+		return nil, fmt.Errorf("invalid bin count: %d", nbins)
+	}
+	var total uint64
+	for _, pc := range stats.Sample {
+		total += pc.Count
+	}
+	// cut the sample where its cumulative count passes each multiple of total/nbins
+	starts := [][]byte{{}}
+	var seen uint64
+	for _, pc := range stats.Sample {
+		seen += pc.Count
+		next := uint64(len(starts))
+		if next == uint64(nbins) {
+			break
+		}
+		// the nodes under the path pass the next cut if the last of them does
+		if (seen-1)*uint64(nbins) < next*total || len(pc.Path) == 0 {
+			continue
+		}
+		if bytes.Compare(pc.Path, starts[len(starts)-1]) > 0 {
+			starts = append(starts, common.CopyBytes(pc.Path))
+		}
+	}
+	return starts, nil
+}
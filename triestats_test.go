@@ -0,0 +1,126 @@
+package iterator_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestCollectTrieStats(t *testing.T) {
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+
+	const depth = 3
+	stats, err := iter.CollectTrieStats(tree.NodeIterator, depth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := func(l iter.LevelStats) uint64 { return l.Branches + l.Extensions + l.ShortLeaves + l.Embedded }
+	if root := stats.Levels[0]; nodes(root) != 1 || root.Branches != 1 {
+		t.Fatalf("expected a root branch, got %+v", root)
+	}
+	// without extensions, the children of each level are the nodes of the next
+	for d := 0; d < depth-1; d++ {
+		level := stats.Levels[d]
+		if level.Extensions != 0 {
+			continue
+		}
+		if children := level.MeanFanout() * float64(level.Branches); uint64(children) != nodes(stats.Levels[d+1]) {
+			t.Fatalf("expected %v nodes at depth %d, got %+v", children, d+1, stats.Levels[d+1])
+		}
+	}
+
+	var sampled, leaves uint64
+	for _, pc := range stats.Sample {
+		sampled += pc.Count
+	}
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count uint64
+	for ; it.Next(len(it.Path()) < depth); count++ {
+		if it.Leaf() {
+			leaves++
+		}
+	}
+	if sampled != count || stats.Leaves != leaves {
+		t.Fatalf("expected %d nodes and %d leaves, got %d and %d", count, leaves, sampled, stats.Leaves)
+	}
+
+	t.Run("weighted paths", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := stats.WriteJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		saved := buf.String()
+		loaded, err := iter.ReadTrieStats(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := loaded.WriteJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != saved {
+			t.Fatalf("stats changed by loading them:\n%s\n%s", saved, buf.String())
+		}
+		for _, nbins := range []uint{1, 2, 3, 5, 8, 16} {
+			expected, err := iter.MakeWeightedPaths(tree.NodeIterator, nbins, depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths, err := iter.MakeWeightedPathsFromStats(loaded, nbins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(paths, expected) {
+				t.Fatalf("expected paths %x for %d bins, got %x", expected, nbins, paths)
+			}
+		}
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		_, err := iter.ReadTrieStats(strings.NewReader(`{"version": 2, "depth": 1}`))
+		if !errors.Is(err, iter.ErrTrieStatsVersion) {
+			t.Fatalf("expected ErrTrieStatsVersion, got %v", err)
+		}
+	})
+}
+
+func TestEstimateTrieSize(t *testing.T) {
+	makeTrie := func(leaves int) *trie.Trie {
+		tree := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+		for i := 0; i < leaves; i++ {
+			tree.MustUpdate(crypto.Keccak256([]byte{byte(i >> 8), byte(i)}), []byte{1})
+		}
+		return tree
+	}
+	estimate := func(tree *trie.Trie, depth int) (uint64, bool) {
+		stats, err := iter.CollectTrieStats(tree.NodeIterator, depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iter.EstimateTrieSize(stats)
+	}
+
+	if size, ok := estimate(makeTrie(10), 4); !ok || size != 10 {
+		t.Fatalf("expected an exact size of 10, got %d (%v)", size, ok)
+	}
+	large := makeTrie(5000)
+	if _, ok := estimate(large, 2); ok {
+		t.Fatal("expected no estimate from a saturated sample")
+	}
+	if size, ok := estimate(large, 3); !ok || size < 4500 || size > 5500 {
+		t.Fatalf("expected a size of about 5000, got %d (%v)", size, ok)
+	}
+}
@@ -48,3 +48,16 @@ func decodeNibbles(nibbles []byte, bytes []byte) {
 func hasTerm(s []byte) bool {
 	return len(s) > 0 && s[len(s)-1] == 16
 }
+
+// KeyBytesToHex turns key bytes into hex nibbles, with the terminator flag. This is the inverse of
+// HexToKeyBytes, so the result for a leaf key is the path of its leaf, as returned by a
+// NodeIterator. To use it as a bound or start path, drop the terminator.
+func KeyBytesToHex(key []byte) []byte {
+	hex := make([]byte, len(key)*2+1)
+	for i, b := range key {
+		hex[i*2] = b / 16
+		hex[i*2+1] = b % 16
+	}
+	hex[len(hex)-1] = 16
+	return hex
+}
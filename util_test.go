@@ -0,0 +1,37 @@
+package iterator_test
+
+import (
+	"bytes"
+	"testing"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestKeyBytesToHex(t *testing.T) {
+	if hex := iter.KeyBytesToHex([]byte{0x12, 0xab}); !bytes.Equal(hex, []byte{1, 2, 10, 11, 16}) {
+		t.Fatalf("wrong hex path: %x", hex)
+	}
+	if hex := iter.KeyBytesToHex(nil); !bytes.Equal(hex, []byte{16}) {
+		t.Fatalf("wrong hex path for empty key: %x", hex)
+	}
+
+	tree, edb := internal.OpenFixtureTrie(t, 1)
+	t.Cleanup(func() { edb.Close() })
+	it, err := tree.NodeIterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next(true) {
+		if !it.Leaf() {
+			continue
+		}
+		hex := iter.KeyBytesToHex(it.LeafKey())
+		if !bytes.Equal(hex, it.Path()) {
+			t.Fatalf("leaf key %x converts to %x, expected path %x", it.LeafKey(), hex, it.Path())
+		}
+		if key := iter.HexToKeyBytes(hex); !bytes.Equal(key, it.LeafKey()) {
+			t.Fatalf("path %x converts back to %x", hex, key)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package iterator
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// MakeWeightedPaths samples a trie down to `depth` nibbles and returns the starting paths of up to
+// `nbins` conterminous bins holding approximately equal numbers of sampled nodes. Unlike MakePaths,
+// this accounts for tries whose nodes are unevenly distributed over the keyspace (e.g. storage
+// tries, or tries with unhashed keys). Fewer bins are returned if the sample is too small to
+// separate them. A greater depth gives a more accurate split, at the cost of a longer sampling walk.
+func MakeWeightedPaths(makeIterator IteratorConstructor, nbins uint, depth int) ([][]byte, error) {
+	if nbins == 0 {
+		return nil, fmt.Errorf("invalid bin count: %d", nbins)
+	}
+	stats, err := CollectTrieStats(makeIterator, depth)
+	if err != nil {
+		return nil, err
+	}
+	return MakeWeightedPathsFromStats(stats, nbins)
+}
+
+// SubtrieIteratorsWeighted cuts a trie into up to `nbins` iterators covering subtries of
+// approximately equal size, as sampled by MakeWeightedPaths.
+func SubtrieIteratorsWeighted(makeIterator IteratorConstructor, nbins uint, depth int) ([]trie.NodeIterator, error) {
+	starts, err := MakeWeightedPaths(makeIterator, nbins, depth)
+	if err != nil {
+		return nil, err
+	}
+	return rangeIterators(makeIterator, starts, false)
+}
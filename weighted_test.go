@@ -0,0 +1,93 @@
+package iterator_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+
+	iter "github.com/cerc-io/eth-iterator-utils"
+	"github.com/cerc-io/eth-iterator-utils/internal"
+)
+
+func TestSubtrieIteratorsWeighted(t *testing.T) {
+	t.Run("trie is covered", func(t *testing.T) {
+		tree, edb := internal.OpenFixtureTrie(t, 1)
+		t.Cleanup(func() { edb.Close() })
+
+		allPaths := internal.FixtureNodePaths
+		runCase := func(t *testing.T, nbins uint) {
+			iters, err := iter.SubtrieIteratorsWeighted(tree.NodeIterator, nbins, 2)
+			if err != nil {
+				t.Fatalf("failed to create subtrie iterators: %v", err)
+			}
+			if uint(len(iters)) != nbins {
+				t.Fatalf("expected %d bins, got %d", nbins, len(iters))
+			}
+			ix := 0
+			for b, it := range iters {
+				for ; it.Next(true); ix++ {
+					if !bytes.Equal(allPaths[ix], it.Path()) {
+						t.Fatalf("wrong path value in bin %d (index %d)\nexpected:\t%v\nactual:\t\t%v",
+							b, ix, allPaths[ix], it.Path())
+					}
+				}
+				// even-length boundary nodes are visited by both adjacent bins (see TestIterator)
+				if len(allPaths[ix-1])&1 == 0 {
+					ix--
+				}
+			}
+			if ix != len(allPaths)-1 && ix != len(allPaths) {
+				t.Fatalf("expected %d nodes, got %d", len(allPaths), ix)
+			}
+		}
+		for _, tc := range []uint{1, 2, 3, 5, 8, 16} {
+			t.Run(fmt.Sprintf("%d bins", tc), func(t *testing.T) { runCase(t, tc) })
+		}
+	})
+
+	t.Run("skewed trie", func(t *testing.T) {
+		// most keys share a prefix, so uniform bins are badly unbalanced
+		tree := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+		for i := 0; i < 1000; i++ {
+			key := []byte{0, byte(i >> 8), byte(i)}
+			if i%10 == 0 {
+				key[0] = byte(i)
+			}
+			tree.MustUpdate(key, []byte{1})
+		}
+		maxLeaves := func(iters []trie.NodeIterator) int {
+			max := 0
+			for _, it := range iters {
+				count := 0
+				for it.Next(true) {
+					if it.Leaf() {
+						count++
+					}
+				}
+				if count > max {
+					max = count
+				}
+			}
+			return max
+		}
+
+		const nbins = 8
+		uniform, err := iter.SubtrieIterators(tree.NodeIterator, nbins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		weighted, err := iter.SubtrieIteratorsWeighted(tree.NodeIterator, nbins, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uniformMax, weightedMax := maxLeaves(uniform), maxLeaves(weighted)
+		if weightedMax*2 > uniformMax {
+			t.Fatalf("weighted bins not balanced: largest bin has %d leaves (uniform: %d)",
+				weightedMax, uniformMax)
+		}
+	})
+}